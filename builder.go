@@ -0,0 +1,283 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+)
+
+// Builder builds a module programmatically.
+//
+// Each method adding an entity to the module returns its index in the
+// corresponding index space. Imported entities precede the defined ones in
+// their index space, so an entity cannot be imported once an entity of the
+// same kind was defined.
+// Errors are recorded by the Builder and reported by Module.
+//
+// Expressions, such as function bodies and initializer expressions, are
+// given as sequences of instructions, which may or may not include the
+// final end instruction.
+type Builder struct {
+	types    []FuncType
+	imports  []ImportEntry
+	funcs    []uint32 // type indices of the defined functions
+	bodies   []FunctionBody
+	tables   []TableType
+	memories []MemoryType
+	globals  []GlobalVariable
+	exports  []ExportEntry
+	start    *StartSection
+	elems    []ElemSegment
+	data     []DataSegment
+
+	spaces [4]builderSpace // index spaces, per ExternalKind
+	err    error
+}
+
+// builderSpace is the index space of one kind of entity.
+type builderSpace struct {
+	n       uint32 // number of entities, imported and defined
+	defined bool   // whether an entity was defined
+}
+
+// NewBuilder returns a Builder of an empty module.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// errorf records the first error of the Builder.
+func (b *Builder) errorf(format string, args ...interface{}) {
+	if b.err == nil {
+		b.err = fmt.Errorf("wasm: "+format, args...)
+	}
+}
+
+// declare adds an entity of the given kind, and returns its index.
+func (b *Builder) declare(kind ExternalKind, imported bool) uint32 {
+	space := &b.spaces[kind]
+	if imported && space.defined {
+		b.errorf("import after %v definition", kind)
+	}
+	if !imported {
+		space.defined = true
+	}
+	idx := space.n
+	space.n++
+	return idx
+}
+
+// Type returns the index of the function signature ft, adding it to the
+// types of the module unless an identical signature was already added.
+func (b *Builder) Type(ft FuncType) uint32 {
+	for i, t := range b.types {
+		if equalFuncTypes(ft, t) {
+			return uint32(i)
+		}
+	}
+	b.types = append(b.types, FuncType{
+		Form:    Op_func,
+		Params:  append([]ValueType(nil), ft.Params...),
+		Results: append([]ValueType(nil), ft.Results...),
+	})
+	return uint32(len(b.types) - 1)
+}
+
+// ImportFunc imports a function of signature ft, and returns its index.
+func (b *Builder) ImportFunc(module, field string, ft FuncType) uint32 {
+	b.imports = append(b.imports, ImportEntry{
+		Module: module, Field: field, Kind: FunctionKind, TypeIndex: b.Type(ft),
+	})
+	return b.declare(FunctionKind, true)
+}
+
+// ImportTable imports a table, and returns its index.
+func (b *Builder) ImportTable(module, field string, t TableType) uint32 {
+	b.imports = append(b.imports, ImportEntry{
+		Module: module, Field: field, Kind: TableKind, Table: t,
+	})
+	return b.declare(TableKind, true)
+}
+
+// ImportMemory imports a linear memory, and returns its index.
+func (b *Builder) ImportMemory(module, field string, t MemoryType) uint32 {
+	b.imports = append(b.imports, ImportEntry{
+		Module: module, Field: field, Kind: MemoryKind, Memory: t,
+	})
+	return b.declare(MemoryKind, true)
+}
+
+// ImportGlobal imports a global variable, and returns its index.
+func (b *Builder) ImportGlobal(module, field string, t GlobalType) uint32 {
+	b.imports = append(b.imports, ImportEntry{
+		Module: module, Field: field, Kind: GlobalKind, Global: t,
+	})
+	return b.declare(GlobalKind, true)
+}
+
+// Funcs returns the number of functions of the module, imported and
+// defined. It is the index of the next defined function, for example to
+// define recursive functions.
+func (b *Builder) Funcs() uint32 {
+	return b.spaces[FunctionKind].n
+}
+
+// Func defines a function of signature ft, with the given local
+// variables, besides its parameters, and body, and returns its index.
+func (b *Builder) Func(ft FuncType, locals []ValueType, body []Instr) uint32 {
+	code, err := encodeExpr(body)
+	if err != nil {
+		b.errorf("function %d: %v", b.Funcs(), err)
+	}
+	return b.FuncCode(ft, locals, code)
+}
+
+// FuncCode defines a function of signature ft, with the given local
+// variables, besides its parameters, and bytecode, and returns its index.
+func (b *Builder) FuncCode(ft FuncType, locals []ValueType, code Code) uint32 {
+	b.funcs = append(b.funcs, b.Type(ft))
+	b.bodies = append(b.bodies, newFunctionBody(locals, code.Code))
+	return b.declare(FunctionKind, false)
+}
+
+// Table defines a table, and returns its index.
+func (b *Builder) Table(t TableType) uint32 {
+	b.tables = append(b.tables, t)
+	return b.declare(TableKind, false)
+}
+
+// Memory defines a linear memory, and returns its index.
+func (b *Builder) Memory(t MemoryType) uint32 {
+	b.memories = append(b.memories, t)
+	return b.declare(MemoryKind, false)
+}
+
+// Global defines a global variable, initialized by the given constant
+// expression, and returns its index.
+func (b *Builder) Global(t GlobalType, init []Instr) uint32 {
+	b.globals = append(b.globals, GlobalVariable{Type: t, Init: b.initExpr("global initializer", init)})
+	return b.declare(GlobalKind, false)
+}
+
+// Export exports the entity of the given kind and index under the name
+// field.
+func (b *Builder) Export(field string, kind ExternalKind, index uint32) {
+	for _, ex := range b.exports {
+		if ex.Field == field {
+			b.errorf("duplicate export %q", field)
+		}
+	}
+	b.exports = append(b.exports, ExportEntry{Field: field, Kind: kind, Index: index})
+}
+
+// Start declares the start function of the module.
+func (b *Builder) Start(index uint32) {
+	b.start = &StartSection{Index: index}
+}
+
+// Elem initializes the elements of a table, starting at the offset given
+// by a constant expression, with the given function indices.
+func (b *Builder) Elem(table uint32, offset []Instr, funcs ...uint32) {
+	b.elems = append(b.elems, ElemSegment{
+		Index:  table,
+		Offset: b.initExpr("element offset", offset),
+		Elems:  append([]uint32(nil), funcs...),
+	})
+}
+
+// Data initializes the bytes of a linear memory, starting at the offset
+// given by a constant expression.
+func (b *Builder) Data(memory uint32, offset []Instr, data []byte) {
+	b.data = append(b.data, DataSegment{
+		Index:  memory,
+		Offset: b.initExpr("data offset", offset),
+		Data:   append([]byte(nil), data...),
+	})
+}
+
+func (b *Builder) initExpr(what string, instrs []Instr) InitExpr {
+	code, err := encodeExpr(instrs)
+	if err != nil {
+		b.errorf("%s: %v", what, err)
+	}
+	return InitExpr{Expr: code.Code, End: code.End}
+}
+
+// Module returns the module built so far, or the first error met while
+// building it.
+// The sections of the module are in the order of the binary format, and
+// the sizes of the function bodies are computed, so that the module is
+// ready to be encoded. Module does not validate the module: see Validate.
+func (b *Builder) Module() (*Module, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	m := NewModule()
+	if len(b.types) > 0 {
+		m.Sections = append(m.Sections, TypeSection{Types: b.types})
+	}
+	if len(b.imports) > 0 {
+		m.Sections = append(m.Sections, ImportSection{Imports: b.imports})
+	}
+	if len(b.funcs) > 0 {
+		m.Sections = append(m.Sections, FunctionSection{Types: b.funcs})
+	}
+	if len(b.tables) > 0 {
+		m.Sections = append(m.Sections, TableSection{Tables: b.tables})
+	}
+	if len(b.memories) > 0 {
+		m.Sections = append(m.Sections, MemorySection{Memories: b.memories})
+	}
+	if len(b.globals) > 0 {
+		m.Sections = append(m.Sections, GlobalSection{Globals: b.globals})
+	}
+	if len(b.exports) > 0 {
+		m.Sections = append(m.Sections, ExportSection{Exports: b.exports})
+	}
+	if b.start != nil {
+		m.Sections = append(m.Sections, *b.start)
+	}
+	if len(b.elems) > 0 {
+		m.Sections = append(m.Sections, ElementSection{Elements: b.elems})
+	}
+	if len(b.bodies) > 0 {
+		m.Sections = append(m.Sections, CodeSection{Bodies: b.bodies})
+	}
+	if len(b.data) > 0 {
+		m.Sections = append(m.Sections, DataSection{Segments: b.data})
+	}
+	return m, nil
+}
+
+// encodeExpr encodes a sequence of instructions, with or without its final
+// end instruction, and checks that its blocks are well nested.
+func encodeExpr(instrs []Instr) (Code, error) {
+	var (
+		code  []byte
+		depth int
+	)
+	for i, ins := range instrs {
+		switch ins.Op {
+		case Op_block, Op_loop, Op_if:
+			depth++
+		case Op_else:
+			if depth == 0 {
+				return Code{}, fmt.Errorf("else outside of if block at instruction %d", i)
+			}
+		case Op_end:
+			depth--
+		}
+		if depth < 0 {
+			if i != len(instrs)-1 {
+				return Code{}, fmt.Errorf("instructions after the end of the expression at instruction %d", i+1)
+			}
+			break
+		}
+		code = appendInstr(code, ins)
+	}
+	if depth > 0 {
+		return Code{}, fmt.Errorf("%d unterminated blocks", depth)
+	}
+	return Code{Code: code, End: byte(Op_end)}, nil
+}
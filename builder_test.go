@@ -0,0 +1,159 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+func TestBuilder(t *testing.T) {
+	const src = `(module
+  (import "env" "log" (func $log (param i32)))
+  (import "env" "mem" (memory 1))
+  (table 2 anyfunc)
+  (global (mut i32) (i32.const 42))
+  (func $fac (param i64) (result i64)
+    (if (result i64) (i64.eqz (get_local 0))
+      (then (i64.const 1))
+      (else (i64.mul (get_local 0) (call $fac (i64.sub (get_local 0) (i64.const 1)))))))
+  (func $main (local i32 i32 f64)
+    (set_local 0 (get_global 0))
+    (call $log (get_local 0)))
+  (export "fac" (func $fac))
+  (export "main" (func $main))
+  (start $main)
+  (elem (i32.const 0) $fac $main)
+  (data (i32.const 8) "hello"))`
+
+	b := wasm.NewBuilder()
+	log := b.ImportFunc("env", "log", wasm.FuncType{Params: []wasm.ValueType{wasm.I32}})
+	b.ImportMemory("env", "mem", wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+	table := b.Table(wasm.TableType{ElemType: wasm.AnyFunc, Limits: wasm.ResizableLimits{Initial: 2}})
+	g := b.Global(
+		wasm.GlobalType{ContentType: wasm.I32, Mutability: 1},
+		[]wasm.Instr{{Op: wasm.Op_i32_const, Value: 42}},
+	)
+	fac := b.Funcs()
+	if got := b.Func(
+		wasm.FuncType{Params: []wasm.ValueType{wasm.I64}, Results: []wasm.ValueType{wasm.I64}},
+		nil,
+		[]wasm.Instr{
+			{Op: wasm.Op_get_local, Index: 0},
+			{Op: wasm.Op_i64_eqz},
+			{Op: wasm.Op_if, Block: wasm.BlockType(wasm.I64)},
+			{Op: wasm.Op_i64_const, Value: 1},
+			{Op: wasm.Op_else},
+			{Op: wasm.Op_get_local, Index: 0},
+			{Op: wasm.Op_get_local, Index: 0},
+			{Op: wasm.Op_i64_const, Value: 1},
+			{Op: wasm.Op_i64_sub},
+			{Op: wasm.Op_call, Index: fac},
+			{Op: wasm.Op_i64_mul},
+			{Op: wasm.Op_end},
+		},
+	); got != fac {
+		t.Errorf("index of fac: got %d, want %d", got, fac)
+	}
+	main := b.Func(
+		wasm.FuncType{},
+		[]wasm.ValueType{wasm.I32, wasm.I32, wasm.F64},
+		[]wasm.Instr{
+			{Op: wasm.Op_get_global, Index: g},
+			{Op: wasm.Op_set_local, Index: 0},
+			{Op: wasm.Op_get_local, Index: 0},
+			{Op: wasm.Op_call, Index: log},
+			{Op: wasm.Op_end},
+		},
+	)
+	b.Export("fac", wasm.FunctionKind, fac)
+	b.Export("main", wasm.FunctionKind, main)
+	b.Start(main)
+	b.Elem(table, []wasm.Instr{{Op: wasm.Op_i32_const}}, fac, main)
+	b.Data(0, []wasm.Instr{{Op: wasm.Op_i32_const, Value: 8}}, []byte("hello"))
+
+	m, err := b.Module()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wasm.Validate(m); err != nil {
+		t.Fatal(err)
+	}
+	want, err := wasm.ParseText([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, exp bytes.Buffer
+	if err := wasm.Encode(*m, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := wasm.Encode(*want, &exp); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), exp.Bytes()) {
+		t.Errorf("encoded module:\ngot:  %x\nwant: %x", got.Bytes(), exp.Bytes())
+	}
+	if _, err := wasm.Decode(&got); err != nil {
+		t.Errorf("could not decode the built module: %v", err)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		build func(b *wasm.Builder)
+		want  string
+	}{
+		{
+			name: "import-after-definition",
+			build: func(b *wasm.Builder) {
+				b.Memory(wasm.MemoryType{})
+				b.ImportMemory("env", "mem", wasm.MemoryType{})
+			},
+			want: "wasm: import after memory definition",
+		},
+		{
+			name: "duplicate-export",
+			build: func(b *wasm.Builder) {
+				f := b.Func(wasm.FuncType{}, nil, nil)
+				b.Export("f", wasm.FunctionKind, f)
+				b.Export("f", wasm.FunctionKind, f)
+			},
+			want: `wasm: duplicate export "f"`,
+		},
+		{
+			name: "unterminated-block",
+			build: func(b *wasm.Builder) {
+				b.Func(wasm.FuncType{}, nil, []wasm.Instr{{Op: wasm.Op_block, Block: wasm.BlockTypeEmpty}})
+			},
+			want: "wasm: function 0: 1 unterminated blocks",
+		},
+		{
+			name: "trailing-instructions",
+			build: func(b *wasm.Builder) {
+				b.Func(wasm.FuncType{}, nil, []wasm.Instr{{Op: wasm.Op_end}, {Op: wasm.Op_nop}})
+			},
+			want: "wasm: function 0: instructions after the end of the expression at instruction 1",
+		},
+		{
+			name: "invalid-offset",
+			build: func(b *wasm.Builder) {
+				b.Data(0, []wasm.Instr{{Op: wasm.Op_else}}, nil)
+			},
+			want: "wasm: data offset: else outside of if block at instruction 0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := wasm.NewBuilder()
+			tc.build(b)
+			_, err := b.Module()
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
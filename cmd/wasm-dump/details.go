@@ -0,0 +1,267 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sbinet/wasm"
+)
+
+// maxPreview is the maximum number of bytes of data segments and custom
+// sections displayed.
+const maxPreview = 64
+
+// details writes the full contents of all sections of mod to w.
+func details(w io.Writer, fname string, mod *wasm.Module) error {
+	var (
+		funcs   = newFuncIndex(mod)
+		nfuncs  = funcs.nimports // index of the next defined function
+		ntables int
+		nmems   int
+		nglobs  int
+	)
+	for _, sec := range mod.Sections {
+		if sec, ok := sec.(wasm.ImportSection); ok {
+			for _, imp := range sec.Imports {
+				switch imp.Kind {
+				case wasm.TableKind:
+					ntables++
+				case wasm.MemoryKind:
+					nmems++
+				case wasm.GlobalKind:
+					nglobs++
+				}
+			}
+		}
+	}
+
+	o := bufio.NewWriter(w)
+	defer o.Flush()
+
+	fmt.Fprintf(o, "%s:\tfile format wasm 0x%x\n\n", fname, mod.Header.Version)
+	fmt.Fprintf(o, "Section Details:\n")
+
+	for i, sec := range mod.Sections {
+		title := sec.ID().String()
+		title = strings.ToUpper(title[:1]) + title[1:]
+		if n := count(sec); n >= 0 {
+			title = fmt.Sprintf("%s[%d]", title, n)
+		}
+		fmt.Fprintf(o, "\n%s", title)
+		if i < len(mod.Layout) {
+			layout := mod.Layout[i]
+			fmt.Fprintf(o, " start=0x%08x end=0x%08x (size=0x%08x)",
+				layout.Payload, layout.Payload+int64(layout.Size), layout.Size,
+			)
+		}
+		fmt.Fprintf(o, ":\n")
+
+		switch sec := sec.(type) {
+		case wasm.CustomSection:
+			fmt.Fprintf(o, " - name: %q\n", sec.Name)
+			if sec.Name != "name" {
+				preview(o, sec.Payload)
+				break
+			}
+			ns, err := sec.NameSection()
+			if err != nil {
+				fmt.Fprintf(o, " - <invalid: %v>\n", err)
+				break
+			}
+			names(o, ns)
+
+		case wasm.TypeSection:
+			for i, ft := range sec.Types {
+				fmt.Fprintf(o, " - type[%d] %v\n", i, ft)
+			}
+
+		case wasm.ImportSection:
+			var idx [4]int
+			for _, imp := range sec.Imports {
+				fmt.Fprintf(o, " - %v[%d]", imp.Kind, idx[imp.Kind&3])
+				switch imp.Kind {
+				case wasm.FunctionKind:
+					fmt.Fprintf(o, " sig=%d", imp.TypeIndex)
+				case wasm.TableKind:
+					fmt.Fprintf(o, " type=%v %s", imp.Table.ElemType, limits(imp.Table.Limits))
+				case wasm.MemoryKind:
+					fmt.Fprintf(o, " pages: %s", limits(imp.Memory.Limits))
+				case wasm.GlobalKind:
+					fmt.Fprintf(o, " %v mutable=%d", imp.Global.ContentType, imp.Global.Mutability)
+				}
+				fmt.Fprintf(o, " <- %s.%s\n", imp.Module, imp.Field)
+				idx[imp.Kind&3]++
+			}
+
+		case wasm.FunctionSection:
+			for _, sig := range sec.Types {
+				fmt.Fprintf(o, " - func[%d] sig=%d%s\n", nfuncs, sig, funcs.name(uint32(nfuncs)))
+				nfuncs++
+			}
+
+		case wasm.TableSection:
+			for _, t := range sec.Tables {
+				fmt.Fprintf(o, " - table[%d] type=%v %s\n", ntables, t.ElemType, limits(t.Limits))
+				ntables++
+			}
+
+		case wasm.MemorySection:
+			for _, m := range sec.Memories {
+				fmt.Fprintf(o, " - memory[%d] pages: %s\n", nmems, limits(m.Limits))
+				nmems++
+			}
+
+		case wasm.GlobalSection:
+			for _, g := range sec.Globals {
+				fmt.Fprintf(o, " - global[%d] %v mutable=%d - init %s\n",
+					nglobs, g.Type.ContentType, g.Type.Mutability, initExpr(g.Init),
+				)
+				nglobs++
+			}
+
+		case wasm.ExportSection:
+			for _, exp := range sec.Exports {
+				fmt.Fprintf(o, " - %v[%d] -> %q\n", exp.Kind, exp.Index, exp.Field)
+			}
+
+		case wasm.StartSection:
+			fmt.Fprintf(o, " - start function: %d%s\n", sec.Index, funcs.name(sec.Index))
+
+		case wasm.ElementSection:
+			for i, seg := range sec.Elements {
+				fmt.Fprintf(o, " - segment[%d] table=%d count=%d - init %s\n",
+					i, seg.Index, len(seg.Elems), initExpr(seg.Offset),
+				)
+				for j, idx := range seg.Elems {
+					fmt.Fprintf(o, "  - elem[%d] = func[%d]%s\n", j, idx, funcs.name(idx))
+				}
+			}
+
+		case wasm.CodeSection:
+			for i, body := range sec.Bodies {
+				idx := uint32(funcs.nimports + i)
+				fmt.Fprintf(o, " - func[%d] size=%d%s\n", idx, body.BodySize, funcs.name(idx))
+			}
+
+		case wasm.DataSection:
+			for i, seg := range sec.Segments {
+				fmt.Fprintf(o, " - segment[%d] memory=%d size=%d - init %s\n",
+					i, seg.Index, len(seg.Data), initExpr(seg.Offset),
+				)
+				preview(o, seg.Data)
+			}
+		}
+	}
+
+	return nil
+}
+
+// count returns the number of entries of a section, or -1 if the section
+// is not a vector of entries.
+func count(sec wasm.Section) int {
+	switch sec := sec.(type) {
+	case wasm.TypeSection:
+		return len(sec.Types)
+	case wasm.ImportSection:
+		return len(sec.Imports)
+	case wasm.FunctionSection:
+		return len(sec.Types)
+	case wasm.TableSection:
+		return len(sec.Tables)
+	case wasm.MemorySection:
+		return len(sec.Memories)
+	case wasm.GlobalSection:
+		return len(sec.Globals)
+	case wasm.ExportSection:
+		return len(sec.Exports)
+	case wasm.ElementSection:
+		return len(sec.Elements)
+	case wasm.CodeSection:
+		return len(sec.Bodies)
+	case wasm.DataSection:
+		return len(sec.Segments)
+	}
+	return -1
+}
+
+func limits(l wasm.ResizableLimits) string {
+	if l.Flags&0x1 != 0 {
+		return fmt.Sprintf("initial=%d max=%d", l.Initial, l.Maximum)
+	}
+	return fmt.Sprintf("initial=%d", l.Initial)
+}
+
+func initExpr(expr wasm.InitExpr) string {
+	instrs, err := expr.Instrs()
+	if err != nil {
+		return fmt.Sprintf("<invalid: %v>", err)
+	}
+	str := make([]string, 0, len(instrs))
+	for _, ins := range instrs[:len(instrs)-1] {
+		str = append(str, ins.String())
+	}
+	return strings.Join(str, ", ")
+}
+
+// preview writes a hex dump of the first bytes of data to w.
+func preview(w io.Writer, data []byte) {
+	n := len(data)
+	if n > maxPreview {
+		n = maxPreview
+	}
+	for _, line := range strings.SplitAfter(hex.Dump(data[:n]), "\n") {
+		if line == "" {
+			continue
+		}
+		fmt.Fprintf(w, "  - %s", line)
+	}
+	if n < len(data) {
+		fmt.Fprintf(w, "  - ... (%d more bytes)\n", len(data)-n)
+	}
+}
+
+// names writes the content of the name section to w.
+func names(w io.Writer, ns wasm.NameSection) {
+	if ns.Module != "" {
+		fmt.Fprintf(w, " - module <%s>\n", ns.Module)
+	}
+	for _, sub := range []struct {
+		kind  string
+		names wasm.NameMap
+	}{
+		{"func", ns.Functions},
+		{"type", ns.Types},
+		{"table", ns.Tables},
+		{"memory", ns.Memories},
+		{"global", ns.Globals},
+		{"elem", ns.Elements},
+		{"data", ns.Data},
+	} {
+		for _, na := range sub.names {
+			fmt.Fprintf(w, " - %s[%d] <%s>\n", sub.kind, na.Index, na.Name)
+		}
+	}
+	for _, sub := range []struct {
+		kind  string
+		names wasm.IndirectNameMap
+	}{
+		{"local", ns.Locals},
+		{"label", ns.Labels},
+	} {
+		for _, fct := range sub.names {
+			for _, na := range fct.Names {
+				fmt.Fprintf(w, " - func[%d] %s[%d] <%s>\n", fct.Index, sub.kind, na.Index, na.Name)
+			}
+		}
+	}
+	for _, sub := range ns.Unknown {
+		fmt.Fprintf(w, " - subsection[%d] size=%d\n", sub.ID, len(sub.Payload))
+	}
+}
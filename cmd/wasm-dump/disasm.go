@@ -0,0 +1,103 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sbinet/wasm"
+)
+
+// maxBytes is the maximum number of instruction bytes displayed on a line.
+const maxBytes = 9
+
+// disassemble writes the disassembly of all function bodies of mod to w.
+func disassemble(w io.Writer, fname string, mod *wasm.Module) error {
+	var (
+		funcs  = newFuncIndex(mod)
+		bodies []wasm.FunctionBody
+	)
+	for _, sec := range mod.Sections {
+		if sec, ok := sec.(wasm.CodeSection); ok {
+			bodies = append(bodies, sec.Bodies...)
+		}
+	}
+
+	o := bufio.NewWriter(w)
+	defer o.Flush()
+
+	fmt.Fprintf(o, "%s:\tfile format wasm 0x%x\n\n", fname, mod.Header.Version)
+	fmt.Fprintf(o, "Code Disassembly:\n")
+
+	for i, body := range bodies {
+		idx := uint32(funcs.nimports + i)
+		fmt.Fprintf(o, "\n%06x func[%d]%s:\n", body.Code.Offset, idx, funcs.name(idx))
+
+		local := uint32(0)
+		for _, le := range body.Locals {
+			if le.Count == 0 {
+				continue
+			}
+			fmt.Fprintf(o, " %6s  %-*s | local[%d..%d] type=%v\n",
+				"", 3*maxBytes, "", local, local+le.Count-1, le.Type,
+			)
+			local += le.Count
+		}
+
+		instrs, err := body.Code.Instrs()
+		if err != nil {
+			return fmt.Errorf("func[%d]: %v", idx, err)
+		}
+
+		code := append(append([]byte(nil), body.Code.Code...), body.Code.End)
+		depth := 0
+		for j, ins := range instrs {
+			end := len(code)
+			if j+1 < len(instrs) {
+				end = instrs[j+1].Offset
+			}
+
+			switch ins.Op {
+			case wasm.Op_end, wasm.Op_else:
+				if depth > 0 {
+					depth--
+				}
+			}
+			indent := strings.Repeat(" ", 2*depth)
+			switch ins.Op {
+			case wasm.Op_block, wasm.Op_loop, wasm.Op_if, wasm.Op_else:
+				depth++
+			}
+
+			fmt.Fprintf(o, " %06x: %-*s |",
+				body.Code.Offset+int64(ins.Offset), 3*maxBytes, hexBytes(code[ins.Offset:end]),
+			)
+			fmt.Fprintf(o, " %s%v\n", indent, ins)
+		}
+	}
+
+	return nil
+}
+
+// hexBytes formats p as a sequence of hexadecimal bytes, eliding the ones
+// that do not fit on a line.
+func hexBytes(p []byte) string {
+	elided := false
+	if len(p) > maxBytes {
+		p = p[:maxBytes-1]
+		elided = true
+	}
+	str := make([]string, 0, len(p)+1)
+	for _, b := range p {
+		str = append(str, fmt.Sprintf("%02x", b))
+	}
+	if elided {
+		str = append(str, "..")
+	}
+	return strings.Join(str, " ")
+}
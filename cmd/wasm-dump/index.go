@@ -0,0 +1,51 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "github.com/sbinet/wasm"
+
+// funcIndex describes the function index space of a module.
+type funcIndex struct {
+	nimports int               // number of imported functions
+	names    map[uint32]string // names of functions, from the name section, exports and imports
+}
+
+func newFuncIndex(mod *wasm.Module) funcIndex {
+	funcs := funcIndex{names: make(map[uint32]string)}
+	for _, sec := range mod.Sections {
+		switch sec := sec.(type) {
+		case wasm.ImportSection:
+			for _, imp := range sec.Imports {
+				if imp.Kind == wasm.FunctionKind {
+					funcs.names[uint32(funcs.nimports)] = imp.Module + "." + imp.Field
+					funcs.nimports++
+				}
+			}
+		case wasm.ExportSection:
+			for _, exp := range sec.Exports {
+				if exp.Kind == wasm.FunctionKind {
+					funcs.names[exp.Index] = exp.Field
+				}
+			}
+		}
+	}
+	if ns, ok, err := mod.Names(); ok && err == nil {
+		for _, na := range ns.Functions {
+			if na.Name != "" {
+				funcs.names[na.Index] = na.Name
+			}
+		}
+	}
+	return funcs
+}
+
+// name returns the decorated name of the idx-th function, if any.
+func (funcs funcIndex) name(idx uint32) string {
+	name, ok := funcs.names[idx]
+	if !ok {
+		return ""
+	}
+	return " <" + name + ">"
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/sbinet/wasm"
+)
+
+// document is the JSON description of a module.
+type document struct {
+	File      string             `json:"file"`
+	Header    wasm.ModuleHeader  `json:"header"`
+	Sections  []section          `json:"sections"`
+	Imports   []wasm.ImportEntry `json:"imports"`
+	Exports   []wasm.ExportEntry `json:"exports"`
+	Functions []function         `json:"functions"`
+}
+
+type section struct {
+	ID     wasm.SectionID `json:"id"`
+	Name   string         `json:"name"`
+	Offset int64          `json:"offset"` // offset of the section payload
+	Size   uint32         `json:"size"`   // size of the section payload
+	Count  *int           `json:"count,omitempty"`
+}
+
+type function struct {
+	Index     uint32        `json:"index"`
+	Name      string        `json:"name,omitempty"`
+	Imported  bool          `json:"imported"`
+	Type      uint32        `json:"type"`
+	Signature wasm.FuncType `json:"signature"`
+	BodySize  uint32        `json:"body_size,omitempty"`
+}
+
+// writeJSON writes a JSON document describing mod to w.
+func writeJSON(w io.Writer, fname string, mod *wasm.Module) error {
+	var (
+		names = newFuncIndex(mod)
+		types []wasm.FuncType
+		doc   = document{
+			File:      fname,
+			Header:    mod.Header,
+			Sections:  make([]section, 0, len(mod.Sections)),
+			Imports:   []wasm.ImportEntry{},
+			Exports:   []wasm.ExportEntry{},
+			Functions: []function{},
+		}
+	)
+
+	for i, sec := range mod.Sections {
+		s := section{ID: sec.ID(), Name: sec.ID().String()}
+		if i < len(mod.Layout) {
+			s.Offset = mod.Layout[i].Payload
+			s.Size = mod.Layout[i].Size
+		}
+		if n := count(sec); n >= 0 {
+			s.Count = &n
+		}
+		doc.Sections = append(doc.Sections, s)
+
+		switch sec := sec.(type) {
+		case wasm.TypeSection:
+			types = sec.Types
+		case wasm.ImportSection:
+			doc.Imports = append(doc.Imports, sec.Imports...)
+			for _, imp := range sec.Imports {
+				if imp.Kind != wasm.FunctionKind {
+					continue
+				}
+				doc.Functions = append(doc.Functions, function{
+					Imported: true,
+					Type:     imp.TypeIndex,
+				})
+			}
+		case wasm.ExportSection:
+			doc.Exports = append(doc.Exports, sec.Exports...)
+		case wasm.FunctionSection:
+			for _, typ := range sec.Types {
+				doc.Functions = append(doc.Functions, function{Type: typ})
+			}
+		case wasm.CodeSection:
+			for i, body := range sec.Bodies {
+				idx := names.nimports + i
+				if idx >= len(doc.Functions) {
+					return fmt.Errorf("wasm-dump: function body #%d without declaration", i)
+				}
+				doc.Functions[idx].BodySize = body.BodySize
+			}
+		}
+	}
+
+	for i := range doc.Functions {
+		f := &doc.Functions[i]
+		f.Index = uint32(i)
+		f.Name = names.names[f.Index]
+		if int(f.Type) < len(types) {
+			f.Signature = types[f.Type]
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -8,6 +8,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/sbinet/wasm"
 )
@@ -16,6 +17,10 @@ func main() {
 	log.SetFlags(0)
 	log.SetPrefix("wasm>> ")
 
+	disasm := flag.Bool("d", false, "disassemble function bodies")
+	detail := flag.Bool("x", false, "show the detailed contents of each section")
+	doJSON := flag.Bool("json", false, "describe the module as a JSON document")
+
 	flag.Parse()
 
 	fname := flag.Arg(0)
@@ -24,6 +29,30 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if *doJSON {
+		err = writeJSON(os.Stdout, fname, mod)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *detail || *disasm {
+		if *detail {
+			err = details(os.Stdout, fname, mod)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *disasm {
+			err = disassemble(os.Stdout, fname, mod)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
 	fmt.Printf("module header: %v\n", mod.Header)
 	fmt.Printf("#sections: %d\n", len(mod.Sections))
 	for _, section := range mod.Sections {
@@ -0,0 +1,145 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command wasm-run runs a WASI command module, or a program built by Go for
+// the js/wasm target.
+//
+// Host directories are made available to the module with -dir, and
+// environment variables with -env. The arguments following the module are
+// passed to the program.
+// When the program traps, its wasm call stack is printed.
+//
+// Usage:
+//
+//	$> wasm-run [options] file.wasm [args...]
+//
+// Example:
+//
+//	$> wasm-run -dir ./data:/data -env LANG=C ./cat.wasm /data/hello.txt
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/gojs"
+	"github.com/sbinet/wasm/wasi"
+)
+
+// list is a repeatable string flag.
+type list []string
+
+func (l *list) String() string { return strings.Join(*l, ",") }
+
+func (l *list) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wasm-run: ")
+
+	var (
+		env     list
+		dirs    list
+		inherit = flag.Bool("inherit-env", false, "pass the environment of wasm-run to the program")
+	)
+	flag.Var(&env, "env", "set an environment variable of the program, as `KEY=VALUE` (repeatable)")
+	flag.Var(&dirs, "dir", "preopen the host directory `host[:guest]` (repeatable)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: wasm-run [options] file.wasm [args...]\n\nOptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fname := flag.Arg(0)
+	raw, err := os.ReadFile(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mod, err := wasm.DecodeBytes(raw)
+	if err != nil {
+		log.Fatalf("%s: %v", fname, err)
+	}
+
+	cfg := wasi.Config{
+		Args:   append([]string{filepath.Base(fname)}, flag.Args()[1:]...),
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	if *inherit {
+		cfg.Env = os.Environ()
+	}
+	cfg.Env = append(cfg.Env, env...)
+	for _, dir := range dirs {
+		host, guest, ok := strings.Cut(dir, ":")
+		if !ok {
+			guest = host
+		}
+		fsys, err := wasi.DirFS(host)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Preopens = append(cfg.Preopens, wasi.Preopen{Path: guest, FS: fsys})
+	}
+
+	var code uint32
+	switch {
+	case isGoJS(mod):
+		if len(cfg.Preopens) > 0 {
+			log.Fatalf("%s: -dir is not supported by js/wasm programs", fname)
+		}
+		rt := gojs.NewRuntime(gojs.Config{
+			Args:   cfg.Args,
+			Env:    cfg.Env,
+			Stdout: cfg.Stdout,
+			Stderr: cfg.Stderr,
+		})
+		var c int32
+		c, err = rt.Run(mod)
+		code = uint32(c)
+	default:
+		code, err = wasi.Run(mod, cfg)
+	}
+	if err != nil {
+		var trap *exec.Trap
+		if errors.As(err, &trap) {
+			log.Fatalf("%s: %s", fname, trap.Trace())
+		}
+		log.Fatalf("%s: %v", fname, err)
+	}
+	os.Exit(int(code))
+}
+
+// isGoJS returns whether the module is a program built by Go for the
+// js/wasm target.
+func isGoJS(m *wasm.Module) bool {
+	for _, sec := range m.Sections {
+		sec, ok := sec.(wasm.ImportSection)
+		if !ok {
+			continue
+		}
+		for _, imp := range sec.Imports {
+			if imp.Module == gojs.ModuleName || imp.Module == "go" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command wasm2wat translates a WebAssembly module from the binary format
+// to the text format.
+//
+// Usage:
+//
+//	$> wasm2wat [options] file.wasm
+//
+// Example:
+//
+//	$> wasm2wat -f -inline-exports -o hello.wat ./testdata/hello.wasm
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/sbinet/wasm"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wasm2wat: ")
+
+	var (
+		fold   = flag.Bool("f", false, "write function bodies as folded expressions")
+		inline = flag.Bool("inline-exports", false, "write exports inline, within the exported definitions")
+		oname  = flag.String("o", "", "path to the output file (default: standard output)")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: wasm2wat [options] file.wasm\n\nOptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	mod, err := wasm.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *oname != "" {
+		f, err := os.Create(*oname)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	opts := wasm.TextOptions{Folded: *fold, InlineExports: *inline}
+	err = opts.WriteText(w, mod)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command wat2wasm translates a WebAssembly module from the text format to
+// the binary format.
+//
+// Usage:
+//
+//	$> wat2wasm [options] file.wat
+//
+// Example:
+//
+//	$> wat2wasm -debug-names -o hello.wasm ./hello.wat
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sbinet/wasm"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wat2wasm: ")
+
+	var (
+		names   = flag.Bool("debug-names", false, "write a name section with the identifiers of the module")
+		nocheck = flag.Bool("no-check", false, "do not validate the module")
+		oname   = flag.String("o", "", "path to the output file (default: input file with a .wasm extension)")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: wat2wasm [options] file.wat\n\nOptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fname := flag.Arg(0)
+	src, err := os.ReadFile(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := wasm.TextOptions{DebugNames: *names}
+	mod, err := opts.ParseText(src)
+	if err != nil {
+		log.Fatalf("%s: %v", fname, err)
+	}
+
+	if !*nocheck {
+		err = wasm.Validate(mod)
+		if errs, ok := err.(wasm.ValidationErrors); ok {
+			for _, err := range errs {
+				log.Printf("%s: %v", fname, err)
+			}
+			os.Exit(1)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	err = wasm.Encode(*mod, buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *oname == "" {
+		*oname = strings.TrimSuffix(fname, ".wat") + ".wasm"
+	}
+	err = os.WriteFile(*oname, buf.Bytes(), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
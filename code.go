@@ -0,0 +1,488 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Instr is a decoded instruction, with its immediates.
+// Only the immediate fields relevant to Op are meaningful.
+type Instr struct {
+	Op     Opcode
+	Misc   MiscOpcode // opcode following the Op_prefix_misc prefix
+	Offset int        // offset of the opcode, in bytes, from the start of the expression
+
+	Block   BlockType // signature of block, loop and if
+	Index   uint32    // label, function, type, local or global index
+	Targets []uint32  // br_table target labels, not including the default one
+	Default uint32    // br_table default label
+	Mem     MemArg    // immediate of load and store instructions
+	Value   uint64    // i32 and i64 constants (sign-extended), f32 and f64 constants (IEEE 754 bits)
+}
+
+// MemArg is the immediate of memory access instructions.
+type MemArg struct {
+	Align  uint32 // log2 of the alignment of the access
+	Offset uint32 // offset added to the address operand
+}
+
+// I32 returns the value of an i32.const instruction.
+func (ins Instr) I32() int32 { return int32(ins.Value) }
+
+// I64 returns the value of an i64.const instruction.
+func (ins Instr) I64() int64 { return int64(ins.Value) }
+
+// F32 returns the value of a f32.const instruction.
+func (ins Instr) F32() float32 { return math.Float32frombits(uint32(ins.Value)) }
+
+// F64 returns the value of a f64.const instruction.
+func (ins Instr) F64() float64 { return math.Float64frombits(ins.Value) }
+
+// String returns the text format of the instruction.
+func (ins Instr) String() string {
+	name := ins.Name()
+	switch ins.Op {
+	case Op_block, Op_loop, Op_if:
+		if ins.Block != BlockTypeEmpty {
+			return name + " " + ValueType(ins.Block).String()
+		}
+	case Op_br, Op_br_if, Op_call, Op_call_indirect,
+		Op_get_local, Op_set_local, Op_tee_local, Op_get_global, Op_set_global:
+		return name + " " + strconv.FormatUint(uint64(ins.Index), 10)
+	case Op_br_table:
+		str := make([]string, 0, len(ins.Targets)+2)
+		str = append(str, name)
+		for _, l := range ins.Targets {
+			str = append(str, strconv.FormatUint(uint64(l), 10))
+		}
+		str = append(str, strconv.FormatUint(uint64(ins.Default), 10))
+		return strings.Join(str, " ")
+	case Op_i32_const:
+		return name + " " + strconv.FormatInt(int64(ins.I32()), 10)
+	case Op_i64_const:
+		return name + " " + strconv.FormatInt(ins.I64(), 10)
+	case Op_f32_const:
+		return name + " " + strconv.FormatFloat(float64(ins.F32()), 'g', -1, 32)
+	case Op_f64_const:
+		return name + " " + strconv.FormatFloat(ins.F64(), 'g', -1, 64)
+	default:
+		if isMemoryAccess(ins.Op) {
+			if ins.Mem.Offset != 0 {
+				name += " offset=" + strconv.FormatUint(uint64(ins.Mem.Offset), 10)
+			}
+			if ins.Mem.Align != naturalAlignment(ins.Op) {
+				name += " align=" + strconv.FormatUint(1<<ins.Mem.Align, 10)
+			}
+		}
+	}
+	return name
+}
+
+// Name returns the text format name of the instruction.
+func (ins Instr) Name() string {
+	if ins.Op == Op_prefix_misc {
+		return ins.Misc.String()
+	}
+	return ins.Op.String()
+}
+
+func isMemoryAccess(op Opcode) bool {
+	return Op_i32_load <= op && op <= Op_i64_store32
+}
+
+// naturalAlignment returns the log2 of the natural alignment of a memory
+// access instruction.
+func naturalAlignment(op Opcode) uint32 {
+	switch op {
+	case Op_i32_load8_s, Op_i32_load8_u, Op_i64_load8_s, Op_i64_load8_u,
+		Op_i32_store8, Op_i64_store8:
+		return 0
+	case Op_i32_load16_s, Op_i32_load16_u, Op_i64_load16_s, Op_i64_load16_u,
+		Op_i32_store16, Op_i64_store16:
+		return 1
+	case Op_i32_load, Op_f32_load, Op_i64_load32_s, Op_i64_load32_u,
+		Op_i32_store, Op_f32_store, Op_i64_store32:
+		return 2
+	}
+	return 3
+}
+
+// Instrs decodes the bytecode of a function body.
+// The returned slice ends with the final end instruction.
+func (c Code) Instrs() ([]Instr, error) {
+	code := make([]byte, len(c.Code)+1)
+	copy(code, c.Code)
+	code[len(c.Code)] = c.End
+	return DecodeExpr(code)
+}
+
+// Instrs decodes the initializer expression.
+// The returned slice ends with the final end instruction.
+func (ie InitExpr) Instrs() ([]Instr, error) {
+	code := make([]byte, len(ie.Expr)+1)
+	copy(code, ie.Expr)
+	code[len(ie.Expr)] = ie.End
+	return DecodeExpr(code)
+}
+
+// DecodeExpr decodes a sequence of instructions terminated by the end
+// instruction closing the outermost block.
+// DecodeExpr returns an error if code holds bytes after that end instruction.
+func DecodeExpr(code []byte) ([]Instr, error) {
+	var instrs []Instr
+	err := walkExpr(code, func(ins Instr) {
+		instrs = append(instrs, ins)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instrs, nil
+}
+
+// walkExpr decodes the instructions of code, like DecodeExpr, and calls f,
+// if not nil, with each of them.
+func walkExpr(code []byte, f func(Instr)) error {
+	r := bytes.NewReader(code)
+	err := decodeExpr(r, f)
+	if err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return &exprError{
+			Offset: len(code) - r.Len(),
+			Err:    fmt.Errorf("wasm: %d trailing bytes after end of expression", r.Len()),
+		}
+	}
+	return nil
+}
+
+// decodeExpr decodes instructions from r up to, and including, the end
+// instruction matching the implicit outermost block, and calls f, if not
+// nil, with each of them.
+func decodeExpr(r io.ByteReader, f func(Instr)) error {
+	var (
+		ir    = instrReader{r: r}
+		depth = 1
+	)
+	for depth > 0 {
+		ins, err := ir.readInstr()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		switch ins.Op {
+		case Op_block, Op_loop, Op_if:
+			depth++
+		case Op_else:
+			if depth == 1 {
+				return &exprError{Offset: ins.Offset, Err: errors.New("wasm: else outside of if block")}
+			}
+		case Op_end:
+			depth--
+		}
+		if f != nil {
+			f(ins)
+		}
+	}
+	return nil
+}
+
+// appendInstr appends the binary encoding of ins to b.
+func appendInstr(b []byte, ins Instr) []byte {
+	b = append(b, byte(ins.Op))
+	switch ins.Op {
+	case Op_block, Op_loop, Op_if:
+		b = append(b, byte(ins.Block))
+
+	case Op_br, Op_br_if, Op_call,
+		Op_get_local, Op_set_local, Op_tee_local, Op_get_global, Op_set_global:
+		b = appendUleb(b, uint64(ins.Index))
+
+	case Op_br_table:
+		b = appendUleb(b, uint64(len(ins.Targets)))
+		for _, l := range ins.Targets {
+			b = appendUleb(b, uint64(l))
+		}
+		b = appendUleb(b, uint64(ins.Default))
+
+	case Op_call_indirect:
+		b = appendUleb(b, uint64(ins.Index))
+		b = append(b, 0)
+
+	case Op_current_memory, Op_grow_memory:
+		b = append(b, 0)
+
+	case Op_prefix_misc:
+		b = appendUleb(b, uint64(ins.Misc))
+		switch ins.Misc {
+		case Op_memory_copy:
+			b = append(b, 0, 0)
+		case Op_memory_fill:
+			b = append(b, 0)
+		}
+
+	case Op_i32_const:
+		b = appendSleb(b, int64(ins.I32()))
+
+	case Op_i64_const:
+		b = appendSleb(b, ins.I64())
+
+	case Op_f32_const:
+		b = binary.LittleEndian.AppendUint32(b, uint32(ins.Value))
+
+	case Op_f64_const:
+		b = binary.LittleEndian.AppendUint64(b, ins.Value)
+
+	default:
+		if isMemoryAccess(ins.Op) {
+			b = appendUleb(b, uint64(ins.Mem.Align))
+			b = appendUleb(b, uint64(ins.Mem.Offset))
+		}
+	}
+	return b
+}
+
+// appendUleb appends the unsigned LEB128 encoding of v to b.
+func appendUleb(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// appendSleb appends the signed LEB128 encoding of v to b.
+func appendSleb(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// instrReader decodes instructions from a stream of bytes.
+type instrReader struct {
+	r io.ByteReader
+	n int // number of bytes read so far
+}
+
+func (ir *instrReader) readByte() (byte, error) {
+	b, err := ir.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	ir.n++
+	return b, nil
+}
+
+func (ir *instrReader) readUint(bits uint) (uint64, error) {
+	var (
+		v     uint64
+		shift uint
+	)
+	for {
+		b, err := ir.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if shift+7 > bits && (b&0x80 != 0 || uint64(b&0x7f)>>(bits-shift) != 0) {
+			return 0, errOverflow
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+		shift += 7
+	}
+}
+
+func (ir *instrReader) readInt(bits uint) (int64, error) {
+	var (
+		v     int64
+		shift uint
+	)
+	for {
+		b, err := ir.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if shift+7 > bits {
+			// the unused bits of the last byte must be a sign extension.
+			if b&0x80 != 0 {
+				return 0, errOverflow
+			}
+			ext := int8(b<<1) >> (bits - shift)
+			if ext != 0 && ext != -1 {
+				return 0, errOverflow
+			}
+		}
+		v |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v, nil
+		}
+	}
+}
+
+func (ir *instrReader) readU32() (uint32, error) {
+	v, err := ir.readUint(32)
+	return uint32(v), err
+}
+
+func (ir *instrReader) readFixed(n int) (uint64, error) {
+	var v uint64
+	for i := 0; i < n; i++ {
+		b, err := ir.readByte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b) << (8 * uint(i))
+	}
+	return v, nil
+}
+
+func (ir *instrReader) readReserved(op fmt.Stringer) error {
+	b, err := ir.readByte()
+	if err != nil {
+		return err
+	}
+	if b != 0 {
+		return fmt.Errorf("wasm: invalid reserved value (0x%02x) for %v", b, op)
+	}
+	return nil
+}
+
+func (ir *instrReader) readInstr() (Instr, error) {
+	var (
+		ins = Instr{Offset: ir.n}
+		err error
+	)
+
+	b, err := ir.readByte()
+	if err != nil {
+		return ins, err
+	}
+	ins.Op = Opcode(b)
+	if opcodeNames[ins.Op] == "" && ins.Op != Op_prefix_misc {
+		return ins, &exprError{Offset: ins.Offset, Err: fmt.Errorf("wasm: invalid opcode 0x%02x", b)}
+	}
+	if ins.Op == Op_prefix_misc {
+		var i uint32
+		i, err = ir.readU32()
+		switch {
+		case err == io.EOF:
+			return ins, err
+		case err != nil:
+			return ins, &exprError{Offset: ins.Offset, Err: fmt.Errorf("wasm: could not decode opcode: %w", err)}
+		case i > 0xff || miscOpcodeNames[i] == "":
+			return ins, &exprError{Offset: ins.Offset, Err: fmt.Errorf("wasm: invalid opcode 0x%02x 0x%02x", b, i)}
+		}
+		ins.Misc = MiscOpcode(i)
+	}
+
+	switch ins.Op {
+	case Op_block, Op_loop, Op_if:
+		var bt byte
+		bt, err = ir.readByte()
+		ins.Block = BlockType(bt)
+		if err == nil {
+			switch ins.Block {
+			case BlockTypeEmpty, BlockType(I32), BlockType(I64), BlockType(F32), BlockType(F64):
+			default:
+				err = fmt.Errorf("wasm: invalid block type 0x%02x", bt)
+			}
+		}
+
+	case Op_br, Op_br_if, Op_call,
+		Op_get_local, Op_set_local, Op_tee_local, Op_get_global, Op_set_global:
+		ins.Index, err = ir.readU32()
+
+	case Op_br_table:
+		var n uint32
+		n, err = ir.readU32()
+		if err != nil {
+			break
+		}
+		for i := uint32(0); i < n && err == nil; i++ {
+			var l uint32
+			l, err = ir.readU32()
+			ins.Targets = append(ins.Targets, l)
+		}
+		if err == nil {
+			ins.Default, err = ir.readU32()
+		}
+
+	case Op_call_indirect:
+		ins.Index, err = ir.readU32()
+		if err == nil {
+			err = ir.readReserved(ins.Op)
+		}
+
+	case Op_current_memory, Op_grow_memory:
+		err = ir.readReserved(ins.Op)
+
+	case Op_prefix_misc:
+		switch ins.Misc {
+		case Op_memory_copy:
+			err = ir.readReserved(ins.Misc)
+			if err == nil {
+				err = ir.readReserved(ins.Misc)
+			}
+		case Op_memory_fill:
+			err = ir.readReserved(ins.Misc)
+		}
+
+	case Op_i32_const:
+		var v int64
+		v, err = ir.readInt(32)
+		ins.Value = uint64(v)
+
+	case Op_i64_const:
+		var v int64
+		v, err = ir.readInt(64)
+		ins.Value = uint64(v)
+
+	case Op_f32_const:
+		ins.Value, err = ir.readFixed(4)
+
+	case Op_f64_const:
+		ins.Value, err = ir.readFixed(8)
+
+	default:
+		if isMemoryAccess(ins.Op) {
+			ins.Mem.Align, err = ir.readU32()
+			if err == nil {
+				ins.Mem.Offset, err = ir.readU32()
+			}
+		}
+	}
+
+	if err != nil && err != io.EOF {
+		err = &exprError{
+			Offset: ins.Offset,
+			Err:    fmt.Errorf("wasm: could not decode %v: %w", ins.Op, err),
+		}
+	}
+	return ins, err
+}
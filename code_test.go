@@ -0,0 +1,106 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+func TestDecodeExpr(t *testing.T) {
+	code := []byte{
+		0x02, 0x40, // block
+		0x03, 0x7f, // loop i32
+		0x20, 0x00, // get_local 0
+		0x0e, 0x02, 0x00, 0x01, 0x0b, // br_table 0 1 11
+		0x0b,       // end
+		0x41, 0x0b, // i32.const 11
+		0x41, 0x7f, // i32.const -1
+		0x42, 0x80, 0x7f, // i64.const -128
+		0x43, 0x00, 0x00, 0x80, 0x3f, // f32.const 1
+		0x28, 0x02, 0x08, // i32.load offset=8
+		0x2d, 0x02, 0x00, // i32.load8_u align=4
+		0x04, 0x40, // if
+		0x05,             // else
+		0x0b,             // end
+		0x11, 0x01, 0x00, // call_indirect 1
+		0xc0,       // i32.extend8_s
+		0xfc, 0x06, // i64.trunc_s:sat/f64
+		0xfc, 0x0a, 0x00, 0x00, // memory.copy
+		0xfc, 0x8b, 0x00, 0x00, // memory.fill, with a padded opcode
+		0x0b, // end
+		0x0b, // end
+	}
+
+	instrs, err := wasm.DecodeExpr(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, ins := range instrs {
+		got = append(got, ins.String())
+	}
+	want := []string{
+		"block",
+		"loop i32",
+		"get_local 0",
+		"br_table 0 1 11",
+		"end",
+		"i32.const 11",
+		"i32.const -1",
+		"i64.const -128",
+		"f32.const 1",
+		"i32.load offset=8",
+		"i32.load8_u align=4",
+		"if",
+		"else",
+		"end",
+		"call_indirect 1",
+		"i32.extend8_s",
+		"i64.trunc_s:sat/f64",
+		"memory.copy",
+		"memory.fill",
+		"end",
+		"end",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid instructions:\ngot= %q\nwant=%q", got, want)
+	}
+
+	if got, want := instrs[5].Offset, 12; got != want {
+		t.Fatalf("invalid offset: got=%d, want=%d", got, want)
+	}
+}
+
+func TestDecodeExprErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		code []byte
+	}{
+		{"empty", []byte{}},
+		{"missing-end", []byte{0x02, 0x40, 0x0b}},
+		{"trailing-bytes", []byte{0x01, 0x0b, 0x01}},
+		{"invalid-opcode", []byte{0xff, 0x0b}},
+		{"invalid-block-type", []byte{0x02, 0x00, 0x0b, 0x0b}},
+		{"i32-overflow", []byte{0x41, 0xff, 0xff, 0xff, 0xff, 0x4f, 0x0b}},
+		{"u32-overflow", []byte{0x20, 0xff, 0xff, 0xff, 0xff, 0x1f, 0x0b}},
+		{"truncated-immediate", []byte{0x44, 0x00, 0x00}},
+		{"else-outside-if", []byte{0x05, 0x0b}},
+		{"reserved", []byte{0x40, 0x01, 0x0b}},
+		{"invalid-misc-opcode", []byte{0xfc, 0x08, 0x0b}},
+		{"misc-opcode-overflow", []byte{0xfc, 0x80, 0x02, 0x0b}},
+		{"misc-reserved", []byte{0xfc, 0x0a, 0x00, 0x01, 0x0b}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := wasm.DecodeExpr(tc.code)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+		})
+	}
+}
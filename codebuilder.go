@@ -0,0 +1,444 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"math"
+)
+
+// CodeBuilder assembles the bytecode of a function body, or of an
+// initializer expression, one instruction at a time:
+//
+//	c := wasm.NewCodeBuilder()
+//	c.Block("done", wasm.BlockTypeEmpty).
+//		GetLocal(0).I32Eqz().BrIf("done").
+//		GetLocal(0).GetLocal(1).I32Add().SetLocal(0).
+//		End()
+//	code, err := c.Code()
+//
+// Blocks, loops and if blocks may be given a label, which branch
+// instructions reference by name; the innermost block with a given label
+// is the target of branches to that label.
+// Errors, such as mismatched end instructions or unknown labels, are
+// recorded by the CodeBuilder and reported by Code.
+type CodeBuilder struct {
+	code  []byte
+	ctrls []codeCtrl // blocks being built, innermost last
+	n     int        // number of instructions
+	err   error
+}
+
+// codeCtrl is a block, loop or if block being built.
+type codeCtrl struct {
+	op    Opcode // Op_block, Op_loop, Op_if, or Op_else once the else instruction is emitted
+	label string
+}
+
+// NewCodeBuilder returns a CodeBuilder of an empty expression.
+func NewCodeBuilder() *CodeBuilder {
+	return &CodeBuilder{}
+}
+
+// errorf records the first error of the CodeBuilder.
+func (c *CodeBuilder) errorf(format string, args ...interface{}) {
+	if c.err == nil {
+		c.err = fmt.Errorf("wasm: instruction %d: "+format, append([]interface{}{c.n}, args...)...)
+	}
+}
+
+// Code returns the bytecode assembled so far, terminated by the end
+// instruction of the expression, or the first error met while assembling
+// it. All the blocks must have been ended.
+func (c *CodeBuilder) Code() (Code, error) {
+	if c.err != nil {
+		return Code{}, c.err
+	}
+	if n := len(c.ctrls); n > 0 {
+		return Code{}, fmt.Errorf("wasm: %d unterminated blocks", n)
+	}
+	code := make([]byte, len(c.code))
+	copy(code, c.code)
+	return Code{Code: code, End: byte(Op_end)}, nil
+}
+
+// Instr appends the instruction ins, whose immediates are given by its
+// fields. Branch instructions given to Instr hold label depths rather than
+// label names.
+func (c *CodeBuilder) Instr(ins Instr) *CodeBuilder {
+	switch ins.Op {
+	case Op_block, Op_loop, Op_if:
+		c.ctrls = append(c.ctrls, codeCtrl{op: ins.Op})
+	case Op_else:
+		n := len(c.ctrls)
+		if n == 0 || c.ctrls[n-1].op != Op_if {
+			c.errorf("else outside of if block")
+			return c
+		}
+		c.ctrls[n-1].op = Op_else
+	case Op_end:
+		n := len(c.ctrls)
+		if n == 0 {
+			c.errorf("end outside of block")
+			return c
+		}
+		c.ctrls = c.ctrls[:n-1]
+	}
+	c.code = appendInstr(c.code, ins)
+	c.n++
+	return c
+}
+
+// Op appends the instruction op, which has no immediate.
+func (c *CodeBuilder) Op(op Opcode) *CodeBuilder {
+	return c.Instr(Instr{Op: op})
+}
+
+// Misc appends the instruction op, following the Op_prefix_misc prefix.
+func (c *CodeBuilder) Misc(op MiscOpcode) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_prefix_misc, Misc: op})
+}
+
+// label returns the depth of the innermost block with the given label.
+func (c *CodeBuilder) label(name string) uint32 {
+	for i := len(c.ctrls) - 1; i >= 0; i-- {
+		if c.ctrls[i].label == name && name != "" {
+			return uint32(len(c.ctrls) - 1 - i)
+		}
+	}
+	c.errorf("unknown label %q", name)
+	return 0
+}
+
+// block appends a block, loop or if instruction, starting a block with
+// the given label.
+func (c *CodeBuilder) block(op Opcode, label string, bt BlockType) *CodeBuilder {
+	c.Instr(Instr{Op: op, Block: bt})
+	c.ctrls[len(c.ctrls)-1].label = label
+	return c
+}
+
+// Control instructions.
+
+func (c *CodeBuilder) Unreachable() *CodeBuilder { return c.Op(Op_unreachable) }
+func (c *CodeBuilder) Nop() *CodeBuilder         { return c.Op(Op_nop) }
+
+// Block starts a block of signature bt, which may be labeled.
+func (c *CodeBuilder) Block(label string, bt BlockType) *CodeBuilder {
+	return c.block(Op_block, label, bt)
+}
+
+// Loop starts a loop of signature bt, which may be labeled.
+func (c *CodeBuilder) Loop(label string, bt BlockType) *CodeBuilder {
+	return c.block(Op_loop, label, bt)
+}
+
+// If starts an if block of signature bt, which may be labeled.
+func (c *CodeBuilder) If(label string, bt BlockType) *CodeBuilder {
+	return c.block(Op_if, label, bt)
+}
+
+// Else starts the else branch of the innermost if block.
+func (c *CodeBuilder) Else() *CodeBuilder { return c.Op(Op_else) }
+
+// End ends the innermost block.
+func (c *CodeBuilder) End() *CodeBuilder { return c.Op(Op_end) }
+
+// Br appends a branch to the block with the given label.
+func (c *CodeBuilder) Br(label string) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_br, Index: c.label(label)})
+}
+
+// BrIf appends a conditional branch to the block with the given label.
+func (c *CodeBuilder) BrIf(label string) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_br_if, Index: c.label(label)})
+}
+
+// BrTable appends a branch to the block whose label is selected by the
+// operand among labels, or to the block labeled def.
+func (c *CodeBuilder) BrTable(labels []string, def string) *CodeBuilder {
+	targets := make([]uint32, len(labels))
+	for i, l := range labels {
+		targets[i] = c.label(l)
+	}
+	return c.Instr(Instr{Op: Op_br_table, Targets: targets, Default: c.label(def)})
+}
+
+func (c *CodeBuilder) Return() *CodeBuilder { return c.Op(Op_return) }
+
+// Call instructions.
+
+// Call appends a call to the function of the given index.
+func (c *CodeBuilder) Call(fn uint32) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_call, Index: fn})
+}
+
+// CallIndirect appends an indirect call to a function of the signature of
+// the given type index.
+func (c *CodeBuilder) CallIndirect(typ uint32) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_call_indirect, Index: typ})
+}
+
+// Parametric instructions.
+
+func (c *CodeBuilder) Drop() *CodeBuilder   { return c.Op(Op_drop) }
+func (c *CodeBuilder) Select() *CodeBuilder { return c.Op(Op_select) }
+
+// Variable instructions.
+
+func (c *CodeBuilder) GetLocal(i uint32) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_get_local, Index: i})
+}
+
+func (c *CodeBuilder) SetLocal(i uint32) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_set_local, Index: i})
+}
+
+func (c *CodeBuilder) TeeLocal(i uint32) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_tee_local, Index: i})
+}
+
+func (c *CodeBuilder) GetGlobal(i uint32) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_get_global, Index: i})
+}
+
+func (c *CodeBuilder) SetGlobal(i uint32) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_set_global, Index: i})
+}
+
+// Memory instructions.
+// Loads and stores take the offset added to their address operand, and
+// assume the natural alignment of the access: MemAccess appends accesses
+// with other alignments.
+
+// MemAccess appends the load or store instruction op, with the memory
+// immediate m.
+func (c *CodeBuilder) MemAccess(op Opcode, m MemArg) *CodeBuilder {
+	if !isMemoryAccess(op) {
+		c.errorf("%v is not a memory access", op)
+		return c
+	}
+	if m.Align > naturalAlignment(op) {
+		c.errorf("alignment of %v must not be larger than natural", op)
+	}
+	return c.Instr(Instr{Op: op, Mem: m})
+}
+
+func (c *CodeBuilder) access(op Opcode, offset uint32) *CodeBuilder {
+	return c.Instr(Instr{Op: op, Mem: MemArg{Align: naturalAlignment(op), Offset: offset}})
+}
+
+func (c *CodeBuilder) I32Load(offset uint32) *CodeBuilder   { return c.access(Op_i32_load, offset) }
+func (c *CodeBuilder) I64Load(offset uint32) *CodeBuilder   { return c.access(Op_i64_load, offset) }
+func (c *CodeBuilder) F32Load(offset uint32) *CodeBuilder   { return c.access(Op_f32_load, offset) }
+func (c *CodeBuilder) F64Load(offset uint32) *CodeBuilder   { return c.access(Op_f64_load, offset) }
+func (c *CodeBuilder) I32Load8S(offset uint32) *CodeBuilder { return c.access(Op_i32_load8_s, offset) }
+func (c *CodeBuilder) I32Load8U(offset uint32) *CodeBuilder { return c.access(Op_i32_load8_u, offset) }
+func (c *CodeBuilder) I32Load16S(offset uint32) *CodeBuilder {
+	return c.access(Op_i32_load16_s, offset)
+}
+func (c *CodeBuilder) I32Load16U(offset uint32) *CodeBuilder {
+	return c.access(Op_i32_load16_u, offset)
+}
+func (c *CodeBuilder) I64Load8S(offset uint32) *CodeBuilder { return c.access(Op_i64_load8_s, offset) }
+func (c *CodeBuilder) I64Load8U(offset uint32) *CodeBuilder { return c.access(Op_i64_load8_u, offset) }
+func (c *CodeBuilder) I64Load16S(offset uint32) *CodeBuilder {
+	return c.access(Op_i64_load16_s, offset)
+}
+func (c *CodeBuilder) I64Load16U(offset uint32) *CodeBuilder {
+	return c.access(Op_i64_load16_u, offset)
+}
+func (c *CodeBuilder) I64Load32S(offset uint32) *CodeBuilder {
+	return c.access(Op_i64_load32_s, offset)
+}
+func (c *CodeBuilder) I64Load32U(offset uint32) *CodeBuilder {
+	return c.access(Op_i64_load32_u, offset)
+}
+func (c *CodeBuilder) I32Store(offset uint32) *CodeBuilder   { return c.access(Op_i32_store, offset) }
+func (c *CodeBuilder) I64Store(offset uint32) *CodeBuilder   { return c.access(Op_i64_store, offset) }
+func (c *CodeBuilder) F32Store(offset uint32) *CodeBuilder   { return c.access(Op_f32_store, offset) }
+func (c *CodeBuilder) F64Store(offset uint32) *CodeBuilder   { return c.access(Op_f64_store, offset) }
+func (c *CodeBuilder) I32Store8(offset uint32) *CodeBuilder  { return c.access(Op_i32_store8, offset) }
+func (c *CodeBuilder) I32Store16(offset uint32) *CodeBuilder { return c.access(Op_i32_store16, offset) }
+func (c *CodeBuilder) I64Store8(offset uint32) *CodeBuilder  { return c.access(Op_i64_store8, offset) }
+func (c *CodeBuilder) I64Store16(offset uint32) *CodeBuilder { return c.access(Op_i64_store16, offset) }
+func (c *CodeBuilder) I64Store32(offset uint32) *CodeBuilder { return c.access(Op_i64_store32, offset) }
+
+func (c *CodeBuilder) CurrentMemory() *CodeBuilder { return c.Op(Op_current_memory) }
+func (c *CodeBuilder) GrowMemory() *CodeBuilder    { return c.Op(Op_grow_memory) }
+func (c *CodeBuilder) MemoryCopy() *CodeBuilder    { return c.Misc(Op_memory_copy) }
+func (c *CodeBuilder) MemoryFill() *CodeBuilder    { return c.Misc(Op_memory_fill) }
+
+// Constants.
+
+func (c *CodeBuilder) I32Const(v int32) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_i32_const, Value: uint64(int64(v))})
+}
+
+func (c *CodeBuilder) I64Const(v int64) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_i64_const, Value: uint64(v)})
+}
+
+func (c *CodeBuilder) F32Const(v float32) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_f32_const, Value: uint64(math.Float32bits(v))})
+}
+
+func (c *CodeBuilder) F64Const(v float64) *CodeBuilder {
+	return c.Instr(Instr{Op: Op_f64_const, Value: math.Float64bits(v)})
+}
+
+// Comparison instructions.
+
+func (c *CodeBuilder) I32Eqz() *CodeBuilder { return c.Op(Op_i32_eqz) }
+func (c *CodeBuilder) I32Eq() *CodeBuilder  { return c.Op(Op_i32_eq) }
+func (c *CodeBuilder) I32Ne() *CodeBuilder  { return c.Op(Op_i32_ne) }
+func (c *CodeBuilder) I32LtS() *CodeBuilder { return c.Op(Op_i32_lt_s) }
+func (c *CodeBuilder) I32LtU() *CodeBuilder { return c.Op(Op_i32_lt_u) }
+func (c *CodeBuilder) I32GtS() *CodeBuilder { return c.Op(Op_i32_gt_s) }
+func (c *CodeBuilder) I32GtU() *CodeBuilder { return c.Op(Op_i32_gt_u) }
+func (c *CodeBuilder) I32LeS() *CodeBuilder { return c.Op(Op_i32_le_s) }
+func (c *CodeBuilder) I32LeU() *CodeBuilder { return c.Op(Op_i32_le_u) }
+func (c *CodeBuilder) I32GeS() *CodeBuilder { return c.Op(Op_i32_ge_s) }
+func (c *CodeBuilder) I32GeU() *CodeBuilder { return c.Op(Op_i32_ge_u) }
+func (c *CodeBuilder) I64Eqz() *CodeBuilder { return c.Op(Op_i64_eqz) }
+func (c *CodeBuilder) I64Eq() *CodeBuilder  { return c.Op(Op_i64_eq) }
+func (c *CodeBuilder) I64Ne() *CodeBuilder  { return c.Op(Op_i64_ne) }
+func (c *CodeBuilder) I64LtS() *CodeBuilder { return c.Op(Op_i64_lt_s) }
+func (c *CodeBuilder) I64LtU() *CodeBuilder { return c.Op(Op_i64_lt_u) }
+func (c *CodeBuilder) I64GtS() *CodeBuilder { return c.Op(Op_i64_gt_s) }
+func (c *CodeBuilder) I64GtU() *CodeBuilder { return c.Op(Op_i64_gt_u) }
+func (c *CodeBuilder) I64LeS() *CodeBuilder { return c.Op(Op_i64_le_s) }
+func (c *CodeBuilder) I64LeU() *CodeBuilder { return c.Op(Op_i64_le_u) }
+func (c *CodeBuilder) I64GeS() *CodeBuilder { return c.Op(Op_i64_ge_s) }
+func (c *CodeBuilder) I64GeU() *CodeBuilder { return c.Op(Op_i64_ge_u) }
+func (c *CodeBuilder) F32Eq() *CodeBuilder  { return c.Op(Op_f32_eq) }
+func (c *CodeBuilder) F32Ne() *CodeBuilder  { return c.Op(Op_f32_ne) }
+func (c *CodeBuilder) F32Lt() *CodeBuilder  { return c.Op(Op_f32_lt) }
+func (c *CodeBuilder) F32Gt() *CodeBuilder  { return c.Op(Op_f32_gt) }
+func (c *CodeBuilder) F32Le() *CodeBuilder  { return c.Op(Op_f32_le) }
+func (c *CodeBuilder) F32Ge() *CodeBuilder  { return c.Op(Op_f32_ge) }
+func (c *CodeBuilder) F64Eq() *CodeBuilder  { return c.Op(Op_f64_eq) }
+func (c *CodeBuilder) F64Ne() *CodeBuilder  { return c.Op(Op_f64_ne) }
+func (c *CodeBuilder) F64Lt() *CodeBuilder  { return c.Op(Op_f64_lt) }
+func (c *CodeBuilder) F64Gt() *CodeBuilder  { return c.Op(Op_f64_gt) }
+func (c *CodeBuilder) F64Le() *CodeBuilder  { return c.Op(Op_f64_le) }
+func (c *CodeBuilder) F64Ge() *CodeBuilder  { return c.Op(Op_f64_ge) }
+
+// Numeric instructions.
+
+func (c *CodeBuilder) I32Clz() *CodeBuilder      { return c.Op(Op_i32_clz) }
+func (c *CodeBuilder) I32Ctz() *CodeBuilder      { return c.Op(Op_i32_ctz) }
+func (c *CodeBuilder) I32Popcnt() *CodeBuilder   { return c.Op(Op_i32_popcnt) }
+func (c *CodeBuilder) I32Add() *CodeBuilder      { return c.Op(Op_i32_add) }
+func (c *CodeBuilder) I32Sub() *CodeBuilder      { return c.Op(Op_i32_sub) }
+func (c *CodeBuilder) I32Mul() *CodeBuilder      { return c.Op(Op_i32_mul) }
+func (c *CodeBuilder) I32DivS() *CodeBuilder     { return c.Op(Op_i32_div_s) }
+func (c *CodeBuilder) I32DivU() *CodeBuilder     { return c.Op(Op_i32_div_u) }
+func (c *CodeBuilder) I32RemS() *CodeBuilder     { return c.Op(Op_i32_rem_s) }
+func (c *CodeBuilder) I32RemU() *CodeBuilder     { return c.Op(Op_i32_rem_u) }
+func (c *CodeBuilder) I32And() *CodeBuilder      { return c.Op(Op_i32_and) }
+func (c *CodeBuilder) I32Or() *CodeBuilder       { return c.Op(Op_i32_or) }
+func (c *CodeBuilder) I32Xor() *CodeBuilder      { return c.Op(Op_i32_xor) }
+func (c *CodeBuilder) I32Shl() *CodeBuilder      { return c.Op(Op_i32_shl) }
+func (c *CodeBuilder) I32ShrS() *CodeBuilder     { return c.Op(Op_i32_shr_s) }
+func (c *CodeBuilder) I32ShrU() *CodeBuilder     { return c.Op(Op_i32_shr_u) }
+func (c *CodeBuilder) I32Rotl() *CodeBuilder     { return c.Op(Op_i32_rotl) }
+func (c *CodeBuilder) I32Rotr() *CodeBuilder     { return c.Op(Op_i32_rotr) }
+func (c *CodeBuilder) I64Clz() *CodeBuilder      { return c.Op(Op_i64_clz) }
+func (c *CodeBuilder) I64Ctz() *CodeBuilder      { return c.Op(Op_i64_ctz) }
+func (c *CodeBuilder) I64Popcnt() *CodeBuilder   { return c.Op(Op_i64_popcnt) }
+func (c *CodeBuilder) I64Add() *CodeBuilder      { return c.Op(Op_i64_add) }
+func (c *CodeBuilder) I64Sub() *CodeBuilder      { return c.Op(Op_i64_sub) }
+func (c *CodeBuilder) I64Mul() *CodeBuilder      { return c.Op(Op_i64_mul) }
+func (c *CodeBuilder) I64DivS() *CodeBuilder     { return c.Op(Op_i64_div_s) }
+func (c *CodeBuilder) I64DivU() *CodeBuilder     { return c.Op(Op_i64_div_u) }
+func (c *CodeBuilder) I64RemS() *CodeBuilder     { return c.Op(Op_i64_rem_s) }
+func (c *CodeBuilder) I64RemU() *CodeBuilder     { return c.Op(Op_i64_rem_u) }
+func (c *CodeBuilder) I64And() *CodeBuilder      { return c.Op(Op_i64_and) }
+func (c *CodeBuilder) I64Or() *CodeBuilder       { return c.Op(Op_i64_or) }
+func (c *CodeBuilder) I64Xor() *CodeBuilder      { return c.Op(Op_i64_xor) }
+func (c *CodeBuilder) I64Shl() *CodeBuilder      { return c.Op(Op_i64_shl) }
+func (c *CodeBuilder) I64ShrS() *CodeBuilder     { return c.Op(Op_i64_shr_s) }
+func (c *CodeBuilder) I64ShrU() *CodeBuilder     { return c.Op(Op_i64_shr_u) }
+func (c *CodeBuilder) I64Rotl() *CodeBuilder     { return c.Op(Op_i64_rotl) }
+func (c *CodeBuilder) I64Rotr() *CodeBuilder     { return c.Op(Op_i64_rotr) }
+func (c *CodeBuilder) F32Abs() *CodeBuilder      { return c.Op(Op_f32_abs) }
+func (c *CodeBuilder) F32Neg() *CodeBuilder      { return c.Op(Op_f32_neg) }
+func (c *CodeBuilder) F32Ceil() *CodeBuilder     { return c.Op(Op_f32_ceil) }
+func (c *CodeBuilder) F32Floor() *CodeBuilder    { return c.Op(Op_f32_floor) }
+func (c *CodeBuilder) F32Trunc() *CodeBuilder    { return c.Op(Op_f32_trunc) }
+func (c *CodeBuilder) F32Nearest() *CodeBuilder  { return c.Op(Op_f32_nearest) }
+func (c *CodeBuilder) F32Sqrt() *CodeBuilder     { return c.Op(Op_f32_sqrt) }
+func (c *CodeBuilder) F32Add() *CodeBuilder      { return c.Op(Op_f32_add) }
+func (c *CodeBuilder) F32Sub() *CodeBuilder      { return c.Op(Op_f32_sub) }
+func (c *CodeBuilder) F32Mul() *CodeBuilder      { return c.Op(Op_f32_mul) }
+func (c *CodeBuilder) F32Div() *CodeBuilder      { return c.Op(Op_f32_div) }
+func (c *CodeBuilder) F32Min() *CodeBuilder      { return c.Op(Op_f32_min) }
+func (c *CodeBuilder) F32Max() *CodeBuilder      { return c.Op(Op_f32_max) }
+func (c *CodeBuilder) F32Copysign() *CodeBuilder { return c.Op(Op_f32_copysign) }
+func (c *CodeBuilder) F64Abs() *CodeBuilder      { return c.Op(Op_f64_abs) }
+func (c *CodeBuilder) F64Neg() *CodeBuilder      { return c.Op(Op_f64_neg) }
+func (c *CodeBuilder) F64Ceil() *CodeBuilder     { return c.Op(Op_f64_ceil) }
+func (c *CodeBuilder) F64Floor() *CodeBuilder    { return c.Op(Op_f64_floor) }
+func (c *CodeBuilder) F64Trunc() *CodeBuilder    { return c.Op(Op_f64_trunc) }
+func (c *CodeBuilder) F64Nearest() *CodeBuilder  { return c.Op(Op_f64_nearest) }
+func (c *CodeBuilder) F64Sqrt() *CodeBuilder     { return c.Op(Op_f64_sqrt) }
+func (c *CodeBuilder) F64Add() *CodeBuilder      { return c.Op(Op_f64_add) }
+func (c *CodeBuilder) F64Sub() *CodeBuilder      { return c.Op(Op_f64_sub) }
+func (c *CodeBuilder) F64Mul() *CodeBuilder      { return c.Op(Op_f64_mul) }
+func (c *CodeBuilder) F64Div() *CodeBuilder      { return c.Op(Op_f64_div) }
+func (c *CodeBuilder) F64Min() *CodeBuilder      { return c.Op(Op_f64_min) }
+func (c *CodeBuilder) F64Max() *CodeBuilder      { return c.Op(Op_f64_max) }
+func (c *CodeBuilder) F64Copysign() *CodeBuilder { return c.Op(Op_f64_copysign) }
+
+// Conversions.
+
+func (c *CodeBuilder) I32WrapI64() *CodeBuilder     { return c.Op(Op_i32_wrap_i64) }
+func (c *CodeBuilder) I32TruncSF32() *CodeBuilder   { return c.Op(Op_i32_trunc_s_f32) }
+func (c *CodeBuilder) I32TruncUF32() *CodeBuilder   { return c.Op(Op_i32_trunc_u_f32) }
+func (c *CodeBuilder) I32TruncSF64() *CodeBuilder   { return c.Op(Op_i32_trunc_s_f64) }
+func (c *CodeBuilder) I32TruncUF64() *CodeBuilder   { return c.Op(Op_i32_trunc_u_f64) }
+func (c *CodeBuilder) I64ExtendSI32() *CodeBuilder  { return c.Op(Op_i64_extend_s_i32) }
+func (c *CodeBuilder) I64ExtendUI32() *CodeBuilder  { return c.Op(Op_i64_extend_u_i32) }
+func (c *CodeBuilder) I64TruncSF32() *CodeBuilder   { return c.Op(Op_i64_trunc_s_f32) }
+func (c *CodeBuilder) I64TruncUF32() *CodeBuilder   { return c.Op(Op_i64_trunc_u_f32) }
+func (c *CodeBuilder) I64TruncSF64() *CodeBuilder   { return c.Op(Op_i64_trunc_s_f64) }
+func (c *CodeBuilder) I64TruncUF64() *CodeBuilder   { return c.Op(Op_i64_trunc_u_f64) }
+func (c *CodeBuilder) F32ConvertSI32() *CodeBuilder { return c.Op(Op_f32_convert_s_i32) }
+func (c *CodeBuilder) F32ConvertUI32() *CodeBuilder { return c.Op(Op_f32_convert_u_i32) }
+func (c *CodeBuilder) F32ConvertSI64() *CodeBuilder { return c.Op(Op_f32_convert_s_i64) }
+func (c *CodeBuilder) F32ConvertUI64() *CodeBuilder { return c.Op(Op_f32_convert_u_i64) }
+func (c *CodeBuilder) F32DemoteF64() *CodeBuilder   { return c.Op(Op_f32_demote_f64) }
+func (c *CodeBuilder) F64ConvertSI32() *CodeBuilder { return c.Op(Op_f64_convert_s_i32) }
+func (c *CodeBuilder) F64ConvertUI32() *CodeBuilder { return c.Op(Op_f64_convert_u_i32) }
+func (c *CodeBuilder) F64ConvertSI64() *CodeBuilder { return c.Op(Op_f64_convert_s_i64) }
+func (c *CodeBuilder) F64ConvertUI64() *CodeBuilder { return c.Op(Op_f64_convert_u_i64) }
+func (c *CodeBuilder) F64PromoteF32() *CodeBuilder  { return c.Op(Op_f64_promote_f32) }
+
+// Reinterpretations.
+
+func (c *CodeBuilder) I32ReinterpretF32() *CodeBuilder { return c.Op(Op_i32_reinterpret_f32) }
+func (c *CodeBuilder) I64ReinterpretF64() *CodeBuilder { return c.Op(Op_i64_reinterpret_f64) }
+func (c *CodeBuilder) F32ReinterpretI32() *CodeBuilder { return c.Op(Op_f32_reinterpret_i32) }
+func (c *CodeBuilder) F64ReinterpretI64() *CodeBuilder { return c.Op(Op_f64_reinterpret_i64) }
+
+// Sign-extension instructions.
+
+func (c *CodeBuilder) I32Extend8S() *CodeBuilder  { return c.Op(Op_i32_extend8_s) }
+func (c *CodeBuilder) I32Extend16S() *CodeBuilder { return c.Op(Op_i32_extend16_s) }
+func (c *CodeBuilder) I64Extend8S() *CodeBuilder  { return c.Op(Op_i64_extend8_s) }
+func (c *CodeBuilder) I64Extend16S() *CodeBuilder { return c.Op(Op_i64_extend16_s) }
+func (c *CodeBuilder) I64Extend32S() *CodeBuilder { return c.Op(Op_i64_extend32_s) }
+
+// Saturating truncation instructions.
+
+func (c *CodeBuilder) I32TruncSatSF32() *CodeBuilder { return c.Misc(Op_i32_trunc_sat_s_f32) }
+func (c *CodeBuilder) I32TruncSatUF32() *CodeBuilder { return c.Misc(Op_i32_trunc_sat_u_f32) }
+func (c *CodeBuilder) I32TruncSatSF64() *CodeBuilder { return c.Misc(Op_i32_trunc_sat_s_f64) }
+func (c *CodeBuilder) I32TruncSatUF64() *CodeBuilder { return c.Misc(Op_i32_trunc_sat_u_f64) }
+func (c *CodeBuilder) I64TruncSatSF32() *CodeBuilder { return c.Misc(Op_i64_trunc_sat_s_f32) }
+func (c *CodeBuilder) I64TruncSatUF32() *CodeBuilder { return c.Misc(Op_i64_trunc_sat_u_f32) }
+func (c *CodeBuilder) I64TruncSatSF64() *CodeBuilder { return c.Misc(Op_i64_trunc_sat_s_f64) }
+func (c *CodeBuilder) I64TruncSatUF64() *CodeBuilder { return c.Misc(Op_i64_trunc_sat_u_f64) }
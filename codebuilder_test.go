@@ -0,0 +1,173 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+func TestCodeBuilder(t *testing.T) {
+	const src = `(module
+  (memory 1)
+  (func (param i32 i32) (result f64)
+    (local i64)
+    block $done
+      loop $loop
+        get_local 0
+        i32.eqz
+        br_if $done
+        get_local 0
+        get_local 1
+        i32.add
+        set_local 0
+        get_local 1
+        i32.load8_u offset=4
+        i64.load align=4
+        drop
+        get_local 1
+        i64.const -129
+        i64.store32 offset=65536
+        block $a
+          block $b
+            get_local 0
+            br_table $a $b $loop
+          end
+        end
+        br $loop
+      end
+    end
+    get_local 0
+    if $if (result f64)
+      f64.const 1.5
+    else
+      f32.const -0.25
+      f64.promote/f32
+    end
+    f32.const 3e9
+    i32.trunc_s:sat/f32
+    drop))`
+	want, err := wasm.ParseText([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := wasm.NewCodeBuilder()
+	c.Block("done", wasm.BlockTypeEmpty).
+		Loop("loop", wasm.BlockTypeEmpty).
+		GetLocal(0).I32Eqz().BrIf("done").
+		GetLocal(0).GetLocal(1).I32Add().SetLocal(0).
+		GetLocal(1).I32Load8U(4).
+		MemAccess(wasm.Op_i64_load, wasm.MemArg{Align: 2}).Drop().
+		GetLocal(1).I64Const(-129).I64Store32(65536).
+		Block("a", wasm.BlockTypeEmpty).
+		Block("b", wasm.BlockTypeEmpty).
+		GetLocal(0).BrTable([]string{"a", "b"}, "loop").
+		End().
+		End().
+		Br("loop").
+		End().
+		End().
+		GetLocal(0).
+		If("if", wasm.BlockType(wasm.F64)).
+		F64Const(1.5).
+		Else().
+		F32Const(-0.25).F64PromoteF32().
+		End().
+		F32Const(3e9).I32TruncSatSF32().Drop()
+	code, err := c.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := wasm.NewBuilder()
+	b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+	b.FuncCode(wasm.FuncType{
+		Params:  []wasm.ValueType{wasm.I32, wasm.I32},
+		Results: []wasm.ValueType{wasm.F64},
+	}, []wasm.ValueType{wasm.I64}, code)
+	m, err := b.Module()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wasm.Validate(m); err != nil {
+		t.Fatal(err)
+	}
+	var got, exp bytes.Buffer
+	if err := wasm.Encode(*m, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := wasm.Encode(*want, &exp); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), exp.Bytes()) {
+		t.Errorf("encoded module:\ngot:  %x\nwant: %x", got.Bytes(), exp.Bytes())
+	}
+}
+
+func TestCodeBuilderErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		build func(c *wasm.CodeBuilder)
+		want  string
+	}{
+		{
+			name:  "unknown-label",
+			build: func(c *wasm.CodeBuilder) { c.Nop().Block("a", wasm.BlockTypeEmpty).Br("b").End() },
+			want:  `wasm: instruction 2: unknown label "b"`,
+		},
+		{
+			name:  "unlabeled",
+			build: func(c *wasm.CodeBuilder) { c.Block("", wasm.BlockTypeEmpty).Br("").End() },
+			want:  `wasm: instruction 1: unknown label ""`,
+		},
+		{
+			name:  "out-of-scope",
+			build: func(c *wasm.CodeBuilder) { c.Block("a", wasm.BlockTypeEmpty).End().Br("a") },
+			want:  `wasm: instruction 2: unknown label "a"`,
+		},
+		{
+			name:  "else",
+			build: func(c *wasm.CodeBuilder) { c.Block("", wasm.BlockTypeEmpty).Else().End() },
+			want:  "wasm: instruction 1: else outside of if block",
+		},
+		{
+			name:  "double-else",
+			build: func(c *wasm.CodeBuilder) { c.I32Const(1).If("", wasm.BlockTypeEmpty).Else().Else().End() },
+			want:  "wasm: instruction 3: else outside of if block",
+		},
+		{
+			name:  "end",
+			build: func(c *wasm.CodeBuilder) { c.Nop().End() },
+			want:  "wasm: instruction 1: end outside of block",
+		},
+		{
+			name:  "unterminated",
+			build: func(c *wasm.CodeBuilder) { c.Block("", wasm.BlockTypeEmpty).Loop("", wasm.BlockTypeEmpty) },
+			want:  "wasm: 2 unterminated blocks",
+		},
+		{
+			name:  "memory-access",
+			build: func(c *wasm.CodeBuilder) { c.MemAccess(wasm.Op_i32_add, wasm.MemArg{}) },
+			want:  "wasm: instruction 0: i32.add is not a memory access",
+		},
+		{
+			name:  "alignment",
+			build: func(c *wasm.CodeBuilder) { c.I32Const(0).MemAccess(wasm.Op_i32_load16_u, wasm.MemArg{Align: 2}) },
+			want:  "wasm: instruction 1: alignment of i32.load16_u must not be larger than natural",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := wasm.NewCodeBuilder()
+			tc.build(c)
+			_, err := c.Code()
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
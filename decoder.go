@@ -5,25 +5,199 @@
 package wasm
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
+	"os"
 )
 
+// Decode reads a WebAssembly module from r.
 func Decode(r io.Reader) (*Module, error) {
-	d := decoder{r: r}
+	return DecodeOptions{}.Decode(r)
+}
+
+// DecodeBytes decodes the WebAssembly module held in buf.
+// The bytecode of the functions, the data segments and the payload of the
+// custom sections of the returned module are not copied, but reference
+// buf: buf must not be modified while the module is in use.
+func DecodeBytes(buf []byte) (*Module, error) {
+	return DecodeOptions{}.DecodeBytes(buf)
+}
+
+// DecodeReaderAt decodes the WebAssembly module of the given size read
+// from r.
+// Each section is read from r at once, and decoded like by DecodeBytes.
+func DecodeReaderAt(r io.ReaderAt, size int64) (*Module, error) {
+	return DecodeOptions{}.DecodeReaderAt(r, size)
+}
+
+// DecodeOptions controls how modules are decoded.
+type DecodeOptions struct {
+	// Lazy defers the decoding of sections until they are accessed: the
+	// decoder only records the layout of the sections of the module, and
+	// the sections are held by *LazySection values.
+	Lazy bool
+}
+
+// Decode reads a WebAssembly module from r.
+// Modules decoded lazily are read in memory at once.
+func (opts DecodeOptions) Decode(r io.Reader) (*Module, error) {
+	if opts.Lazy {
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return opts.DecodeBytes(buf)
+	}
+	return decodeModule(newDecoder(r))
+}
+
+// DecodeBytes decodes the WebAssembly module held in buf, like the
+// DecodeBytes function.
+func (opts DecodeOptions) DecodeBytes(buf []byte) (*Module, error) {
+	d := newDecoderBytes(buf)
+	if opts.Lazy {
+		d.src = &source{buf: buf}
+	}
+	return decodeModule(d)
+}
+
+// DecodeReaderAt decodes the WebAssembly module of the given size read
+// from r, like the DecodeReaderAt function.
+// The sections of modules decoded lazily are read from r when they are
+// accessed.
+func (opts DecodeOptions) DecodeReaderAt(r io.ReaderAt, size int64) (*Module, error) {
+	d := newDecoderReaderAt(r, size)
+	if opts.Lazy {
+		d.src = &source{ra: r, size: size}
+	}
+	return decodeModule(d)
+}
+
+// Open decodes the WebAssembly module stored in the named file, like the
+// Open function.
+// The file of modules decoded lazily remains open, for their sections to
+// be read when they are accessed, until the module is closed.
+func (opts DecodeOptions) Open(name string) (*Module, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	m, err := opts.DecodeReaderAt(f, fi.Size())
+	if err != nil || !opts.Lazy {
+		f.Close()
+		return m, err
+	}
+	m.closer = f
+	return m, nil
+}
+
+func decodeModule(d *decoder) (*Module, error) {
 	m, err := d.readModule()
 	if err != nil {
-		return nil, err // TODO: wrap?
+		return nil, err
 	}
 	return &m, nil
 }
 
 type decoder struct {
 	r   io.Reader
+	cr  offsetReader
+	src *source // content of a module decoded lazily, or nil
 	err error
+
+	// context of the current entry, to report errors.
+	section int   // ID of the current section, -1 for the module header
+	entry   int   // index of the current entry within the section, or -1
+	errOff  int64 // position of the error, if not the current offset, or -1
+}
+
+func newDecoder(r io.Reader) *decoder {
+	cr := &countReader{r: r}
+	return &decoder{r: cr, cr: cr, section: -1, entry: -1, errOff: -1}
+}
+
+func newDecoderBytes(buf []byte) *decoder {
+	c := &cursor{buf: buf}
+	return &decoder{r: c, cr: c, section: -1, entry: -1, errOff: -1}
+}
+
+func newDecoderReaderAt(r io.ReaderAt, size int64) *decoder {
+	c := &cursor{ra: r, size: size}
+	return &decoder{r: c, cr: c, section: -1, entry: -1, errOff: -1}
+}
+
+// enter records that the i-th entry of the current section is being decoded.
+func (d *decoder) enter(i int) {
+	if d.err != nil {
+		return
+	}
+	d.entry = i
+}
+
+// exprErr records the error that occurred while decoding the expression
+// starting at offset.
+func (d *decoder) exprErr(offset int64, err error) {
+	d.err = err
+	if e, ok := err.(*exprError); ok {
+		d.errOff = offset + int64(e.Offset)
+		d.err = e.Err
+	}
+}
+
+// wrap returns the current error, annotated with its context.
+func (d *decoder) wrap() error {
+	if d.err == nil {
+		return nil
+	}
+	if _, ok := d.err.(*DecodeError); ok {
+		return d.err
+	}
+	err := &DecodeError{
+		Offset:  d.offset(),
+		Section: d.section,
+		Entry:   d.entry,
+		Err:     d.err,
+	}
+	if d.errOff >= 0 {
+		err.Offset = d.errOff
+	}
+	return err
+}
+
+// offset returns the number of bytes consumed so far from the module.
+func (d *decoder) offset() int64 {
+	return d.cr.offset()
+}
+
+// countReader counts the number of bytes read from an io.Reader.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countReader) ReadByte() (byte, error) {
+	b, err := readByte(cr.r)
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
+
+func (cr *countReader) offset() int64 {
+	return cr.n
 }
 
 func (d *decoder) readVarI7(r io.Reader, v *int32) {
@@ -61,8 +235,11 @@ func (d *decoder) readString(r io.Reader, s *string) {
 	}
 	var sz uint32
 	d.readVarU32(r, &sz)
-	var buf = make([]byte, sz)
-	d.read(r, buf)
+	if d.err != nil {
+		return
+	}
+	var buf []byte
+	buf, d.err = next(r, int(sz))
 	*s = string(buf)
 }
 
@@ -70,29 +247,25 @@ func (d *decoder) read(r io.Reader, buf []byte) {
 	if d.err != nil || len(buf) == 0 {
 		return
 	}
-	_, d.err = r.Read(buf)
+	_, d.err = io.ReadFull(r, buf)
 }
 
 func (d *decoder) readModule() (Module, error) {
-	var (
-		m   Module
-		err error
-	)
+	var m Module
 
 	if d.err != nil {
-		err = d.err
-		return m, err
+		return m, d.wrap()
 	}
 
 	d.readHeader(d.r, &m.Header)
 	for {
-		s := d.readSection()
+		s, layout := d.readSection()
 		if s == nil {
-			return m, d.err
+			return m, d.wrap()
 		}
 		m.Sections = append(m.Sections, s)
+		m.Layout = append(m.Layout, layout)
 	}
-	return m, d.err
 }
 
 func (d *decoder) readHeader(r io.Reader, hdr *ModuleHeader) {
@@ -101,6 +274,9 @@ func (d *decoder) readHeader(r io.Reader, hdr *ModuleHeader) {
 	}
 	d.err = binary.Read(r, order, hdr)
 	if d.err != nil {
+		if d.err == io.EOF {
+			d.err = io.ErrUnexpectedEOF
+		}
 		return
 	}
 
@@ -108,158 +284,262 @@ func (d *decoder) readHeader(r io.Reader, hdr *ModuleHeader) {
 		d.err = fmt.Errorf("wasm: invalid magic number (%q)", string(hdr.Magic[:]))
 		return
 	}
+	if !supportedVersion(hdr.Version) {
+		d.errOff = int64(len(hdr.Magic))
+		d.err = fmt.Errorf("wasm: unsupported version (0x%x)", hdr.Version)
+		return
+	}
 }
 
-func (d *decoder) readSection() Section {
+func (d *decoder) readSection() (Section, SectionLayout) {
 	var (
-		id  uint32
-		sz  uint32
-		sec Section
+		id     uint32
+		sz     uint32
+		layout = SectionLayout{Offset: d.offset()}
 	)
 
+	if d.err != nil {
+		return nil, layout
+	}
+
 	d.readVarU32(d.r, &id)
 	if d.err != nil {
-		if d.err == io.EOF {
+		if d.err == io.EOF && d.offset() == layout.Offset {
+			// no more sections.
 			d.err = nil
 		}
-		return nil
+		return nil, layout
 	}
+	d.section = int(id)
+	d.entry = -1
 	d.readVarU32(d.r, &sz)
+	layout.Payload = d.offset()
+	layout.Size = sz
+
+	if d.src != nil {
+		return d.skipSection(SectionID(id), layout), layout
+	}
+	return d.readPayload(SectionID(id), d.limit(sz)), layout
+}
+
+// limit returns a reader of the next n bytes of the module, reading them
+// at once from modules held in an io.ReaderAt.
+func (d *decoder) limit(n uint32) *limitedReader {
+	if c, ok := d.r.(*cursor); ok && d.err == nil {
+		d.err = c.fill(int(n))
+	}
+	return &limitedReader{r: d.r, n: int64(n)}
+}
+
+// readPayload decodes the payload of a section from r.
+func (d *decoder) readPayload(id SectionID, r *limitedReader) Section {
+	if d.err != nil {
+		return nil
+	}
 
-	r := &io.LimitedReader{R: d.r, N: int64(sz)}
-	switch SectionID(id) {
+	var sec Section
+	switch id {
 	case UnknownID:
-		var s NameSection
-		d.readNameSection(r, &s)
-		// fmt.Printf("--- name: %q, funcs: %d\n", s.name, len(s.funcs))
+		var s CustomSection
+		d.readCustomSection(r, &s)
 		sec = s
 
 	case TypeID:
 		var s TypeSection
 		d.readTypeSection(r, &s)
-		// fmt.Printf("--- types: %d\n", len(s.types))
 		sec = s
 
 	case ImportID:
 		var s ImportSection
 		d.readImportSection(r, &s)
-		// fmt.Printf("--- imports: %d\n", len(s.imports))
-		/*
-			for ii, imp := range s.imports {
-				fmt.Printf("    entry[%d]: %q|%q|%x\n", ii, imp.module, imp.field, imp.kind)
-			}
-		*/
 		sec = s
 
 	case FunctionID:
 		var s FunctionSection
 		d.readFunctionSection(r, &s)
-		// fmt.Printf("--- functions: %d\n", len(s.types))
 		sec = s
 
 	case TableID:
 		var s TableSection
 		d.readTableSection(r, &s)
-		// fmt.Printf("--- tables: %d\n", len(s.tables))
 		sec = s
 
 	case MemoryID:
 		var s MemorySection
 		d.readMemorySection(r, &s)
-		// fmt.Printf("--- memories: %d\n", len(s.memories))
 		sec = s
 
 	case GlobalID:
 		var s GlobalSection
 		d.readGlobalSection(r, &s)
-		// fmt.Printf("--- globals: %d\n", len(s.globals))
-		/*
-			for ii, ge := range s.globals {
-				fmt.Printf("   ge[%d]: type={%x, 0x%x} init=%d\n",
-					ii, ge.Type.ContentType, ge.Type.Mutability, len(ge.Init.Expr),
-				)
-			}
-		*/
 		sec = s
 
 	case ExportID:
 		var s ExportSection
 		d.readExportSection(r, &s)
-		// fmt.Printf("--- exports: %d\n", len(s.exports))
 		sec = s
 
 	case StartID:
 		var s StartSection
 		d.readStartSection(r, &s)
-		// fmt.Printf("--- start: 0x%x\n", s.Index)
 		sec = s
 
 	case ElementID:
 		var s ElementSection
 		d.readElementSection(r, &s)
-		// fmt.Printf("--- elements: %d\n", len(s.elements))
 		sec = s
 
 	case CodeID:
 		var s CodeSection
 		d.readCodeSection(r, &s)
-		// fmt.Printf("--- func-bodies: %d\n", len(s.Bodies))
 		sec = s
 
 	case DataID:
 		var s DataSection
 		d.readDataSection(r, &s)
-		// fmt.Printf("--- data-segments: %d\n", len(s.segments))
 		sec = s
 
 	default:
-		d.err = fmt.Errorf("wasm: invalid section ID")
+		d.err = fmt.Errorf("wasm: invalid section ID (%d)", id)
+	}
 
+	if d.err != nil {
+		if d.err == io.EOF {
+			d.err = io.ErrUnexpectedEOF
+		}
+		return nil
 	}
 
-	if r.N != 0 {
-		log.Printf("wasm: N=%d bytes unread! (section=%d)\n", r.N, sec.ID())
-		buf := make([]byte, r.N)
-		d.read(r, buf)
+	if r.n != 0 {
+		d.entry = -1
+		d.err = fmt.Errorf("wasm: unexpected data at the end of the section (%d bytes)", r.n)
+		return nil
 	}
 
 	return sec
 }
 
-func (d *decoder) readNameSection(r io.Reader, s *NameSection) {
+// skipSection skips the payload of a section of a module decoded lazily,
+// and returns the section to decode later.
+func (d *decoder) skipSection(id SectionID, layout SectionLayout) Section {
+	if d.err != nil {
+		return nil
+	}
+	if id > DataID {
+		d.err = fmt.Errorf("wasm: invalid section ID (%d)", id)
+		return nil
+	}
+
+	s := &LazySection{id: id, layout: layout, src: d.src}
+	r := &limitedReader{r: d.r, n: int64(layout.Size)}
+	if id == UnknownID {
+		d.readString(r, &s.name)
+	}
+	if d.err == nil {
+		d.err = r.skip(r.n)
+	}
+	if d.err != nil {
+		if d.err == io.EOF {
+			d.err = io.ErrUnexpectedEOF
+		}
+		return nil
+	}
+	return s
+}
+
+func (d *decoder) readCustomSection(r *limitedReader, s *CustomSection) {
 	if d.err != nil {
 		return
 	}
 
-	d.readString(r, &s.name)
-	var n uint32
-	d.readVarU32(r, &n)
-	s.funcs = make([]FunctionNames, int(n))
-	for i := range s.funcs {
-		d.readFunctionNames(r, &s.funcs[i])
+	d.readString(r, &s.Name)
+	if d.err != nil {
+		return
+	}
+	s.Offset = d.offset()
+	s.Payload, d.err = readAll(r)
+}
+
+// readNameSection decodes the payload of the "name" custom section.
+func (d *decoder) readNameSection(r *limitedReader, s *NameSection) {
+	if d.err != nil {
+		return
+	}
+
+	for i := 0; r.n > 0 && d.err == nil; i++ {
+		d.enter(i)
+		var (
+			id [1]byte
+			sz uint32
+		)
+		d.read(r, id[:])
+		d.readVarU32(r, &sz)
+		sub := &limitedReader{r: r, n: int64(sz)}
+		switch id[0] {
+		case ModuleNameID:
+			d.readString(sub, &s.Module)
+		case FunctionNamesID:
+			d.readNameMap(sub, &s.Functions)
+		case LocalNamesID:
+			d.readIndirectNameMap(sub, &s.Locals)
+		case LabelNamesID:
+			d.readIndirectNameMap(sub, &s.Labels)
+		case TypeNamesID:
+			d.readNameMap(sub, &s.Types)
+		case TableNamesID:
+			d.readNameMap(sub, &s.Tables)
+		case MemoryNamesID:
+			d.readNameMap(sub, &s.Memories)
+		case GlobalNamesID:
+			d.readNameMap(sub, &s.Globals)
+		case ElemNamesID:
+			d.readNameMap(sub, &s.Elements)
+		case DataNamesID:
+			d.readNameMap(sub, &s.Data)
+		default:
+			if d.err != nil {
+				break
+			}
+			ns := NameSubsection{ID: id[0]}
+			ns.Payload, d.err = next(sub, int(sz))
+			s.Unknown = append(s.Unknown, ns)
+		}
+		if d.err == nil && sub.n != 0 {
+			d.err = fmt.Errorf("wasm: %d trailing bytes in name subsection %d", sub.n, id[0])
+		}
 	}
 }
 
-func (d *decoder) readFunctionNames(r io.Reader, f *FunctionNames) {
+func (d *decoder) readNameMap(r io.Reader, m *NameMap) {
 	if d.err != nil {
 		return
 	}
 
-	d.readString(r, &f.name)
 	var n uint32
 	d.readVarU32(r, &n)
-	f.locals = make([]LocalName, int(n))
-	for i := range f.locals {
-		d.readLocalName(r, &f.locals[i])
+	*m = make(NameMap, 0, n&0xffff)
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		var na Naming
+		d.readVarU32(r, &na.Index)
+		d.readString(r, &na.Name)
+		*m = append(*m, na)
 	}
 }
 
-func (d *decoder) readLocalName(r io.Reader, local *LocalName) {
+func (d *decoder) readIndirectNameMap(r io.Reader, m *IndirectNameMap) {
 	if d.err != nil {
 		return
 	}
 
-	d.readString(r, &local.name)
+	var n uint32
+	d.readVarU32(r, &n)
+	*m = make(IndirectNameMap, 0, n&0xffff)
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		var na IndirectNaming
+		d.readVarU32(r, &na.Index)
+		d.readNameMap(r, &na.Names)
+		*m = append(*m, na)
+	}
 }
 
 func (d *decoder) readTypeSection(r io.Reader, s *TypeSection) {
@@ -269,9 +549,12 @@ func (d *decoder) readTypeSection(r io.Reader, s *TypeSection) {
 
 	var n uint32
 	d.readVarU32(r, &n)
-	s.types = make([]FuncType, int(n))
-	for i := range s.types {
-		d.readFuncType(r, &s.types[i])
+	s.Types = make([]FuncType, 0, n&0xffff)
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		d.enter(int(i))
+		var ft FuncType
+		d.readFuncType(r, &ft)
+		s.Types = append(s.Types, ft)
 	}
 }
 
@@ -280,20 +563,24 @@ func (d *decoder) readFuncType(r io.Reader, ft *FuncType) {
 		return
 	}
 
-	d.readValueType(r, &ft.form)
+	d.readValueType(r, &ft.Form)
 
 	var params uint32
 	d.readVarU32(r, &params)
-	ft.params = make([]ValueType, int(params))
-	for i := range ft.params {
-		d.readValueType(r, &ft.params[i])
+	ft.Params = make([]ValueType, 0, params&0xffff)
+	for i := uint32(0); i < params && d.err == nil; i++ {
+		var vt ValueType
+		d.readValueType(r, &vt)
+		ft.Params = append(ft.Params, vt)
 	}
 
 	var results uint32
 	d.readVarU32(r, &results)
-	ft.results = make([]ValueType, int(results))
-	for i := range ft.results {
-		d.readValueType(r, &ft.results[i])
+	ft.Results = make([]ValueType, 0, results&0xffff)
+	for i := uint32(0); i < results && d.err == nil; i++ {
+		var vt ValueType
+		d.readValueType(r, &vt)
+		ft.Results = append(ft.Results, vt)
 	}
 }
 
@@ -314,9 +601,12 @@ func (d *decoder) readImportSection(r io.Reader, s *ImportSection) {
 
 	var sz uint32
 	d.readVarU32(r, &sz)
-	s.imports = make([]ImportEntry, int(sz))
-	for i := range s.imports {
-		d.readImportEntry(r, &s.imports[i])
+	s.Imports = make([]ImportEntry, 0, sz&0xffff)
+	for i := uint32(0); i < sz && d.err == nil; i++ {
+		d.enter(int(i))
+		var ie ImportEntry
+		d.readImportEntry(r, &ie)
+		s.Imports = append(s.Imports, ie)
 	}
 }
 
@@ -325,34 +615,25 @@ func (d *decoder) readImportEntry(r io.Reader, ie *ImportEntry) {
 		return
 	}
 
-	d.readString(r, &ie.module)
-	d.readString(r, &ie.field)
-	d.readExternalKind(r, &ie.kind)
+	d.readString(r, &ie.Module)
+	d.readString(r, &ie.Field)
+	d.readExternalKind(r, &ie.Kind)
 
-	switch ie.kind {
+	switch ie.Kind {
 	case FunctionKind:
-		var idx uint32
-		d.readVarU32(r, &idx)
-		ie.typ = idx
+		d.readVarU32(r, &ie.TypeIndex)
 
 	case TableKind:
-		var tt TableType
-		d.readTableType(r, &tt)
-		ie.typ = tt
+		d.readTableType(r, &ie.Table)
 
 	case MemoryKind:
-		var mt MemoryType
-		d.readMemoryType(r, &mt)
-		ie.typ = mt
+		d.readMemoryType(r, &ie.Memory)
 
 	case GlobalKind:
-		var gt GlobalType
-		d.readGlobalType(r, &gt)
-		ie.typ = gt
+		d.readGlobalType(r, &ie.Global)
 
 	default:
-		fmt.Printf("module=%q field=%q\n", ie.module, ie.field)
-		d.err = fmt.Errorf("wasm: invalid ExternalKind (%d)", byte(ie.kind))
+		d.err = fmt.Errorf("wasm: invalid ExternalKind (%d)", byte(ie.Kind))
 	}
 }
 
@@ -380,9 +661,9 @@ func (d *decoder) readElemType(r io.Reader, et *ElemType) {
 		return
 	}
 
-	var v int32
-	d.readVarI7(r, &v)
-	*et = ElemType(v)
+	var v [1]byte
+	d.read(r, v[:])
+	*et = ElemType(v[0])
 }
 
 func (d *decoder) readResizableLimits(r io.Reader, tl *ResizableLimits) {
@@ -411,9 +692,7 @@ func (d *decoder) readGlobalType(r io.Reader, gt *GlobalType) {
 	}
 
 	d.readValueType(r, &gt.ContentType)
-	var mut uint32
-	d.readVarU1(r, &mut)
-	gt.Mutability = varuint1(mut)
+	d.readVarU1(r, &gt.Mutability)
 }
 
 func (d *decoder) readFunctionSection(r io.Reader, s *FunctionSection) {
@@ -423,9 +702,12 @@ func (d *decoder) readFunctionSection(r io.Reader, s *FunctionSection) {
 
 	var sz uint32
 	d.readVarU32(r, &sz)
-	s.types = make([]uint32, int(sz))
-	for i := range s.types {
-		d.readVarU32(r, &s.types[i])
+	s.Types = make([]uint32, 0, sz&0xffff)
+	for i := uint32(0); i < sz && d.err == nil; i++ {
+		d.enter(int(i))
+		var idx uint32
+		d.readVarU32(r, &idx)
+		s.Types = append(s.Types, idx)
 	}
 }
 
@@ -436,9 +718,12 @@ func (d *decoder) readTableSection(r io.Reader, s *TableSection) {
 
 	var sz uint32
 	d.readVarU32(r, &sz)
-	s.tables = make([]TableType, int(sz))
-	for i := range s.tables {
-		d.readTableType(r, &s.tables[i])
+	s.Tables = make([]TableType, 0, sz&0xffff)
+	for i := uint32(0); i < sz && d.err == nil; i++ {
+		d.enter(int(i))
+		var tt TableType
+		d.readTableType(r, &tt)
+		s.Tables = append(s.Tables, tt)
 	}
 }
 
@@ -449,9 +734,12 @@ func (d *decoder) readMemorySection(r io.Reader, s *MemorySection) {
 
 	var sz uint32
 	d.readVarU32(r, &sz)
-	s.memories = make([]MemoryType, int(sz))
-	for i := range s.memories {
-		d.readMemoryType(r, &s.memories[i])
+	s.Memories = make([]MemoryType, 0, sz&0xffff)
+	for i := uint32(0); i < sz && d.err == nil; i++ {
+		d.enter(int(i))
+		var mt MemoryType
+		d.readMemoryType(r, &mt)
+		s.Memories = append(s.Memories, mt)
 	}
 }
 
@@ -462,9 +750,12 @@ func (d *decoder) readGlobalSection(r io.Reader, s *GlobalSection) {
 
 	var sz uint32
 	d.readVarU32(r, &sz)
-	s.globals = make([]GlobalVariable, int(sz))
-	for i := range s.globals {
-		d.readGlobalVariable(r, &s.globals[i])
+	s.Globals = make([]GlobalVariable, 0, sz&0xffff)
+	for i := uint32(0); i < sz && d.err == nil; i++ {
+		d.enter(int(i))
+		var gv GlobalVariable
+		d.readGlobalVariable(r, &gv)
+		s.Globals = append(s.Globals, gv)
 	}
 }
 
@@ -473,8 +764,6 @@ func (d *decoder) readGlobalVariable(r io.Reader, gv *GlobalVariable) {
 		return
 	}
 
-	out := new(bytes.Buffer)
-	r = io.TeeReader(r, out)
 	d.readGlobalType(r, &gv.Type)
 	d.readInitExpr(r, &gv.Init)
 }
@@ -484,27 +773,33 @@ func (d *decoder) readInitExpr(r io.Reader, ie *InitExpr) {
 		return
 	}
 
-	var err error
-	var n int
-	var buf [1]byte
-	for {
-		n, err = r.Read(buf[:])
-		if err != nil || n <= 0 {
-			break
-		}
-		v := buf[0]
-		if v == Op_end {
-			ie.End = byte(Op_end)
-			break
-		}
-		ie.Expr = append(ie.Expr, v)
-	}
-	if err == io.EOF {
-		err = nil
+	var (
+		br     = byteRecorder{r: r}
+		offset = d.offset()
+		err    error
+	)
+	err = decodeExpr(&br, nil)
+	if err != nil {
+		d.exprErr(offset, err)
+		return
 	}
+	ie.Expr = br.buf[:len(br.buf)-1]
+	ie.End = br.buf[len(br.buf)-1]
+}
+
+// byteRecorder is an io.ByteReader keeping a copy of all the bytes it read.
+type byteRecorder struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (br *byteRecorder) ReadByte() (byte, error) {
+	b, err := readByte(br.r)
 	if err != nil {
-		d.err = err
+		return 0, err
 	}
+	br.buf = append(br.buf, b)
+	return b, nil
 }
 
 func (d *decoder) readExportSection(r io.Reader, s *ExportSection) {
@@ -514,9 +809,12 @@ func (d *decoder) readExportSection(r io.Reader, s *ExportSection) {
 
 	var sz uint32
 	d.readVarU32(r, &sz)
-	s.exports = make([]ExportEntry, int(sz))
-	for i := range s.exports {
-		d.readExportEntry(r, &s.exports[i])
+	s.Exports = make([]ExportEntry, 0, sz&0xffff)
+	for i := uint32(0); i < sz && d.err == nil; i++ {
+		d.enter(int(i))
+		var ee ExportEntry
+		d.readExportEntry(r, &ee)
+		s.Exports = append(s.Exports, ee)
 	}
 }
 
@@ -525,9 +823,9 @@ func (d *decoder) readExportEntry(r io.Reader, ee *ExportEntry) {
 		return
 	}
 
-	d.readString(r, &ee.field)
-	d.readExternalKind(r, &ee.kind)
-	d.readVarU32(r, &ee.index)
+	d.readString(r, &ee.Field)
+	d.readExternalKind(r, &ee.Kind)
+	d.readVarU32(r, &ee.Index)
 }
 
 func (d *decoder) readStartSection(r io.Reader, s *StartSection) {
@@ -545,9 +843,12 @@ func (d *decoder) readElementSection(r io.Reader, s *ElementSection) {
 
 	var sz uint32
 	d.readVarU32(r, &sz)
-	s.elements = make([]ElemSegment, int(sz))
-	for i := range s.elements {
-		d.readElemSegment(r, &s.elements[i])
+	s.Elements = make([]ElemSegment, 0, sz&0xffff)
+	for i := uint32(0); i < sz && d.err == nil; i++ {
+		d.enter(int(i))
+		var es ElemSegment
+		d.readElemSegment(r, &es)
+		s.Elements = append(s.Elements, es)
 	}
 }
 
@@ -561,9 +862,11 @@ func (d *decoder) readElemSegment(r io.Reader, es *ElemSegment) {
 
 	var sz uint32
 	d.readVarU32(r, &sz)
-	es.Elems = make([]uint32, int(sz))
-	for i := range es.Elems {
-		d.readVarU32(r, &es.Elems[i])
+	es.Elems = make([]uint32, 0, sz&0xffff)
+	for i := uint32(0); i < sz && d.err == nil; i++ {
+		var idx uint32
+		d.readVarU32(r, &idx)
+		es.Elems = append(es.Elems, idx)
 	}
 }
 
@@ -574,9 +877,12 @@ func (d *decoder) readCodeSection(r io.Reader, s *CodeSection) {
 
 	var sz uint32
 	d.readVarU32(r, &sz)
-	s.Bodies = make([]FunctionBody, int(sz))
-	for i := range s.Bodies {
-		d.readFunctionBody(r, &s.Bodies[i])
+	s.Bodies = make([]FunctionBody, 0, sz&0xffff)
+	for i := uint32(0); i < sz && d.err == nil; i++ {
+		d.enter(int(i))
+		var fb FunctionBody
+		d.readFunctionBody(r, &fb)
+		s.Bodies = append(s.Bodies, fb)
 	}
 }
 
@@ -585,18 +891,21 @@ func (d *decoder) readFunctionBody(r io.Reader, fb *FunctionBody) {
 		return
 	}
 
+	start := d.offset()
 	d.readVarU32(r, &fb.BodySize)
-	r = io.LimitReader(r, int64(fb.BodySize))
+	fb.sizeWidth = int(d.offset() - start)
+	r = &limitedReader{r: r, n: int64(fb.BodySize)}
 	var locals uint32
 	d.readVarU32(r, &locals)
-	fb.Locals = make([]LocalEntry, int(locals))
-	for i := range fb.Locals {
-		d.readLocalEntry(r, &fb.Locals[i])
+	fb.LocalCount = locals
+	fb.Locals = make([]LocalEntry, 0, locals&0xffff)
+	for i := uint32(0); i < locals && d.err == nil; i++ {
+		var le LocalEntry
+		d.readLocalEntry(r, &le)
+		fb.Locals = append(fb.Locals, le)
 	}
 
-	rcode := new(bytes.Buffer)
-	io.Copy(rcode, r)
-	d.readCode(rcode, &fb.Code)
+	d.readCode(r, &fb.Code)
 }
 
 func (d *decoder) readCode(r io.Reader, code *Code) {
@@ -604,27 +913,23 @@ func (d *decoder) readCode(r io.Reader, code *Code) {
 		return
 	}
 
-	var err error
-	var n int
-	var buf [1]byte
-	for {
-		n, err = r.Read(buf[:])
-		if err != nil || n <= 0 {
-			break
-		}
-		v := buf[0]
-		if v == Op_end {
-			code.End = byte(Op_end)
-			break
-		}
-		code.Code = append(code.Code, v)
-	}
-	if err == io.EOF {
-		err = nil
+	code.Offset = d.offset()
+	var buf []byte
+	buf, d.err = readAll(r)
+	if d.err != nil {
+		return
 	}
-	if err != nil {
-		d.err = err
+	// the instructions are only decoded on request, by Code.Instrs.
+	n := len(buf) - 1
+	if n < 0 || buf[n] != byte(Op_end) {
+		d.exprErr(code.Offset, &exprError{
+			Offset: len(buf),
+			Err:    fmt.Errorf("wasm: function body does not end with an end instruction"),
+		})
+		return
 	}
+	code.Code = buf[:n:n]
+	code.End = buf[n]
 }
 
 func (d *decoder) readLocalEntry(r io.Reader, le *LocalEntry) {
@@ -643,9 +948,12 @@ func (d *decoder) readDataSection(r io.Reader, s *DataSection) {
 
 	var sz uint32
 	d.readVarU32(r, &sz)
-	s.segments = make([]DataSegment, int(sz))
-	for i := range s.segments {
-		d.readDataSegment(r, &s.segments[i])
+	s.Segments = make([]DataSegment, 0, sz&0xffff)
+	for i := uint32(0); i < sz && d.err == nil; i++ {
+		d.enter(int(i))
+		var ds DataSegment
+		d.readDataSegment(r, &ds)
+		s.Segments = append(s.Segments, ds)
 	}
 }
 
@@ -659,6 +967,8 @@ func (d *decoder) readDataSegment(r io.Reader, ds *DataSegment) {
 
 	var sz uint32
 	d.readVarU32(r, &sz)
-	ds.Data = make([]byte, int(sz))
-	d.read(r, ds.Data)
+	if d.err != nil {
+		return
+	}
+	ds.Data, d.err = next(r, int(sz))
 }
@@ -0,0 +1,512 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+func TestDecodeError(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		want wasm.DecodeError
+		msg  string
+	}{
+		{
+			name: "invalid-magic",
+			raw:  []byte{0x00, 'w', 'a', 's', 0x01, 0x00, 0x00, 0x00},
+			want: wasm.DecodeError{Offset: 8, Section: -1, Entry: -1},
+			msg:  `wasm: module header, offset 0x8: invalid magic number ("\x00was")`,
+		},
+		{
+			name: "truncated-header",
+			raw:  []byte{0x00, 'a', 's', 'm', 0x01},
+			want: wasm.DecodeError{Offset: 5, Section: -1, Entry: -1, Err: io.ErrUnexpectedEOF},
+			msg:  "wasm: module header, offset 0x5: unexpected EOF",
+		},
+		{
+			name: "unsupported-version",
+			raw:  []byte{0x00, 'a', 's', 'm', 0x02, 0x00, 0x00, 0x00},
+			want: wasm.DecodeError{Offset: 4, Section: -1, Entry: -1},
+			msg:  "wasm: module header, offset 0x4: unsupported version (0x2)",
+		},
+		{
+			name: "invalid-section-id",
+			raw: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x0c, 0x00,
+			},
+			want: wasm.DecodeError{Offset: 10, Section: 12, Entry: -1},
+			msg:  "wasm: SectionID(12) section, offset 0xa: invalid section ID (12)",
+		},
+		{
+			name: "truncated-section",
+			raw: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0x07, 0x01, 0x60, 0x02, 0x7f,
+			},
+			want: wasm.DecodeError{Offset: 14, Section: 1, Entry: 0, Err: io.ErrUnexpectedEOF},
+			msg:  "wasm: type section, entry 0, offset 0xe: unexpected EOF",
+		},
+		{
+			name: "trailing-section-bytes",
+			raw: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0x05, 0x01, 0x60, 0x00, 0x00, 0xff,
+			},
+			want: wasm.DecodeError{Offset: 14, Section: 1, Entry: -1},
+			msg:  "wasm: type section, offset 0xe: unexpected data at the end of the section (1 bytes)",
+		},
+		{
+			name: "overflowing-section-size",
+			raw: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0xff, 0xff, 0xff, 0xff, 0x1f,
+			},
+			want: wasm.DecodeError{Offset: 14, Section: 1, Entry: -1},
+			msg:  "wasm: type section, offset 0xe: overflow",
+		},
+		{
+			name: "overlong-count",
+			raw: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0x06, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00,
+			},
+			want: wasm.DecodeError{Offset: 15, Section: 1, Entry: -1},
+			msg:  "wasm: type section, offset 0xf: overflow",
+		},
+		{
+			name: "invalid-import-kind",
+			raw: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x02, 0x0d, 0x02,
+				0x01, 0x61, 0x01, 0x62, 0x03, 0x7f, 0x00,
+				0x01, 0x61, 0x01, 0x63, 0x04,
+			},
+			want: wasm.DecodeError{Offset: 23, Section: 2, Entry: 1},
+			msg:  "wasm: import section, entry 1, offset 0x17: invalid ExternalKind (4)",
+		},
+		{
+			name: "missing-end",
+			raw: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+				0x03, 0x03, 0x02, 0x00, 0x00,
+				0x0a, 0x09, 0x02,
+				0x02, 0x00, 0x0b,
+				0x04, 0x00, 0x01, 0x01, 0x01,
+			},
+			want: wasm.DecodeError{Offset: 30, Section: 10, Entry: 1},
+			msg:  "wasm: code section, entry 1, offset 0x1e: function body does not end with an end instruction",
+		},
+	} {
+		for _, dec := range decoders {
+			t.Run(tc.name+"/"+dec.name, func(t *testing.T) {
+				_, err := dec.decode(tc.raw)
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+
+				var derr *wasm.DecodeError
+				if !errors.As(err, &derr) {
+					t.Fatalf("expected a *DecodeError, got %T: %v", err, err)
+				}
+				if derr.Offset != tc.want.Offset || derr.Section != tc.want.Section || derr.Entry != tc.want.Entry {
+					t.Fatalf("invalid error context:\ngot= (offset=%d, section=%d, entry=%d)\nwant=(offset=%d, section=%d, entry=%d)",
+						derr.Offset, derr.Section, derr.Entry,
+						tc.want.Offset, tc.want.Section, tc.want.Entry,
+					)
+				}
+				if tc.want.Err != nil && !errors.Is(err, tc.want.Err) {
+					t.Fatalf("expected error to wrap %v, got %v", tc.want.Err, derr.Err)
+				}
+				if got := err.Error(); got != tc.msg {
+					t.Fatalf("invalid error message:\ngot= %q\nwant=%q", got, tc.msg)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeErrorWithoutCause(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{&wasm.DecodeError{}, "wasm: custom section, entry 0, offset 0x0: invalid module"},
+		{&wasm.DecodeError{Section: -1, Entry: -1, Offset: 4}, "wasm: module header, offset 0x4: invalid module"},
+		{&wasm.SyntaxError{}, "wasm: 0:0: syntax error"},
+	} {
+		if got := tc.err.Error(); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+		if err := errors.Unwrap(tc.err); err != nil {
+			t.Errorf("%v: unwrapped %v, want nil", tc.err, err)
+		}
+	}
+}
+
+func TestDecodeHostileCounts(t *testing.T) {
+	// the counts and sizes below do not allocate more memory than the
+	// module holds.
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+	}{
+		{
+			name: "types",
+			raw:  []byte{0x01, 0x05, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		},
+		{
+			name: "types-section-size",
+			raw: []byte{
+				0x01, 0xff, 0xff, 0xff, 0xff, 0x0f,
+				0xff, 0xff, 0xff, 0xff, 0x0f,
+			},
+		},
+		{
+			name: "params",
+			raw:  []byte{0x01, 0x07, 0x01, 0x60, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		},
+		{
+			name: "elems",
+			raw: []byte{
+				0x09, 0xff, 0xff, 0xff, 0xff, 0x0f,
+				0x01, 0x00, 0x41, 0x00, 0x0b,
+				0xff, 0xff, 0xff, 0xff, 0x0f,
+			},
+		},
+		{
+			name: "locals",
+			raw: []byte{
+				0x0a, 0xff, 0xff, 0xff, 0xff, 0x0f,
+				0x01, 0xff, 0xff, 0xff, 0xff, 0x0f,
+				0xff, 0xff, 0xff, 0xff, 0x0f,
+			},
+		},
+		{
+			name: "data",
+			raw: []byte{
+				0x0b, 0xff, 0xff, 0xff, 0xff, 0x0f,
+				0x01, 0x00, 0x41, 0x00, 0x0b,
+				0xff, 0xff, 0xff, 0xff, 0x0f, 0x00,
+			},
+		},
+		{
+			name: "custom-name",
+			raw: []byte{
+				0x00, 0xff, 0xff, 0xff, 0xff, 0x0f,
+				0xff, 0xff, 0xff, 0xff, 0x0f, 'n',
+			},
+		},
+	} {
+		raw := append([]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}, tc.raw...)
+		for i, dec := range append(decoders, lazyDecoders...) {
+			name := dec.name
+			if i >= len(decoders) {
+				name = "Lazy" + name
+			}
+			t.Run(tc.name+"/"+name, func(t *testing.T) {
+				m, err := dec.decode(raw)
+				if err == nil {
+					err = m.Load()
+				}
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				var derr *wasm.DecodeError
+				if !errors.As(err, &derr) {
+					t.Fatalf("expected a *DecodeError, got %T: %v", err, err)
+				}
+			})
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, name := range []string{"testdata/hello.wasm", "testdata/empty.wasm"} {
+		raw, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(raw)
+	}
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		want, err := wasm.DecodeBytes(raw)
+		for _, dec := range decoders {
+			got, gerr := dec.decode(raw)
+			if (gerr == nil) != (err == nil) {
+				t.Fatalf("%s: got error %v, want %v", dec.name, gerr, err)
+			}
+			if err == nil && !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: decoded modules differ", dec.name)
+			}
+		}
+	})
+}
+
+func TestDecodeInvalidCode(t *testing.T) {
+	raw := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+		0x03, 0x02, 0x01, 0x00,
+		0x0a, 0x06, 0x01,
+		0x04, 0x00, 0x01, 0xff, 0x0b,
+	}
+	for _, dec := range decoders {
+		// the instructions are only decoded on request.
+		m, err := dec.decode(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", dec.name, err)
+		}
+		code := m.Sections[2].(wasm.CodeSection).Bodies[0].Code
+		_, err = code.Instrs()
+		if err == nil || !strings.Contains(err.Error(), "invalid opcode 0xff") {
+			t.Errorf("%s: got error %v", dec.name, err)
+		}
+		if err := wasm.Validate(m); err == nil {
+			t.Errorf("%s: expected a validation error", dec.name)
+		}
+	}
+}
+
+func TestCustomSections(t *testing.T) {
+	raw := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x00, 0x1b, 0x10, 0x73, 0x6f, 0x75, 0x72, 0x63,
+		0x65, 0x4d, 0x61, 0x70, 0x70, 0x69, 0x6e, 0x67,
+		0x55, 0x52, 0x4c, 0x09, 0x68, 0x65, 0x6c, 0x6c,
+		0x6f, 0x2e, 0x6d, 0x61, 0x70, 0x00, 0x0f, 0x0b,
+		0x2e, 0x64, 0x65, 0x62, 0x75, 0x67, 0x5f, 0x69,
+		0x6e, 0x66, 0x6f, 0x01, 0x02, 0xff, 0x00, 0x07,
+		0x04, 0x6e, 0x61, 0x6d, 0x65, 0xff, 0xff,
+	}
+	mod, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mod.Sections) != 3 {
+		t.Fatalf("invalid number of sections: got=%d, want=3", len(mod.Sections))
+	}
+
+	sec, ok := mod.Custom(".debug_info")
+	if !ok {
+		t.Fatalf("could not find .debug_info custom section")
+	}
+	if !bytes.Equal(sec.Payload, []byte{0x01, 0x02, 0xff}) {
+		t.Fatalf("invalid payload: %x", sec.Payload)
+	}
+
+	sec, ok = mod.Custom("name")
+	if !ok {
+		t.Fatalf("could not find name custom section")
+	}
+	_, err = sec.NameSection()
+	var derr *wasm.DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("expected a *DecodeError decoding an invalid name section, got %v", err)
+	}
+	if derr.Offset != int64(len(raw)) {
+		t.Fatalf("invalid error offset: got=%d, want=%d", derr.Offset, len(raw))
+	}
+
+	// the invalid name section is ignored.
+	if _, ok, err := mod.Names(); ok || err != nil {
+		t.Fatalf("invalid name section: ok=%v, err=%v", ok, err)
+	}
+}
+
+func TestLegacyNameSection(t *testing.T) {
+	// hello.wasm holds a "name" section in the layout of pre-release
+	// versions of the binary format.
+	mod, err := wasm.Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns, ok, err := mod.Names()
+	if ok || err != nil {
+		t.Fatalf("legacy name section: ok=%v, err=%v, names=%v", ok, err, ns)
+	}
+
+	sec, ok := mod.Custom("name")
+	if !ok {
+		t.Fatalf("could not find name custom section")
+	}
+	_, err = sec.NameSection()
+	var derr *wasm.DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("expected a *DecodeError, got %v", err)
+	}
+	layout := mod.Layout[len(mod.Layout)-1]
+	if derr.Offset < layout.Payload || derr.Offset > layout.Payload+int64(layout.Size) {
+		t.Fatalf("error offset 0x%x outside of the name section %+v", derr.Offset, layout)
+	}
+}
+
+func TestNameSection(t *testing.T) {
+	ns := wasm.NameSection{
+		Module:    "mod",
+		Functions: wasm.NameMap{{Index: 0, Name: "log"}, {Index: 2, Name: "main"}},
+		Locals: wasm.IndirectNameMap{
+			{Index: 2, Names: wasm.NameMap{{Index: 0, Name: "x"}, {Index: 3, Name: "y"}}},
+		},
+		Globals: wasm.NameMap{{Index: 1, Name: "sp"}},
+		Unknown: []wasm.NameSubsection{{ID: 42, Payload: []byte{0x01, 0x02}}},
+	}
+
+	mod := wasm.NewModule()
+	mod.Sections = append(mod.Sections, ns)
+	w := new(bytes.Buffer)
+	err := wasm.Encode(*mod, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mod, err = wasm.Decode(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mod.Sections[0].(wasm.CustomSection); !ok {
+		t.Fatalf("expected a custom section, got %T", mod.Sections[0])
+	}
+	got, ok, err := mod.Names()
+	if err != nil || !ok {
+		t.Fatalf("could not decode name section: ok=%v, err=%v", ok, err)
+	}
+	if !reflect.DeepEqual(got, ns) {
+		t.Fatalf("invalid name section:\ngot= %#v\nwant=%#v", got, ns)
+	}
+
+	for _, tc := range []struct {
+		fct, local uint32
+		want       string
+	}{
+		{0, 0, ""},
+		{2, 0, "x"},
+		{2, 1, ""},
+		{2, 3, "y"},
+		{3, 0, ""},
+	} {
+		name, ok := got.LocalName(tc.fct, tc.local)
+		if name != tc.want || ok != (tc.want != "") {
+			t.Errorf("local[%d][%d]: got=(%q, %v), want=%q", tc.fct, tc.local, name, ok, tc.want)
+		}
+	}
+	for idx, want := range []string{"log", "", "main", ""} {
+		name, _ := got.FunctionName(uint32(idx))
+		if name != want {
+			t.Errorf("func[%d]: got=%q, want=%q", idx, name, want)
+		}
+	}
+}
+
+// decoders are the ways of decoding a module.
+var decoders = []struct {
+	name   string
+	decode func(raw []byte) (*wasm.Module, error)
+}{
+	{"Decode", func(raw []byte) (*wasm.Module, error) { return wasm.Decode(bytes.NewReader(raw)) }},
+	{"DecodeBytes", wasm.DecodeBytes},
+	{"DecodeReaderAt", func(raw []byte) (*wasm.Module, error) {
+		return wasm.DecodeReaderAt(bytes.NewReader(raw), int64(len(raw)))
+	}},
+}
+
+func TestDecodeBytes(t *testing.T) {
+	raw, err := os.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dec := range decoders[1:] {
+		got, err := dec.decode(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", dec.name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: decoded modules differ", dec.name)
+		}
+	}
+
+	// DecodeBytes does not copy the bytecode.
+	m, err := wasm.DecodeBytes(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sec := range m.Sections {
+		sec, ok := sec.(wasm.CodeSection)
+		if !ok {
+			continue
+		}
+		code := sec.Bodies[0].Code
+		if &code.Code[0] != &raw[code.Offset] {
+			t.Errorf("the bytecode of the function bodies is copied")
+		}
+		if cap(code.Code) != len(code.Code) {
+			t.Errorf("the bytecode of the function bodies may be appended to")
+		}
+	}
+}
+
+// benchModule returns a large module, with many functions and data
+// segments.
+func benchModule(b *testing.B) []byte {
+	mb := wasm.NewBuilder()
+	mb.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 64}})
+	ft := wasm.FuncType{Params: []wasm.ValueType{wasm.I32}, Results: []wasm.ValueType{wasm.I32}}
+	for i := 0; i < 2000; i++ {
+		c := wasm.NewCodeBuilder()
+		c.GetLocal(0)
+		for j := 0; j < 100; j++ {
+			c.I32Const(int32(i * j)).I32Add().I32Load(uint32(j))
+		}
+		code, err := c.Code()
+		if err != nil {
+			b.Fatal(err)
+		}
+		mb.FuncCode(ft, []wasm.ValueType{wasm.I32, wasm.I64}, code)
+	}
+	data := bytes.Repeat([]byte("data"), 1<<10)
+	for i := 0; i < 256; i++ {
+		mb.Data(0, []wasm.Instr{{Op: wasm.Op_i32_const, Value: uint64(i * len(data))}}, data)
+	}
+	m, err := mb.Module()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := wasm.Encode(*m, &buf); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkDecode(b *testing.B) {
+	raw := benchModule(b)
+	for _, dec := range decoders {
+		b.Run(dec.name, func(b *testing.B) {
+			b.SetBytes(int64(len(raw)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := dec.decode(raw); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -7,12 +7,27 @@ package wasm
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
 )
 
-//Encode writes the wasm binary to the writer
+// EncodeOptions controls how modules are encoded in the binary format.
+type EncodeOptions struct {
+	CompressLocals bool // merge consecutive local entries of the same type
+}
+
+// Encode writes the wasm binary to the writer
 func Encode(m Module, w io.Writer) error {
-	e := encoder{w: w}
+	return EncodeOptions{}.Encode(m, w)
+}
+
+// Encode writes the wasm binary to the writer.
+// The sizes of the sections and of the function bodies, and the number of
+// local entries of the function bodies, are derived from their content, so
+// that modified modules may be encoded.
+func (opts EncodeOptions) Encode(m Module, w io.Writer) error {
+	e := encoder{w: w, opts: opts}
 	e.writeModule(m)
 
 	if e.err != nil {
@@ -22,8 +37,9 @@ func Encode(m Module, w io.Writer) error {
 }
 
 type encoder struct {
-	w   io.Writer
-	err error
+	w    io.Writer
+	opts EncodeOptions
+	err  error
 }
 
 func (e *encoder) writeVaruint7(v varuint7) {
@@ -42,6 +58,16 @@ func (e *encoder) writeVaruint32(v varuint32) {
 	_, e.err = v.write(e.w)
 }
 
+// writeSize writes the size of a section or of a function body, in at
+// least width bytes.
+func (e *encoder) writeSize(v varuint32, width int) {
+	if e.err != nil {
+		return
+	}
+
+	_, e.err = v.writePadded(e.w, width)
+}
+
 func (e *encoder) writeVarint7(v varint7) {
 	if e.err != nil {
 		return
@@ -72,8 +98,12 @@ func (e *encoder) writeModule(m Module) {
 	}
 
 	e.writeHeader(m.Header)
-	for _, s := range m.Sections {
-		e.writeSection(s)
+	for i, s := range m.Sections {
+		var layout SectionLayout
+		if i < len(m.Layout) {
+			layout = m.Layout[i]
+		}
+		e.writeSection(s, layout)
 	}
 }
 
@@ -90,7 +120,9 @@ func (e *encoder) writeHeader(hdr ModuleHeader) {
 	e.err = binary.Write(e.w, order, hdr.Version)
 }
 
-func (e *encoder) writeSection(sec Section) {
+// writeSection writes sec. If its payload has the size of the section
+// described by layout, its size is encoded in as many bytes as there.
+func (e *encoder) writeSection(sec Section, layout SectionLayout) {
 	if e.err != nil {
 		return
 	}
@@ -98,23 +130,174 @@ func (e *encoder) writeSection(sec Section) {
 	e.writeVaruint7(varuint7(sec.ID())) // id
 
 	b := new(bytes.Buffer)
-	encSec := &encoder{w: b}
+	encSec := &encoder{w: b, opts: e.opts}
 	switch s := sec.(type) {
+	case CustomSection:
+		encSec.writeCustomSection(s)
+	case NameSection:
+		encSec.writeString("name")
+		encSec.writeNameSection(s)
 	case TypeSection:
 		encSec.writeTypeSection(s)
+	case ImportSection:
+		encSec.writeImportSection(s)
 	case FunctionSection:
 		encSec.writeFunctionSection(s)
+	case TableSection:
+		encSec.writeTableSection(s)
+	case MemorySection:
+		encSec.writeMemorySection(s)
+	case GlobalSection:
+		encSec.writeGlobalSection(s)
 	case ExportSection:
 		encSec.writeExportSection(s)
+	case StartSection:
+		encSec.writeStartSection(s)
+	case ElementSection:
+		encSec.writeElementSection(s)
 	case CodeSection:
 		encSec.writeCodeSection(s)
+	case DataSection:
+		encSec.writeDataSection(s)
+	case *LazySection:
+		// the payload of sections decoded lazily is written verbatim.
+		payload, err := s.Payload()
+		if err != nil {
+			e.err = err
+			return
+		}
+		encSec.write(payload)
 	default:
-		panic("TODO")
+		e.err = fmt.Errorf("wasm: unknown section type %T", sec)
+		return
+	}
+	if encSec.err != nil {
+		e.err = encSec.err
+		return
+	}
+	width := 0
+	if b.Len() == int(layout.Size) {
+		width = int(layout.Payload - layout.Offset - 1)
+	}
+	e.writeSize(varuint32(b.Len()), width) // payload_len
+	e.write(b.Bytes())
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+
+	_, e.err = e.w.Write(p)
+}
+
+func (e *encoder) writeString(s string) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(len(s)))
+	e.write([]byte(s))
+}
+
+func (e *encoder) writeCustomSection(s CustomSection) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeString(s.Name)
+	e.write(s.Payload)
+}
+
+// writeNameSection writes the payload of the "name" custom section.
+func (e *encoder) writeNameSection(s NameSection) {
+	if e.err != nil {
+		return
+	}
+
+	unknown := s.Unknown
+	writeUnknown := func(id byte) {
+		for len(unknown) > 0 && unknown[0].ID < id {
+			e.writeNameSubsection(unknown[0].ID, func(e *encoder) { e.write(unknown[0].Payload) })
+			unknown = unknown[1:]
+		}
+	}
+
+	if s.Module != "" {
+		e.writeNameSubsection(ModuleNameID, func(e *encoder) { e.writeString(s.Module) })
+	}
+	for _, sub := range []struct {
+		id    byte
+		names NameMap
+		local IndirectNameMap
+	}{
+		{id: FunctionNamesID, names: s.Functions},
+		{id: LocalNamesID, local: s.Locals},
+		{id: LabelNamesID, local: s.Labels},
+		{id: TypeNamesID, names: s.Types},
+		{id: TableNamesID, names: s.Tables},
+		{id: MemoryNamesID, names: s.Memories},
+		{id: GlobalNamesID, names: s.Globals},
+		{id: ElemNamesID, names: s.Elements},
+		{id: DataNamesID, names: s.Data},
+	} {
+		writeUnknown(sub.id)
+		switch {
+		case len(sub.names) > 0:
+			names := sub.names
+			e.writeNameSubsection(sub.id, func(e *encoder) { e.writeNameMap(names) })
+		case len(sub.local) > 0:
+			local := sub.local
+			e.writeNameSubsection(sub.id, func(e *encoder) { e.writeIndirectNameMap(local) })
+		}
+	}
+	writeUnknown(0xff)
+	for _, ns := range unknown {
+		e.writeNameSubsection(ns.ID, func(e *encoder) { e.write(ns.Payload) })
+	}
+}
+
+// writeNameSubsection writes the subsection id of the "name" section,
+// whose content is written by fct.
+func (e *encoder) writeNameSubsection(id byte, fct func(e *encoder)) {
+	if e.err != nil {
+		return
+	}
+
+	b := new(bytes.Buffer)
+	sub := &encoder{w: b, opts: e.opts}
+	fct(sub)
+	if sub.err != nil {
+		e.err = sub.err
+		return
+	}
+	e.write([]byte{id})
+	e.writeVaruint32(varuint32(b.Len()))
+	e.write(b.Bytes())
+}
+
+func (e *encoder) writeNameMap(m NameMap) {
+	if e.err != nil {
+		return
 	}
-	e.writeVaruint32(varuint32(b.Len())) // payload_len
-	_, e.err = e.w.Write(b.Bytes())
 
-	// TODO: name_len, name
+	e.writeVaruint32(varuint32(len(m)))
+	for _, na := range m {
+		e.writeVaruint32(varuint32(na.Index))
+		e.writeString(na.Name)
+	}
+}
+
+func (e *encoder) writeIndirectNameMap(m IndirectNameMap) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(len(m)))
+	for _, na := range m {
+		e.writeVaruint32(varuint32(na.Index))
+		e.writeNameMap(na.Names)
+	}
 }
 
 func (e *encoder) writeTypeSection(s TypeSection) {
@@ -122,8 +305,8 @@ func (e *encoder) writeTypeSection(s TypeSection) {
 		return
 	}
 
-	e.writeVaruint32(varuint32(len(s.types)))
-	for _, t := range s.types {
+	e.writeVaruint32(varuint32(len(s.Types)))
+	for _, t := range s.Types {
 		e.writeFuncType(t)
 	}
 }
@@ -133,15 +316,15 @@ func (e *encoder) writeFuncType(ft FuncType) {
 		return
 	}
 
-	e.writeValueType(ft.form)
+	e.writeValueType(ft.Form)
 
-	e.writeVaruint32(varuint32(len(ft.params)))
-	for _, v := range ft.params {
+	e.writeVaruint32(varuint32(len(ft.Params)))
+	for _, v := range ft.Params {
 		e.writeValueType(v)
 	}
 
-	e.writeVaruint32(varuint32(len(ft.results)))
-	for _, v := range ft.results {
+	e.writeVaruint32(varuint32(len(ft.Results)))
+	for _, v := range ft.Results {
 		e.writeValueType(v)
 	}
 }
@@ -154,17 +337,180 @@ func (e *encoder) writeValueType(v ValueType) {
 	e.writeVarint7(varint7(v))
 }
 
+func (e *encoder) writeImportSection(s ImportSection) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(len(s.Imports)))
+	for _, ie := range s.Imports {
+		e.writeImportEntry(ie)
+	}
+}
+
+func (e *encoder) writeImportEntry(ie ImportEntry) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeString(ie.Module)
+	e.writeString(ie.Field)
+	e.writeExternalKind(ie.Kind)
+
+	switch ie.Kind {
+	case FunctionKind:
+		e.writeVaruint32(varuint32(ie.TypeIndex))
+	case TableKind:
+		e.writeTableType(ie.Table)
+	case MemoryKind:
+		e.writeMemoryType(ie.Memory)
+	case GlobalKind:
+		e.writeGlobalType(ie.Global)
+	default:
+		e.err = fmt.Errorf("wasm: invalid ExternalKind (%d)", byte(ie.Kind))
+	}
+}
+
+func (e *encoder) writeTableType(tt TableType) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeElemType(tt.ElemType)
+	e.writeResizableLimits(tt.Limits)
+}
+
+func (e *encoder) writeElemType(et ElemType) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVarint7(varint7(et))
+}
+
+func (e *encoder) writeResizableLimits(rl ResizableLimits) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(rl.Flags))
+	e.writeVaruint32(varuint32(rl.Initial))
+	if rl.Flags&0x1 != 0 {
+		e.writeVaruint32(varuint32(rl.Maximum))
+	}
+}
+
+func (e *encoder) writeMemoryType(mt MemoryType) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeResizableLimits(mt.Limits)
+}
+
+func (e *encoder) writeGlobalType(gt GlobalType) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeValueType(gt.ContentType)
+	e.writeVaruint32(varuint32(gt.Mutability))
+}
+
+func (e *encoder) writeInitExpr(ie InitExpr) {
+	if e.err != nil {
+		return
+	}
+
+	e.write(ie.Expr)
+	e.write([]byte{ie.End})
+}
+
 func (e *encoder) writeFunctionSection(s FunctionSection) {
 	if e.err != nil {
 		return
 	}
 
-	e.writeVaruint32(varuint32(len(s.types)))
-	for _, t := range s.types {
+	e.writeVaruint32(varuint32(len(s.Types)))
+	for _, t := range s.Types {
 		e.writeVaruint32(varuint32(t))
 	}
 }
 
+func (e *encoder) writeTableSection(s TableSection) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(len(s.Tables)))
+	for _, t := range s.Tables {
+		e.writeTableType(t)
+	}
+}
+
+func (e *encoder) writeMemorySection(s MemorySection) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(len(s.Memories)))
+	for _, m := range s.Memories {
+		e.writeMemoryType(m)
+	}
+}
+
+func (e *encoder) writeGlobalSection(s GlobalSection) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(len(s.Globals)))
+	for _, g := range s.Globals {
+		e.writeGlobalVariable(g)
+	}
+}
+
+func (e *encoder) writeGlobalVariable(gv GlobalVariable) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeGlobalType(gv.Type)
+	e.writeInitExpr(gv.Init)
+}
+
+func (e *encoder) writeStartSection(s StartSection) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(s.Index))
+}
+
+func (e *encoder) writeElementSection(s ElementSection) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(len(s.Elements)))
+	for _, es := range s.Elements {
+		e.writeElemSegment(es)
+	}
+}
+
+func (e *encoder) writeElemSegment(es ElemSegment) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(es.Index))
+	e.writeInitExpr(es.Offset)
+	e.writeVaruint32(varuint32(len(es.Elems)))
+	for _, idx := range es.Elems {
+		e.writeVaruint32(varuint32(idx))
+	}
+}
+
 func (e *encoder) writeCodeSection(s CodeSection) {
 	if e.err != nil {
 		return
@@ -181,12 +527,45 @@ func (e *encoder) writeFunctionBody(fb FunctionBody) {
 		return
 	}
 
-	e.writeVaruint32(varuint32(fb.BodySize))
-	e.writeVaruint32(fb.LocalCount)
-	for _, l := range fb.Locals {
-		e.writeLocalEntry(l)
+	locals := fb.Locals
+	if e.opts.CompressLocals {
+		locals = compressLocals(locals)
 	}
-	e.writeCode(fb.Code)
+
+	b := new(bytes.Buffer)
+	encBody := &encoder{w: b, opts: e.opts}
+	encBody.writeVaruint32(varuint32(len(locals)))
+	for _, l := range locals {
+		encBody.writeLocalEntry(l)
+	}
+	encBody.writeCode(fb.Code)
+	if encBody.err != nil {
+		e.err = encBody.err
+		return
+	}
+	width := 0
+	if b.Len() == int(fb.BodySize) {
+		width = fb.sizeWidth
+	}
+	e.writeSize(varuint32(b.Len()), width) // body_size
+	e.write(b.Bytes())
+}
+
+// compressLocals returns the local entries declaring the same local
+// variables as locals, with consecutive variables of the same type
+// declared by a single entry, as long as their count fits in a uint32.
+func compressLocals(locals []LocalEntry) []LocalEntry {
+	var o []LocalEntry
+	for _, l := range locals {
+		switch n := len(o); {
+		case l.Count == 0:
+		case n > 0 && o[n-1].Type == l.Type && o[n-1].Count <= math.MaxUint32-l.Count:
+			o[n-1].Count += l.Count
+		default:
+			o = append(o, l)
+		}
+	}
+	return o
 }
 
 func (e *encoder) writeLocalEntry(l LocalEntry) {
@@ -203,8 +582,8 @@ func (e *encoder) writeExportSection(s ExportSection) {
 		return
 	}
 
-	e.writeVaruint32(varuint32(len(s.exports)))
-	for _, ex := range s.exports {
+	e.writeVaruint32(varuint32(len(s.Exports)))
+	for _, ex := range s.Exports {
 		e.writeExportEntry(ex)
 	}
 }
@@ -214,13 +593,9 @@ func (e *encoder) writeExportEntry(ex ExportEntry) {
 		return
 	}
 
-	e.writeVaruint32(varuint32(len(ex.field)))
-	_, e.err = e.w.Write([]byte(ex.field))
-	if e.err != nil {
-		return
-	}
-	e.writeExternalKind(ex.kind)
-	e.writeVaruint32(varuint32(ex.index))
+	e.writeString(ex.Field)
+	e.writeExternalKind(ex.Kind)
+	e.writeVaruint32(varuint32(ex.Index))
 }
 
 func (e *encoder) writeExternalKind(k ExternalKind) {
@@ -242,3 +617,25 @@ func (e *encoder) writeCode(c Code) {
 	}
 	_, e.err = e.w.Write([]byte{c.End})
 }
+
+func (e *encoder) writeDataSection(s DataSection) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(len(s.Segments)))
+	for _, ds := range s.Segments {
+		e.writeDataSegment(ds)
+	}
+}
+
+func (e *encoder) writeDataSegment(ds DataSegment) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeVaruint32(varuint32(ds.Index))
+	e.writeInitExpr(ds.Offset)
+	e.writeVaruint32(varuint32(len(ds.Data)))
+	e.write(ds.Data)
+}
@@ -7,6 +7,9 @@ package wasm_test
 import (
 	"bytes"
 	"encoding/hex"
+	"math"
+	"os"
+	"reflect"
 	"testing"
 
 	"github.com/sbinet/wasm"
@@ -37,6 +40,63 @@ func TestBinaries(t *testing.T) {
 			0x03, 0x61, 0x64, 0x64, 0x00, 0x00, 0x0a, 0x09,
 			0x01, 0x07, 0x00, 0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b,
 		},
+		// (module
+		//  (type (func (param i32)))
+		//  (type (func))
+		//  (import "env" "log" (func (type 0)))
+		//  (import "env" "mem" (memory 1))
+		//  (import "env" "g" (global i32))
+		//  (table 1 2 anyfunc)
+		//  (global (mut i32) (i32.const 11))
+		//  (global f64 (f64.const 1))
+		//  (export "main" (func 1))
+		//  (export "tbl" (table 0))
+		//  (start 1)
+		//  (elem (i32.const 0) 1)
+		//  (func $main (local $x i32) (local i32)
+		//   block
+		//    nop
+		//   end
+		//   i32.const 11
+		//   call 0)
+		//  (data (i32.const 8) "hello")
+		// )
+		// with a "name" custom section.
+		[]byte{
+			0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+			0x01, 0x08, 0x02, 0x60, 0x01, 0x7f, 0x00, 0x60,
+			0x00, 0x00, 0x02, 0x1f, 0x03, 0x03, 0x65, 0x6e,
+			0x76, 0x03, 0x6c, 0x6f, 0x67, 0x00, 0x00, 0x03,
+			0x65, 0x6e, 0x76, 0x03, 0x6d, 0x65, 0x6d, 0x02,
+			0x00, 0x01, 0x03, 0x65, 0x6e, 0x76, 0x01, 0x67,
+			0x03, 0x7f, 0x00, 0x03, 0x02, 0x01, 0x01, 0x04,
+			0x05, 0x01, 0x70, 0x01, 0x01, 0x02, 0x06, 0x12,
+			0x02, 0x7f, 0x01, 0x41, 0x0b, 0x0b, 0x7c, 0x00,
+			0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0,
+			0x3f, 0x0b, 0x07, 0x0e, 0x02, 0x04, 0x6d, 0x61,
+			0x69, 0x6e, 0x00, 0x01, 0x03, 0x74, 0x62, 0x6c,
+			0x01, 0x00, 0x08, 0x01, 0x01, 0x09, 0x07, 0x01,
+			0x00, 0x41, 0x00, 0x0b, 0x01, 0x01, 0x0a, 0x0e,
+			0x01, 0x0c, 0x01, 0x02, 0x7f, 0x02, 0x40, 0x01,
+			0x0b, 0x41, 0x0b, 0x10, 0x00, 0x0b, 0x0b, 0x0b,
+			0x01, 0x00, 0x41, 0x08, 0x0b, 0x05, 0x68, 0x65,
+			0x6c, 0x6c, 0x6f, 0x00, 0x16, 0x04, 0x6e, 0x61,
+			0x6d, 0x65, 0x01, 0x07, 0x01, 0x01, 0x04, 0x6d,
+			0x61, 0x69, 0x6e, 0x02, 0x06, 0x01, 0x01, 0x01,
+			0x00, 0x01, 0x78,
+		},
+		// (module) with custom sections:
+		//  "sourceMappingURL", ".debug_info" and an invalid "name" one.
+		[]byte{
+			0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+			0x00, 0x1b, 0x10, 0x73, 0x6f, 0x75, 0x72, 0x63,
+			0x65, 0x4d, 0x61, 0x70, 0x70, 0x69, 0x6e, 0x67,
+			0x55, 0x52, 0x4c, 0x09, 0x68, 0x65, 0x6c, 0x6c,
+			0x6f, 0x2e, 0x6d, 0x61, 0x70, 0x00, 0x0f, 0x0b,
+			0x2e, 0x64, 0x65, 0x62, 0x75, 0x67, 0x5f, 0x69,
+			0x6e, 0x66, 0x6f, 0x01, 0x02, 0xff, 0x00, 0x07,
+			0x04, 0x6e, 0x61, 0x6d, 0x65, 0xff, 0xff,
+		},
 	}
 	for i, binary := range binaries {
 		t.Logf("%d-th binary", i)
@@ -44,6 +104,21 @@ func TestBinaries(t *testing.T) {
 	}
 }
 
+func TestFiles(t *testing.T) {
+	for _, fname := range []string{
+		"testdata/empty.wasm",
+		"testdata/hello.wasm",
+	} {
+		t.Run(fname, func(t *testing.T) {
+			raw, err := os.ReadFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decodeAndEncodeed(t, raw)
+		})
+	}
+}
+
 func decodeAndEncodeed(t *testing.T, in []byte) {
 	r := bytes.NewBuffer(in)
 	mod, err := wasm.Decode(r)
@@ -64,3 +139,130 @@ func decodeAndEncodeed(t *testing.T, in []byte) {
 		t.Errorf("re-encoded binary does not match the original bytes\nin :%x\nout:%x", in, out)
 	}
 }
+
+func TestEncodeModified(t *testing.T) {
+	m, err := wasm.ParseText([]byte(`(module
+  (func (export "f") (param i32) (result i32)
+    (local i32)
+    (i32.add (get_local 0) (i32.const 1))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := wasm.Encode(*m, &buf); err != nil {
+		t.Fatal(err)
+	}
+	m, err = wasm.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// add local variables and instructions to the decoded function body.
+	var code *wasm.FunctionBody
+	for _, sec := range m.Sections {
+		if sec, ok := sec.(wasm.CodeSection); ok {
+			code = &sec.Bodies[0]
+		}
+	}
+	code.Locals = append(code.Locals,
+		wasm.LocalEntry{Count: 2, Type: wasm.I32},
+		wasm.LocalEntry{Count: 0, Type: wasm.F64},
+		wasm.LocalEntry{Count: 300, Type: wasm.I64},
+	)
+	c := wasm.NewCodeBuilder()
+	for i := 0; i < 100; i++ {
+		c.I32Const(int32(i)).SetLocal(1)
+	}
+	prologue, err := c.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code.Code.Code = append(prologue.Code, code.Code.Code...)
+
+	for _, tc := range []struct {
+		opts   wasm.EncodeOptions
+		locals []wasm.LocalEntry
+	}{
+		{
+			locals: []wasm.LocalEntry{
+				{Count: 1, Type: wasm.I32},
+				{Count: 2, Type: wasm.I32},
+				{Count: 0, Type: wasm.F64},
+				{Count: 300, Type: wasm.I64},
+			},
+		},
+		{
+			opts: wasm.EncodeOptions{CompressLocals: true},
+			locals: []wasm.LocalEntry{
+				{Count: 3, Type: wasm.I32},
+				{Count: 300, Type: wasm.I64},
+			},
+		},
+	} {
+		var buf bytes.Buffer
+		if err := tc.opts.Encode(*m, &buf); err != nil {
+			t.Fatal(err)
+		}
+		size := buf.Len()
+		got, err := wasm.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%+v: could not decode the modified module: %v", tc.opts, err)
+		}
+		if err := wasm.Validate(got); err != nil {
+			t.Errorf("%+v: invalid modified module: %v", tc.opts, err)
+		}
+		for _, sec := range got.Sections {
+			sec, ok := sec.(wasm.CodeSection)
+			if !ok {
+				continue
+			}
+			body := sec.Bodies[0]
+			if !reflect.DeepEqual(body.Locals, tc.locals) {
+				t.Errorf("%+v: got locals %v, want %v", tc.opts, body.Locals, tc.locals)
+			}
+			if got, want := body.LocalCount, uint32(len(tc.locals)); got != want {
+				t.Errorf("%+v: got %d local entries, want %d", tc.opts, got, want)
+			}
+			if !bytes.Equal(body.Code.Code, code.Code.Code) {
+				t.Errorf("%+v: got code %x, want %x", tc.opts, body.Code.Code, code.Code.Code)
+			}
+		}
+		if layout := got.Layout[len(got.Layout)-1]; layout.Payload+int64(layout.Size) != int64(size) {
+			t.Errorf("%+v: invalid size of the last section: %+v", tc.opts, layout)
+		}
+	}
+}
+
+func TestCompressLocalsOverflow(t *testing.T) {
+	locals := []wasm.LocalEntry{
+		{Count: math.MaxUint32 - 1, Type: wasm.I32},
+		{Count: 5, Type: wasm.I32},
+		{Count: 2, Type: wasm.I32},
+	}
+	m := wasm.NewModule()
+	m.Sections = append(m.Sections, wasm.CodeSection{
+		Bodies: []wasm.FunctionBody{{
+			Locals: locals,
+			Code:   wasm.Code{End: byte(wasm.Op_end)},
+		}},
+	})
+	var buf bytes.Buffer
+	if err := (wasm.EncodeOptions{CompressLocals: true}).Encode(*m, &buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := wasm.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec, _, err := got.Section(wasm.CodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []wasm.LocalEntry{
+		{Count: math.MaxUint32 - 1, Type: wasm.I32},
+		{Count: 7, Type: wasm.I32},
+	}
+	if body := sec.(wasm.CodeSection).Bodies[0]; !reflect.DeepEqual(body.Locals, want) {
+		t.Errorf("got locals %v, want %v", body.Locals, want)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errOverflow = errors.New("wasm: overflow")
+
+// DecodeError describes an error that occurred while decoding a module.
+type DecodeError struct {
+	Offset  int64 // position, in bytes, of the faulty data within the module
+	Section int   // ID of the section being decoded, or -1 while decoding the module header
+	Entry   int   // index of the entry being decoded within the section, or -1
+	Err     error // underlying error
+}
+
+func (e *DecodeError) Error() string {
+	var ctx []string
+	if e.Section >= 0 {
+		ctx = append(ctx, fmt.Sprintf("%v section", SectionID(e.Section)))
+	} else {
+		ctx = append(ctx, "module header")
+	}
+	if e.Entry >= 0 {
+		ctx = append(ctx, fmt.Sprintf("entry %d", e.Entry))
+	}
+	ctx = append(ctx, fmt.Sprintf("offset 0x%x", e.Offset))
+	return fmt.Sprintf("wasm: %s: %s", strings.Join(ctx, ", "), errorMessage(e.Err, "invalid module"))
+}
+
+// Unwrap returns the underlying error, or nil.
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// errorMessage returns the message of the underlying error err of a
+// DecodeError or SyntaxError, without its "wasm: " prefix, or msg if err
+// is nil.
+func errorMessage(err error, msg string) string {
+	if err == nil {
+		return msg
+	}
+	return strings.TrimPrefix(err.Error(), "wasm: ")
+}
+
+// exprError describes an error that occurred while decoding an expression.
+type exprError struct {
+	Offset int // position of the faulty instruction within the expression
+	Err    error
+}
+
+func (e *exprError) Error() string {
+	msg := strings.TrimPrefix(e.Err.Error(), "wasm: ")
+	return fmt.Sprintf("wasm: %s (offset=%d)", msg, e.Offset)
+}
+
+func (e *exprError) Unwrap() error { return e.Err }
+
+// SyntaxError describes an error in a module in the text format.
+type SyntaxError struct {
+	Line int   // line of the faulty token, starting at 1
+	Col  int   // column of the faulty token, in bytes, starting at 1
+	Err  error // underlying error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("wasm: %d:%d: %s", e.Line, e.Col, errorMessage(e.Err, "syntax error"))
+}
+
+// Unwrap returns the underlying error, or nil.
+func (e *SyntaxError) Unwrap() error { return e.Err }
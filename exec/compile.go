@@ -0,0 +1,502 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+
+	"github.com/sbinet/wasm"
+)
+
+// Engine selects how instances execute their functions.
+type Engine int
+
+const (
+	// Compiler translates function bodies to an internal representation
+	// before running them: branch targets and operand stack heights are
+	// resolved, structured control instructions are removed, and common
+	// sequences of instructions are fused.
+	Compiler Engine = iota
+
+	// Interpreter runs the decoded instructions of function bodies,
+	// tracking the blocks they enter at run time.
+	// It is slower than Compiler, and mostly useful as a reference.
+	Interpreter
+)
+
+// Internal opcodes of compiled functions, in a range of opcodes not used
+// by the instructions supported by the wasm package.
+const (
+	opJump      wasm.Opcode = 0xe0 + iota // jump to a
+	opBr                                  // branch to a, unwinding the operand stack to height b with c results
+	opBrIf                                // branch to a, as opBr, if the popped operand is not zero
+	opBrUnless                            // jump to a if the popped operand is zero
+	opBrTable                             // branch to the target selected by the popped operand, among tables[a]
+	opGetLocal2                           // push locals a and b
+	opI32AddLL                            // push the sum of the i32 locals a and b
+	opI32AddLC                            // push the sum of the i32 local a and the constant v
+)
+
+// instr is an instruction of a compiled function.
+type instr struct {
+	op      wasm.Opcode
+	a, b, c uint32 // local, global, function or type index, MiscOpcode, or branch target, height and arity
+	v       uint64 // constant or memory offset
+	off     int    // offset of the originating wasm instruction in the bytecode
+}
+
+// branch is a resolved branch target.
+type branch struct {
+	pc     int // index of the instruction to continue with
+	height int // height of the operand stack at the target, relative to the locals of the frame
+	arity  int // number of values carried by the branch
+}
+
+// ctrl is a block, loop or if being compiled.
+type ctrl struct {
+	op       wasm.Opcode
+	height   int // height of the operand stack when entering the block
+	arity    int // number of results of the block
+	start    int // index of the first instruction of a loop
+	elseJump int // index of the opBrUnless instruction of an if, or -1
+
+	fixups []func(pc int) // branches to the end of the block, resolved at its end
+}
+
+// compiler compiles the function bodies of a module.
+type compiler struct {
+	types []wasm.FuncType // types of the module
+	funcs []wasm.FuncType // types of the functions of the module, including imported ones
+	costs *Costs          // cost of instructions, or nil
+
+	code    *funcCode
+	ctrls   []ctrl
+	height  int    // height of the operand stack
+	dead    bool   // whether the current instruction is unreachable
+	nested  int    // number of blocks entered by unreachable instructions
+	pending uint64 // fuel consumed by the instructions not emitted yet
+}
+
+// compile compiles the body of a function of type typ.
+func (c *compiler) compile(body wasm.FunctionBody, typ wasm.FuncType) (*funcCode, error) {
+	instrs, err := body.Code.Instrs()
+	if err != nil {
+		return nil, err
+	}
+
+	code := &funcCode{offset: body.Code.Offset}
+	for _, l := range body.Locals {
+		code.nlocals += int(l.Count)
+	}
+	c.code = code
+	c.height = len(typ.Params) + code.nlocals
+	c.dead = false
+	c.nested = 0
+	c.pending = 0
+	c.ctrls = append(c.ctrls[:0], ctrl{
+		op:       wasm.Op_block,
+		height:   c.height,
+		arity:    len(typ.Results),
+		elseJump: -1,
+	})
+
+	for i := 0; i < len(instrs); i++ {
+		ins := &instrs[i]
+		if c.dead {
+			switch ins.Op {
+			case wasm.Op_block, wasm.Op_loop, wasm.Op_if:
+				c.nested++
+				continue
+			case wasm.Op_else, wasm.Op_end:
+				if c.nested > 0 {
+					if ins.Op == wasm.Op_end {
+						c.nested--
+					}
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		if c.costs != nil {
+			c.pending += c.costs.cost(ins)
+		}
+		i += c.translate(instrs[i:])
+	}
+	if len(c.ctrls) != 0 {
+		return nil, fmt.Errorf("exec: unterminated block")
+	}
+	return code, nil
+}
+
+// emit appends an instruction to the compiled code, and returns its index.
+func (c *compiler) emit(in instr) int {
+	code := c.code
+	code.ir = append(code.ir, in)
+	if c.costs != nil {
+		code.cost = append(code.cost, c.pending)
+		c.pending = 0
+	}
+	return len(code.ir) - 1
+}
+
+// target returns the branch target of a branch to the label at the given
+// depth. Branches to the end of a block are resolved later, by set.
+func (c *compiler) target(depth uint32, set func(br branch)) {
+	l := &c.ctrls[len(c.ctrls)-1-int(depth)]
+	br := branch{height: l.height, arity: l.arity}
+	if l.op == wasm.Op_loop {
+		br.pc = l.start
+		br.arity = 0
+		set(br)
+		return
+	}
+	l.fixups = append(l.fixups, func(pc int) {
+		br.pc = pc
+		set(br)
+	})
+}
+
+// branch emits a branch instruction to the label at the given depth.
+func (c *compiler) branch(op wasm.Opcode, depth uint32, off int) {
+	i := c.emit(instr{op: op, off: off})
+	c.target(depth, func(br branch) {
+		in := &c.code.ir[i]
+		in.a, in.b, in.c = uint32(br.pc), uint32(br.height), uint32(br.arity)
+	})
+}
+
+// translate compiles the instruction instrs[0], possibly fused with the
+// following ones, and returns the number of following instructions it
+// consumed.
+func (c *compiler) translate(instrs []wasm.Instr) int {
+	ins := &instrs[0]
+	off := ins.Offset
+	switch ins.Op {
+	case wasm.Op_nop:
+
+	case wasm.Op_block:
+		c.ctrls = append(c.ctrls, ctrl{
+			op:       ins.Op,
+			height:   c.height,
+			arity:    blockArity(ins.Block),
+			elseJump: -1,
+		})
+
+	case wasm.Op_loop:
+		// the loop instruction polls for interruptions at each iteration.
+		c.ctrls = append(c.ctrls, ctrl{
+			op:       ins.Op,
+			height:   c.height,
+			arity:    blockArity(ins.Block),
+			start:    c.emit(instr{op: wasm.Op_loop, off: off}),
+			elseJump: -1,
+		})
+
+	case wasm.Op_if:
+		c.height--
+		c.ctrls = append(c.ctrls, ctrl{
+			op:       ins.Op,
+			height:   c.height,
+			arity:    blockArity(ins.Block),
+			elseJump: c.emit(instr{op: opBrUnless, off: off}),
+		})
+
+	case wasm.Op_else:
+		l := &c.ctrls[len(c.ctrls)-1]
+		if !c.dead {
+			i := c.emit(instr{op: opJump, off: off})
+			l.fixups = append(l.fixups, func(pc int) { c.code.ir[i].a = uint32(pc) })
+		}
+		c.code.ir[l.elseJump].a = uint32(len(c.code.ir))
+		l.elseJump = -1
+		c.height = l.height
+		c.dead = false
+
+	case wasm.Op_end:
+		l := c.ctrls[len(c.ctrls)-1]
+		c.ctrls = c.ctrls[:len(c.ctrls)-1]
+		if len(c.ctrls) == 0 {
+			// end of the function: branches to its label return.
+			pc := c.emit(instr{op: wasm.Op_return, off: off})
+			for _, fix := range l.fixups {
+				fix(pc)
+			}
+			break
+		}
+		pc := len(c.code.ir)
+		if l.elseJump >= 0 {
+			c.code.ir[l.elseJump].a = uint32(pc)
+		}
+		for _, fix := range l.fixups {
+			fix(pc)
+		}
+		c.height = l.height + l.arity
+		c.dead = false
+
+	case wasm.Op_br:
+		c.branch(opBr, ins.Index, off)
+		c.dead = true
+
+	case wasm.Op_br_if:
+		c.height--
+		c.branch(opBrIf, ins.Index, off)
+
+	case wasm.Op_br_table:
+		c.height--
+		t := len(c.code.tables)
+		table := make([]branch, len(ins.Targets)+1)
+		c.code.tables = append(c.code.tables, table)
+		for j, depth := range append(ins.Targets[:len(ins.Targets):len(ins.Targets)], ins.Default) {
+			j := j
+			c.target(depth, func(br branch) { c.code.tables[t][j] = br })
+		}
+		c.emit(instr{op: opBrTable, a: uint32(t), off: off})
+		c.dead = true
+
+	case wasm.Op_return, wasm.Op_unreachable:
+		c.emit(instr{op: ins.Op, off: off})
+		c.dead = true
+
+	case wasm.Op_call:
+		typ := c.funcs[ins.Index]
+		c.emit(instr{op: ins.Op, a: ins.Index, off: off})
+		c.height += len(typ.Results) - len(typ.Params)
+
+	case wasm.Op_call_indirect:
+		typ := c.types[ins.Index]
+		c.emit(instr{op: ins.Op, a: ins.Index, off: off})
+		c.height += len(typ.Results) - len(typ.Params) - 1
+
+	case wasm.Op_get_local:
+		return c.getLocal(instrs)
+
+	case wasm.Op_drop, wasm.Op_set_local, wasm.Op_set_global:
+		c.emit(instr{op: ins.Op, a: ins.Index, off: off})
+		c.height--
+
+	case wasm.Op_select:
+		c.emit(instr{op: ins.Op, off: off})
+		c.height -= 2
+
+	case wasm.Op_tee_local, wasm.Op_grow_memory:
+		c.emit(instr{op: ins.Op, a: ins.Index, off: off})
+
+	case wasm.Op_get_global, wasm.Op_current_memory:
+		c.emit(instr{op: ins.Op, a: ins.Index, off: off})
+		c.height++
+
+	case wasm.Op_i32_const:
+		c.emit(instr{op: ins.Op, v: uint64(uint32(ins.Value)), off: off})
+		c.height++
+
+	case wasm.Op_i64_const, wasm.Op_f32_const, wasm.Op_f64_const:
+		c.emit(instr{op: ins.Op, v: ins.Value, off: off})
+		c.height++
+
+	case wasm.Op_prefix_misc:
+		params, results, _ := wasm.MiscSignature(ins.Misc)
+		c.emit(instr{op: ins.Op, a: uint32(ins.Misc), off: off})
+		c.height += len(results) - len(params)
+
+	default:
+		// memory accesses and numeric instructions.
+		// the module was validated: all the other instructions have a
+		// signature.
+		params, results, _ := wasm.Signature(ins.Op)
+		c.emit(instr{op: ins.Op, v: uint64(ins.Mem.Offset), off: off})
+		c.height += len(results) - len(params)
+	}
+	return 0
+}
+
+// getLocal compiles the get_local instruction instrs[0], fused with the
+// following instructions when they form one of the sequences:
+//
+//	get_local a; get_local b; i32.add
+//	get_local a; i32.const v; i32.add
+//	get_local a; get_local b
+func (c *compiler) getLocal(instrs []wasm.Instr) int {
+	ins := &instrs[0]
+	if len(instrs) >= 3 && instrs[2].Op == wasm.Op_i32_add {
+		switch next := &instrs[1]; next.Op {
+		case wasm.Op_get_local:
+			c.consume(instrs[1:3])
+			c.emit(instr{op: opI32AddLL, a: ins.Index, b: next.Index, off: instrs[2].Offset})
+			c.height++
+			return 2
+		case wasm.Op_i32_const:
+			c.consume(instrs[1:3])
+			c.emit(instr{op: opI32AddLC, a: ins.Index, v: uint64(uint32(next.Value)), off: instrs[2].Offset})
+			c.height++
+			return 2
+		}
+	}
+	if len(instrs) >= 2 && instrs[1].Op == wasm.Op_get_local {
+		c.consume(instrs[1:2])
+		c.emit(instr{op: opGetLocal2, a: ins.Index, b: instrs[1].Index, off: instrs[1].Offset})
+		c.height += 2
+		return 1
+	}
+	c.emit(instr{op: ins.Op, a: ins.Index, off: ins.Offset})
+	c.height++
+	return 0
+}
+
+// consume adds the fuel consumed by instructions fused with the current
+// one.
+func (c *compiler) consume(instrs []wasm.Instr) {
+	if c.costs == nil {
+		return
+	}
+	for i := range instrs {
+		c.pending += c.costs.cost(&instrs[i])
+	}
+}
+
+// run executes the compiled body of f, whose locals start at base on the
+// operand stack.
+func (m *machine) run(f *Func, base int) {
+	var (
+		inst = f.inst
+		code = f.code
+		ir   = code.ir
+		mem  *Memory
+		pc   int
+	)
+	if len(inst.mems) > 0 {
+		mem = inst.mems[0]
+	}
+
+	defer m.recordTrace(&pc)
+
+	for pc < len(ir) {
+		in := &ir[pc]
+		pc++
+		if code.cost != nil {
+			inst.consume(code.cost[pc-1])
+		}
+		switch in.op {
+		case wasm.Op_unreachable:
+			panic(trap{ErrUnreachable})
+
+		case wasm.Op_loop:
+			m.poll()
+
+		case opJump:
+			pc = int(in.a)
+
+		case opBr:
+			pc = m.unwind(base, int(in.a), int(in.b), int(in.c))
+
+		case opBrIf:
+			if uint32(m.pop()) != 0 {
+				pc = m.unwind(base, int(in.a), int(in.b), int(in.c))
+			}
+
+		case opBrUnless:
+			if uint32(m.pop()) == 0 {
+				pc = int(in.a)
+			}
+
+		case opBrTable:
+			table := code.tables[in.a]
+			i := uint64(uint32(m.pop()))
+			if i >= uint64(len(table)) {
+				i = uint64(len(table) - 1)
+			}
+			br := &table[i]
+			pc = m.unwind(base, br.pc, br.height, br.arity)
+
+		case wasm.Op_return:
+			pc = len(ir)
+
+		case wasm.Op_call:
+			m.frames[len(m.frames)-1].pc = pc
+			m.call(inst.funcs[in.a], inst)
+
+		case wasm.Op_call_indirect:
+			m.frames[len(m.frames)-1].pc = pc
+			m.call(inst.indirect(uint32(m.pop()), in.a), inst)
+
+		case wasm.Op_drop:
+			m.stack = m.stack[:len(m.stack)-1]
+
+		case wasm.Op_select:
+			cond := uint32(m.pop())
+			v := m.pop()
+			if cond == 0 {
+				m.stack[len(m.stack)-1] = v
+			}
+
+		case wasm.Op_get_local:
+			m.push(m.stack[base+int(in.a)])
+
+		case opGetLocal2:
+			m.push(m.stack[base+int(in.a)])
+			m.push(m.stack[base+int(in.b)])
+
+		case opI32AddLL:
+			m.push(uint64(uint32(m.stack[base+int(in.a)]) + uint32(m.stack[base+int(in.b)])))
+
+		case opI32AddLC:
+			m.push(uint64(uint32(m.stack[base+int(in.a)]) + uint32(in.v)))
+
+		case wasm.Op_set_local:
+			m.stack[base+int(in.a)] = m.pop()
+
+		case wasm.Op_tee_local:
+			m.stack[base+int(in.a)] = m.stack[len(m.stack)-1]
+
+		case wasm.Op_get_global:
+			m.push(inst.globals[in.a].val)
+
+		case wasm.Op_set_global:
+			inst.globals[in.a].val = m.pop()
+
+		case wasm.Op_i32_load, wasm.Op_i64_load, wasm.Op_f32_load, wasm.Op_f64_load,
+			wasm.Op_i32_load8_s, wasm.Op_i32_load8_u, wasm.Op_i32_load16_s, wasm.Op_i32_load16_u,
+			wasm.Op_i64_load8_s, wasm.Op_i64_load8_u, wasm.Op_i64_load16_s, wasm.Op_i64_load16_u,
+			wasm.Op_i64_load32_s, wasm.Op_i64_load32_u:
+			m.load(mem, in.op, uint32(in.v))
+
+		case wasm.Op_i32_store, wasm.Op_i64_store, wasm.Op_f32_store, wasm.Op_f64_store,
+			wasm.Op_i32_store8, wasm.Op_i32_store16,
+			wasm.Op_i64_store8, wasm.Op_i64_store16, wasm.Op_i64_store32:
+			m.store(mem, in.op, uint32(in.v))
+
+		case wasm.Op_current_memory:
+			m.push(uint64(mem.Size()))
+
+		case wasm.Op_grow_memory:
+			m.growMemory(mem)
+
+		case wasm.Op_prefix_misc:
+			m.misc(mem, wasm.MiscOpcode(in.a))
+
+		case wasm.Op_i32_const, wasm.Op_i64_const, wasm.Op_f32_const, wasm.Op_f64_const:
+			m.push(in.v)
+
+		default:
+			m.numeric(in.op)
+		}
+	}
+
+	// move the results of the function in place of its locals.
+	n := len(f.typ.Results)
+	copy(m.stack[base:], m.stack[len(m.stack)-n:])
+	m.stack = m.stack[:base+n]
+}
+
+// unwind unwinds the operand stack of the frame starting at base to the
+// given height, keeping the arity values on top of it, and returns pc.
+func (m *machine) unwind(base, pc, height, arity int) int {
+	h := base + height
+	if n := len(m.stack); n != h+arity {
+		copy(m.stack[h:], m.stack[n-arity:])
+		m.stack = m.stack[:h+arity]
+	}
+	return pc
+}
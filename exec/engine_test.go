@@ -0,0 +1,169 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+// computeModule holds compute-heavy functions, run by both engines.
+const computeModule = `(module
+  (memory 2)
+  (func $fib (export "fib") (param i32) (result i32)
+    (if (result i32) (i32.lt_u (get_local 0) (i32.const 2))
+      (then (get_local 0))
+      (else
+        (i32.add
+          (call $fib (i32.sub (get_local 0) (i32.const 1)))
+          (call $fib (i32.sub (get_local 0) (i32.const 2)))))))
+
+  (func (export "sum") (param i32) (result i64)
+    (local i32 i64)
+    (block
+      (loop
+        (br_if 1 (i32.ge_u (get_local 1) (get_local 0)))
+        (set_local 2 (i64.add (get_local 2) (i64.extend_u/i32 (get_local 1))))
+        (set_local 1 (i32.add (get_local 1) (i32.const 1)))
+        (br 0)))
+    (get_local 2))
+
+  ;; sieve returns the number of primes below n, at most 65536.
+  (func (export "sieve") (param $n i32) (result i32)
+    (local $i i32) (local $j i32) (local $count i32)
+    (memory.fill (i32.const 0) (i32.const 1) (get_local $n))
+    (set_local $i (i32.const 2))
+    (block $done
+      (loop $outer
+        (br_if $done (i32.ge_u (get_local $i) (get_local $n)))
+        (if (i32.load8_u (get_local $i))
+          (then
+            (set_local $count (i32.add (get_local $count) (i32.const 1)))
+            (set_local $j (i32.mul (get_local $i) (get_local $i)))
+            (block $inner_done
+              (loop $inner
+                (br_if $inner_done (i32.ge_u (get_local $j) (get_local $n)))
+                (i32.store8 (get_local $j) (i32.const 0))
+                (set_local $j (i32.add (get_local $j) (get_local $i)))
+                (br $inner)))))
+        (set_local $i (i32.add (get_local $i) (i32.const 1)))
+        (br $outer)))
+    (get_local $count))
+
+  ;; collatz returns the total number of steps of the Collatz sequences of
+  ;; the integers below n.
+  (func (export "collatz") (param $n i32) (result i32)
+    (local $i i32) (local $x i64) (local $steps i32)
+    (set_local $i (i32.const 1))
+    (loop $outer
+      (set_local $x (i64.extend_u/i32 (get_local $i)))
+      (block $done
+        (loop $inner
+          (br_if $done (i64.eq (get_local $x) (i64.const 1)))
+          (set_local $x
+            (select
+              (i64.add (i64.mul (get_local $x) (i64.const 3)) (i64.const 1))
+              (i64.shr_u (get_local $x) (i64.const 1))
+              (i32.wrap/i64 (i64.and (get_local $x) (i64.const 1)))))
+          (set_local $steps (i32.add (get_local $steps) (i32.const 1)))
+          (br $inner)))
+      (br_if $outer (i32.lt_u (tee_local $i (i32.add (get_local $i) (i32.const 1))) (get_local $n))))
+    (get_local $steps))
+
+  (func (export "dot") (param $n i32) (result f64)
+    (local $i i32) (local $acc f64) (local $x f64)
+    (block
+      (loop
+        (br_if 1 (i32.ge_s (get_local $i) (get_local $n)))
+        (set_local $x (f64.convert_s/i32 (get_local $i)))
+        (set_local $acc (f64.add (get_local $acc) (f64.mul (get_local $x) (f64.div (get_local $x) (f64.const 2)))))
+        (set_local $i (i32.add (get_local $i) (i32.const 1)))
+        (br 0)))
+    (get_local $acc))
+
+  (func (export "switch") (param i32) (result i32)
+    (block $d
+      (block $c
+        (block $b
+          (block $a
+            (br_table $a $b $c $d (get_local 0)))
+          (return (i32.const 10)))
+        (return (i32.const 11)))
+      (br 1 (i32.const 12)))
+    (i32.const 13))
+)`
+
+var computeCalls = []struct {
+	name string
+	arg  int32
+	want interface{}
+}{
+	{"fib", 20, int32(6765)},
+	{"sum", 100000, int64(4999950000)},
+	{"sieve", 65536, int32(6542)},
+	{"collatz", 1000, int32(59431)},
+	{"dot", 1000, 166416750.0},
+	{"switch", 0, int32(10)},
+	{"switch", 2, int32(12)},
+	{"switch", 3, int32(13)},
+	{"switch", 100, int32(13)},
+}
+
+func TestEngines(t *testing.T) {
+	mod := parse(t, computeModule)
+	for _, engine := range []exec.Engine{exec.Compiler, exec.Interpreter} {
+		inst, err := exec.InstantiateConfig(mod, exec.Config{Engine: engine})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tc := range computeCalls {
+			got, err := inst.Call(tc.name, tc.arg)
+			if err != nil {
+				t.Errorf("engine %d: %s(%d): %v", engine, tc.name, tc.arg, err)
+				continue
+			}
+			if want := []interface{}{tc.want}; !reflect.DeepEqual(got, want) {
+				t.Errorf("engine %d: %s(%d): got %v, want %v", engine, tc.name, tc.arg, got, want)
+			}
+		}
+	}
+}
+
+func benchmarkEngines(b *testing.B, name string, arg int32) {
+	mod, err := wasm.ParseText([]byte(computeModule))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bench := range []struct {
+		name   string
+		engine exec.Engine
+	}{
+		{"Compiler", exec.Compiler},
+		{"Interpreter", exec.Interpreter},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			inst, err := exec.InstantiateConfig(mod, exec.Config{Engine: bench.engine})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := inst.Call(name, arg)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFib(b *testing.B)     { benchmarkEngines(b, "fib", 20) }
+func BenchmarkSum(b *testing.B)     { benchmarkEngines(b, "sum", 100000) }
+func BenchmarkSieve(b *testing.B)   { benchmarkEngines(b, "sieve", 65536) }
+func BenchmarkCollatz(b *testing.B) { benchmarkEngines(b, "collatz", 1000) }
+func BenchmarkDot(b *testing.B)     { benchmarkEngines(b, "dot", 1000) }
@@ -0,0 +1,143 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors reported when the execution of a function traps.
+var (
+	ErrUnreachable              = errors.New("exec: unreachable executed")
+	ErrDivideByZero             = errors.New("exec: integer divide by zero")
+	ErrIntegerOverflow          = errors.New("exec: integer overflow")
+	ErrInvalidConversion        = errors.New("exec: invalid conversion to integer")
+	ErrOutOfBounds              = errors.New("exec: out of bounds memory access")
+	ErrUndefinedElement         = errors.New("exec: undefined element")
+	ErrUninitializedElement     = errors.New("exec: uninitialized element")
+	ErrIndirectCallTypeMismatch = errors.New("exec: indirect call type mismatch")
+	ErrCallStackExhausted       = errors.New("exec: call stack exhausted")
+	ErrValueStackExhausted      = errors.New("exec: value stack exhausted")
+	ErrOutOfFuel                = errors.New("exec: out of fuel")
+)
+
+// ErrInterrupted is reported when the execution of a function is
+// interrupted by the cancellation of its context.
+// The error returned by CallContext then also wraps the error of the
+// context.
+var ErrInterrupted = errors.New("exec: execution interrupted")
+
+// trap is the value panicking through the interpreter when the execution
+// of a function traps.
+type trap struct {
+	err error
+}
+
+// TrapKind is the cause of a trap.
+type TrapKind int
+
+// Causes of traps.
+const (
+	TrapHost                     TrapKind = iota // error returned by a host function
+	TrapUnreachable                              // unreachable executed
+	TrapDivideByZero                             // integer divide by zero
+	TrapIntegerOverflow                          // integer overflow
+	TrapInvalidConversion                        // invalid conversion to integer
+	TrapOutOfBounds                              // out of bounds memory access
+	TrapUndefinedElement                         // undefined table element
+	TrapUninitializedElement                     // uninitialized table element
+	TrapIndirectCallTypeMismatch                 // indirect call type mismatch
+	TrapCallStackExhausted                       // call stack exhausted
+	TrapValueStackExhausted                      // value stack exhausted
+	TrapOutOfFuel                                // out of fuel
+	TrapInterrupted                              // execution interrupted
+)
+
+var trapErrors = []error{
+	TrapUnreachable:              ErrUnreachable,
+	TrapDivideByZero:             ErrDivideByZero,
+	TrapIntegerOverflow:          ErrIntegerOverflow,
+	TrapInvalidConversion:        ErrInvalidConversion,
+	TrapOutOfBounds:              ErrOutOfBounds,
+	TrapUndefinedElement:         ErrUndefinedElement,
+	TrapUninitializedElement:     ErrUninitializedElement,
+	TrapIndirectCallTypeMismatch: ErrIndirectCallTypeMismatch,
+	TrapCallStackExhausted:       ErrCallStackExhausted,
+	TrapValueStackExhausted:      ErrValueStackExhausted,
+	TrapOutOfFuel:                ErrOutOfFuel,
+	TrapInterrupted:              ErrInterrupted,
+}
+
+func (k TrapKind) String() string {
+	if k == TrapHost {
+		return "host error"
+	}
+	if k > 0 && int(k) < len(trapErrors) {
+		return strings.TrimPrefix(trapErrors[k].Error(), "exec: ")
+	}
+	return fmt.Sprintf("TrapKind(%d)", int(k))
+}
+
+// trapKind returns the kind of a trap caused by err.
+func trapKind(err error) TrapKind {
+	for k, e := range trapErrors {
+		if e != nil && errors.Is(err, e) {
+			return TrapKind(k)
+		}
+	}
+	return TrapHost
+}
+
+// Trap is the error returned when the execution of a function traps.
+// It wraps the error causing the trap: one of the Err* errors of this
+// package, or the error returned by a host function.
+type Trap struct {
+	Kind  TrapKind
+	Err   error
+	Stack []Frame // wasm call stack, innermost frame first
+}
+
+func (t *Trap) Error() string { return t.Err.Error() }
+
+// Unwrap returns the error causing the trap.
+func (t *Trap) Unwrap() error { return t.Err }
+
+// Trace returns a description of the trap and of its call stack, one frame
+// per line.
+func (t *Trap) Trace() string {
+	var b strings.Builder
+	b.WriteString(t.Error())
+	for _, f := range t.Stack {
+		b.WriteString("\n\tat ")
+		b.WriteString(f.String())
+	}
+	return b.String()
+}
+
+// Frame is a frame of the wasm call stack of a trap.
+type Frame struct {
+	Func uint32 // index of the function in its module
+	Name string // name of the function, from the name section of its module, or ""
+
+	// Offset is the offset of the executing instruction from the start of
+	// the bytecode of the function.
+	// ModuleOffset is the offset of that instruction within the module, or
+	// -1 if the module was not decoded from its binary format.
+	Offset       int
+	ModuleOffset int64
+}
+
+func (f Frame) String() string {
+	name := fmt.Sprintf("func[%d]", f.Func)
+	if f.Name != "" {
+		name = fmt.Sprintf("$%s (func[%d])", f.Name, f.Func)
+	}
+	if f.ModuleOffset >= 0 {
+		return fmt.Sprintf("%s +0x%x (module offset 0x%x)", name, f.Offset, f.ModuleOffset)
+	}
+	return fmt.Sprintf("%s +0x%x", name, f.Offset)
+}
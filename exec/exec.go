@@ -0,0 +1,322 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package exec executes WebAssembly modules.
+//
+// A module is first instantiated, which allocates its functions, tables,
+// memories and globals, initializes them and runs its start function.
+// The exported functions of the instance may then be called:
+//
+//	inst, err := exec.Instantiate(mod)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	res, err := inst.Call("add", int32(1), int32(2))
+//
+// Values of type i32, i64, f32 and f64 are passed to and returned from
+// functions as int32, int64, float32 and float64 Go values.
+// A function that traps returns a *Trap, describing the cause of the trap
+// and the wasm call stack, and wrapping one of the Err* errors of this
+// package or the error of a host function.
+//
+// Functions imported by a module may be implemented in Go, and provided to
+// Instantiate through host modules:
+//
+//	env := exec.NewHostModule("env")
+//	err := env.DefineFunc("log", func(c *exec.Caller, ptr, n uint32) {
+//		log.Printf("%s", c.Memory().Bytes()[ptr:ptr+n])
+//	})
+//	...
+//	inst, err := exec.Instantiate(mod, env)
+//
+// A Linker instantiates modules importing the functions, tables, memories
+// and globals exported by previously instantiated modules.
+//
+// Untrusted modules may be instantiated with a Config bounding the fuel,
+// call depth, operand stack, memories and tables used by their instances,
+// and their functions called with a context.Context interrupting them on
+// cancellation:
+//
+//	inst, err := exec.InstantiateConfig(mod, exec.Config{Fuel: 1e9, MaxMemoryPages: 256})
+//	...
+//	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+//	defer cancel()
+//	res, err := inst.CallContext(ctx, "run")
+package exec
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sbinet/wasm"
+)
+
+// Instance is an instantiated module.
+// An Instance is not safe for concurrent use.
+type Instance struct {
+	types   []wasm.FuncType
+	funcs   []*Func
+	tables  []*Table
+	mems    []*Memory
+	globals []*Global
+	exports map[string]Extern
+
+	names wasm.NameMap // names of functions, from the name section
+
+	cfg  Config
+	fuel uint64 // remaining fuel of a metered instance
+
+	machine *machine // machine running the functions of the instance, or nil
+}
+
+// Instantiate validates and instantiates the module m.
+// The imports of m are resolved against the given host modules.
+// Use a Linker to import entities exported by other instances.
+func Instantiate(m *wasm.Module, hosts ...*HostModule) (*Instance, error) {
+	return InstantiateConfig(m, Config{}, hosts...)
+}
+
+// InstantiateConfig validates and instantiates the module m, with the
+// limits of the given configuration.
+func InstantiateConfig(m *wasm.Module, cfg Config, hosts ...*HostModule) (*Instance, error) {
+	return instantiate(m, cfg, func(module, field string) (Extern, bool) {
+		for _, hm := range hosts {
+			if hm.Name() == module {
+				return hm.Export(field)
+			}
+		}
+		return nil, false
+	})
+}
+
+// resolver returns the entity exported under the given module and field
+// names.
+type resolver func(module, field string) (Extern, bool)
+
+func instantiate(m *wasm.Module, cfg Config, resolve resolver) (*Instance, error) {
+	err := wasm.Validate(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		inst = &Instance{exports: make(map[string]Extern), cfg: cfg, fuel: cfg.Fuel}
+
+		funcs    []uint32
+		bodies   []wasm.FunctionBody
+		exports  []wasm.ExportEntry
+		start    *wasm.StartSection
+		elements []wasm.ElemSegment
+		data     []wasm.DataSegment
+	)
+	for _, sec := range m.Sections {
+		if lazy, ok := sec.(*wasm.LazySection); ok {
+			sec, err = lazy.Decode()
+			if err != nil {
+				return nil, err
+			}
+		}
+		switch sec := sec.(type) {
+		case wasm.TypeSection:
+			inst.types = append(inst.types, sec.Types...)
+		case wasm.ImportSection:
+			for _, imp := range sec.Imports {
+				ext, ok := resolve(imp.Module, imp.Field)
+				if !ok {
+					return nil, fmt.Errorf("exec: unknown import %q %q", imp.Module, imp.Field)
+				}
+				err := inst.link(imp, ext)
+				if err != nil {
+					return nil, err
+				}
+			}
+		case wasm.FunctionSection:
+			funcs = append(funcs, sec.Types...)
+		case wasm.TableSection:
+			max := cfg.MaxTableSize
+			if max == 0 {
+				max = defaultMaxTableSize
+			}
+			for _, typ := range sec.Tables {
+				if typ.Limits.Initial > max {
+					return nil, fmt.Errorf("exec: table of %d elements exceeds the limit of %d elements", typ.Limits.Initial, max)
+				}
+				inst.tables = append(inst.tables, NewTable(typ))
+			}
+		case wasm.MemorySection:
+			for _, typ := range sec.Memories {
+				max := cfg.MaxMemoryPages
+				if max > 0 && typ.Limits.Initial > max {
+					return nil, fmt.Errorf("exec: memory of %d pages exceeds the limit of %d pages", typ.Limits.Initial, max)
+				}
+				mem := NewMemory(typ)
+				mem.limit = max
+				inst.mems = append(inst.mems, mem)
+			}
+		case wasm.GlobalSection:
+			for _, g := range sec.Globals {
+				v, err := inst.eval(g.Init)
+				if err != nil {
+					return nil, err
+				}
+				inst.globals = append(inst.globals, &Global{typ: g.Type, val: v})
+			}
+		case wasm.ExportSection:
+			exports = append(exports, sec.Exports...)
+		case wasm.StartSection:
+			start = &sec
+		case wasm.ElementSection:
+			elements = append(elements, sec.Elements...)
+		case wasm.CodeSection:
+			bodies = append(bodies, sec.Bodies...)
+		case wasm.DataSection:
+			data = append(data, sec.Segments...)
+		}
+	}
+
+	// a malformed name section does not prevent the instantiation.
+	if ns, ok, err := m.Names(); ok && err == nil {
+		inst.names = ns.Functions
+	}
+
+	c := &compiler{types: inst.types, costs: inst.costs()}
+	for _, f := range inst.funcs {
+		c.funcs = append(c.funcs, f.typ)
+	}
+	for _, typ := range funcs {
+		c.funcs = append(c.funcs, inst.types[typ])
+	}
+	for i, typ := range funcs {
+		idx := uint32(len(inst.funcs))
+		var code *funcCode
+		switch cfg.Engine {
+		case Interpreter:
+			code, err = decode(bodies[i], c.costs)
+		default:
+			code, err = c.compile(bodies[i], inst.types[typ])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("exec: func[%d]: %w", idx, err)
+		}
+		inst.funcs = append(inst.funcs, &Func{
+			typ:  inst.types[typ],
+			inst: inst,
+			idx:  idx,
+			code: code,
+		})
+	}
+
+	for _, exp := range exports {
+		var ext Extern
+		switch exp.Kind {
+		case wasm.FunctionKind:
+			ext = inst.funcs[exp.Index]
+		case wasm.TableKind:
+			ext = inst.tables[exp.Index]
+		case wasm.MemoryKind:
+			ext = inst.mems[exp.Index]
+		case wasm.GlobalKind:
+			ext = inst.globals[exp.Index]
+		}
+		inst.exports[exp.Field] = ext
+	}
+
+	// check all segments fit before modifying any table or memory.
+	elemOffsets := make([]uint64, len(elements))
+	for i, seg := range elements {
+		off, err := inst.eval(seg.Offset)
+		if err != nil {
+			return nil, err
+		}
+		off = uint64(uint32(off))
+		if off+uint64(len(seg.Elems)) > uint64(inst.tables[seg.Index].Len()) {
+			return nil, fmt.Errorf("exec: element segment %d does not fit", i)
+		}
+		elemOffsets[i] = off
+	}
+	dataOffsets := make([]uint64, len(data))
+	for i, seg := range data {
+		off, err := inst.eval(seg.Offset)
+		if err != nil {
+			return nil, err
+		}
+		off = uint64(uint32(off))
+		if off+uint64(len(seg.Data)) > uint64(len(inst.mems[seg.Index].buf)) {
+			return nil, fmt.Errorf("exec: data segment %d does not fit", i)
+		}
+		dataOffsets[i] = off
+	}
+
+	for i, seg := range elements {
+		tbl := inst.tables[seg.Index]
+		for j, fct := range seg.Elems {
+			tbl.elems[elemOffsets[i]+uint64(j)] = inst.funcs[fct]
+		}
+	}
+	for i, seg := range data {
+		copy(inst.mems[seg.Index].buf[dataOffsets[i]:], seg.Data)
+	}
+
+	if start != nil {
+		_, err := inst.funcs[start.Index].Call()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inst, nil
+}
+
+// eval evaluates a constant initializer expression.
+func (inst *Instance) eval(expr wasm.InitExpr) (uint64, error) {
+	instrs, err := expr.Instrs()
+	if err != nil {
+		return 0, err
+	}
+	ins := instrs[0]
+	switch ins.Op {
+	case wasm.Op_i32_const:
+		return uint64(uint32(ins.Value)), nil
+	case wasm.Op_i64_const, wasm.Op_f32_const, wasm.Op_f64_const:
+		return ins.Value, nil
+	case wasm.Op_get_global:
+		return inst.globals[ins.Index].val, nil
+	}
+	return 0, fmt.Errorf("exec: invalid instruction %v in initializer expression", ins.Op)
+}
+
+// Export returns the entity exported under the given name.
+func (inst *Instance) Export(name string) (Extern, bool) {
+	ext, ok := inst.exports[name]
+	return ext, ok
+}
+
+// costs returns the cost of instructions of a metered instance, or nil.
+func (inst *Instance) costs() *Costs {
+	switch {
+	case inst.cfg.Fuel == 0:
+		return nil
+	case inst.cfg.Costs != nil:
+		return inst.cfg.Costs
+	}
+	return &DefaultCosts
+}
+
+// Call calls the exported function with the given name.
+func (inst *Instance) Call(name string, args ...interface{}) ([]interface{}, error) {
+	return inst.CallContext(context.Background(), name, args...)
+}
+
+// CallContext calls the exported function with the given name, and
+// interrupts it when ctx is done.
+func (inst *Instance) CallContext(ctx context.Context, name string, args ...interface{}) ([]interface{}, error) {
+	ext, ok := inst.exports[name]
+	if !ok {
+		return nil, fmt.Errorf("exec: unknown export %q", name)
+	}
+	f, ok := ext.(*Func)
+	if !ok {
+		return nil, fmt.Errorf("exec: export %q is not a function", name)
+	}
+	return f.CallContext(ctx, args...)
+}
@@ -0,0 +1,352 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+func instantiate(t *testing.T, src string) *exec.Instance {
+	t.Helper()
+	mod, err := wasm.ParseText([]byte(src))
+	if err != nil {
+		t.Fatalf("could not parse module: %v", err)
+	}
+	inst, err := exec.Instantiate(mod)
+	if err != nil {
+		t.Fatalf("could not instantiate module: %v", err)
+	}
+	return inst
+}
+
+func TestCall(t *testing.T) {
+	inst := instantiate(t, `(module
+  (type $i32_i32 (func (param i32) (result i32)))
+  (memory 1 2)
+  (table 4 anyfunc)
+  (global $counter (mut i32) (i32.const 0))
+  (elem (i32.const 0) $fac $fib $add)
+  (data (i32.const 16) "\01\02\03\04\05\06\07\08\ff")
+
+  (func $fac (export "fac") (param i32) (result i32)
+    (if (result i32) (i32.eqz (get_local 0))
+      (then (i32.const 1))
+      (else
+        (i32.mul (get_local 0) (call $fac (i32.sub (get_local 0) (i32.const 1)))))))
+
+  (func $fib (export "fib") (param $n i32) (result i32)
+    (local $a i32) (local $b i32) (local $tmp i32)
+    (set_local $b (i32.const 1))
+    (block $done
+      (loop $loop
+        (br_if $done (i32.eqz (get_local $n)))
+        (set_local $tmp (i32.add (get_local $a) (get_local $b)))
+        (set_local $a (get_local $b))
+        (set_local $b (get_local $tmp))
+        (set_local $n (i32.sub (get_local $n) (i32.const 1)))
+        (br $loop)))
+    (get_local $a))
+
+  (func $add (param i32 i32) (result i32)
+    (i32.add (get_local 0) (get_local 1)))
+
+  (func (export "dispatch") (param i32 i32) (result i32)
+    (call_indirect (type $i32_i32) (get_local 1) (get_local 0)))
+
+  (func (export "switch") (param i32) (result i64)
+    (block $default
+      (block $two
+        (block $one
+          (block $zero
+            (br_table $zero $one $two $default (get_local 0)))
+          (return (i64.const 100)))
+        (return (i64.const 101)))
+      (return (i64.const 102)))
+    (i64.const -1))
+
+  (func (export "count") (result i32)
+    (set_global $counter (i32.add (get_global $counter) (i32.const 1)))
+    (get_global $counter))
+
+  (func (export "load") (param i32) (result i64)
+    (i64.load offset=16 (get_local 0)))
+  (func (export "load8_s") (param i32) (result i32)
+    (i32.load8_s offset=16 (get_local 0)))
+  (func (export "load16_u") (param i32) (result i64)
+    (i64.load16_u (get_local 0)))
+  (func (export "store") (param i32 f64) (result f64)
+    (f64.store (get_local 0) (get_local 1))
+    (f64.load (get_local 0)))
+  (func (export "grow") (param i32) (result i32)
+    (grow_memory (get_local 0)))
+  (func (export "size") (result i32)
+    (current_memory))
+
+  (func (export "div_s") (param i32 i32) (result i32)
+    (i32.div_s (get_local 0) (get_local 1)))
+  (func (export "rem_s") (param i64 i64) (result i64)
+    (i64.rem_s (get_local 0) (get_local 1)))
+  (func (export "rotl") (param i32 i32) (result i32)
+    (i32.rotl (get_local 0) (get_local 1)))
+  (func (export "shr_s") (param i64 i64) (result i64)
+    (i64.shr_s (get_local 0) (get_local 1)))
+  (func (export "clz") (param i64) (result i64)
+    (i64.clz (get_local 0)))
+  (func (export "lt_u") (param i32 i32) (result i32)
+    (i32.lt_u (get_local 0) (get_local 1)))
+  (func (export "select") (param i32) (result f32)
+    (select (f32.const 1.5) (f32.const -2.5) (get_local 0)))
+
+  (func (export "min") (param f64 f64) (result f64)
+    (f64.min (get_local 0) (get_local 1)))
+  (func (export "nearest") (param f32) (result f32)
+    (f32.nearest (get_local 0)))
+  (func (export "copysign") (param f32 f32) (result f32)
+    (f32.copysign (get_local 0) (get_local 1)))
+  (func (export "sqrt") (param f64) (result f64)
+    (f64.sqrt (get_local 0)))
+  (func (export "ne") (param f64 f64) (result i32)
+    (f64.ne (get_local 0) (get_local 1)))
+
+  (func (export "trunc_s") (param f64) (result i32)
+    (i32.trunc_s/f64 (get_local 0)))
+  (func (export "trunc_u") (param f32) (result i64)
+    (i64.trunc_u/f32 (get_local 0)))
+  (func (export "convert_u") (param i64) (result f64)
+    (f64.convert_u/i64 (get_local 0)))
+  (func (export "extend_s") (param i32) (result i64)
+    (i64.extend_s/i32 (get_local 0)))
+  (func (export "wrap") (param i64) (result i32)
+    (i32.wrap/i64 (get_local 0)))
+  (func (export "reinterpret") (param f32) (result i32)
+    (i32.reinterpret/f32 (get_local 0)))
+  (func (export "demote") (param f64) (result f32)
+    (f32.demote/f64 (get_local 0)))
+  (func (export "extend8_s") (param i32) (result i32)
+    (i32.extend8_s (get_local 0)))
+  (func (export "extend32_s") (param i64) (result i64)
+    (i64.extend32_s (get_local 0)))
+  (func (export "trunc_sat_s") (param f64) (result i32)
+    (i32.trunc_sat_f64_s (get_local 0)))
+  (func (export "trunc_sat_u") (param f32) (result i64)
+    (i64.trunc_sat_f32_u (get_local 0)))
+
+  (func (export "fill") (param i32 i32 i32) (result i64)
+    (memory.fill (get_local 0) (get_local 1) (get_local 2))
+    (i64.load (get_local 0)))
+  (func (export "copy") (param i32 i32 i32) (result i64)
+    (memory.copy (get_local 0) (get_local 1) (get_local 2))
+    (i64.load (get_local 0)))
+
+  (func (export "unreachable")
+    (unreachable))
+  (func $loop (export "recurse")
+    (call $loop))
+)`)
+
+	nan := math.Float64frombits(0x7ff8000000000000)
+	for _, tc := range []struct {
+		name string
+		args []interface{}
+		want []interface{}
+		err  error
+	}{
+		{name: "fac", args: []interface{}{int32(0)}, want: []interface{}{int32(1)}},
+		{name: "fac", args: []interface{}{int32(10)}, want: []interface{}{int32(3628800)}},
+		{name: "fib", args: []interface{}{int32(0)}, want: []interface{}{int32(0)}},
+		{name: "fib", args: []interface{}{int32(30)}, want: []interface{}{int32(832040)}},
+		{name: "dispatch", args: []interface{}{int32(0), int32(5)}, want: []interface{}{int32(120)}},
+		{name: "dispatch", args: []interface{}{int32(1), int32(10)}, want: []interface{}{int32(55)}},
+		{name: "dispatch", args: []interface{}{int32(2), int32(1)}, err: exec.ErrIndirectCallTypeMismatch},
+		{name: "dispatch", args: []interface{}{int32(3), int32(1)}, err: exec.ErrUninitializedElement},
+		{name: "dispatch", args: []interface{}{int32(4), int32(1)}, err: exec.ErrUndefinedElement},
+		{name: "dispatch", args: []interface{}{int32(-1), int32(1)}, err: exec.ErrUndefinedElement},
+		{name: "switch", args: []interface{}{int32(0)}, want: []interface{}{int64(100)}},
+		{name: "switch", args: []interface{}{int32(2)}, want: []interface{}{int64(102)}},
+		{name: "switch", args: []interface{}{int32(3)}, want: []interface{}{int64(-1)}},
+		{name: "switch", args: []interface{}{int32(-1)}, want: []interface{}{int64(-1)}},
+		{name: "count", want: []interface{}{int32(1)}},
+		{name: "count", want: []interface{}{int32(2)}},
+		{name: "load", args: []interface{}{int32(0)}, want: []interface{}{int64(0x0807060504030201)}},
+		{name: "load", args: []interface{}{int32(65528 - 16)}, want: []interface{}{int64(0)}},
+		{name: "load", args: []interface{}{int32(65529 - 16)}, err: exec.ErrOutOfBounds},
+		{name: "load", args: []interface{}{int32(-1)}, err: exec.ErrOutOfBounds},
+		{name: "load8_s", args: []interface{}{int32(8)}, want: []interface{}{int32(-1)}},
+		{name: "load16_u", args: []interface{}{int32(23)}, want: []interface{}{int64(0xff08)}},
+		{name: "store", args: []interface{}{int32(32), 1.5}, want: []interface{}{1.5}},
+		{name: "store", args: []interface{}{int32(65535), 1.5}, err: exec.ErrOutOfBounds},
+		{name: "size", want: []interface{}{int32(1)}},
+		{name: "grow", args: []interface{}{int32(1)}, want: []interface{}{int32(1)}},
+		{name: "grow", args: []interface{}{int32(1)}, want: []interface{}{int32(-1)}},
+		{name: "size", want: []interface{}{int32(2)}},
+		{name: "store", args: []interface{}{int32(65535), 1.5}, want: []interface{}{1.5}},
+		{name: "div_s", args: []interface{}{int32(-7), int32(2)}, want: []interface{}{int32(-3)}},
+		{name: "div_s", args: []interface{}{int32(1), int32(0)}, err: exec.ErrDivideByZero},
+		{name: "div_s", args: []interface{}{int32(math.MinInt32), int32(-1)}, err: exec.ErrIntegerOverflow},
+		{name: "rem_s", args: []interface{}{int64(-7), int64(2)}, want: []interface{}{int64(-1)}},
+		{name: "rem_s", args: []interface{}{int64(math.MinInt64), int64(-1)}, want: []interface{}{int64(0)}},
+		{name: "rotl", args: []interface{}{int32(-0x7fffffff), int32(33)}, want: []interface{}{int32(3)}},
+		{name: "shr_s", args: []interface{}{int64(-8), int64(65)}, want: []interface{}{int64(-4)}},
+		{name: "clz", args: []interface{}{int64(1)}, want: []interface{}{int64(63)}},
+		{name: "lt_u", args: []interface{}{int32(1), int32(-1)}, want: []interface{}{int32(1)}},
+		{name: "select", args: []interface{}{int32(1)}, want: []interface{}{float32(1.5)}},
+		{name: "select", args: []interface{}{int32(0)}, want: []interface{}{float32(-2.5)}},
+		{name: "min", args: []interface{}{0.0, math.Copysign(0, -1)}, want: []interface{}{math.Copysign(0, -1)}},
+		{name: "nearest", args: []interface{}{float32(2.5)}, want: []interface{}{float32(2)}},
+		{name: "nearest", args: []interface{}{float32(-3.5)}, want: []interface{}{float32(-4)}},
+		{name: "copysign", args: []interface{}{float32(2), float32(-0.0)}, want: []interface{}{float32(2)}},
+		{name: "copysign", args: []interface{}{float32(2), float32(math.Inf(-1))}, want: []interface{}{float32(-2)}},
+		{name: "sqrt", args: []interface{}{16.0}, want: []interface{}{4.0}},
+		{name: "ne", args: []interface{}{nan, nan}, want: []interface{}{int32(1)}},
+		{name: "trunc_s", args: []interface{}{-3.9}, want: []interface{}{int32(-3)}},
+		{name: "trunc_s", args: []interface{}{2147483647.9}, want: []interface{}{int32(math.MaxInt32)}},
+		{name: "trunc_s", args: []interface{}{2147483648.0}, err: exec.ErrIntegerOverflow},
+		{name: "trunc_s", args: []interface{}{nan}, err: exec.ErrInvalidConversion},
+		{name: "trunc_u", args: []interface{}{float32(-0.5)}, want: []interface{}{int64(0)}},
+		{name: "trunc_u", args: []interface{}{float32(1 << 63)}, want: []interface{}{int64(math.MinInt64)}},
+		{name: "trunc_u", args: []interface{}{float32(-1)}, err: exec.ErrIntegerOverflow},
+		{name: "convert_u", args: []interface{}{int64(-1)}, want: []interface{}{18446744073709551616.0}},
+		{name: "extend_s", args: []interface{}{int32(-2)}, want: []interface{}{int64(-2)}},
+		{name: "wrap", args: []interface{}{int64(0x1_0000_0005)}, want: []interface{}{int32(5)}},
+		{name: "reinterpret", args: []interface{}{float32(-1)}, want: []interface{}{int32(-0x40800000)}},
+		{name: "demote", args: []interface{}{1e300}, want: []interface{}{float32(math.Inf(1))}},
+		{name: "extend8_s", args: []interface{}{int32(0x180)}, want: []interface{}{int32(-128)}},
+		{name: "extend32_s", args: []interface{}{int64(0x1_8000_0000)}, want: []interface{}{int64(math.MinInt32)}},
+		{name: "trunc_sat_s", args: []interface{}{-3.9}, want: []interface{}{int32(-3)}},
+		{name: "trunc_sat_s", args: []interface{}{1e10}, want: []interface{}{int32(math.MaxInt32)}},
+		{name: "trunc_sat_s", args: []interface{}{-1e10}, want: []interface{}{int32(math.MinInt32)}},
+		{name: "trunc_sat_s", args: []interface{}{nan}, want: []interface{}{int32(0)}},
+		{name: "trunc_sat_u", args: []interface{}{float32(-1)}, want: []interface{}{int64(0)}},
+		{name: "trunc_sat_u", args: []interface{}{float32(math.Inf(1))}, want: []interface{}{int64(-1)}},
+		{name: "fill", args: []interface{}{int32(40), int32(0x1ab), int32(8)}, want: []interface{}{int64(-0x5454545454545455)}},
+		{name: "fill", args: []interface{}{int32(-1), int32(0), int32(2)}, err: exec.ErrOutOfBounds},
+		{name: "copy", args: []interface{}{int32(48), int32(16), int32(8)}, want: []interface{}{int64(0x0807060504030201)}},
+		{name: "copy", args: []interface{}{int32(52), int32(48), int32(8)}, want: []interface{}{int64(0x0807060504030201)}},
+		{name: "copy", args: []interface{}{int32(0), int32(-8), int32(8)}, err: exec.ErrOutOfBounds},
+		{name: "unreachable", err: exec.ErrUnreachable},
+		{name: "recurse", err: exec.ErrCallStackExhausted},
+	} {
+		got, err := inst.Call(tc.name, tc.args...)
+		switch {
+		case tc.err != nil:
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%s%v: got error %v, want %v", tc.name, tc.args, err, tc.err)
+			}
+		case err != nil:
+			t.Errorf("%s%v: unexpected error: %v", tc.name, tc.args, err)
+		case !reflect.DeepEqual(got, tc.want):
+			t.Errorf("%s%v: got %v, want %v", tc.name, tc.args, got, tc.want)
+		}
+	}
+
+	// a trap leaves the instance usable.
+	got, err := inst.Call("fac", int32(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int32(120)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	inst := instantiate(t, `(module
+  (memory (export "mem") 1)
+  (func (export "f") (param i32 f64)))`)
+
+	for _, tc := range []struct {
+		name string
+		args []interface{}
+		want string
+	}{
+		{name: "g", want: `exec: unknown export "g"`},
+		{name: "mem", want: `exec: export "mem" is not a function`},
+		{name: "f", args: []interface{}{int32(1)}, want: "exec: invalid number of arguments (got 1, want 2)"},
+		{name: "f", args: []interface{}{int32(1), float32(1)}, want: "exec: argument 1: invalid value 1 (type float32) for f64"},
+	} {
+		_, err := inst.Call(tc.name, tc.args...)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%s%v: got error %v, want %q", tc.name, tc.args, err, tc.want)
+		}
+	}
+}
+
+func TestInstantiate(t *testing.T) {
+	inst := instantiate(t, `(module
+  (memory (export "mem") 1)
+  (table (export "tbl") anyfunc (elem $f))
+  (global $g (export "g") (mut f32) (f32.const 0))
+  (data (i32.const 0) "hello")
+  (func $f
+    (set_global $g (f32.const 42))
+    (i32.store8 (i32.const 0) (i32.const 72)))
+  (start $f))`)
+
+	mem, ok := inst.Export("mem")
+	if !ok {
+		t.Fatalf("missing memory export")
+	}
+	if got := string(mem.(*exec.Memory).Bytes()[:5]); got != "Hello" {
+		t.Fatalf("invalid memory content: got %q, want %q", got, "Hello")
+	}
+	g, _ := inst.Export("g")
+	if got := g.(*exec.Global).Get(); got != float32(42) {
+		t.Fatalf("invalid global value: got %v, want 42", got)
+	}
+	tbl, _ := inst.Export("tbl")
+	if n := tbl.(*exec.Table).Len(); n != 1 {
+		t.Fatalf("invalid table size: got %d, want 1", n)
+	}
+
+	for _, tc := range []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "import",
+			src:  `(module (import "env" "f" (func)))`,
+			want: `exec: unknown import "env" "f"`,
+		},
+		{
+			name: "data",
+			src:  `(module (memory 1) (data (i32.const 65535) "ab"))`,
+			want: "exec: data segment 0 does not fit",
+		},
+		{
+			name: "elem",
+			src:  `(module (table 1 anyfunc) (func $f) (elem (i32.const 0) $f $f))`,
+			want: "exec: element segment 0 does not fit",
+		},
+		{
+			name: "start",
+			src:  `(module (func $f unreachable) (start $f))`,
+			want: exec.ErrUnreachable.Error(),
+		},
+		{
+			name: "invalid",
+			src:  `(module (func (result i32)))`,
+			want: "wasm: func[0], offset 0x0: end: operand stack underflow",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mod, err := wasm.ParseText([]byte(tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = exec.Instantiate(mod)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
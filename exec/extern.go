@@ -0,0 +1,338 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/sbinet/wasm"
+)
+
+const (
+	pageSize = 65536 // size of a page of linear memory, in bytes
+	maxPages = 65536 // maximum number of pages of a linear memory
+)
+
+// Extern is an entity exported by an instance: a *Func, a *Table,
+// a *Memory or a *Global.
+type Extern interface {
+	Kind() wasm.ExternalKind
+}
+
+// Func is a function instance: a function defined by a module, or a host
+// function implemented in Go.
+type Func struct {
+	typ  wasm.FuncType
+	inst *Instance // instance defining the function, nil for host functions
+	idx  uint32    // index of the function in the index space of inst
+	code *funcCode
+	host HostFunc
+}
+
+// Kind returns wasm.FunctionKind.
+func (f *Func) Kind() wasm.ExternalKind { return wasm.FunctionKind }
+
+// Type returns the signature of the function.
+func (f *Func) Type() wasm.FuncType { return f.typ }
+
+// Call calls the function with the given arguments, and returns its
+// results.
+// Arguments and results are int32, int64, float32 or float64 values,
+// matching the signature of the function.
+func (f *Func) Call(args ...interface{}) ([]interface{}, error) {
+	return f.CallContext(context.Background(), args...)
+}
+
+// CallContext calls the function with the given arguments, and returns its
+// results.
+// The execution is interrupted, and traps with ErrInterrupted, when ctx is
+// done.
+// A host function calling back into an instance running the wasm code
+// which called it shares the call depth, operand stack and interruption of
+// that code.
+func (f *Func) CallContext(ctx context.Context, args ...interface{}) (res []interface{}, err error) {
+	if len(args) != len(f.typ.Params) {
+		return nil, fmt.Errorf("exec: invalid number of arguments (got %d, want %d)", len(args), len(f.typ.Params))
+	}
+	var m *machine
+	if f.inst != nil {
+		m = f.inst.machine
+	}
+	if m == nil {
+		m = newMachine(ctx, f.inst)
+		defer m.exit()
+	} else {
+		defer m.reenter(ctx)()
+	}
+	base := len(m.stack)
+	for i, arg := range args {
+		v, err := toRaw(f.typ.Params[i], arg)
+		if err != nil {
+			return nil, fmt.Errorf("exec: argument %d: %w", i, err)
+		}
+		m.stack = append(m.stack, v)
+	}
+
+	if ctx.Done() != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, &Trap{Kind: TrapInterrupted, Err: fmt.Errorf("%w: %w", ErrInterrupted, err)}
+		}
+		stop := context.AfterFunc(ctx, func() { m.interrupted.Store(true) })
+		defer stop()
+	}
+
+	defer func() {
+		e := recover()
+		if e == nil {
+			return
+		}
+		t, ok := e.(trap)
+		if !ok {
+			panic(e)
+		}
+		if t.err == ErrInterrupted {
+			t.err = fmt.Errorf("%w: %w", ErrInterrupted, m.cause())
+		}
+		res, err = nil, &Trap{Kind: trapKind(t.err), Err: t.err, Stack: m.trace}
+	}()
+
+	m.call(f, nil)
+	for i, typ := range f.typ.Results {
+		res = append(res, fromRaw(typ, m.stack[base+i]))
+	}
+	return res, nil
+}
+
+// Table is a table instance: a vector of function references.
+type Table struct {
+	typ   wasm.TableType
+	elems []*Func
+}
+
+// NewTable returns a table of the given type, with uninitialized elements.
+func NewTable(typ wasm.TableType) *Table {
+	return &Table{typ: typ, elems: make([]*Func, typ.Limits.Initial)}
+}
+
+// Kind returns wasm.TableKind.
+func (t *Table) Kind() wasm.ExternalKind { return wasm.TableKind }
+
+// Type returns the type of the table.
+func (t *Table) Type() wasm.TableType {
+	typ := t.typ
+	typ.Limits.Initial = uint32(len(t.elems))
+	return typ
+}
+
+// Len returns the number of elements of the table.
+func (t *Table) Len() int { return len(t.elems) }
+
+// Get returns the i-th element of the table, or nil if that element is
+// uninitialized.
+func (t *Table) Get(i int) *Func { return t.elems[i] }
+
+// Set sets the i-th element of the table.
+func (t *Table) Set(i int, f *Func) { t.elems[i] = f }
+
+// Memory is a linear memory instance.
+type Memory struct {
+	typ   wasm.MemoryType
+	buf   []byte
+	limit uint32 // maximum size set by Config.MaxMemoryPages, or 0
+}
+
+// NewMemory returns a zeroed linear memory of the given type.
+func NewMemory(typ wasm.MemoryType) *Memory {
+	return &Memory{typ: typ, buf: make([]byte, int(typ.Limits.Initial)*pageSize)}
+}
+
+// Kind returns wasm.MemoryKind.
+func (m *Memory) Kind() wasm.ExternalKind { return wasm.MemoryKind }
+
+// Type returns the type of the memory.
+func (m *Memory) Type() wasm.MemoryType {
+	typ := m.typ
+	typ.Limits.Initial = m.Size()
+	return typ
+}
+
+// Bytes returns the content of the memory.
+// The returned slice is only valid until the memory grows.
+func (m *Memory) Bytes() []byte { return m.buf }
+
+// Size returns the size of the memory, in pages.
+func (m *Memory) Size() uint32 { return uint32(len(m.buf) / pageSize) }
+
+// Grow grows the memory by delta pages, and returns its previous size.
+// Grow returns false if the memory would exceed its maximum size, or the
+// limit of the configuration of the instance defining it.
+func (m *Memory) Grow(delta uint32) (uint32, bool) {
+	size := m.Size()
+	max := uint64(maxPages)
+	if m.typ.Limits.Flags&1 != 0 {
+		max = uint64(m.typ.Limits.Maximum)
+	}
+	if m.limit > 0 && uint64(m.limit) < max {
+		max = uint64(m.limit)
+	}
+	if uint64(size)+uint64(delta) > max {
+		return size, false
+	}
+	if delta > 0 {
+		m.buf = append(m.buf, make([]byte, int(delta)*pageSize)...)
+	}
+	return size, true
+}
+
+// Read returns the n bytes of memory at offset off, or false if they are
+// out of bounds.
+// The returned slice aliases the memory, and is only valid until the memory
+// grows.
+func (m *Memory) Read(off, n uint32) ([]byte, bool) {
+	end := uint64(off) + uint64(n)
+	if end > uint64(len(m.buf)) {
+		return nil, false
+	}
+	return m.buf[off:end:end], true
+}
+
+// Write copies p to the memory at offset off.
+// Write returns false, without modifying the memory, if p does not fit.
+func (m *Memory) Write(off uint32, p []byte) bool {
+	if uint64(len(p)) > math.MaxUint32 {
+		return false
+	}
+	b, ok := m.Read(off, uint32(len(p)))
+	if !ok {
+		return false
+	}
+	copy(b, p)
+	return true
+}
+
+// ReadUint32 returns the little-endian uint32 at offset off.
+func (m *Memory) ReadUint32(off uint32) (uint32, bool) {
+	b, ok := m.Read(off, 4)
+	if !ok {
+		return 0, false
+	}
+	return order.Uint32(b), true
+}
+
+// ReadUint64 returns the little-endian uint64 at offset off.
+func (m *Memory) ReadUint64(off uint32) (uint64, bool) {
+	b, ok := m.Read(off, 8)
+	if !ok {
+		return 0, false
+	}
+	return order.Uint64(b), true
+}
+
+// WriteUint16 writes v at offset off, in little-endian order.
+func (m *Memory) WriteUint16(off uint32, v uint16) bool {
+	b, ok := m.Read(off, 2)
+	if ok {
+		order.PutUint16(b, v)
+	}
+	return ok
+}
+
+// WriteUint32 writes v at offset off, in little-endian order.
+func (m *Memory) WriteUint32(off uint32, v uint32) bool {
+	b, ok := m.Read(off, 4)
+	if ok {
+		order.PutUint32(b, v)
+	}
+	return ok
+}
+
+// WriteUint64 writes v at offset off, in little-endian order.
+func (m *Memory) WriteUint64(off uint32, v uint64) bool {
+	b, ok := m.Read(off, 8)
+	if ok {
+		order.PutUint64(b, v)
+	}
+	return ok
+}
+
+// Global is a global variable instance.
+type Global struct {
+	typ wasm.GlobalType
+	val uint64
+}
+
+// NewGlobal returns a global of the given type, holding the value v:
+// an int32, int64, float32 or float64.
+func NewGlobal(typ wasm.GlobalType, v interface{}) (*Global, error) {
+	raw, err := toRaw(typ.ContentType, v)
+	if err != nil {
+		return nil, fmt.Errorf("exec: %w", err)
+	}
+	return &Global{typ: typ, val: raw}, nil
+}
+
+// Kind returns wasm.GlobalKind.
+func (g *Global) Kind() wasm.ExternalKind { return wasm.GlobalKind }
+
+// Type returns the type of the global.
+func (g *Global) Type() wasm.GlobalType { return g.typ }
+
+// Get returns the value of the global: an int32, int64, float32 or float64.
+func (g *Global) Get() interface{} { return fromRaw(g.typ.ContentType, g.val) }
+
+// Set sets the value of a mutable global.
+func (g *Global) Set(v interface{}) error {
+	if g.typ.Mutability == 0 {
+		return fmt.Errorf("exec: global is immutable")
+	}
+	raw, err := toRaw(g.typ.ContentType, v)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	g.val = raw
+	return nil
+}
+
+// toRaw converts v to the representation of a value of type typ on the
+// operand stack.
+func toRaw(typ wasm.ValueType, v interface{}) (uint64, error) {
+	switch v := v.(type) {
+	case int32:
+		if typ == wasm.I32 {
+			return uint64(uint32(v)), nil
+		}
+	case int64:
+		if typ == wasm.I64 {
+			return uint64(v), nil
+		}
+	case float32:
+		if typ == wasm.F32 {
+			return uint64(math.Float32bits(v)), nil
+		}
+	case float64:
+		if typ == wasm.F64 {
+			return math.Float64bits(v), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid value %v (type %T) for %v", v, v, typ)
+}
+
+// fromRaw converts a value of type typ from its representation on the
+// operand stack.
+func fromRaw(typ wasm.ValueType, v uint64) interface{} {
+	switch typ {
+	case wasm.I32:
+		return int32(v)
+	case wasm.I64:
+		return int64(v)
+	case wasm.F32:
+		return math.Float32frombits(uint32(v))
+	case wasm.F64:
+		return math.Float64frombits(v)
+	}
+	panic(fmt.Errorf("exec: invalid value type %v", typ))
+}
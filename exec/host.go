@@ -0,0 +1,228 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sbinet/wasm"
+)
+
+// HostFunc is the Go implementation of a function.
+// It receives the arguments of the call as int32, int64, float32 or float64
+// values, and returns the results of the call likewise.
+// A non-nil error traps, and is returned to the Go caller of the wasm code.
+type HostFunc func(c *Caller, args []interface{}) ([]interface{}, error)
+
+// Caller describes the calling context of a host function.
+type Caller struct {
+	inst *Instance
+	ctx  context.Context
+}
+
+// Context returns the context of the call to the wasm code calling the
+// host function.
+func (c *Caller) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Instance returns the instance calling the host function, or nil if the
+// host function is called directly from Go.
+func (c *Caller) Instance() *Instance { return c.inst }
+
+// Memory returns the linear memory of the calling instance, or nil if it
+// has none.
+func (c *Caller) Memory() *Memory {
+	if c.inst == nil || len(c.inst.mems) == 0 {
+		return nil
+	}
+	return c.inst.mems[0]
+}
+
+// NewFunc returns a function with the given signature, implemented by fn.
+func NewFunc(typ wasm.FuncType, fn HostFunc) *Func {
+	return &Func{typ: typ, host: fn}
+}
+
+var (
+	callerType = reflect.TypeOf((*Caller)(nil))
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// WrapFunc returns a function implemented by the Go function fn.
+//
+// The signature of the function is derived from the one of fn:
+// int32 and uint32 values map to i32, int64 and uint64 to i64, float32 to
+// f32 and float64 to f64.
+// fn may take a *Caller as first parameter, and may return an error as last
+// result, which traps when non-nil. For example:
+//
+//	func(c *exec.Caller, ptr, n uint32) (int32, error)
+func WrapFunc(fn interface{}) (*Func, error) {
+	rv := reflect.ValueOf(fn)
+	rt := rv.Type()
+	if rt.Kind() != reflect.Func {
+		return nil, fmt.Errorf("exec: invalid host function type %T", fn)
+	}
+
+	typ := wasm.FuncType{Form: wasm.Op_func}
+	first := 0
+	if rt.NumIn() > 0 && rt.In(0) == callerType {
+		first = 1
+	}
+	for i := first; i < rt.NumIn(); i++ {
+		vt, ok := valueTypeOf(rt.In(i))
+		if !ok {
+			return nil, fmt.Errorf("exec: invalid parameter type %v in host function type %T", rt.In(i), fn)
+		}
+		typ.Params = append(typ.Params, vt)
+	}
+
+	nout := rt.NumOut()
+	withErr := nout > 0 && rt.Out(nout-1) == errorType
+	if withErr {
+		nout--
+	}
+	if nout > 1 {
+		return nil, fmt.Errorf("exec: too many results in host function type %T", fn)
+	}
+	for i := 0; i < nout; i++ {
+		vt, ok := valueTypeOf(rt.Out(i))
+		if !ok {
+			return nil, fmt.Errorf("exec: invalid result type %v in host function type %T", rt.Out(i), fn)
+		}
+		typ.Results = append(typ.Results, vt)
+	}
+
+	host := func(c *Caller, args []interface{}) ([]interface{}, error) {
+		in := make([]reflect.Value, 0, len(args)+first)
+		if first == 1 {
+			in = append(in, reflect.ValueOf(c))
+		}
+		for i, arg := range args {
+			in = append(in, reflect.ValueOf(arg).Convert(rt.In(first+i)))
+		}
+		out := rv.Call(in)
+		if withErr {
+			if err := out[nout]; !err.IsNil() {
+				return nil, err.Interface().(error)
+			}
+		}
+		res := make([]interface{}, nout)
+		for i := range res {
+			res[i] = out[i].Convert(goTypes[typ.Results[i]]).Interface()
+		}
+		return res, nil
+	}
+	return NewFunc(typ, host), nil
+}
+
+var goTypes = map[wasm.ValueType]reflect.Type{
+	wasm.I32: reflect.TypeOf(int32(0)),
+	wasm.I64: reflect.TypeOf(int64(0)),
+	wasm.F32: reflect.TypeOf(float32(0)),
+	wasm.F64: reflect.TypeOf(float64(0)),
+}
+
+func valueTypeOf(rt reflect.Type) (wasm.ValueType, bool) {
+	switch rt.Kind() {
+	case reflect.Int32, reflect.Uint32:
+		return wasm.I32, true
+	case reflect.Int64, reflect.Uint64:
+		return wasm.I64, true
+	case reflect.Float32:
+		return wasm.F32, true
+	case reflect.Float64:
+		return wasm.F64, true
+	}
+	return 0, false
+}
+
+// HostModule is a named set of host functions, that may be imported by
+// modules.
+type HostModule struct {
+	name    string
+	exports map[string]Extern
+}
+
+// NewHostModule returns an empty host module with the given name.
+func NewHostModule(name string) *HostModule {
+	return &HostModule{name: name, exports: make(map[string]Extern)}
+}
+
+// Name returns the name of the host module.
+func (hm *HostModule) Name() string { return hm.name }
+
+// Define exports ext under the given name.
+func (hm *HostModule) Define(name string, ext Extern) {
+	hm.exports[name] = ext
+}
+
+// DefineFunc exports the Go function fn under the given name.
+// See WrapFunc for the supported signatures of fn.
+func (hm *HostModule) DefineFunc(name string, fn interface{}) error {
+	f, err := WrapFunc(fn)
+	if err != nil {
+		msg := strings.TrimPrefix(err.Error(), "exec: ")
+		return fmt.Errorf("exec: %s.%s: %s", hm.name, name, msg)
+	}
+	hm.Define(name, f)
+	return nil
+}
+
+// Export returns the entity exported under the given name.
+func (hm *HostModule) Export(name string) (Extern, bool) {
+	ext, ok := hm.exports[name]
+	return ext, ok
+}
+
+// callHost calls the host function f, on behalf of the caller instance.
+func (m *machine) callHost(f *Func, caller *Instance) {
+	n := len(f.typ.Params)
+	args := make([]interface{}, n)
+	for i, typ := range f.typ.Params {
+		args[i] = fromRaw(typ, m.stack[len(m.stack)-n+i])
+	}
+	m.stack = m.stack[:len(m.stack)-n]
+
+	res, err := runHost(f, &Caller{inst: caller, ctx: m.ctx}, args)
+	if err != nil {
+		panic(trap{err})
+	}
+	if len(res) != len(f.typ.Results) {
+		panic(trap{fmt.Errorf("exec: host function returned %d results, want %d", len(res), len(f.typ.Results))})
+	}
+	for i, typ := range f.typ.Results {
+		v, err := toRaw(typ, res[i])
+		if err != nil {
+			panic(trap{fmt.Errorf("exec: host function result %d: %w", i, err)})
+		}
+		m.push(v)
+	}
+}
+
+// runHost runs the host function f, and returns the panic of f, if any, as
+// an error.
+func runHost(f *Func, c *Caller, args []interface{}) (res []interface{}, err error) {
+	defer func() {
+		e := recover()
+		switch e := e.(type) {
+		case nil:
+		case trap:
+			panic(e)
+		case error:
+			err = fmt.Errorf("exec: host function panicked: %w", e)
+		default:
+			err = fmt.Errorf("exec: host function panicked: %v", e)
+		}
+	}()
+	return f.host(c, args)
+}
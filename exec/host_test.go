@@ -0,0 +1,225 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+func TestHostFuncs(t *testing.T) {
+	mod, err := wasm.ParseText([]byte(`(module
+  (import "env" "print" (func $print (param i32 i32)))
+  (import "env" "add" (func $add (param i64 i64) (result i64)))
+  (import "env" "scale" (func $scale (param f64) (result f64)))
+  (import "env" "fail" (func $fail (param i32) (result i32)))
+  (memory 1)
+  (table anyfunc (elem $add))
+  (data (i32.const 8) "hello, world")
+
+  (func (export "hello")
+    (call $print (i32.const 8) (i32.const 5))
+    (call $print (i32.const 15) (i32.const 5)))
+  (func (export "add") (param i64) (result i64)
+    (call_indirect (param i64 i64) (result i64) (get_local 0) (i64.const 1) (i32.const 0)))
+  (func (export "scale") (param f64) (result f64)
+    (call $scale (get_local 0)))
+  (func (export "fail") (param i32) (result i32)
+    (i32.add (call $fail (get_local 0)) (i32.const 1))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		printed []string
+		errFail = errors.New("host failure")
+	)
+	env := exec.NewHostModule("env")
+	for _, def := range []struct {
+		name string
+		fn   interface{}
+	}{
+		{"print", func(c *exec.Caller, ptr, n uint32) {
+			printed = append(printed, string(c.Memory().Bytes()[ptr:ptr+n]))
+		}},
+		{"add", func(a, b int64) int64 { return a + b }},
+		{"fail", func(v int32) (uint32, error) {
+			if v < 0 {
+				return 0, errFail
+			}
+			return uint32(v) * 2, nil
+		}},
+	} {
+		err := env.DefineFunc(def.name, def.fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.Define("scale", exec.NewFunc(
+		wasm.FuncType{Form: wasm.Op_func, Params: []wasm.ValueType{wasm.F64}, Results: []wasm.ValueType{wasm.F64}},
+		func(c *exec.Caller, args []interface{}) ([]interface{}, error) {
+			return []interface{}{args[0].(float64) * 1.5}, nil
+		},
+	))
+
+	inst, err := exec.Instantiate(mod, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []interface{}
+		want []interface{}
+		err  error
+	}{
+		{name: "hello"},
+		{name: "add", args: []interface{}{int64(41)}, want: []interface{}{int64(42)}},
+		{name: "scale", args: []interface{}{2.0}, want: []interface{}{3.0}},
+		{name: "fail", args: []interface{}{int32(2)}, want: []interface{}{int32(5)}},
+		{name: "fail", args: []interface{}{int32(-2)}, err: errFail},
+	} {
+		got, err := inst.Call(tc.name, tc.args...)
+		switch {
+		case tc.err != nil:
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%s%v: got error %v, want %v", tc.name, tc.args, err, tc.err)
+			}
+		case err != nil:
+			t.Errorf("%s%v: unexpected error: %v", tc.name, tc.args, err)
+		case !reflect.DeepEqual(got, tc.want):
+			t.Errorf("%s%v: got %v, want %v", tc.name, tc.args, got, tc.want)
+		}
+	}
+
+	if want := []string{"hello", "world"}; !reflect.DeepEqual(printed, want) {
+		t.Fatalf("invalid printed strings: got %q, want %q", printed, want)
+	}
+
+	// host functions may be called directly from Go.
+	add, _ := env.Export("add")
+	got, err := add.(*exec.Func).Call(int64(1), int64(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int64(3)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestHostFuncErrors(t *testing.T) {
+	env := exec.NewHostModule("env")
+	for _, tc := range []struct {
+		fn   interface{}
+		want string
+	}{
+		{42, "exec: env.f: invalid host function type int"},
+		{func(string) {}, "exec: env.f: invalid parameter type string in host function type func(string)"},
+		{func() (int32, int32) { return 0, 0 }, "exec: env.f: too many results in host function type func() (int32, int32)"},
+		{func() bool { return false }, "exec: env.f: invalid result type bool in host function type func() bool"},
+	} {
+		err := env.DefineFunc("f", tc.fn)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("got error %v, want %q", err, tc.want)
+		}
+	}
+
+	err := env.DefineFunc("f", func(int32) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Define("bad", exec.NewFunc(
+		wasm.FuncType{Form: wasm.Op_func, Results: []wasm.ValueType{wasm.I32}},
+		func(c *exec.Caller, args []interface{}) ([]interface{}, error) {
+			return []interface{}{int64(1)}, nil
+		},
+	))
+
+	for _, tc := range []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "unknown-module",
+			src:  `(module (import "foo" "f" (func (param i32))))`,
+			want: `exec: unknown import "foo" "f"`,
+		},
+		{
+			name: "unknown-field",
+			src:  `(module (import "env" "g" (func (param i32))))`,
+			want: `exec: unknown import "env" "g"`,
+		},
+		{
+			name: "signature",
+			src:  `(module (import "env" "f" (func (param i64))))`,
+			want: `exec: incompatible import type for "env" "f": want (i64) -> (), got (i32) -> ()`,
+		},
+		{
+			name: "kind",
+			src:  `(module (import "env" "f" (memory 1)))`,
+			want: `exec: incompatible import type for "env" "f": want memory, got func`,
+		},
+		{
+			name: "result",
+			src:  `(module (import "env" "bad" (func $bad (result i32))) (start $start) (func $start (drop (call $bad))))`,
+			want: "exec: host function result 0: invalid value 1 (type int64) for i32",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mod, err := wasm.ParseText([]byte(tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = exec.Instantiate(mod, env)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestHostFuncPanic(t *testing.T) {
+	env := exec.NewHostModule("env")
+	err := env.DefineFunc("index", func(i int32) int32 {
+		return []int32{1, 2, 3}[i]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := wasm.ParseText([]byte(`(module
+  (import "env" "index" (func $index (param i32) (result i32)))
+  (func (export "index") (param i32) (result i32)
+    (call $index (get_local 0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := exec.Instantiate(mod, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = inst.Call("index", int32(7))
+	var trap *exec.Trap
+	if !errors.As(err, &trap) || trap.Kind != exec.TrapHost {
+		t.Fatalf("got error %v, want a host trap", err)
+	}
+	if want := "exec: host function panicked: runtime error: index out of range [7] with length 3"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+	if len(trap.Stack) != 1 {
+		t.Errorf("got stack %v", trap.Stack)
+	}
+
+	got, err := inst.Call("index", int32(1))
+	if err != nil || !reflect.DeepEqual(got, []interface{}{int32(2)}) {
+		t.Errorf("index(1): got %v, %v", got, err)
+	}
+}
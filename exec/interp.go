@@ -0,0 +1,585 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"sync/atomic"
+
+	"github.com/sbinet/wasm"
+)
+
+var order = binary.LittleEndian
+
+// maxCallDepth is the default maximum number of nested function calls.
+const maxCallDepth = 1 << 14
+
+// funcCode is the code of a function, compiled or decoded.
+type funcCode struct {
+	nlocals int      // number of locals, not including the parameters
+	cost    []uint64 // fuel consumed by each instruction of a metered function
+	offset  int64    // offset of the bytecode within the decoded module, or 0
+
+	// compiled functions.
+	ir     []instr
+	tables [][]branch // targets of br_table instructions
+
+	// interpreted functions.
+	instrs []wasm.Instr
+	end    []int // index of the end matching a block, loop, if or else instruction
+	elseAt []int // index of the else instruction of an if, or -1
+}
+
+// offsetAt returns the offset of the i-th instruction in the bytecode of
+// the function.
+func (code *funcCode) offsetAt(i int) int {
+	if code.ir != nil {
+		return code.ir[i].off
+	}
+	return code.instrs[i].Offset
+}
+
+// decode decodes the body of a function, to be interpreted.
+// The function is metered if costs is not nil.
+func decode(body wasm.FunctionBody, costs *Costs) (*funcCode, error) {
+	instrs, err := body.Code.Instrs()
+	if err != nil {
+		return nil, err
+	}
+
+	code := &funcCode{
+		offset: body.Code.Offset,
+		instrs: instrs,
+		end:    make([]int, len(instrs)),
+		elseAt: make([]int, len(instrs)),
+	}
+	for _, l := range body.Locals {
+		code.nlocals += int(l.Count)
+	}
+	if costs != nil {
+		code.cost = make([]uint64, len(instrs))
+		for i := range instrs {
+			code.cost[i] = costs.cost(&instrs[i])
+		}
+	}
+
+	var blocks []int
+	for i, ins := range instrs {
+		code.elseAt[i] = -1
+		switch ins.Op {
+		case wasm.Op_block, wasm.Op_loop, wasm.Op_if:
+			blocks = append(blocks, i)
+		case wasm.Op_else:
+			code.elseAt[blocks[len(blocks)-1]] = i
+		case wasm.Op_end:
+			if len(blocks) == 0 {
+				continue // end of the function body.
+			}
+			blk := blocks[len(blocks)-1]
+			blocks = blocks[:len(blocks)-1]
+			code.end[blk] = i
+			if e := code.elseAt[blk]; e >= 0 {
+				code.end[e] = i
+			}
+		}
+	}
+	return code, nil
+}
+
+// label is a branch target.
+type label struct {
+	height int // height of the operand stack when entering the block
+	arity  int // number of values carried by a branch to the label
+	cont   int // index of the instruction to execute after a branch to the label
+}
+
+// machine holds the state of a thread of execution.
+type machine struct {
+	stack []uint64 // operand stack, holding locals and operands of all frames
+	depth int      // number of nested function calls
+
+	frames  []frame     // calls of functions defined by modules, outermost first
+	trace   []Frame     // call stack of a trap, once recorded
+	entered []*Instance // instances whose functions the machine runs
+
+	ctx         context.Context
+	outer       []context.Context // contexts of the calls re-entered by host functions
+	interrupted atomic.Bool       // whether ctx is done
+	maxDepth    int
+	maxStack    int
+}
+
+// frame is the state of a call of a function defined by a module.
+type frame struct {
+	f  *Func
+	pc int // index of the instruction following the executing one
+}
+
+// newMachine returns a machine calling a function of inst, with the limits
+// of its configuration.
+func newMachine(ctx context.Context, inst *Instance) *machine {
+	m := &machine{
+		stack:    make([]uint64, 0, 64),
+		ctx:      ctx,
+		maxDepth: maxCallDepth,
+		maxStack: math.MaxInt,
+	}
+	if inst != nil {
+		if n := inst.cfg.MaxCallDepth; n > 0 {
+			m.maxDepth = n
+		}
+		if n := inst.cfg.MaxStackSize; n > 0 {
+			m.maxStack = n
+		}
+	}
+	return m
+}
+
+// exit records that the machine no longer runs the functions of the
+// instances it entered.
+func (m *machine) exit() {
+	for _, inst := range m.entered {
+		inst.machine = nil
+	}
+}
+
+// reenter prepares the machine for a call by a host function, interrupted
+// when ctx or the context of the machine is done, and returns a function
+// restoring its state once the call returns or traps.
+func (m *machine) reenter(ctx context.Context) func() {
+	var (
+		stack  = len(m.stack)
+		depth  = m.depth
+		frames = len(m.frames)
+	)
+	m.outer = append(m.outer, m.ctx)
+	m.ctx = ctx
+	return func() {
+		m.ctx = m.outer[len(m.outer)-1]
+		m.outer = m.outer[:len(m.outer)-1]
+		m.stack = m.stack[:stack]
+		m.depth = depth
+		m.frames = m.frames[:frames]
+		m.trace = nil
+		m.interrupted.Store(m.cause() != nil)
+	}
+}
+
+// cause returns the error of the innermost done context of the calls run
+// by the machine, or nil.
+func (m *machine) cause() error {
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	for i := len(m.outer) - 1; i >= 0; i-- {
+		if err := m.outer[i].Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *machine) push(v uint64) {
+	if len(m.stack) >= m.maxStack {
+		panic(trap{ErrValueStackExhausted})
+	}
+	m.stack = append(m.stack, v)
+}
+
+// poll traps if the execution was interrupted.
+func (m *machine) poll() {
+	if m.interrupted.Load() {
+		panic(trap{ErrInterrupted})
+	}
+}
+
+func (m *machine) pop() uint64 {
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+// call calls f on behalf of the caller instance: f pops its arguments from
+// the operand stack and pushes its results.
+func (m *machine) call(f *Func, caller *Instance) {
+	if m.depth >= m.maxDepth {
+		panic(trap{ErrCallStackExhausted})
+	}
+	m.poll()
+	m.depth++
+	if f.host != nil {
+		m.callHost(f, caller)
+		m.depth--
+		return
+	}
+	if f.inst.machine == nil {
+		f.inst.machine = m
+		m.entered = append(m.entered, f.inst)
+	}
+	base := len(m.stack) - len(f.typ.Params)
+	for i := 0; i < f.code.nlocals; i++ {
+		m.push(0)
+	}
+	m.frames = append(m.frames, frame{f: f})
+	if f.code.ir != nil {
+		m.run(f, base)
+	} else {
+		m.interpret(f, base)
+	}
+	m.frames = m.frames[:len(m.frames)-1]
+	m.depth--
+}
+
+// interpret executes the decoded body of f, whose locals start at base on
+// the operand stack.
+func (m *machine) interpret(f *Func, base int) {
+	var (
+		inst   = f.inst
+		code   = f.code
+		instrs = code.instrs
+		mem    *Memory
+		labels = []label{{height: len(m.stack), arity: len(f.typ.Results), cont: len(instrs)}}
+		pc     int
+	)
+	if len(inst.mems) > 0 {
+		mem = inst.mems[0]
+	}
+
+	defer m.recordTrace(&pc)
+
+	for pc < len(instrs) {
+		ins := &instrs[pc]
+		pc++
+		if code.cost != nil {
+			inst.consume(code.cost[pc-1])
+		}
+		switch ins.Op {
+		case wasm.Op_unreachable:
+			panic(trap{ErrUnreachable})
+
+		case wasm.Op_nop:
+
+		case wasm.Op_block:
+			labels = append(labels, label{
+				height: len(m.stack),
+				arity:  blockArity(ins.Block),
+				cont:   code.end[pc-1] + 1,
+			})
+
+		case wasm.Op_loop:
+			// branches to the loop run this instruction again.
+			m.poll()
+			labels = append(labels, label{height: len(m.stack), cont: pc - 1})
+
+		case wasm.Op_if:
+			cond := uint32(m.pop())
+			l := label{
+				height: len(m.stack),
+				arity:  blockArity(ins.Block),
+				cont:   code.end[pc-1] + 1,
+			}
+			switch e := code.elseAt[pc-1]; {
+			case cond != 0:
+				labels = append(labels, l)
+			case e >= 0:
+				labels = append(labels, l)
+				pc = e + 1
+			default:
+				pc = code.end[pc-1] + 1
+			}
+
+		case wasm.Op_else:
+			// end of the then branch: run the end instruction of the if.
+			pc = code.end[pc-1]
+
+		case wasm.Op_end:
+			labels = labels[:len(labels)-1]
+
+		case wasm.Op_br:
+			pc, labels = m.branch(labels, ins.Index)
+
+		case wasm.Op_br_if:
+			if uint32(m.pop()) != 0 {
+				pc, labels = m.branch(labels, ins.Index)
+			}
+
+		case wasm.Op_br_table:
+			i := uint32(m.pop())
+			depth := ins.Default
+			if uint64(i) < uint64(len(ins.Targets)) {
+				depth = ins.Targets[i]
+			}
+			pc, labels = m.branch(labels, depth)
+
+		case wasm.Op_return:
+			pc = len(instrs)
+
+		case wasm.Op_call:
+			m.frames[len(m.frames)-1].pc = pc
+			m.call(inst.funcs[ins.Index], inst)
+
+		case wasm.Op_call_indirect:
+			m.frames[len(m.frames)-1].pc = pc
+			m.call(inst.indirect(uint32(m.pop()), ins.Index), inst)
+
+		case wasm.Op_drop:
+			m.pop()
+
+		case wasm.Op_select:
+			cond := uint32(m.pop())
+			v := m.pop()
+			if cond == 0 {
+				m.stack[len(m.stack)-1] = v
+			}
+
+		case wasm.Op_get_local:
+			m.push(m.stack[base+int(ins.Index)])
+
+		case wasm.Op_set_local:
+			m.stack[base+int(ins.Index)] = m.pop()
+
+		case wasm.Op_tee_local:
+			m.stack[base+int(ins.Index)] = m.stack[len(m.stack)-1]
+
+		case wasm.Op_get_global:
+			m.push(inst.globals[ins.Index].val)
+
+		case wasm.Op_set_global:
+			inst.globals[ins.Index].val = m.pop()
+
+		case wasm.Op_i32_load, wasm.Op_i64_load, wasm.Op_f32_load, wasm.Op_f64_load,
+			wasm.Op_i32_load8_s, wasm.Op_i32_load8_u, wasm.Op_i32_load16_s, wasm.Op_i32_load16_u,
+			wasm.Op_i64_load8_s, wasm.Op_i64_load8_u, wasm.Op_i64_load16_s, wasm.Op_i64_load16_u,
+			wasm.Op_i64_load32_s, wasm.Op_i64_load32_u:
+			m.load(mem, ins.Op, ins.Mem.Offset)
+
+		case wasm.Op_i32_store, wasm.Op_i64_store, wasm.Op_f32_store, wasm.Op_f64_store,
+			wasm.Op_i32_store8, wasm.Op_i32_store16,
+			wasm.Op_i64_store8, wasm.Op_i64_store16, wasm.Op_i64_store32:
+			m.store(mem, ins.Op, ins.Mem.Offset)
+
+		case wasm.Op_current_memory:
+			m.push(uint64(mem.Size()))
+
+		case wasm.Op_grow_memory:
+			m.growMemory(mem)
+
+		case wasm.Op_prefix_misc:
+			m.misc(mem, ins.Misc)
+
+		case wasm.Op_i32_const:
+			m.push(uint64(uint32(ins.Value)))
+
+		case wasm.Op_i64_const, wasm.Op_f32_const, wasm.Op_f64_const:
+			m.push(ins.Value)
+
+		default:
+			m.numeric(ins.Op)
+		}
+	}
+
+	// move the results of the function in place of its locals.
+	n := len(f.typ.Results)
+	copy(m.stack[base:], m.stack[len(m.stack)-n:])
+	m.stack = m.stack[:base+n]
+}
+
+func (m *machine) growMemory(mem *Memory) {
+	size, ok := mem.Grow(uint32(m.pop()))
+	if !ok {
+		size = 0xffffffff // -1
+	}
+	m.push(uint64(size))
+}
+
+// misc executes an instruction following the Op_prefix_misc prefix.
+func (m *machine) misc(mem *Memory, op wasm.MiscOpcode) {
+	switch op {
+	case wasm.Op_memory_copy:
+		m.memoryCopy(mem)
+	case wasm.Op_memory_fill:
+		m.memoryFill(mem)
+	default:
+		s := m.stack
+		s[len(s)-1] = convertSat(op, s[len(s)-1])
+	}
+}
+
+func (m *machine) memoryCopy(mem *Memory) {
+	n := uint64(uint32(m.pop()))
+	src := uint64(uint32(m.pop()))
+	dst := uint64(uint32(m.pop()))
+	if src+n > uint64(len(mem.buf)) || dst+n > uint64(len(mem.buf)) {
+		panic(trap{ErrOutOfBounds})
+	}
+	copy(mem.buf[dst:dst+n], mem.buf[src:src+n])
+}
+
+func (m *machine) memoryFill(mem *Memory) {
+	n := uint64(uint32(m.pop()))
+	v := byte(m.pop())
+	dst := uint64(uint32(m.pop()))
+	if dst+n > uint64(len(mem.buf)) {
+		panic(trap{ErrOutOfBounds})
+	}
+	b := mem.buf[dst : dst+n]
+	for i := range b {
+		b[i] = v
+	}
+}
+
+// recordTrace records the call stack of the machine when the execution of
+// the innermost frame, at *pc, panics.
+func (m *machine) recordTrace(pc *int) {
+	if m.trace != nil {
+		return // recorded by the innermost frame.
+	}
+	if e := recover(); e != nil {
+		m.frames[len(m.frames)-1].pc = *pc
+		m.trace = m.stackTrace()
+		panic(e)
+	}
+}
+
+// stackTrace returns the call stack of the machine, innermost frame first.
+func (m *machine) stackTrace() []Frame {
+	trace := make([]Frame, 0, len(m.frames))
+	for i := len(m.frames) - 1; i >= 0; i-- {
+		f := m.frames[i].f
+		off := f.code.offsetAt(m.frames[i].pc - 1)
+		fr := Frame{
+			Func:         f.idx,
+			Offset:       off,
+			ModuleOffset: -1,
+		}
+		fr.Name, _ = f.inst.names.Name(f.idx)
+		if f.code.offset > 0 {
+			fr.ModuleOffset = f.code.offset + int64(off)
+		}
+		trace = append(trace, fr)
+	}
+	return trace
+}
+
+// branch unwinds the operand stack and the labels for a branch to the
+// label at the given depth, and returns the index of the instruction to
+// continue with.
+func (m *machine) branch(labels []label, depth uint32) (int, []label) {
+	i := len(labels) - 1 - int(depth)
+	l := labels[i]
+	copy(m.stack[l.height:], m.stack[len(m.stack)-l.arity:])
+	m.stack = m.stack[:l.height+l.arity]
+	return l.cont, labels[:i]
+}
+
+func blockArity(bt wasm.BlockType) int {
+	if bt == wasm.BlockTypeEmpty {
+		return 0
+	}
+	return 1
+}
+
+// indirect returns the function called by a call_indirect instruction
+// with the given element index and expected type index.
+func (inst *Instance) indirect(i, typ uint32) *Func {
+	tbl := inst.tables[0]
+	if uint64(i) >= uint64(len(tbl.elems)) {
+		panic(trap{ErrUndefinedElement})
+	}
+	f := tbl.elems[i]
+	if f == nil {
+		panic(trap{ErrUninitializedElement})
+	}
+	if !sameType(f.typ, inst.types[typ]) {
+		panic(trap{ErrIndirectCallTypeMismatch})
+	}
+	return f
+}
+
+func sameType(a, b wasm.FuncType) bool {
+	if len(a.Params) != len(b.Params) || len(a.Results) != len(b.Results) {
+		return false
+	}
+	for i, p := range a.Params {
+		if p != b.Params[i] {
+			return false
+		}
+	}
+	for i, r := range a.Results {
+		if r != b.Results[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// addr pops the address operand of a memory access of size bytes, and
+// returns the effective address of the access.
+func (m *machine) addr(mem *Memory, offset uint32, size int) int {
+	ea := uint64(uint32(m.pop())) + uint64(offset)
+	if ea+uint64(size) > uint64(len(mem.buf)) {
+		panic(trap{ErrOutOfBounds})
+	}
+	return int(ea)
+}
+
+// load executes the load instruction op, with the given offset.
+func (m *machine) load(mem *Memory, op wasm.Opcode, offset uint32) {
+	var v uint64
+	switch op {
+	case wasm.Op_i32_load, wasm.Op_f32_load:
+		p := m.addr(mem, offset, 4)
+		v = uint64(order.Uint32(mem.buf[p:]))
+	case wasm.Op_i64_load, wasm.Op_f64_load:
+		p := m.addr(mem, offset, 8)
+		v = order.Uint64(mem.buf[p:])
+	case wasm.Op_i32_load8_s:
+		p := m.addr(mem, offset, 1)
+		v = uint64(uint32(int8(mem.buf[p])))
+	case wasm.Op_i32_load8_u, wasm.Op_i64_load8_u:
+		p := m.addr(mem, offset, 1)
+		v = uint64(mem.buf[p])
+	case wasm.Op_i32_load16_s:
+		p := m.addr(mem, offset, 2)
+		v = uint64(uint32(int16(order.Uint16(mem.buf[p:]))))
+	case wasm.Op_i32_load16_u, wasm.Op_i64_load16_u:
+		p := m.addr(mem, offset, 2)
+		v = uint64(order.Uint16(mem.buf[p:]))
+	case wasm.Op_i64_load8_s:
+		p := m.addr(mem, offset, 1)
+		v = uint64(int8(mem.buf[p]))
+	case wasm.Op_i64_load16_s:
+		p := m.addr(mem, offset, 2)
+		v = uint64(int16(order.Uint16(mem.buf[p:])))
+	case wasm.Op_i64_load32_s:
+		p := m.addr(mem, offset, 4)
+		v = uint64(int32(order.Uint32(mem.buf[p:])))
+	case wasm.Op_i64_load32_u:
+		p := m.addr(mem, offset, 4)
+		v = uint64(order.Uint32(mem.buf[p:]))
+	}
+	m.push(v)
+}
+
+// store executes the store instruction op, with the given offset.
+func (m *machine) store(mem *Memory, op wasm.Opcode, offset uint32) {
+	v := m.pop()
+	switch op {
+	case wasm.Op_i32_store, wasm.Op_f32_store, wasm.Op_i64_store32:
+		p := m.addr(mem, offset, 4)
+		order.PutUint32(mem.buf[p:], uint32(v))
+	case wasm.Op_i64_store, wasm.Op_f64_store:
+		p := m.addr(mem, offset, 8)
+		order.PutUint64(mem.buf[p:], v)
+	case wasm.Op_i32_store8, wasm.Op_i64_store8:
+		p := m.addr(mem, offset, 1)
+		mem.buf[p] = byte(v)
+	case wasm.Op_i32_store16, wasm.Op_i64_store16:
+		p := m.addr(mem, offset, 2)
+		order.PutUint16(mem.buf[p:], uint16(v))
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"github.com/sbinet/wasm"
+)
+
+// Config configures the execution of instances, and bounds the resources
+// they use, to run untrusted modules.
+// The zero Config compiles functions, and imposes no limit besides the
+// default maximum call depth and table size.
+type Config struct {
+	Engine Engine // how functions are executed (default: Compiler)
+
+	// Fuel is the initial fuel of instances.
+	// If Fuel is not zero, instances are metered: each instruction executed
+	// by the functions of an instance consumes its fuel, as given by Costs,
+	// and the execution traps with ErrOutOfFuel once the fuel is exhausted.
+	Fuel  uint64
+	Costs *Costs // fuel consumed by instructions (default: DefaultCosts)
+
+	MaxCallDepth   int    // maximum number of nested function calls (default: 16384)
+	MaxStackSize   int    // maximum number of values on the operand stack, including locals (default: unlimited)
+	MaxMemoryPages uint32 // maximum size of the memories defined by instances, in pages (default: their maximum size)
+	MaxTableSize   uint32 // maximum number of elements of the tables defined by instances (default: 10000000)
+}
+
+// defaultMaxTableSize is the maximum number of elements of the tables
+// defined by instances, when Config.MaxTableSize is zero.
+const defaultMaxTableSize = 10000000
+
+// Costs gives the fuel consumed by each class of instructions.
+type Costs struct {
+	Control  uint64 // unreachable, nop, block, loop, if, else, end, br, br_if, br_table and return
+	Call     uint64 // call and call_indirect
+	Variable uint64 // drop, select, and local and global variable accesses
+	Load     uint64 // memory loads
+	Store    uint64 // memory stores
+	Memory   uint64 // current_memory, grow_memory and bulk memory instructions
+	Const    uint64 // constants
+	Numeric  uint64 // numeric instructions
+}
+
+// DefaultCosts is the cost of instructions of metered instances, when
+// Config.Costs is nil.
+var DefaultCosts = Costs{
+	Control:  1,
+	Call:     1,
+	Variable: 1,
+	Load:     1,
+	Store:    1,
+	Memory:   1,
+	Const:    1,
+	Numeric:  1,
+}
+
+// cost returns the fuel consumed by the instruction ins.
+func (c *Costs) cost(ins *wasm.Instr) uint64 {
+	switch op := ins.Op; {
+	case op <= wasm.Op_return:
+		return c.Control
+	case op == wasm.Op_call || op == wasm.Op_call_indirect:
+		return c.Call
+	case op >= wasm.Op_drop && op <= wasm.Op_set_global:
+		return c.Variable
+	case op >= wasm.Op_i32_load && op <= wasm.Op_i64_load32_u:
+		return c.Load
+	case op >= wasm.Op_i32_store && op <= wasm.Op_i64_store32:
+		return c.Store
+	case op == wasm.Op_current_memory || op == wasm.Op_grow_memory:
+		return c.Memory
+	case op == wasm.Op_prefix_misc &&
+		(ins.Misc == wasm.Op_memory_copy || ins.Misc == wasm.Op_memory_fill):
+		return c.Memory
+	case op >= wasm.Op_i32_const && op <= wasm.Op_f64_const:
+		return c.Const
+	}
+	return c.Numeric
+}
+
+// Fuel returns the remaining fuel of a metered instance.
+func (inst *Instance) Fuel() uint64 {
+	return inst.fuel
+}
+
+// SetFuel sets the remaining fuel of a metered instance, for example to
+// call its functions again after they ran out of fuel.
+func (inst *Instance) SetFuel(fuel uint64) {
+	inst.fuel = fuel
+}
+
+// consume consumes the fuel of the instance, or traps if it is exhausted.
+func (inst *Instance) consume(fuel uint64) {
+	if inst.fuel < fuel {
+		inst.fuel = 0
+		panic(trap{ErrOutOfFuel})
+	}
+	inst.fuel -= fuel
+}
@@ -0,0 +1,255 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sbinet/wasm/exec"
+)
+
+const limitsModule = `(module
+  (memory 1 4)
+  (func (export "add") (param i32 i32) (result i32)
+    (i32.add (get_local 0) (get_local 1)))
+  (func (export "count") (param i32) (result i32)
+    (local i32)
+    (block
+      (loop
+        (br_if 1 (i32.ge_u (get_local 1) (get_local 0)))
+        (set_local 1 (i32.add (get_local 1) (i32.const 1)))
+        (br 0)))
+    (get_local 1))
+  (func $spin (export "spin")
+    (loop (br 0)))
+  (func $recurse (export "recurse") (param i32) (result i32)
+    (if (result i32) (i32.eqz (get_local 0))
+      (then (i32.const 0))
+      (else (i32.add (i32.const 1) (call $recurse (i32.sub (get_local 0) (i32.const 1)))))))
+  (func (export "grow") (param i32) (result i32)
+    (grow_memory (get_local 0)))
+)`
+
+func TestFuel(t *testing.T) {
+	mod := parse(t, limitsModule)
+	costs := exec.Costs{Control: 100, Variable: 2, Numeric: 10}
+	inst, err := exec.InstantiateConfig(mod, exec.Config{Fuel: 1000, Costs: &costs})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get_local, get_local, i32.add and end.
+	if _, err := inst.Call("add", int32(1), int32(2)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inst.Fuel(), uint64(1000-2-2-10-100); got != want {
+		t.Errorf("fuel: got %d, want %d", got, want)
+	}
+
+	_, err = inst.Call("count", int32(1000))
+	if !errors.Is(err, exec.ErrOutOfFuel) {
+		t.Fatalf("count: got error %v, want %v", err, exec.ErrOutOfFuel)
+	}
+	if got := inst.Fuel(); got != 0 {
+		t.Errorf("fuel: got %d, want 0", got)
+	}
+
+	inst.SetFuel(1 << 20)
+	got, err := inst.Call("count", int32(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int32(1000)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("count: got %v, want %v", got, want)
+	}
+
+	// instances are not metered by default.
+	inst, err = exec.Instantiate(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inst.Call("count", int32(1000)); err != nil {
+		t.Fatal(err)
+	}
+	if got := inst.Fuel(); got != 0 {
+		t.Errorf("fuel of an unmetered instance: got %d, want 0", got)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	mod := parse(t, limitsModule)
+	for _, tc := range []struct {
+		name string
+		cfg  exec.Config
+		fn   string
+		args []interface{}
+		want []interface{}
+		err  error
+	}{
+		{name: "depth", fn: "recurse", args: []interface{}{int32(1000)}, want: []interface{}{int32(1000)}},
+		{name: "depth-limit", cfg: exec.Config{MaxCallDepth: 100}, fn: "recurse", args: []interface{}{int32(99)}, want: []interface{}{int32(99)}},
+		{name: "depth-limit", cfg: exec.Config{MaxCallDepth: 100}, fn: "recurse", args: []interface{}{int32(100)}, err: exec.ErrCallStackExhausted},
+		{name: "stack-limit", cfg: exec.Config{MaxStackSize: 4096}, fn: "recurse", args: []interface{}{int32(100)}, want: []interface{}{int32(100)}},
+		{name: "stack-limit", cfg: exec.Config{MaxStackSize: 128}, fn: "recurse", args: []interface{}{int32(100)}, err: exec.ErrValueStackExhausted},
+		{name: "memory", fn: "grow", args: []interface{}{int32(3)}, want: []interface{}{int32(1)}},
+		{name: "memory-limit", cfg: exec.Config{MaxMemoryPages: 2}, fn: "grow", args: []interface{}{int32(1)}, want: []interface{}{int32(1)}},
+		{name: "memory-limit", cfg: exec.Config{MaxMemoryPages: 2}, fn: "grow", args: []interface{}{int32(2)}, want: []interface{}{int32(-1)}},
+		{name: "memory-above-maximum", cfg: exec.Config{MaxMemoryPages: 8}, fn: "grow", args: []interface{}{int32(4)}, want: []interface{}{int32(-1)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inst, err := exec.InstantiateConfig(mod, tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := inst.Call(tc.fn, tc.args...)
+			switch {
+			case tc.err != nil:
+				if !errors.Is(err, tc.err) {
+					t.Errorf("%s%v: got error %v, want %v", tc.fn, tc.args, err, tc.err)
+				}
+			case err != nil:
+				t.Errorf("%s%v: unexpected error: %v", tc.fn, tc.args, err)
+			case !reflect.DeepEqual(got, tc.want):
+				t.Errorf("%s%v: got %v, want %v", tc.fn, tc.args, got, tc.want)
+			}
+		})
+	}
+
+	_, err := exec.InstantiateConfig(parse(t, `(module (memory 3))`), exec.Config{MaxMemoryPages: 2})
+	if err == nil {
+		t.Errorf("instantiated a memory exceeding the limit")
+	}
+
+	for _, tc := range []struct {
+		src string
+		cfg exec.Config
+		ok  bool
+	}{
+		{src: `(module (table 16 anyfunc))`, cfg: exec.Config{MaxTableSize: 16}, ok: true},
+		{src: `(module (table 17 anyfunc))`, cfg: exec.Config{MaxTableSize: 16}},
+		{src: `(module (table 0xffffffff anyfunc))`, cfg: exec.Config{MaxMemoryPages: 1}},
+	} {
+		_, err := exec.InstantiateConfig(parse(t, tc.src), tc.cfg)
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("%s: got error %v", tc.src, err)
+		}
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	env := exec.NewHostModule("env")
+	err := env.DefineFunc("check", func(c *exec.Caller) int32 {
+		if c.Context().Value(key{}) != "value" {
+			return 0
+		}
+		return 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := exec.Instantiate(parse(t, `(module
+  (import "env" "check" (func $check (result i32)))
+  (func (export "check") (result i32)
+    (call $check))
+  (func (export "spin")
+    (loop (br 0)))
+)`), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), key{}, "value")
+	got, err := inst.CallContext(ctx, "check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int32(1)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("check: got %v, want %v", got, want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = inst.CallContext(ctx, "spin")
+	if !errors.Is(err, exec.ErrInterrupted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("spin: got error %v, want %v and %v", err, exec.ErrInterrupted, context.DeadlineExceeded)
+	}
+
+	// a canceled context does not start the call.
+	_, err = inst.CallContext(ctx, "check")
+	if !errors.Is(err, exec.ErrInterrupted) {
+		t.Errorf("check: got error %v, want %v", err, exec.ErrInterrupted)
+	}
+}
+
+type key struct{}
+
+func TestReentrantCall(t *testing.T) {
+	var inst *exec.Instance
+	env := exec.NewHostModule("env")
+	err := env.DefineFunc("reenter", func(c *exec.Caller, n int32) (int32, error) {
+		res, err := inst.CallContext(c.Context(), "f", n)
+		if err != nil {
+			return 0, err
+		}
+		return res[0].(int32), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.DefineFunc("spin", func(c *exec.Caller) error {
+		_, err := inst.Call("spin")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod := parse(t, `(module
+  (import "env" "reenter" (func $reenter (param i32) (result i32)))
+  (import "env" "spin" (func $spin))
+  (func (export "f") (param i32) (result i32)
+    (if (result i32) (i32.eqz (get_local 0))
+      (then (i32.const 0))
+      (else (i32.add (call $reenter (i32.sub (get_local 0) (i32.const 1))) (i32.const 1)))))
+  (func (export "reenter-spin")
+    (call $spin))
+  (func (export "spin")
+    (loop (br 0)))
+)`)
+	inst, err = exec.InstantiateConfig(mod, exec.Config{MaxCallDepth: 100}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each level of recursion calls f and reenter.
+	got, err := inst.Call("f", int32(49))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int32(49)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("f(49): got %v, want %v", got, want)
+	}
+	_, err = inst.Call("f", int32(50))
+	if !errors.Is(err, exec.ErrCallStackExhausted) {
+		t.Errorf("f(50): got error %v, want %v", err, exec.ErrCallStackExhausted)
+	}
+
+	// the context of the outer call interrupts the calls of host functions.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = inst.CallContext(ctx, "reenter-spin")
+	if !errors.Is(err, exec.ErrInterrupted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("reenter-spin: got error %v, want %v and %v", err, exec.ErrInterrupted, context.DeadlineExceeded)
+	}
+
+	// the instance is usable after the traps of nested calls.
+	got, err = inst.Call("f", int32(3))
+	if err != nil || !reflect.DeepEqual(got, []interface{}{int32(3)}) {
+		t.Errorf("f(3): got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+
+	"github.com/sbinet/wasm"
+)
+
+// Exporter is a set of named entities that may be imported by modules:
+// an *Instance or a *HostModule.
+type Exporter interface {
+	Export(name string) (Extern, bool)
+}
+
+// Linker instantiates modules whose imports are resolved against a set of
+// named exporters: host modules, and previously instantiated modules.
+type Linker struct {
+	modules map[string]Exporter
+	cfg     Config
+}
+
+// NewLinker returns a linker without any registered module.
+func NewLinker() *Linker {
+	return &Linker{modules: make(map[string]Exporter)}
+}
+
+// Register makes the entities exported by mod available to the imports
+// of subsequently instantiated modules, under the given module name.
+func (l *Linker) Register(name string, mod Exporter) {
+	l.modules[name] = mod
+}
+
+// RegisterHost registers the host module under its name.
+func (l *Linker) RegisterHost(hm *HostModule) {
+	l.Register(hm.Name(), hm)
+}
+
+// SetConfig sets the limits of subsequently instantiated modules.
+func (l *Linker) SetConfig(cfg Config) {
+	l.cfg = cfg
+}
+
+// Module returns the exporter registered under the given name.
+func (l *Linker) Module(name string) (Exporter, bool) {
+	mod, ok := l.modules[name]
+	return mod, ok
+}
+
+// Instantiate validates and instantiates the module m, resolving its
+// imports against the registered modules.
+// If name is not empty, the new instance is registered under that name.
+func (l *Linker) Instantiate(name string, m *wasm.Module) (*Instance, error) {
+	inst, err := instantiate(m, l.cfg, func(module, field string) (Extern, bool) {
+		mod, ok := l.modules[module]
+		if !ok {
+			return nil, false
+		}
+		return mod.Export(field)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		l.Register(name, inst)
+	}
+	return inst, nil
+}
+
+// link adds ext, satisfying the import imp, to the index spaces of the
+// instance.
+// link checks ext matches the type of the import, following the import
+// matching rules of the specification.
+func (inst *Instance) link(imp wasm.ImportEntry, ext Extern) error {
+	errorf := func(want, got interface{}) error {
+		return fmt.Errorf("exec: incompatible import type for %q %q: want %v, got %v", imp.Module, imp.Field, want, got)
+	}
+	if ext.Kind() != imp.Kind {
+		return errorf(imp.Kind, ext.Kind())
+	}
+	unsupported := func() error {
+		return fmt.Errorf("exec: unsupported %v %T for import %q %q", imp.Kind, ext, imp.Module, imp.Field)
+	}
+
+	switch imp.Kind {
+	case wasm.FunctionKind:
+		f, ok := ext.(*Func)
+		if !ok {
+			return unsupported()
+		}
+		if want := inst.types[imp.TypeIndex]; !sameType(f.typ, want) {
+			return errorf(want, f.typ)
+		}
+		inst.funcs = append(inst.funcs, f)
+
+	case wasm.TableKind:
+		t, ok := ext.(*Table)
+		if !ok {
+			return unsupported()
+		}
+		typ := t.Type()
+		if typ.ElemType != imp.Table.ElemType || !matchLimits(typ.Limits, imp.Table.Limits) {
+			return errorf(tableString(imp.Table), tableString(typ))
+		}
+		inst.tables = append(inst.tables, t)
+
+	case wasm.MemoryKind:
+		m, ok := ext.(*Memory)
+		if !ok {
+			return unsupported()
+		}
+		typ := m.Type()
+		if !matchLimits(typ.Limits, imp.Memory.Limits) {
+			return errorf(memoryString(imp.Memory), memoryString(typ))
+		}
+		inst.mems = append(inst.mems, m)
+
+	case wasm.GlobalKind:
+		g, ok := ext.(*Global)
+		if !ok {
+			return unsupported()
+		}
+		if g.typ != imp.Global {
+			return errorf(globalString(imp.Global), globalString(g.typ))
+		}
+		inst.globals = append(inst.globals, g)
+	}
+	return nil
+}
+
+// matchLimits returns whether the limits of an imported entity match the
+// ones expected by the import.
+func matchLimits(got, want wasm.ResizableLimits) bool {
+	if got.Initial < want.Initial {
+		return false
+	}
+	if want.Flags&1 == 0 {
+		return true
+	}
+	return got.Flags&1 != 0 && got.Maximum <= want.Maximum
+}
+
+func limitsString(l wasm.ResizableLimits) string {
+	if l.Flags&1 != 0 {
+		return fmt.Sprintf("%d %d", l.Initial, l.Maximum)
+	}
+	return fmt.Sprintf("%d", l.Initial)
+}
+
+func tableString(t wasm.TableType) string {
+	return fmt.Sprintf("table %s %v", limitsString(t.Limits), t.ElemType)
+}
+
+func memoryString(m wasm.MemoryType) string {
+	return "memory " + limitsString(m.Limits)
+}
+
+func globalString(g wasm.GlobalType) string {
+	if g.Mutability != 0 {
+		return fmt.Sprintf("global (mut %v)", g.ContentType)
+	}
+	return fmt.Sprintf("global %v", g.ContentType)
+}
@@ -0,0 +1,211 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+func parse(t *testing.T, src string) *wasm.Module {
+	t.Helper()
+	mod, err := wasm.ParseText([]byte(src))
+	if err != nil {
+		t.Fatalf("could not parse module: %v", err)
+	}
+	return mod
+}
+
+func TestLinker(t *testing.T) {
+	l := exec.NewLinker()
+
+	env := exec.NewHostModule("env")
+	err := env.DefineFunc("double", func(v int32) int32 { return 2 * v })
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := exec.NewGlobal(wasm.GlobalType{ContentType: wasm.I32}, int32(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Define("base", base)
+	l.RegisterHost(env)
+
+	_, err = l.Instantiate("runtime", parse(t, `(module
+  (import "env" "double" (func $double (param i32) (result i32)))
+  (import "env" "base" (global $base i32))
+  (memory (export "mem") 1 4)
+  (table (export "tbl") 2 anyfunc)
+  (global (export "version") i32 (i32.const 3))
+  (elem (i32.const 0) $double)
+  (func (export "alloc") (param i32) (result i32)
+    (i32.add (get_global $base) (get_local 0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst, err := l.Instantiate("plugin", parse(t, `(module
+  (import "runtime" "mem" (memory 1))
+  (import "runtime" "tbl" (table 1 anyfunc))
+  (import "runtime" "version" (global $version i32))
+  (import "runtime" "alloc" (func $alloc (param i32) (result i32)))
+  (global $answer i32 (get_global $version))
+  (elem (i32.const 1) $quadruple)
+  (data (i32.const 0) "plugin")
+  (func $quadruple (param i32) (result i32)
+    (call_indirect (param i32) (result i32)
+      (call_indirect (param i32) (result i32) (get_local 0) (i32.const 0))
+      (i32.const 0)))
+  (func (export "run") (param i32) (result i32)
+    (i32.add
+      (call $alloc (get_global $answer))
+      (call_indirect (param i32) (result i32) (get_local 0) (i32.const 1)))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := inst.Call("run", int32(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int32(123)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	runtime, ok := l.Module("runtime")
+	if !ok {
+		t.Fatalf("missing runtime module")
+	}
+	mem, _ := runtime.Export("mem")
+	if got := string(mem.(*exec.Memory).Bytes()[:6]); got != "plugin" {
+		t.Fatalf("invalid shared memory content: got %q", got)
+	}
+	tbl, _ := runtime.Export("tbl")
+	if tbl.(*exec.Table).Get(1) == nil {
+		t.Fatalf("shared table was not initialized")
+	}
+}
+
+func TestLinkerMutableGlobals(t *testing.T) {
+	l := exec.NewLinker()
+	_, err := l.Instantiate("runtime", parse(t, `(module
+  (global (export "counter") (mut i32) (i32.const 40)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := l.Instantiate("plugin", parse(t, `(module
+  (import "runtime" "counter" (global $counter (mut i32)))
+  (func (export "incr")
+    (set_global $counter (i32.add (get_global $counter) (i32.const 1)))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := inst.Call("incr"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runtime, _ := l.Module("runtime")
+	counter, _ := runtime.Export("counter")
+	if got := counter.(*exec.Global).Get(); got != int32(42) {
+		t.Fatalf("invalid shared global value: got %v", got)
+	}
+}
+
+// customGlobal is a global not implemented by this package.
+type customGlobal struct{}
+
+func (customGlobal) Kind() wasm.ExternalKind { return wasm.GlobalKind }
+
+func TestLinkerErrors(t *testing.T) {
+	l := exec.NewLinker()
+	_, err := l.Instantiate("m", parse(t, `(module
+  (memory (export "mem") 2 3)
+  (table (export "tbl") 1 anyfunc)
+  (global (export "g") f32 (f32.const 0))
+  (func (export "f") (param i32)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "unknown",
+			src:  `(module (import "m" "h" (func)))`,
+			want: `exec: unknown import "m" "h"`,
+		},
+		{
+			name: "kind",
+			src:  `(module (import "m" "f" (global i32)))`,
+			want: `exec: incompatible import type for "m" "f": want global, got func`,
+		},
+		{
+			name: "func",
+			src:  `(module (import "m" "f" (func (param i32) (result i32))))`,
+			want: `exec: incompatible import type for "m" "f": want (i32) -> (i32), got (i32) -> ()`,
+		},
+		{
+			name: "memory-min",
+			src:  `(module (import "m" "mem" (memory 3)))`,
+			want: `exec: incompatible import type for "m" "mem": want memory 3, got memory 2 3`,
+		},
+		{
+			name: "memory-max",
+			src:  `(module (import "m" "mem" (memory 1 2)))`,
+			want: `exec: incompatible import type for "m" "mem": want memory 1 2, got memory 2 3`,
+		},
+		{
+			name: "table",
+			src:  `(module (import "m" "tbl" (table 1 1 anyfunc)))`,
+			want: `exec: incompatible import type for "m" "tbl": want table 1 1 anyfunc, got table 1 anyfunc`,
+		},
+		{
+			name: "global",
+			src:  `(module (import "m" "g" (global f64)))`,
+			want: `exec: incompatible import type for "m" "g": want global f64, got global f32`,
+		},
+		{
+			name: "global-mut",
+			src:  `(module (import "m" "g" (global (mut f32))))`,
+			want: `exec: incompatible import type for "m" "g": want global (mut f32), got global f32`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := l.Instantiate("", parse(t, tc.src))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+
+	// only the externs of this package may be imported.
+	env := exec.NewHostModule("env")
+	env.Define("g", customGlobal{})
+	l.RegisterHost(env)
+	_, err = l.Instantiate("", parse(t, `(module (import "env" "g" (global i32)))`))
+	if want := `exec: unsupported global exec_test.customGlobal for import "env" "g"`; err == nil || err.Error() != want {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+
+	// a memory matches a wider import once it has grown.
+	m, _ := l.Module("m")
+	mem, _ := m.Export("mem")
+	if _, ok := mem.(*exec.Memory).Grow(1); !ok {
+		t.Fatalf("could not grow memory")
+	}
+	_, err = l.Instantiate("", parse(t, `(module (import "m" "mem" (memory 3)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,514 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/sbinet/wasm"
+)
+
+// numeric executes a numeric instruction, without immediate, on top of the
+// operand stack.
+func (m *machine) numeric(op wasm.Opcode) {
+	s := m.stack
+	n := len(s)
+	switch {
+	case op == wasm.Op_i32_eqz:
+		s[n-1] = fromBool(uint32(s[n-1]) == 0)
+	case op == wasm.Op_i64_eqz:
+		s[n-1] = fromBool(s[n-1] == 0)
+
+	case op <= wasm.Op_i32_ge_u:
+		s[n-2] = fromBool(i32Compare(op, uint32(s[n-2]), uint32(s[n-1])))
+		m.stack = s[:n-1]
+	case op <= wasm.Op_i64_ge_u:
+		s[n-2] = fromBool(i64Compare(op, s[n-2], s[n-1]))
+		m.stack = s[:n-1]
+	case op <= wasm.Op_f32_ge:
+		a, b := math.Float32frombits(uint32(s[n-2])), math.Float32frombits(uint32(s[n-1]))
+		s[n-2] = fromBool(floatCompare(op-wasm.Op_f32_eq, float64(a), float64(b)))
+		m.stack = s[:n-1]
+	case op <= wasm.Op_f64_ge:
+		a, b := math.Float64frombits(s[n-2]), math.Float64frombits(s[n-1])
+		s[n-2] = fromBool(floatCompare(op-wasm.Op_f64_eq, a, b))
+		m.stack = s[:n-1]
+
+	case op <= wasm.Op_i32_popcnt:
+		s[n-1] = uint64(i32Unary(op, uint32(s[n-1])))
+	case op <= wasm.Op_i32_rotr:
+		s[n-2] = uint64(i32Binary(op, uint32(s[n-2]), uint32(s[n-1])))
+		m.stack = s[:n-1]
+	case op <= wasm.Op_i64_popcnt:
+		s[n-1] = i64Unary(op, s[n-1])
+	case op <= wasm.Op_i64_rotr:
+		s[n-2] = i64Binary(op, s[n-2], s[n-1])
+		m.stack = s[:n-1]
+
+	case op <= wasm.Op_f32_sqrt:
+		s[n-1] = uint64(f32Unary(op, uint32(s[n-1])))
+	case op <= wasm.Op_f32_copysign:
+		s[n-2] = uint64(f32Binary(op, uint32(s[n-2]), uint32(s[n-1])))
+		m.stack = s[:n-1]
+	case op <= wasm.Op_f64_sqrt:
+		s[n-1] = f64Unary(op, s[n-1])
+	case op <= wasm.Op_f64_copysign:
+		s[n-2] = f64Binary(op, s[n-2], s[n-1])
+		m.stack = s[:n-1]
+
+	case op <= wasm.Op_f64_reinterpret_i64:
+		s[n-1] = convert(op, s[n-1])
+
+	case op <= wasm.Op_i64_extend32_s:
+		s[n-1] = extend(op, s[n-1])
+
+	default:
+		panic("exec: invalid opcode " + op.String())
+	}
+}
+
+func fromBool(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func i32Compare(op wasm.Opcode, a, b uint32) bool {
+	switch op {
+	case wasm.Op_i32_eq:
+		return a == b
+	case wasm.Op_i32_ne:
+		return a != b
+	case wasm.Op_i32_lt_s:
+		return int32(a) < int32(b)
+	case wasm.Op_i32_lt_u:
+		return a < b
+	case wasm.Op_i32_gt_s:
+		return int32(a) > int32(b)
+	case wasm.Op_i32_gt_u:
+		return a > b
+	case wasm.Op_i32_le_s:
+		return int32(a) <= int32(b)
+	case wasm.Op_i32_le_u:
+		return a <= b
+	case wasm.Op_i32_ge_s:
+		return int32(a) >= int32(b)
+	}
+	return a >= b
+}
+
+func i64Compare(op wasm.Opcode, a, b uint64) bool {
+	switch op {
+	case wasm.Op_i64_eq:
+		return a == b
+	case wasm.Op_i64_ne:
+		return a != b
+	case wasm.Op_i64_lt_s:
+		return int64(a) < int64(b)
+	case wasm.Op_i64_lt_u:
+		return a < b
+	case wasm.Op_i64_gt_s:
+		return int64(a) > int64(b)
+	case wasm.Op_i64_gt_u:
+		return a > b
+	case wasm.Op_i64_le_s:
+		return int64(a) <= int64(b)
+	case wasm.Op_i64_le_u:
+		return a <= b
+	case wasm.Op_i64_ge_s:
+		return int64(a) >= int64(b)
+	}
+	return a >= b
+}
+
+// floatCompare compares a and b, where op is the offset of the comparison
+// from f32.eq or f64.eq.
+func floatCompare(op wasm.Opcode, a, b float64) bool {
+	switch op {
+	case 0: // eq
+		return a == b
+	case 1: // ne
+		return a != b
+	case 2: // lt
+		return a < b
+	case 3: // gt
+		return a > b
+	case 4: // le
+		return a <= b
+	}
+	return a >= b
+}
+
+func i32Unary(op wasm.Opcode, a uint32) uint32 {
+	switch op {
+	case wasm.Op_i32_clz:
+		return uint32(bits.LeadingZeros32(a))
+	case wasm.Op_i32_ctz:
+		return uint32(bits.TrailingZeros32(a))
+	}
+	return uint32(bits.OnesCount32(a))
+}
+
+func i32Binary(op wasm.Opcode, a, b uint32) uint32 {
+	switch op {
+	case wasm.Op_i32_add:
+		return a + b
+	case wasm.Op_i32_sub:
+		return a - b
+	case wasm.Op_i32_mul:
+		return a * b
+	case wasm.Op_i32_div_s:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int32(a) == math.MinInt32 && int32(b) == -1 {
+			panic(trap{ErrIntegerOverflow})
+		}
+		return uint32(int32(a) / int32(b))
+	case wasm.Op_i32_div_u:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		return a / b
+	case wasm.Op_i32_rem_s:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int32(b) == -1 {
+			return 0
+		}
+		return uint32(int32(a) % int32(b))
+	case wasm.Op_i32_rem_u:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		return a % b
+	case wasm.Op_i32_and:
+		return a & b
+	case wasm.Op_i32_or:
+		return a | b
+	case wasm.Op_i32_xor:
+		return a ^ b
+	case wasm.Op_i32_shl:
+		return a << (b & 31)
+	case wasm.Op_i32_shr_s:
+		return uint32(int32(a) >> (b & 31))
+	case wasm.Op_i32_shr_u:
+		return a >> (b & 31)
+	case wasm.Op_i32_rotl:
+		return bits.RotateLeft32(a, int(b&31))
+	}
+	return bits.RotateLeft32(a, -int(b&31))
+}
+
+func i64Unary(op wasm.Opcode, a uint64) uint64 {
+	switch op {
+	case wasm.Op_i64_clz:
+		return uint64(bits.LeadingZeros64(a))
+	case wasm.Op_i64_ctz:
+		return uint64(bits.TrailingZeros64(a))
+	}
+	return uint64(bits.OnesCount64(a))
+}
+
+func i64Binary(op wasm.Opcode, a, b uint64) uint64 {
+	switch op {
+	case wasm.Op_i64_add:
+		return a + b
+	case wasm.Op_i64_sub:
+		return a - b
+	case wasm.Op_i64_mul:
+		return a * b
+	case wasm.Op_i64_div_s:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			panic(trap{ErrIntegerOverflow})
+		}
+		return uint64(int64(a) / int64(b))
+	case wasm.Op_i64_div_u:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		return a / b
+	case wasm.Op_i64_rem_s:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int64(b) == -1 {
+			return 0
+		}
+		return uint64(int64(a) % int64(b))
+	case wasm.Op_i64_rem_u:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		return a % b
+	case wasm.Op_i64_and:
+		return a & b
+	case wasm.Op_i64_or:
+		return a | b
+	case wasm.Op_i64_xor:
+		return a ^ b
+	case wasm.Op_i64_shl:
+		return a << (b & 63)
+	case wasm.Op_i64_shr_s:
+		return uint64(int64(a) >> (b & 63))
+	case wasm.Op_i64_shr_u:
+		return a >> (b & 63)
+	case wasm.Op_i64_rotl:
+		return bits.RotateLeft64(a, int(b&63))
+	}
+	return bits.RotateLeft64(a, -int(b&63))
+}
+
+const (
+	f32SignBit = 1 << 31
+	f64SignBit = 1 << 63
+)
+
+func f32Unary(op wasm.Opcode, a uint32) uint32 {
+	// abs and neg only operate on the sign bit, preserving NaN payloads.
+	switch op {
+	case wasm.Op_f32_abs:
+		return a &^ f32SignBit
+	case wasm.Op_f32_neg:
+		return a ^ f32SignBit
+	}
+
+	v := float64(math.Float32frombits(a))
+	switch op {
+	case wasm.Op_f32_ceil:
+		v = math.Ceil(v)
+	case wasm.Op_f32_floor:
+		v = math.Floor(v)
+	case wasm.Op_f32_trunc:
+		v = math.Trunc(v)
+	case wasm.Op_f32_nearest:
+		v = math.RoundToEven(v)
+	case wasm.Op_f32_sqrt:
+		v = math.Sqrt(v)
+	}
+	return math.Float32bits(float32(v))
+}
+
+func f32Binary(op wasm.Opcode, a, b uint32) uint32 {
+	if op == wasm.Op_f32_copysign {
+		return a&^f32SignBit | b&f32SignBit
+	}
+
+	x, y := math.Float32frombits(a), math.Float32frombits(b)
+	var v float32
+	switch op {
+	case wasm.Op_f32_add:
+		v = x + y
+	case wasm.Op_f32_sub:
+		v = x - y
+	case wasm.Op_f32_mul:
+		v = x * y
+	case wasm.Op_f32_div:
+		v = x / y
+	case wasm.Op_f32_min:
+		v = float32(math.Min(float64(x), float64(y)))
+	case wasm.Op_f32_max:
+		v = float32(math.Max(float64(x), float64(y)))
+	}
+	return math.Float32bits(v)
+}
+
+func f64Unary(op wasm.Opcode, a uint64) uint64 {
+	switch op {
+	case wasm.Op_f64_abs:
+		return a &^ f64SignBit
+	case wasm.Op_f64_neg:
+		return a ^ f64SignBit
+	}
+
+	v := math.Float64frombits(a)
+	switch op {
+	case wasm.Op_f64_ceil:
+		v = math.Ceil(v)
+	case wasm.Op_f64_floor:
+		v = math.Floor(v)
+	case wasm.Op_f64_trunc:
+		v = math.Trunc(v)
+	case wasm.Op_f64_nearest:
+		v = math.RoundToEven(v)
+	case wasm.Op_f64_sqrt:
+		v = math.Sqrt(v)
+	}
+	return math.Float64bits(v)
+}
+
+func f64Binary(op wasm.Opcode, a, b uint64) uint64 {
+	if op == wasm.Op_f64_copysign {
+		return a&^f64SignBit | b&f64SignBit
+	}
+
+	x, y := math.Float64frombits(a), math.Float64frombits(b)
+	var v float64
+	switch op {
+	case wasm.Op_f64_add:
+		v = x + y
+	case wasm.Op_f64_sub:
+		v = x - y
+	case wasm.Op_f64_mul:
+		v = x * y
+	case wasm.Op_f64_div:
+		v = x / y
+	case wasm.Op_f64_min:
+		v = math.Min(x, y)
+	case wasm.Op_f64_max:
+		v = math.Max(x, y)
+	}
+	return math.Float64bits(v)
+}
+
+// convert executes a conversion instruction.
+func convert(op wasm.Opcode, a uint64) uint64 {
+	f32 := func() float64 { return float64(math.Float32frombits(uint32(a))) }
+	f64 := func() float64 { return math.Float64frombits(a) }
+
+	switch op {
+	case wasm.Op_i32_wrap_i64:
+		return uint64(uint32(a))
+	case wasm.Op_i32_trunc_s_f32:
+		return uint64(uint32(int32(truncate(f32(), -1<<31, 1<<31))))
+	case wasm.Op_i32_trunc_u_f32:
+		return uint64(uint32(truncate(f32(), 0, 1<<32)))
+	case wasm.Op_i32_trunc_s_f64:
+		return uint64(uint32(int32(truncate(f64(), -1<<31, 1<<31))))
+	case wasm.Op_i32_trunc_u_f64:
+		return uint64(uint32(truncate(f64(), 0, 1<<32)))
+	case wasm.Op_i64_extend_s_i32:
+		return uint64(int64(int32(a)))
+	case wasm.Op_i64_extend_u_i32:
+		return uint64(uint32(a))
+	case wasm.Op_i64_trunc_s_f32:
+		return uint64(int64(truncate(f32(), -1<<63, 1<<63)))
+	case wasm.Op_i64_trunc_u_f32:
+		return truncateU64(f32())
+	case wasm.Op_i64_trunc_s_f64:
+		return uint64(int64(truncate(f64(), -1<<63, 1<<63)))
+	case wasm.Op_i64_trunc_u_f64:
+		return truncateU64(f64())
+	case wasm.Op_f32_convert_s_i32:
+		return uint64(math.Float32bits(float32(int32(a))))
+	case wasm.Op_f32_convert_u_i32:
+		return uint64(math.Float32bits(float32(uint32(a))))
+	case wasm.Op_f32_convert_s_i64:
+		return uint64(math.Float32bits(float32(int64(a))))
+	case wasm.Op_f32_convert_u_i64:
+		return uint64(math.Float32bits(float32(a)))
+	case wasm.Op_f32_demote_f64:
+		return uint64(math.Float32bits(float32(f64())))
+	case wasm.Op_f64_convert_s_i32:
+		return math.Float64bits(float64(int32(a)))
+	case wasm.Op_f64_convert_u_i32:
+		return math.Float64bits(float64(uint32(a)))
+	case wasm.Op_f64_convert_s_i64:
+		return math.Float64bits(float64(int64(a)))
+	case wasm.Op_f64_convert_u_i64:
+		return math.Float64bits(float64(a))
+	case wasm.Op_f64_promote_f32:
+		return math.Float64bits(f32())
+	case wasm.Op_i32_reinterpret_f32, wasm.Op_f32_reinterpret_i32:
+		return uint64(uint32(a))
+	}
+	// i64.reinterpret/f64 and f64.reinterpret/i64.
+	return a
+}
+
+// truncate truncates v towards zero, and traps if the result is not in
+// the [min, max) range.
+func truncate(v, min, max float64) float64 {
+	if math.IsNaN(v) {
+		panic(trap{ErrInvalidConversion})
+	}
+	v = math.Trunc(v)
+	if v < min || v >= max {
+		panic(trap{ErrIntegerOverflow})
+	}
+	return v
+}
+
+// truncateU64 truncates v towards zero to an unsigned 64-bit integer.
+func truncateU64(v float64) uint64 {
+	v = truncate(v, 0, 1<<64)
+	if v >= 1<<63 {
+		return uint64(v-(1<<63)) | 1<<63
+	}
+	return uint64(v)
+}
+
+// extend executes a sign-extension instruction.
+func extend(op wasm.Opcode, a uint64) uint64 {
+	switch op {
+	case wasm.Op_i32_extend8_s:
+		return uint64(uint32(int8(a)))
+	case wasm.Op_i32_extend16_s:
+		return uint64(uint32(int16(a)))
+	case wasm.Op_i64_extend8_s:
+		return uint64(int8(a))
+	case wasm.Op_i64_extend16_s:
+		return uint64(int16(a))
+	}
+	// i64.extend32_s
+	return uint64(int32(a))
+}
+
+// convertSat executes a saturating truncation instruction: NaN is
+// converted to 0, and values out of the range of the result type to the
+// nearest bound.
+func convertSat(op wasm.MiscOpcode, a uint64) uint64 {
+	v := math.Float64frombits(a)
+	switch op {
+	case wasm.Op_i32_trunc_sat_s_f32, wasm.Op_i32_trunc_sat_u_f32,
+		wasm.Op_i64_trunc_sat_s_f32, wasm.Op_i64_trunc_sat_u_f32:
+		v = float64(math.Float32frombits(uint32(a)))
+	}
+
+	switch op {
+	case wasm.Op_i32_trunc_sat_s_f32, wasm.Op_i32_trunc_sat_s_f64:
+		switch {
+		case math.IsNaN(v):
+			return 0
+		case v <= math.MinInt32:
+			return 1 << 31
+		case v >= 1<<31:
+			return math.MaxInt32
+		}
+		return uint64(uint32(int32(v)))
+	case wasm.Op_i32_trunc_sat_u_f32, wasm.Op_i32_trunc_sat_u_f64:
+		switch {
+		case math.IsNaN(v) || v <= 0:
+			return 0
+		case v >= 1<<32:
+			return math.MaxUint32
+		}
+		return uint64(uint32(v))
+	case wasm.Op_i64_trunc_sat_s_f32, wasm.Op_i64_trunc_sat_s_f64:
+		switch {
+		case math.IsNaN(v):
+			return 0
+		case v <= math.MinInt64:
+			return 1 << 63
+		case v >= 1<<63:
+			return math.MaxInt64
+		}
+		return uint64(int64(v))
+	}
+	// i64.trunc_u:sat/f32 and i64.trunc_u:sat/f64.
+	switch {
+	case math.IsNaN(v) || v <= 0:
+		return 0
+	case v >= 1<<64:
+		return math.MaxUint64
+	}
+	return truncateU64(v)
+}
@@ -0,0 +1,151 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+const trapModule = `(module
+  (import "env" "fail" (func $fail))
+  (type $v (func))
+  (table 2 anyfunc)
+  (elem (i32.const 0) $div)
+  (func $div (param i32) (result i32)
+    (i32.div_s (i32.const 1) (get_local 0)))
+  (func $outer (export "outer") (param i32) (result i32)
+    (nop)
+    (call $div (get_local 0)))
+  (func (export "indirect") (param i32)
+    (call_indirect (type $v) (get_local 0)))
+  (func (export "host")
+    (call $fail))
+)`
+
+func TestTrap(t *testing.T) {
+	env := exec.NewHostModule("env")
+	errFail := errors.New("failure")
+	err := env.DefineFunc("fail", func() error { return errFail })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mod, err := wasm.TextOptions{DebugNames: true}.ParseText([]byte(trapModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decoded := range []bool{false, true} {
+		m := mod
+		if decoded {
+			var buf bytes.Buffer
+			if err := wasm.Encode(*mod, &buf); err != nil {
+				t.Fatal(err)
+			}
+			m, err = wasm.Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		inst, err := exec.Instantiate(m, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range []struct {
+			name  string
+			args  []interface{}
+			kind  exec.TrapKind
+			err   error
+			funcs []uint32
+			names []string
+		}{
+			{
+				name: "outer", args: []interface{}{int32(0)},
+				kind: exec.TrapDivideByZero, err: exec.ErrDivideByZero,
+				funcs: []uint32{1, 2}, names: []string{"div", "outer"},
+			},
+			{
+				name: "indirect", args: []interface{}{int32(0)},
+				kind: exec.TrapIndirectCallTypeMismatch, err: exec.ErrIndirectCallTypeMismatch,
+				funcs: []uint32{3}, names: []string{""},
+			},
+			{
+				name: "indirect", args: []interface{}{int32(5)},
+				kind: exec.TrapUndefinedElement, err: exec.ErrUndefinedElement,
+				funcs: []uint32{3}, names: []string{""},
+			},
+			{
+				name: "host",
+				kind: exec.TrapHost, err: errFail,
+				funcs: []uint32{4}, names: []string{""},
+			},
+		} {
+			_, err := inst.Call(tc.name, tc.args...)
+			var trap *exec.Trap
+			if !errors.As(err, &trap) {
+				t.Errorf("%s%v: got error %v, want a *Trap", tc.name, tc.args, err)
+				continue
+			}
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%s%v: got error %v, want %v", tc.name, tc.args, err, tc.err)
+			}
+			if trap.Kind != tc.kind {
+				t.Errorf("%s%v: got kind %v, want %v", tc.name, tc.args, trap.Kind, tc.kind)
+			}
+			var (
+				funcs []uint32
+				names []string
+			)
+			for _, f := range trap.Stack {
+				funcs = append(funcs, f.Func)
+				names = append(names, f.Name)
+				if decoded != (f.ModuleOffset >= 0) {
+					t.Errorf("%s%v: %v: invalid module offset", tc.name, tc.args, f)
+				}
+			}
+			if !reflect.DeepEqual(funcs, tc.funcs) || !reflect.DeepEqual(names, tc.names) {
+				t.Errorf("%s%v: got stack %v %q, want %v %q", tc.name, tc.args, funcs, names, tc.funcs, tc.names)
+			}
+		}
+
+		_, err = inst.Call("outer", int32(0))
+		trap := err.(*exec.Trap)
+		// i32.const, get_local, then i32.div_s at offset 4.
+		if got := trap.Stack[0].Offset; got != 4 {
+			t.Errorf("offset of the trap: got %d, want 4", got)
+		}
+		// nop, get_local, then call at offset 3.
+		if got := trap.Stack[1].Offset; got != 3 {
+			t.Errorf("offset of the call: got %d, want 3", got)
+		}
+		want := "exec: integer divide by zero\n\tat $div (func[1]) +0x4"
+		if got := trap.Trace(); !strings.HasPrefix(got, want) {
+			t.Errorf("trace:\ngot:\n%s\nwant prefix:\n%s", got, want)
+		}
+	}
+}
+
+func TestTrapKind(t *testing.T) {
+	for _, tc := range []struct {
+		kind exec.TrapKind
+		want string
+	}{
+		{exec.TrapHost, "host error"},
+		{exec.TrapOutOfBounds, "out of bounds memory access"},
+		{exec.TrapInterrupted, "execution interrupted"},
+		{exec.TrapKind(100), "TrapKind(100)"},
+	} {
+		if got := tc.kind.String(); got != tc.want {
+			t.Errorf("%d: got %q, want %q", int(tc.kind), got, tc.want)
+		}
+	}
+}
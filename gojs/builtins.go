@@ -0,0 +1,205 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gojs
+
+import (
+	"io"
+	"math"
+)
+
+// newClass returns a constructor of builtin objects.
+func newClass(name string, ctor Func) *Object {
+	c := NewObject()
+	c.ctor = ctor
+	c.class = "Function"
+	c.props["name"] = name
+	c.props["prototype"] = NewObject()
+	return c
+}
+
+// method adds a method, implemented by fn, to the object.
+func method(o *Object, name string, fn Func) {
+	f := NewFunction(fn)
+	f.props["name"] = name
+	o.props[name] = f
+}
+
+// arg returns the i-th argument of a call, or Undefined.
+func arg(args []Value, i int) Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return Undefined
+}
+
+// maxLength is the maximum length of the arrays created by programs.
+const maxLength = 1 << 26
+
+// validLength reports whether n is a valid length of a new array.
+func validLength(n float64) bool {
+	return n >= 0 && n <= maxLength && n == math.Trunc(n)
+}
+
+// enosys returns the error thrown by unsupported functions of the fs and
+// process objects.
+func enosys() *Object {
+	err := NewError("not implemented")
+	err.props["code"] = "ENOSYS"
+	return err
+}
+
+// newGlobal returns the global object of a runtime: the builtin objects
+// used by the syscall/js package and the standard library.
+func (r *Runtime) newGlobal() *Object {
+	global := NewObject()
+	global.props["globalThis"] = global
+
+	global.props["Object"] = newClass("Object", func(_ Value, args []Value) (Value, error) {
+		if o, ok := arg(args, 0).(*Object); ok {
+			return o, nil
+		}
+		return NewObject(), nil
+	})
+	global.props["Array"] = newClass("Array", func(_ Value, args []Value) (Value, error) {
+		if n, ok := arg(args, 0).(float64); ok && len(args) == 1 {
+			if !validLength(n) {
+				return nil, rangeError("Invalid array length")
+			}
+			elems := make([]Value, int(n))
+			for i := range elems {
+				elems[i] = Undefined
+			}
+			return NewArray(elems...), nil
+		}
+		return NewArray(append([]Value(nil), args...)...), nil
+	})
+	global.props["Uint8Array"] = newClass("Uint8Array", func(_ Value, args []Value) (Value, error) {
+		switch v := arg(args, 0).(type) {
+		case float64:
+			if !validLength(v) {
+				return nil, rangeError("Invalid typed array length: %v", v)
+			}
+			return NewUint8Array(make([]byte, int(v))), nil
+		case *Object:
+			b := make([]byte, v.Len())
+			for i := range b {
+				b[i] = byte(toInt(v.Index(i)))
+			}
+			return NewUint8Array(b), nil
+		}
+		return NewUint8Array(nil), nil
+	})
+	global.props["Error"] = newClass("Error", func(_ Value, args []Value) (Value, error) {
+		msg := ""
+		if v := arg(args, 0); v != Undefined {
+			msg = toString(v)
+		}
+		return NewError(msg), nil
+	})
+	global.props["Date"] = newClass("Date", func(_ Value, args []Value) (Value, error) {
+		d := NewObject()
+		method(d, "getTimezoneOffset", func(Value, []Value) (Value, error) {
+			return 0.0, nil
+		})
+		return d, nil
+	})
+
+	global.props["fs"] = r.newFS()
+	global.props["process"] = newProcess()
+	return global
+}
+
+// newFS returns the fs object, in the style of the one of Node.js, used by
+// the syscall package. Only writes to the standard output and error are
+// supported.
+func (r *Runtime) newFS() *Object {
+	fs := NewObject()
+
+	constants := NewObject()
+	for _, name := range []string{"O_WRONLY", "O_RDWR", "O_CREAT", "O_TRUNC", "O_APPEND", "O_EXCL", "O_DIRECTORY"} {
+		constants.props[name] = -1.0
+	}
+	fs.props["constants"] = constants
+
+	writeSync := func(fd Value, buf []byte) (int, error) {
+		var w io.Writer
+		switch toInt(fd) {
+		case 1:
+			w = r.stdout
+		case 2:
+			w = r.stderr
+		default:
+			return 0, &Exception{Value: enosys()}
+		}
+		return w.Write(buf)
+	}
+	method(fs, "writeSync", func(_ Value, args []Value) (Value, error) {
+		buf, ok := arg(args, 1).(*Object)
+		if !ok {
+			return nil, typeError("invalid buffer")
+		}
+		n, err := writeSync(arg(args, 0), buf.bytes)
+		return float64(n), err
+	})
+	// write(fd, buf, offset, length, position, callback)
+	method(fs, "write", func(_ Value, args []Value) (Value, error) {
+		callback, _ := arg(args, 5).(*Object)
+		if callback == nil {
+			return nil, typeError("missing callback")
+		}
+		buf, ok := arg(args, 1).(*Object)
+		if !ok || toInt(arg(args, 2)) != 0 || toInt(arg(args, 3)) != len(buf.bytes) || arg(args, 4) != nil {
+			return callback.Invoke(enosys())
+		}
+		n, err := writeSync(arg(args, 0), buf.bytes)
+		if err != nil {
+			if exc, ok := err.(*Exception); ok {
+				return callback.Invoke(exc.Value)
+			}
+			return callback.Invoke(NewError(err.Error()))
+		}
+		return callback.Invoke(nil, float64(n))
+	})
+	method(fs, "fsync", func(_ Value, args []Value) (Value, error) {
+		if callback, ok := arg(args, 1).(*Object); ok {
+			return callback.Invoke(nil)
+		}
+		return Undefined, nil
+	})
+	for _, name := range []string{
+		"chmod", "chown", "close", "fchmod", "fchown", "fstat", "ftruncate",
+		"lchown", "link", "lstat", "mkdir", "open", "read", "readdir",
+		"readlink", "rename", "rmdir", "stat", "symlink", "truncate",
+		"unlink", "utimes",
+	} {
+		method(fs, name, func(_ Value, args []Value) (Value, error) {
+			if len(args) > 0 {
+				if callback, ok := args[len(args)-1].(*Object); ok && callback.IsFunction() {
+					return callback.Invoke(enosys())
+				}
+			}
+			return nil, &Exception{Value: enosys()}
+		})
+	}
+	return fs
+}
+
+// newProcess returns the process object used by the syscall package.
+func newProcess() *Object {
+	p := NewObject()
+	p.props["pid"] = -1.0
+	p.props["ppid"] = -1.0
+	for _, name := range []string{"getuid", "getgid", "geteuid", "getegid"} {
+		method(p, name, func(Value, []Value) (Value, error) {
+			return -1.0, nil
+		})
+	}
+	for _, name := range []string{"getgroups", "umask", "cwd", "chdir"} {
+		method(p, name, func(Value, []Value) (Value, error) {
+			return nil, &Exception{Value: enosys()}
+		})
+	}
+	return p
+}
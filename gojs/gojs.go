@@ -0,0 +1,335 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gojs runs programs compiled by Go for the js/wasm target with the
+// exec package, without a JavaScript engine.
+//
+// It implements the functions imported by those programs, normally provided
+// by wasm_exec.js, over a small model of JavaScript values: the global
+// object of the program is an *Object, which may be used to expose Go
+// functions to the program, or to call the functions it exports:
+//
+//	rt := gojs.NewRuntime(gojs.Config{Stdout: os.Stdout})
+//	rt.Global().Set("double", gojs.NewFunction(func(this gojs.Value, args []gojs.Value) (gojs.Value, error) {
+//		return 2 * args[0].(float64), nil
+//	}))
+//	code, err := rt.Run(mod)
+//
+// Programs built by Go 1.21 or later import the "gojs" module; programs
+// built by earlier versions import the "go" module.
+package gojs
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+// ModuleName is the name of the module imported by Go programs.
+const ModuleName = "gojs"
+
+// Config describes the environment of a Go program.
+type Config struct {
+	Args []string // command line arguments, including the program name (default: "js")
+	Env  []string // environment variables, as "key=value" strings
+
+	Stdout io.Writer // standard output (default: discarded)
+	Stderr io.Writer // standard error (default: discarded)
+
+	Rand io.Reader        // source of random data (default: crypto/rand.Reader)
+	Now  func() time.Time // wall clock (default: time.Now)
+}
+
+// Runtime is the host environment of a Go js/wasm program.
+type Runtime struct {
+	args   []string
+	env    []string
+	stdout io.Writer
+	stderr io.Writer
+	rand   io.Reader
+	now    func() time.Time
+	start  time.Time // origin of the monotonic clock
+
+	global *Object
+	goObj  *Object // the Go object of wasm_exec.js, this of the program
+
+	// references of the program to JavaScript values.
+	values []Value
+	refs   []int // number of references to each value
+	ids    map[Value]uint32
+	pool   []uint32 // unused ids
+
+	inst     *exec.Instance
+	timeouts map[int32]time.Time // deadlines of the scheduled timeout events
+	nextID   int32
+	exited   bool
+	code     int32
+}
+
+// NewRuntime returns a runtime for a program running in the given
+// environment.
+func NewRuntime(cfg Config) *Runtime {
+	r := &Runtime{
+		args:     cfg.Args,
+		env:      cfg.Env,
+		stdout:   cfg.Stdout,
+		stderr:   cfg.Stderr,
+		rand:     cfg.Rand,
+		now:      cfg.Now,
+		start:    time.Now(),
+		timeouts: make(map[int32]time.Time),
+		nextID:   1,
+	}
+	if len(r.args) == 0 {
+		r.args = []string{"js"}
+	}
+	if r.stdout == nil {
+		r.stdout = io.Discard
+	}
+	if r.stderr == nil {
+		r.stderr = io.Discard
+	}
+	if r.rand == nil {
+		r.rand = rand.Reader
+	}
+	if r.now == nil {
+		r.now = time.Now
+	}
+	r.global = r.newGlobal()
+	r.goObj = NewObject()
+	method(r.goObj, "_makeFuncWrapper", func(_ Value, args []Value) (Value, error) {
+		return r.funcWrapper(arg(args, 0)), nil
+	})
+	return r
+}
+
+// Global returns the global object of the program.
+func (r *Runtime) Global() *Object {
+	return r.global
+}
+
+// HostModule returns the module imported by the program.
+func (r *Runtime) HostModule() *exec.HostModule {
+	hm := exec.NewHostModule(ModuleName)
+	for name, fn := range r.imports() {
+		r.define(hm, name, fn)
+	}
+	hm.Define("debug", mustWrap(func(v int32) {
+		fmt.Fprintf(r.stderr, "debug: %d\n", v)
+	}))
+	return hm
+}
+
+func (r *Runtime) define(hm *exec.HostModule, name string, fn func(f *frame)) {
+	hm.Define(name, mustWrap(func(c *exec.Caller, sp uint32) error {
+		f := &frame{r: r, c: c, mem: c.Memory(), sp: sp}
+		if f.mem == nil {
+			return errors.New("gojs: missing memory")
+		}
+		fn(f)
+		return f.err
+	}))
+}
+
+func mustWrap(fn interface{}) *exec.Func {
+	f, err := exec.WrapFunc(fn)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Run runs the program m until it exits, and returns its exit code.
+func (r *Runtime) Run(m *wasm.Module) (int32, error) {
+	err := r.Start(m)
+	if err != nil {
+		return 0, err
+	}
+	return r.Wait()
+}
+
+// Start instantiates the program m, and runs it until it exits, or until
+// all its goroutines are blocked and no timeout is pending.
+// The program may then be driven by calling the functions it registered
+// on the global object.
+func (r *Runtime) Start(m *wasm.Module) error {
+	if r.inst != nil {
+		return errors.New("gojs: runtime already started")
+	}
+	hm := r.HostModule()
+	l := exec.NewLinker()
+	l.RegisterHost(hm)
+	l.Register("go", hm)
+	inst, err := l.Instantiate("", m)
+	if err != nil {
+		return err
+	}
+	r.inst = inst
+	r.resetValues()
+
+	argc, argv, err := r.writeArgs()
+	if err != nil {
+		return err
+	}
+	_, err = inst.Call("run", argc, argv)
+	if err != nil {
+		return err
+	}
+	return r.runTimeouts()
+}
+
+// Wait runs the pending timeout events of the started program until it
+// exits, and returns its exit code.
+// If the program waits for events when no timeout is pending, Wait
+// resumes it, as Node.js does, so it reports a deadlock.
+func (r *Runtime) Wait() (int32, error) {
+	if r.inst == nil {
+		return 0, errors.New("gojs: runtime not started")
+	}
+	err := r.runTimeouts()
+	if err != nil {
+		return 0, err
+	}
+	if !r.exited {
+		obj := NewObject()
+		obj.props["id"] = 0.0
+		r.goObj.props["_pendingEvent"] = obj
+		if err := r.resume(); err != nil {
+			return 0, err
+		}
+		if !r.exited {
+			return 0, errors.New("gojs: program is waiting for events")
+		}
+	}
+	return r.code, nil
+}
+
+// runTimeouts runs the timeout events of the program, in order, until it
+// exits or none is pending.
+func (r *Runtime) runTimeouts() error {
+	for !r.exited && len(r.timeouts) > 0 {
+		id, deadline := int32(0), time.Time{}
+		for i, t := range r.timeouts {
+			if id == 0 || t.Before(deadline) || (t.Equal(deadline) && i < id) {
+				id, deadline = i, t
+			}
+		}
+		time.Sleep(time.Until(deadline))
+		if err := r.resume(); err != nil {
+			return err
+		}
+		if _, ok := r.timeouts[id]; ok && !r.exited {
+			// the program did not handle the event: drop it, so it is
+			// not resumed forever.
+			delete(r.timeouts, id)
+		}
+	}
+	return nil
+}
+
+// Exited returns whether the program has exited.
+func (r *Runtime) Exited() bool {
+	return r.exited
+}
+
+func (r *Runtime) resume() error {
+	if r.exited {
+		return errors.New("gojs: program has already exited")
+	}
+	_, err := r.inst.Call("resume")
+	return err
+}
+
+// funcWrapper returns the JavaScript function calling the function of the
+// program with the given id.
+func (r *Runtime) funcWrapper(id Value) *Object {
+	return NewFunction(func(this Value, args []Value) (Value, error) {
+		event := NewObject()
+		event.props["id"] = id
+		event.props["this"] = this
+		event.props["args"] = NewArray(args...)
+		r.goObj.props["_pendingEvent"] = event
+		if err := r.resume(); err != nil {
+			return nil, err
+		}
+		return event.Get("result"), nil
+	})
+}
+
+// resetValues initializes the references of the program, with the
+// predefined values of wasm_exec.js.
+func (r *Runtime) resetValues() {
+	r.values = []Value{nanValue, 0.0, nil, true, false, r.global, r.goObj}
+	r.refs = make([]int, len(r.values))
+	r.ids = make(map[Value]uint32)
+	for i, v := range r.values {
+		r.refs[i] = -1 // never released
+		if i > 0 {
+			r.ids[v] = uint32(i)
+		}
+	}
+	r.pool = nil
+}
+
+// nanValue is the NaN number, referenced by the program as value 0.
+var nanValue Value = nan()
+
+// Start of the command line arguments and environment in memory, and
+// start of the data of the program.
+const (
+	argsAddr    = 4096
+	minDataAddr = 4096 + 8192
+)
+
+// writeArgs writes the command line arguments and environment of the
+// program to its memory.
+func (r *Runtime) writeArgs() (argc, argv int32, err error) {
+	exp, ok := r.inst.Export("mem")
+	if !ok {
+		return 0, 0, errors.New("gojs: missing exported memory")
+	}
+	mem := exp.(*exec.Memory)
+
+	var (
+		off  = uint32(argsAddr)
+		ptrs []uint32
+		buf  []byte
+	)
+	str := func(s string) {
+		ptrs = append(ptrs, off)
+		buf = append(buf, s...)
+		buf = append(buf, 0)
+		off += uint32(len(s) + 1)
+		for off%8 != 0 {
+			buf = append(buf, 0)
+			off++
+		}
+	}
+	for _, arg := range r.args {
+		str(arg)
+	}
+	ptrs = append(ptrs, 0)
+	for _, kv := range r.env {
+		str(kv)
+	}
+	ptrs = append(ptrs, 0)
+
+	argv = int32(off)
+	for _, p := range ptrs {
+		buf = order.AppendUint64(buf, uint64(p))
+		off += 8
+	}
+	if off >= minDataAddr {
+		return 0, 0, errors.New("gojs: total length of command line and environment variables exceeds limit")
+	}
+	if !mem.Write(argsAddr, buf) {
+		return 0, 0, errors.New("gojs: memory too small for command line and environment variables")
+	}
+	return int32(len(r.args)), argv, nil
+}
@@ -0,0 +1,280 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gojs_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/gojs"
+)
+
+func TestObject(t *testing.T) {
+	o := gojs.NewObject()
+	o.Set("a", 1.0)
+	if v := o.Get("a"); v != 1.0 {
+		t.Errorf("a: got %v, want 1", v)
+	}
+	if v := o.Get("b"); v != gojs.Undefined {
+		t.Errorf("b: got %v, want undefined", v)
+	}
+	o.Delete("a")
+	if v := o.Get("a"); v != gojs.Undefined {
+		t.Errorf("deleted a: got %v, want undefined", v)
+	}
+
+	arr := gojs.NewArray("x")
+	arr.SetIndex(2, true)
+	if n := arr.Len(); n != 3 {
+		t.Errorf("array length: got %d, want 3", n)
+	}
+	for i, want := range []gojs.Value{"x", gojs.Undefined, true} {
+		if v := arr.Index(i); v != want {
+			t.Errorf("array[%d]: got %v, want %v", i, v, want)
+		}
+	}
+
+	b := gojs.NewUint8Array([]byte{1, 2})
+	b.Set("1", 7.0)
+	if got := b.Bytes(); !bytes.Equal(got, []byte{1, 7}) {
+		t.Errorf("bytes: got %v, want [1 7]", got)
+	}
+	if n := b.Len(); n != 2 {
+		t.Errorf("Uint8Array length: got %d, want 2", n)
+	}
+}
+
+func TestFunction(t *testing.T) {
+	add := gojs.NewFunction(func(this gojs.Value, args []gojs.Value) (gojs.Value, error) {
+		if len(args) != 2 {
+			return nil, errors.New("want 2 arguments")
+		}
+		return args[0].(float64) + args[1].(float64), nil
+	})
+	v, err := add.Invoke(1.0, 2.0)
+	if err != nil || v != 3.0 {
+		t.Errorf("add(1, 2): got %v, %v", v, err)
+	}
+
+	_, err = add.Invoke()
+	exc, ok := err.(*gojs.Exception)
+	if !ok {
+		t.Fatalf("add(): got error %v, want an exception", err)
+	}
+	if got, want := exc.Error(), "gojs: uncaught exception: Error: want 2 arguments"; got != want {
+		t.Errorf("add(): got error %q, want %q", got, want)
+	}
+
+	o := gojs.NewObject()
+	o.Set("add", add)
+	if v, err := o.Call("add", 4.0, 5.0); err != nil || v != 9.0 {
+		t.Errorf("o.add(4, 5): got %v, %v", v, err)
+	}
+	if _, err := o.Call("sub", 4.0, 5.0); err == nil {
+		t.Errorf("o.sub(4, 5): want an error")
+	}
+
+	point := gojs.NewFunction(func(this gojs.Value, args []gojs.Value) (gojs.Value, error) {
+		this.(*gojs.Object).Set("x", args[0])
+		return gojs.Undefined, nil
+	})
+	point.Set("prototype", gojs.NewObject())
+	p, err := point.New(1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := p.(*gojs.Object).Get("x"); v != 1.0 {
+		t.Errorf("new point(1).x: got %v, want 1", v)
+	}
+	if !p.(*gojs.Object).InstanceOf(point) {
+		t.Errorf("new point(1) is not an instance of point")
+	}
+	if gojs.NewObject().InstanceOf(point) {
+		t.Errorf("{} is an instance of point")
+	}
+}
+
+func TestArrayLength(t *testing.T) {
+	global := gojs.NewRuntime(gojs.Config{}).Global()
+	for _, name := range []string{"Array", "Uint8Array"} {
+		class := global.Get(name).(*gojs.Object)
+		v, err := class.New(3.0)
+		if err != nil {
+			t.Fatalf("new %s(3): %v", name, err)
+		}
+		if n := v.(*gojs.Object).Len(); n != 3 {
+			t.Errorf("new %s(3): got length %d", name, n)
+		}
+		for _, n := range []float64{-1, 1.5, 1 << 40} {
+			_, err := class.New(n)
+			exc, ok := err.(*gojs.Exception)
+			if !ok {
+				t.Fatalf("new %s(%v): got error %v, want an exception", name, n, err)
+			}
+			if got := exc.Value.(*gojs.Object).Get("name"); got != "RangeError" {
+				t.Errorf("new %s(%v): got %v, want a RangeError", name, n, got)
+			}
+		}
+	}
+}
+
+const program = `package main
+
+import (
+	"fmt"
+	"os"
+	"syscall/js"
+	"time"
+)
+
+func main() {
+	fmt.Println("args:", os.Args[1:], os.Getenv("GREETING"))
+	time.Sleep(10 * time.Millisecond)
+
+	double := js.Global().Get("double")
+	fmt.Println("double:", double.Invoke(21).Int())
+
+	_, err := os.Open("/etc/passwd")
+	fmt.Println("open:", err != nil)
+
+	func() {
+		defer func() { fmt.Println("recovered:", recover() != nil) }()
+		js.Global().Call("fail")
+	}()
+
+	done := make(chan int)
+	js.Global().Set("concat", js.FuncOf(func(this js.Value, args []js.Value) any {
+		return args[0].String() + args[1].String()
+	}))
+	js.Global().Set("quit", js.FuncOf(func(this js.Value, args []js.Value) any {
+		done <- args[0].Int()
+		return nil
+	}))
+	fmt.Fprintln(os.Stderr, "waiting")
+	os.Exit(<-done)
+}
+`
+
+// build compiles the test program for the js/wasm target.
+func build(t *testing.T) *wasm.Module {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping build of a js/wasm program in short mode")
+	}
+	gocmd := filepath.Join(runtime.GOROOT(), "bin", "go")
+	if _, err := os.Stat(gocmd); err != nil {
+		t.Skipf("go tool not available: %v", err)
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":  "module prog\n\ngo 1.21\n",
+		"main.go": program,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(gocmd, "build", "-o", "prog.wasm", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOOS=js", "GOARCH=wasm", "GO111MODULE=on", "GOFLAGS=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("could not build program: %v\n%s", err, out)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "prog.wasm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := wasm.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// newRuntime returns a runtime defining the functions called by the test
+// program.
+func newRuntime(cfg gojs.Config) *gojs.Runtime {
+	rt := gojs.NewRuntime(cfg)
+	rt.Global().Set("double", gojs.NewFunction(func(this gojs.Value, args []gojs.Value) (gojs.Value, error) {
+		return 2 * args[0].(float64), nil
+	}))
+	rt.Global().Set("fail", gojs.NewFunction(func(this gojs.Value, args []gojs.Value) (gojs.Value, error) {
+		return nil, errors.New("failure")
+	}))
+	return rt
+}
+
+func TestRun(t *testing.T) {
+	m := build(t)
+
+	var stdout, stderr bytes.Buffer
+	rt := newRuntime(gojs.Config{
+		Args:   []string{"prog", "a", "b"},
+		Env:    []string{"GREETING=hello"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	global := rt.Global()
+
+	if err := rt.Start(m); err != nil {
+		t.Fatalf("start: %v\nstderr:\n%s", err, stderr.Bytes())
+	}
+	if rt.Exited() {
+		t.Fatalf("program exited early\nstderr:\n%s", stderr.Bytes())
+	}
+
+	v, err := global.Call("concat", "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "foobar" {
+		t.Errorf("concat: got %v, want foobar", v)
+	}
+
+	if _, err := global.Call("quit", 3.0); err != nil {
+		t.Fatal(err)
+	}
+	code, err := rt.Wait()
+	if err != nil {
+		t.Fatalf("wait: %v\nstderr:\n%s", err, stderr.Bytes())
+	}
+	if code != 3 {
+		t.Errorf("exit code: got %d, want 3\nstderr:\n%s", code, stderr.Bytes())
+	}
+
+	want := "args: [a b] hello\ndouble: 42\nopen: true\nrecovered: true\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("stdout:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := stderr.String(), "waiting\n"; got != want {
+		t.Errorf("stderr: got %q, want %q", got, want)
+	}
+}
+
+func TestDeadlock(t *testing.T) {
+	m := build(t)
+
+	var stderr bytes.Buffer
+	rt := newRuntime(gojs.Config{Stderr: &stderr})
+	code, err := rt.Run(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 2 {
+		t.Errorf("exit code: got %d, want 2", code)
+	}
+	if !bytes.Contains(stderr.Bytes(), []byte("all goroutines are asleep")) {
+		t.Errorf("missing deadlock report:\n%s", stderr.Bytes())
+	}
+}
@@ -0,0 +1,464 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gojs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/sbinet/wasm/exec"
+)
+
+var order = binary.LittleEndian
+
+func nan() float64 { return math.NaN() }
+
+// frame gives access to the arguments and results of an imported function,
+// stored on the stack of the program: the function takes a single sp
+// parameter, and its arguments start at sp+8.
+type frame struct {
+	r   *Runtime
+	c   *exec.Caller
+	mem *exec.Memory
+	sp  uint32
+	err error // first error
+}
+
+func (f *frame) fail(err error) {
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// read returns the n bytes at off. Out of bounds, it fails, and returns
+// zeros for the fixed-size values of the frame, or nil.
+func (f *frame) read(off, n uint32) []byte {
+	b, ok := f.mem.Read(off, n)
+	if !ok {
+		f.fail(fmt.Errorf("gojs: out of bounds memory access at 0x%x", off))
+		if n > 8 {
+			return nil
+		}
+		return make([]byte, n)
+	}
+	return b
+}
+
+func (f *frame) write(off uint32, p []byte) {
+	if !f.mem.Write(off, p) {
+		f.fail(fmt.Errorf("gojs: out of bounds memory access at 0x%x", off))
+	}
+}
+
+func (f *frame) getInt64(off uint32) int64 {
+	return int64(order.Uint64(f.read(f.sp+off, 8)))
+}
+
+func (f *frame) getInt32(off uint32) int32 {
+	return int32(order.Uint32(f.read(f.sp+off, 4)))
+}
+
+func (f *frame) setInt64(off uint32, v int64) {
+	f.write(f.sp+off, order.AppendUint64(nil, uint64(v)))
+}
+
+func (f *frame) setInt32(off uint32, v int32) {
+	f.write(f.sp+off, order.AppendUint32(nil, uint32(v)))
+}
+
+func (f *frame) setBool(off uint32, v bool) {
+	b := byte(0)
+	if v {
+		b = 1
+	}
+	f.write(f.sp+off, []byte{b})
+}
+
+// slice returns the bytes of the Go slice at off, whose elements have the
+// given size.
+func (f *frame) slice(off, size uint32) []byte {
+	ptr, n := f.getInt64(off), f.getInt64(off+8)
+	if ptr < 0 || n < 0 || ptr > math.MaxUint32 || n > math.MaxUint32/int64(size) {
+		f.fail(fmt.Errorf("gojs: invalid slice [0x%x:+%d]", ptr, n))
+		return nil
+	}
+	return f.read(uint32(ptr), uint32(n)*size)
+}
+
+// bytes returns the []byte at off.
+func (f *frame) bytes(off uint32) []byte {
+	return f.slice(off, 1)
+}
+
+// string returns the Go string at off.
+func (f *frame) string(off uint32) string {
+	return string(f.bytes(off))
+}
+
+// value returns the value referenced at off.
+func (f *frame) value(off uint32) Value {
+	return f.r.load(order.Uint64(f.read(f.sp+off, 8)))
+}
+
+// values returns the values of the slice of references at off.
+func (f *frame) values(off uint32) []Value {
+	b := f.slice(off, 8)
+	vs := make([]Value, len(b)/8)
+	for i := range vs {
+		vs[i] = f.r.load(order.Uint64(b[8*i:]))
+	}
+	return vs
+}
+
+// setValue stores a reference to v at off.
+func (f *frame) setValue(off uint32, v Value) {
+	f.write(f.sp+off, order.AppendUint64(nil, f.r.store(v)))
+}
+
+// resync updates sp after a call which may have run Go code, and moved the
+// stack of the current goroutine.
+func (f *frame) resync() {
+	res, err := f.c.Instance().Call("getsp")
+	if err != nil {
+		f.fail(err)
+		return
+	}
+	f.sp = uint32(res[0].(int32))
+}
+
+// References to JavaScript values are NaN-boxed: numbers are stored as
+// float64, other values as a NaN whose low 32 bits hold an id, and whose
+// high bits hold a type flag.
+const (
+	nanHead = 0x7ff80000
+
+	typeFlagNone     = 0
+	typeFlagObject   = 1
+	typeFlagString   = 2
+	typeFlagSymbol   = 3
+	typeFlagFunction = 4
+)
+
+// load returns the value of a reference.
+func (r *Runtime) load(ref uint64) Value {
+	v := math.Float64frombits(ref)
+	switch {
+	case v == 0:
+		return Undefined
+	case !math.IsNaN(v):
+		return v
+	}
+	id := uint32(ref)
+	if int(id) >= len(r.values) {
+		return Undefined
+	}
+	return r.values[id]
+}
+
+// store returns a reference to v.
+func (r *Runtime) store(v Value) uint64 {
+	if f, ok := v.(float64); ok && f != 0 {
+		if math.IsNaN(f) {
+			return nanHead << 32
+		}
+		return math.Float64bits(f)
+	}
+	if v == Undefined {
+		return 0
+	}
+
+	id, ok := r.ids[v]
+	if !ok {
+		if n := len(r.pool); n > 0 {
+			id, r.pool = r.pool[n-1], r.pool[:n-1]
+			r.values[id] = v
+			r.refs[id] = 0
+		} else {
+			id = uint32(len(r.values))
+			r.values = append(r.values, v)
+			r.refs = append(r.refs, 0)
+		}
+		r.ids[v] = id
+	}
+	if r.refs[id] >= 0 {
+		r.refs[id]++
+	}
+
+	flag := uint64(typeFlagNone)
+	switch typeOf(v) {
+	case "object":
+		if v != nil {
+			flag = typeFlagObject
+		}
+	case "string":
+		flag = typeFlagString
+	case "function":
+		flag = typeFlagFunction
+	}
+	return (nanHead|flag)<<32 | uint64(id)
+}
+
+// release releases a reference of the program to the value with the given
+// id.
+func (r *Runtime) release(id uint32) {
+	if int(id) >= len(r.refs) || r.refs[id] <= 0 {
+		return
+	}
+	r.refs[id]--
+	if r.refs[id] == 0 {
+		delete(r.ids, r.values[id])
+		r.values[id] = nil
+		r.pool = append(r.pool, id)
+	}
+}
+
+// call runs a JavaScript call, and stores its result, or the exception it
+// threw, at off, followed by a success flag.
+func (f *frame) call(off uint32, fn func() (Value, error)) {
+	v, err := fn()
+	f.resync()
+	if err != nil {
+		exc, ok := err.(*Exception)
+		if !ok {
+			f.fail(err)
+			return
+		}
+		f.setValue(off, exc.Value)
+		f.setBool(off+8, false)
+		return
+	}
+	f.setValue(off, v)
+	f.setBool(off+8, true)
+}
+
+// object returns v as an object, or fails with a TypeError.
+func (f *frame) object(v Value, op string) *Object {
+	o, ok := v.(*Object)
+	if !ok {
+		f.fail(typeError("cannot %s of %s", op, toString(v)))
+	}
+	return o
+}
+
+// get returns the named property of v.
+func get(v Value, name string) (Value, error) {
+	switch v := v.(type) {
+	case *Object:
+		return v.Get(name), nil
+	case string:
+		if name == "length" {
+			return float64(stringLength(v)), nil
+		}
+		return Undefined, nil
+	case undefined, nil:
+		return nil, typeError("cannot read properties of %s (reading '%s')", toString(v), name)
+	}
+	return Undefined, nil
+}
+
+// imports returns the functions imported by the program, taking the stack
+// pointer as their parameter.
+func (r *Runtime) imports() map[string]func(f *frame) {
+	fns := map[string]func(f *frame){
+		// func wasmExit(code int32)
+		"runtime.wasmExit": func(f *frame) {
+			r.exited = true
+			r.code = f.getInt32(8)
+		},
+
+		// func wasmWrite(fd uintptr, p unsafe.Pointer, n int32)
+		"runtime.wasmWrite": func(f *frame) {
+			fd := f.getInt64(8)
+			p := f.getInt64(16)
+			n := f.getInt32(24)
+			b := f.read(uint32(p), uint32(n))
+			w := r.stdout
+			if fd == 2 {
+				w = r.stderr
+			}
+			w.Write(b)
+		},
+
+		// func resetMemoryDataView()
+		"runtime.resetMemoryDataView": func(f *frame) {},
+
+		// func nanotime1() int64
+		"runtime.nanotime1": func(f *frame) {
+			f.setInt64(8, r.start.UnixNano()+int64(time.Since(r.start)))
+		},
+
+		// func walltime() (sec int64, nsec int32)
+		"runtime.walltime": func(f *frame) {
+			now := r.now()
+			f.setInt64(8, now.Unix())
+			f.setInt32(16, int32(now.Nanosecond()))
+		},
+
+		// func scheduleTimeoutEvent(delay int64) int32
+		"runtime.scheduleTimeoutEvent": func(f *frame) {
+			id := r.nextID
+			r.nextID++
+			r.timeouts[id] = time.Now().Add(time.Duration(f.getInt64(8)) * time.Millisecond)
+			f.setInt32(16, id)
+		},
+
+		// func clearTimeoutEvent(id int32)
+		"runtime.clearTimeoutEvent": func(f *frame) {
+			delete(r.timeouts, f.getInt32(8))
+		},
+
+		// func getRandomData(r []byte)
+		"runtime.getRandomData": func(f *frame) {
+			if _, err := io.ReadFull(r.rand, f.bytes(8)); err != nil {
+				f.fail(err)
+			}
+		},
+
+		// func finalizeRef(v ref)
+		"syscall/js.finalizeRef": func(f *frame) {
+			r.release(order.Uint32(f.read(f.sp+8, 4)))
+		},
+
+		// func stringVal(value string) ref
+		"syscall/js.stringVal": func(f *frame) {
+			f.setValue(24, f.string(8))
+		},
+
+		// func valueGet(v ref, p string) ref
+		"syscall/js.valueGet": func(f *frame) {
+			v, err := get(f.value(8), f.string(16))
+			if err != nil {
+				f.fail(err)
+				return
+			}
+			f.resync()
+			f.setValue(32, v)
+		},
+
+		// func valueSet(v ref, p string, x ref)
+		"syscall/js.valueSet": func(f *frame) {
+			if o := f.object(f.value(8), "set properties"); o != nil {
+				o.Set(f.string(16), f.value(32))
+			}
+		},
+
+		// func valueDelete(v ref, p string)
+		"syscall/js.valueDelete": func(f *frame) {
+			if o := f.object(f.value(8), "delete properties"); o != nil {
+				o.Delete(f.string(16))
+			}
+		},
+
+		// func valueIndex(v ref, i int) ref
+		"syscall/js.valueIndex": func(f *frame) {
+			if o := f.object(f.value(8), "read elements"); o != nil {
+				f.setValue(24, o.Index(int(f.getInt64(16))))
+			}
+		},
+
+		// valueSetIndex(v ref, i int, x ref)
+		"syscall/js.valueSetIndex": func(f *frame) {
+			if o := f.object(f.value(8), "set elements"); o != nil {
+				o.SetIndex(int(f.getInt64(16)), f.value(24))
+			}
+		},
+
+		// func valueCall(v ref, m string, args []ref) (ref, bool)
+		"syscall/js.valueCall": func(f *frame) {
+			v, name, args := f.value(8), f.string(16), f.values(32)
+			f.call(56, func() (Value, error) {
+				m, err := get(v, name)
+				if err != nil {
+					return nil, err
+				}
+				return invoke(m, v, args)
+			})
+		},
+
+		// func valueInvoke(v ref, args []ref) (ref, bool)
+		"syscall/js.valueInvoke": func(f *frame) {
+			v, args := f.value(8), f.values(16)
+			f.call(40, func() (Value, error) {
+				return invoke(v, Undefined, args)
+			})
+		},
+
+		// func valueNew(v ref, args []ref) (ref, bool)
+		"syscall/js.valueNew": func(f *frame) {
+			v, args := f.value(8), f.values(16)
+			f.call(40, func() (Value, error) {
+				c, ok := v.(*Object)
+				if !ok {
+					return nil, typeError("%s is not a constructor", toString(v))
+				}
+				return c.New(args...)
+			})
+		},
+
+		// func valueLength(v ref) int
+		"syscall/js.valueLength": func(f *frame) {
+			n, err := get(f.value(8), "length")
+			if err != nil {
+				f.fail(err)
+				return
+			}
+			f.setInt64(16, int64(toInt(n)))
+		},
+
+		// valuePrepareString(v ref) (ref, int)
+		"syscall/js.valuePrepareString": func(f *frame) {
+			str := []byte(toString(f.value(8)))
+			f.setValue(16, NewUint8Array(str))
+			f.setInt64(24, int64(len(str)))
+		},
+
+		// valueLoadString(v ref, b []byte)
+		"syscall/js.valueLoadString": func(f *frame) {
+			if o := f.object(f.value(8), "load string"); o != nil {
+				copy(f.bytes(16), o.bytes)
+			}
+		},
+
+		// func valueInstanceOf(v ref, t ref) bool
+		"syscall/js.valueInstanceOf": func(f *frame) {
+			v, _ := f.value(8).(*Object)
+			t, _ := f.value(16).(*Object)
+			f.setBool(24, v != nil && t != nil && v.InstanceOf(t))
+		},
+
+		// func copyBytesToGo(dst []byte, src ref) (int, bool)
+		"syscall/js.copyBytesToGo": func(f *frame) {
+			src, ok := f.value(32).(*Object)
+			if !ok || src.class != "Uint8Array" {
+				f.setBool(48, false)
+				return
+			}
+			n := copy(f.bytes(8), src.bytes)
+			f.setInt64(40, int64(n))
+			f.setBool(48, true)
+		},
+
+		// func copyBytesToJS(dst ref, src []byte) (int, bool)
+		"syscall/js.copyBytesToJS": func(f *frame) {
+			dst, ok := f.value(8).(*Object)
+			if !ok || dst.class != "Uint8Array" {
+				f.setBool(48, false)
+				return
+			}
+			n := copy(dst.bytes, f.bytes(16))
+			f.setInt64(40, int64(n))
+			f.setBool(48, true)
+		},
+	}
+
+	// names of the functions imported by programs built by Go 1.20 and
+	// earlier.
+	fns["runtime.nanotime"] = fns["runtime.nanotime1"]
+	fns["runtime.walltime1"] = fns["runtime.walltime"]
+	return fns
+}
@@ -0,0 +1,366 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gojs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Value is a JavaScript value: Undefined, nil for null, a bool, a float64,
+// a string, or an *Object.
+type Value interface{}
+
+type undefined struct{}
+
+func (undefined) String() string { return "undefined" }
+
+// Undefined is the JavaScript undefined value.
+var Undefined Value = undefined{}
+
+// Object is a JavaScript object.
+// Functions and constructors are objects which may be called.
+type Object struct {
+	props map[string]Value
+	proto *Object // prototype, looked up for properties missing from the object
+
+	fn    Func    // implementation of functions
+	ctor  Func    // implementation of constructors, with an undefined this
+	elems []Value // elements of arrays
+	bytes []byte  // content of Uint8Array objects
+	class string  // name of the constructor of builtin objects
+}
+
+// Func is the implementation of a JavaScript function in Go.
+// A non-nil error is thrown as an exception: errors other than *Exception
+// are thrown as Error objects.
+type Func func(this Value, args []Value) (Value, error)
+
+// NewObject returns an empty object.
+func NewObject() *Object {
+	return &Object{props: make(map[string]Value), class: "Object"}
+}
+
+// NewFunction returns a function implemented by fn.
+func NewFunction(fn Func) *Object {
+	o := NewObject()
+	o.fn = fn
+	o.class = "Function"
+	return o
+}
+
+// NewArray returns an array holding the given elements.
+func NewArray(elems ...Value) *Object {
+	o := NewObject()
+	o.elems = elems
+	o.class = "Array"
+	return o
+}
+
+// NewUint8Array returns a Uint8Array object, holding the bytes of b.
+func NewUint8Array(b []byte) *Object {
+	o := NewObject()
+	o.bytes = b
+	o.class = "Uint8Array"
+	return o
+}
+
+// NewError returns an Error object with the given message.
+func NewError(msg string) *Object {
+	o := NewObject()
+	o.props["message"] = msg
+	o.class = "Error"
+	return o
+}
+
+// Get returns the named property of the object, or Undefined.
+func (o *Object) Get(name string) Value {
+	for p := o; p != nil; p = p.proto {
+		if v, ok := p.props[name]; ok {
+			return v
+		}
+	}
+	switch {
+	case name == "length" && o.class == "Array":
+		return float64(len(o.elems))
+	case name == "length" && o.class == "Uint8Array":
+		return float64(len(o.bytes))
+	}
+	if i, err := strconv.Atoi(name); err == nil && i >= 0 {
+		return o.Index(i)
+	}
+	return Undefined
+}
+
+// Set sets the named property of the object.
+func (o *Object) Set(name string, v Value) {
+	if i, err := strconv.Atoi(name); err == nil && i >= 0 && (o.class == "Array" || o.class == "Uint8Array") {
+		o.SetIndex(i, v)
+		return
+	}
+	o.props[name] = v
+}
+
+// Delete removes the named property of the object.
+func (o *Object) Delete(name string) {
+	delete(o.props, name)
+}
+
+// Index returns the i-th element of an array or Uint8Array object, or
+// Undefined.
+func (o *Object) Index(i int) Value {
+	switch {
+	case o.class == "Uint8Array" && i < len(o.bytes):
+		return float64(o.bytes[i])
+	case i < len(o.elems):
+		return o.elems[i]
+	}
+	if v, ok := o.props[strconv.Itoa(i)]; ok {
+		return v
+	}
+	return Undefined
+}
+
+// SetIndex sets the i-th element of an array or Uint8Array object.
+// Arrays grow as needed.
+func (o *Object) SetIndex(i int, v Value) {
+	switch o.class {
+	case "Uint8Array":
+		if i < len(o.bytes) {
+			o.bytes[i] = byte(toInt(v))
+		}
+	case "Array":
+		for len(o.elems) <= i {
+			o.elems = append(o.elems, Undefined)
+		}
+		o.elems[i] = v
+	default:
+		o.props[strconv.Itoa(i)] = v
+	}
+}
+
+// Len returns the value of the length property of the object.
+func (o *Object) Len() int {
+	return toInt(o.Get("length"))
+}
+
+// Bytes returns the content of a Uint8Array object.
+func (o *Object) Bytes() []byte {
+	return o.bytes
+}
+
+// IsFunction returns whether the object may be called.
+func (o *Object) IsFunction() bool {
+	return o.fn != nil || o.ctor != nil
+}
+
+// Invoke calls the function with an undefined this.
+func (o *Object) Invoke(args ...Value) (Value, error) {
+	return invoke(o, Undefined, args)
+}
+
+// Call calls the named method of the object.
+func (o *Object) Call(name string, args ...Value) (Value, error) {
+	return invoke(o.Get(name), o, args)
+}
+
+// New calls the object as a constructor.
+func (o *Object) New(args ...Value) (Value, error) {
+	switch {
+	case o.ctor != nil:
+		return catch(o.ctor(Undefined, args))
+	case o.fn != nil:
+		this := NewObject()
+		if proto, ok := o.Get("prototype").(*Object); ok {
+			this.proto = proto
+		}
+		v, err := catch(o.fn(this, args))
+		if err != nil {
+			return nil, err
+		}
+		if obj, ok := v.(*Object); ok {
+			return obj, nil
+		}
+		return this, nil
+	}
+	return nil, typeError("%s is not a constructor", typeOf(o))
+}
+
+// InstanceOf returns whether the object was created by the constructor c.
+func (o *Object) InstanceOf(c *Object) bool {
+	switch name := c.Get("name"); {
+	case name == "Object":
+		return true
+	case name == o.class && o.class != "Object":
+		// builtin objects do not have prototypes.
+		return true
+	}
+	proto, ok := c.Get("prototype").(*Object)
+	if !ok {
+		return false
+	}
+	for p := o.proto; p != nil; p = p.proto {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
+func invoke(fn, this Value, args []Value) (Value, error) {
+	o, ok := fn.(*Object)
+	if !ok || !o.IsFunction() {
+		return nil, typeError("%s is not a function", toString(fn))
+	}
+	if o.fn == nil {
+		return nil, typeError("class constructor %s cannot be invoked without 'new'", toString(o.Get("name")))
+	}
+	return catch(o.fn(this, args))
+}
+
+// catch converts the errors returned by a Func to exceptions.
+func catch(v Value, err error) (Value, error) {
+	if err == nil {
+		return v, nil
+	}
+	if _, ok := err.(*Exception); ok {
+		return nil, err
+	}
+	return nil, &Exception{Value: NewError(err.Error())}
+}
+
+// Exception is an error holding a thrown JavaScript value.
+type Exception struct {
+	Value Value
+}
+
+func (e *Exception) Error() string {
+	return "gojs: uncaught exception: " + toString(e.Value)
+}
+
+func typeError(format string, args ...interface{}) error {
+	err := NewError(fmt.Sprintf(format, args...))
+	err.props["name"] = "TypeError"
+	return &Exception{Value: err}
+}
+
+func rangeError(format string, args ...interface{}) error {
+	err := NewError(fmt.Sprintf(format, args...))
+	err.props["name"] = "RangeError"
+	return &Exception{Value: err}
+}
+
+// typeOf returns the result of the typeof operator applied to v.
+func typeOf(v Value) string {
+	switch v := v.(type) {
+	case undefined:
+		return "undefined"
+	case nil:
+		return "object"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Object:
+		if v.IsFunction() {
+			return "function"
+		}
+	}
+	return "object"
+}
+
+// toString converts v to a string, following the String function of
+// JavaScript.
+func toString(v Value) string {
+	switch v := v.(type) {
+	case undefined:
+		return "undefined"
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return formatNumber(v)
+	case string:
+		return v
+	case *Object:
+		switch {
+		case v.class == "Error":
+			name := "Error"
+			if n, ok := v.Get("name").(string); ok {
+				name = n
+			}
+			if msg := toString(v.Get("message")); msg != "" {
+				return name + ": " + msg
+			}
+			return name
+		case v.class == "Array" || v.class == "Uint8Array":
+			elems := make([]string, v.Len())
+			for i := range elems {
+				switch e := v.Index(i); e {
+				case Undefined, nil:
+				default:
+					elems[i] = toString(e)
+				}
+			}
+			return strings.Join(elems, ",")
+		case v.IsFunction():
+			return "function " + toString(v.Get("name")) + "() { [native code] }"
+		}
+		if s, ok := v.Get("toString").(*Object); ok && s.fn != nil {
+			if str, err := s.fn(v, nil); err == nil {
+				return toString(str)
+			}
+		}
+	}
+	return "[object Object]"
+}
+
+// formatNumber formats v as JavaScript does.
+func formatNumber(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	case v == 0:
+		return "0"
+	}
+	if abs := math.Abs(v); abs >= 1e21 || abs < 1e-6 {
+		s := strconv.FormatFloat(v, 'e', -1, 64)
+		// JavaScript does not pad exponents: 1e-7, not 1e-07.
+		mant, exp, _ := strings.Cut(s, "e")
+		sign := exp[:1]
+		exp = strings.TrimLeft(exp[1:], "0")
+		return mant + "e" + sign + exp
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// toInt converts v to an integer, following the parseInt function of
+// JavaScript for numbers, and returning 0 for other values.
+func toInt(v Value) int {
+	f, ok := v.(float64)
+	if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return int(f)
+}
+
+// stringLength returns the length of s, in UTF-16 code units.
+func stringLength(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
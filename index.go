@@ -0,0 +1,88 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+// moduleIndex gathers the index spaces of a module.
+type moduleIndex struct {
+	types    []FuncType
+	imports  []ImportEntry
+	funcs    []uint32 // type index of every function, imported ones first
+	tables   []TableType
+	memories []MemoryType
+	globals  []GlobalType
+	exports  []ExportEntry
+	start    *StartSection
+	elements []ElemSegment
+	bodies   []FunctionBody
+	data     []DataSegment
+
+	nfuncs   int // number of imported functions
+	nglobals int // number of imported globals
+
+	globalInits []InitExpr // initializers of the defined globals
+}
+
+func newModuleIndex(m *Module) *moduleIndex {
+	idx := &moduleIndex{}
+	for _, sec := range m.Sections {
+		// sections which cannot be decoded lazily are reported by Validate.
+		sec, _ := decodeSection(sec)
+		switch sec := sec.(type) {
+		case TypeSection:
+			idx.types = append(idx.types, sec.Types...)
+		case ImportSection:
+			idx.imports = append(idx.imports, sec.Imports...)
+			for _, imp := range sec.Imports {
+				switch imp.Kind {
+				case FunctionKind:
+					idx.funcs = append(idx.funcs, imp.TypeIndex)
+					idx.nfuncs++
+				case TableKind:
+					idx.tables = append(idx.tables, imp.Table)
+				case MemoryKind:
+					idx.memories = append(idx.memories, imp.Memory)
+				case GlobalKind:
+					idx.globals = append(idx.globals, imp.Global)
+					idx.nglobals++
+				}
+			}
+		case FunctionSection:
+			idx.funcs = append(idx.funcs, sec.Types...)
+		case TableSection:
+			idx.tables = append(idx.tables, sec.Tables...)
+		case MemorySection:
+			idx.memories = append(idx.memories, sec.Memories...)
+		case GlobalSection:
+			for _, g := range sec.Globals {
+				idx.globals = append(idx.globals, g.Type)
+				idx.globalInits = append(idx.globalInits, g.Init)
+			}
+		case ExportSection:
+			idx.exports = append(idx.exports, sec.Exports...)
+		case StartSection:
+			start := sec
+			idx.start = &start
+		case ElementSection:
+			idx.elements = append(idx.elements, sec.Elements...)
+		case CodeSection:
+			idx.bodies = append(idx.bodies, sec.Bodies...)
+		case DataSection:
+			idx.data = append(idx.data, sec.Segments...)
+		}
+	}
+	return idx
+}
+
+// funcType returns the signature of the i-th function.
+func (idx *moduleIndex) funcType(i uint32) (FuncType, bool) {
+	if int(i) >= len(idx.funcs) {
+		return FuncType{}, false
+	}
+	t := idx.funcs[i]
+	if int(t) >= len(idx.types) {
+		return FuncType{}, false
+	}
+	return idx.types[t], true
+}
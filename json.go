@@ -0,0 +1,104 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (vt ValueType) MarshalText() ([]byte, error) {
+	return []byte(vt.String()), nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (et ElemType) MarshalText() ([]byte, error) {
+	return []byte(et.String()), nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k ExternalKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+// The initializer expression is marshaled in the text format.
+func (ie InitExpr) MarshalText() ([]byte, error) {
+	instrs, err := ie.Instrs()
+	if err != nil {
+		return nil, err
+	}
+	str := make([]string, 0, len(instrs))
+	for _, ins := range instrs[:len(instrs)-1] {
+		str = append(str, ins.String())
+	}
+	return []byte(strings.Join(str, " ")), nil
+}
+
+// MarshalJSON implements json.Marshaler.
+// Only the descriptor relevant to the kind of import is marshaled.
+func (ie ImportEntry) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Module    string       `json:"module"`
+		Field     string       `json:"field"`
+		Kind      ExternalKind `json:"kind"`
+		TypeIndex *uint32      `json:"type_index,omitempty"`
+		Table     *TableType   `json:"table,omitempty"`
+		Memory    *MemoryType  `json:"memory,omitempty"`
+		Global    *GlobalType  `json:"global,omitempty"`
+	}{
+		Module: ie.Module,
+		Field:  ie.Field,
+		Kind:   ie.Kind,
+	}
+	switch ie.Kind {
+	case FunctionKind:
+		v.TypeIndex = &ie.TypeIndex
+	case TableKind:
+		v.Table = &ie.Table
+	case MemoryKind:
+		v.Memory = &ie.Memory
+	case GlobalKind:
+		v.Global = &ie.Global
+	}
+	return json.Marshal(v)
+}
+
+// jsonSection is the JSON representation of a section.
+type jsonSection struct {
+	ID      SectionID      `json:"id"`
+	Name    string         `json:"name"`
+	Layout  *SectionLayout `json:"layout,omitempty"`
+	Section Section        `json:"section"`
+}
+
+// MarshalJSON implements json.Marshaler.
+// Each section is marshaled together with its ID and, for decoded modules,
+// its layout.
+func (m Module) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Header   ModuleHeader  `json:"header"`
+		Sections []jsonSection `json:"sections"`
+	}{
+		Header:   m.Header,
+		Sections: make([]jsonSection, len(m.Sections)),
+	}
+	for i, sec := range m.Sections {
+		sec, err := decodeSection(sec)
+		if err != nil {
+			return nil, err
+		}
+		v.Sections[i] = jsonSection{
+			ID:      sec.ID(),
+			Name:    sec.ID().String(),
+			Section: sec,
+		}
+		if i < len(m.Layout) {
+			v.Sections[i].Layout = &m.Layout[i]
+		}
+	}
+	return json.Marshal(v)
+}
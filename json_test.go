@@ -0,0 +1,89 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	// (module
+	//  (import "env" "mem" (memory 1 2))
+	//  (global i32 (i32.const -1))
+	//  (func $add (export "add") (param $lhs i32) (param $rhs i32) (result i32)
+	//   get_local $lhs
+	//   get_local $rhs
+	//   i32.add)
+	// )
+	raw := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x07, 0x01, 0x60, 0x02, 0x7f, 0x7f, 0x01,
+		0x7f, 0x02, 0x0d, 0x01, 0x03, 0x65, 0x6e, 0x76,
+		0x03, 0x6d, 0x65, 0x6d, 0x02, 0x01, 0x01, 0x02,
+		0x03, 0x02, 0x01, 0x00, 0x06, 0x06, 0x01, 0x7f,
+		0x00, 0x41, 0x7f, 0x0b, 0x07, 0x07, 0x01, 0x03,
+		0x61, 0x64, 0x64, 0x00, 0x00, 0x0a, 0x09, 0x01,
+		0x07, 0x00, 0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b,
+	}
+	mod, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := new(bytes.Buffer)
+	err = json.Compact(want, []byte(`{
+	"header": {"magic": [0, 97, 115, 109], "version": 1},
+	"sections": [
+		{
+			"id": 1, "name": "type",
+			"layout": {"offset": 8, "payload": 10, "size": 7},
+			"section": {"types": [{"form": "func", "params": ["i32", "i32"], "results": ["i32"]}]}
+		},
+		{
+			"id": 2, "name": "import",
+			"layout": {"offset": 17, "payload": 19, "size": 13},
+			"section": {"imports": [
+				{"module": "env", "field": "mem", "kind": "memory", "memory": {"limits": {"flags": 1, "initial": 1, "maximum": 2}}}
+			]}
+		},
+		{
+			"id": 3, "name": "function",
+			"layout": {"offset": 32, "payload": 34, "size": 2},
+			"section": {"types": [0]}
+		},
+		{
+			"id": 6, "name": "global",
+			"layout": {"offset": 36, "payload": 38, "size": 6},
+			"section": {"globals": [{"type": {"content_type": "i32", "mutability": 0}, "init": "i32.const -1"}]}
+		},
+		{
+			"id": 7, "name": "export",
+			"layout": {"offset": 44, "payload": 46, "size": 7},
+			"section": {"exports": [{"field": "add", "kind": "func", "index": 0}]}
+		},
+		{
+			"id": 10, "name": "code",
+			"layout": {"offset": 53, "payload": 55, "size": 9},
+			"section": {"bodies": [{"body_size": 7, "local_count": 0, "locals": [], "code": {"code": "IAAgAWo=", "end": 11}}]}
+		}
+	]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("invalid JSON:\ngot= %s\nwant=%s", got, want.Bytes())
+	}
+}
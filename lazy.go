@@ -0,0 +1,219 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// LazySection is a section of a module decoded lazily (see DecodeOptions).
+// The section is only decoded when Decode is first called, and the function
+// bodies of a code section may be decoded one at a time with Body.
+// A LazySection is safe for concurrent use.
+type LazySection struct {
+	id     SectionID
+	name   string // name of a custom section
+	layout SectionLayout
+	src    *source
+
+	mu     sync.Mutex
+	sec    Section // decoded section, or nil
+	err    error   // error decoding the section
+	bodies []lazyBody
+}
+
+// lazyBody is a function body of a code section decoded lazily.
+type lazyBody struct {
+	offset int64
+	body   *FunctionBody // decoded function body, or nil
+}
+
+// source is the content of a module decoded lazily, held in memory or read
+// from an io.ReaderAt.
+type source struct {
+	buf  []byte
+	ra   io.ReaderAt
+	size int64
+}
+
+// decoder returns a decoder of the module, starting at offset off.
+func (src *source) decoder(off int64) *decoder {
+	c := &cursor{buf: src.buf, pos: int(off), ra: src.ra, size: src.size}
+	if src.ra != nil {
+		c.pos, c.base = 0, off
+	}
+	return &decoder{r: c, cr: c, section: -1, entry: -1, errOff: -1}
+}
+
+func (s *LazySection) ID() SectionID {
+	return s.id
+}
+
+// Name returns the name of a custom section.
+func (s *LazySection) Name() string {
+	return s.name
+}
+
+// Layout returns where the section is found in the module.
+func (s *LazySection) Layout() SectionLayout {
+	return s.layout
+}
+
+// Payload returns the undecoded payload of the section.
+func (s *LazySection) Payload() ([]byte, error) {
+	d := s.src.decoder(s.layout.Payload)
+	d.section = int(s.id)
+	r := d.limit(s.layout.Size)
+	if d.err == nil {
+		var buf []byte
+		buf, d.err = readAll(r)
+		if d.err == io.EOF {
+			d.err = io.ErrUnexpectedEOF
+		}
+		if d.err == nil {
+			return buf, nil
+		}
+	}
+	return nil, d.wrap()
+}
+
+// Decode decodes the section, and returns it.
+// The section is decoded once: later calls return the same section.
+func (s *LazySection) Decode() (Section, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sec == nil && s.err == nil {
+		d := s.src.decoder(s.layout.Payload)
+		d.section = int(s.id)
+		s.sec = d.readPayload(s.id, d.limit(s.layout.Size))
+		s.err = d.wrap()
+	}
+	return s.sec, s.err
+}
+
+// NumBodies returns the number of function bodies of a code section.
+func (s *LazySection) NumBodies() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sec, ok := s.sec.(CodeSection); ok {
+		return len(sec.Bodies), nil
+	}
+	if err := s.indexBodies(); err != nil {
+		return 0, err
+	}
+	return len(s.bodies), nil
+}
+
+// Body returns the i-th function body of a code section, decoding it alone
+// if the section was not decoded yet.
+func (s *LazySection) Body(i int) (FunctionBody, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sec, ok := s.sec.(CodeSection); ok {
+		if i < 0 || i >= len(sec.Bodies) {
+			return FunctionBody{}, fmt.Errorf("wasm: function body %d out of range", i)
+		}
+		return sec.Bodies[i], nil
+	}
+	if err := s.indexBodies(); err != nil {
+		return FunctionBody{}, err
+	}
+	if i < 0 || i >= len(s.bodies) {
+		return FunctionBody{}, fmt.Errorf("wasm: function body %d out of range", i)
+	}
+	if fb := s.bodies[i].body; fb != nil {
+		return *fb, nil
+	}
+
+	end := s.layout.Payload + int64(s.layout.Size)
+	if i+1 < len(s.bodies) {
+		end = s.bodies[i+1].offset
+	}
+	off := s.bodies[i].offset
+	d := s.src.decoder(off)
+	d.section = int(CodeID)
+	d.enter(i)
+	var fb FunctionBody
+	d.readFunctionBody(d.limit(uint32(end-off)), &fb)
+	if d.err == io.EOF {
+		d.err = io.ErrUnexpectedEOF
+	}
+	if err := d.wrap(); err != nil {
+		return FunctionBody{}, err
+	}
+	s.bodies[i].body = &fb
+	return fb, nil
+}
+
+// indexBodies records the offsets of the function bodies of a code section,
+// without decoding them.
+func (s *LazySection) indexBodies() error {
+	if s.bodies != nil {
+		return nil
+	}
+	if s.id != CodeID {
+		return fmt.Errorf("wasm: %v section has no function bodies", s.id)
+	}
+
+	d := s.src.decoder(s.layout.Payload)
+	d.section = int(CodeID)
+	r := &limitedReader{r: d.r, n: int64(s.layout.Size)}
+	var n uint32
+	d.readVarU32(r, &n)
+	bodies := make([]lazyBody, 0, int(n)&0xffff)
+	for i := 0; i < int(n) && d.err == nil; i++ {
+		d.enter(i)
+		bodies = append(bodies, lazyBody{offset: d.offset()})
+		var sz uint32
+		d.readVarU32(r, &sz)
+		if d.err == nil {
+			d.err = r.skip(int64(sz))
+		}
+	}
+	if d.err == io.EOF {
+		d.err = io.ErrUnexpectedEOF
+	}
+	if err := d.wrap(); err != nil {
+		return err
+	}
+	s.bodies = bodies
+	return nil
+}
+
+// decodeSection returns sec, decoded if it is a LazySection.
+func decodeSection(sec Section) (Section, error) {
+	if s, ok := sec.(*LazySection); ok {
+		return s.Decode()
+	}
+	return sec, nil
+}
+
+// Section returns the first section of the module with the given ID,
+// decoding it if it is a LazySection. Custom sections are found with Custom.
+func (m *Module) Section(id SectionID) (Section, bool, error) {
+	for _, sec := range m.Sections {
+		if sec.ID() != id || id == UnknownID {
+			continue
+		}
+		sec, err := decodeSection(sec)
+		return sec, err == nil, err
+	}
+	return nil, false, nil
+}
+
+// Load decodes the sections of the module decoded lazily, and replaces
+// them in Sections. It returns the first error decoding them.
+func (m *Module) Load() error {
+	for i, sec := range m.Sections {
+		sec, err := decodeSection(sec)
+		if err != nil {
+			return err
+		}
+		m.Sections[i] = sec
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+// lazyDecoders are the ways of decoding a module lazily.
+var lazyDecoders = []struct {
+	name   string
+	decode func(raw []byte) (*wasm.Module, error)
+}{
+	{"Decode", func(raw []byte) (*wasm.Module, error) {
+		return wasm.DecodeOptions{Lazy: true}.Decode(bytes.NewReader(raw))
+	}},
+	{"DecodeBytes", wasm.DecodeOptions{Lazy: true}.DecodeBytes},
+	{"DecodeReaderAt", func(raw []byte) (*wasm.Module, error) {
+		return wasm.DecodeOptions{Lazy: true}.DecodeReaderAt(bytes.NewReader(raw), int64(len(raw)))
+	}},
+}
+
+func TestDecodeLazy(t *testing.T) {
+	raw, err := os.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := wasm.DecodeBytes(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dec := range lazyDecoders {
+		m, err := dec.decode(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", dec.name, err)
+		}
+		if !reflect.DeepEqual(m.Layout, want.Layout) {
+			t.Errorf("%s: got layout %v, want %v", dec.name, m.Layout, want.Layout)
+		}
+		for i, sec := range m.Sections {
+			if _, ok := sec.(*wasm.LazySection); !ok {
+				t.Errorf("%s: section %d is decoded (%T)", dec.name, i, sec)
+			}
+		}
+
+		// function bodies are decoded one at a time.
+		var (
+			lazy  *wasm.LazySection
+			code  wasm.CodeSection
+			found bool
+		)
+		for i, sec := range want.Sections {
+			if sec, ok := sec.(wasm.CodeSection); ok {
+				lazy, code, found = m.Sections[i].(*wasm.LazySection), sec, true
+			}
+		}
+		if !found {
+			t.Fatalf("no code section")
+		}
+		n, err := lazy.NumBodies()
+		if err != nil || n != len(code.Bodies) {
+			t.Fatalf("%s: got %d bodies (err=%v), want %d", dec.name, n, err, len(code.Bodies))
+		}
+		for i := n - 1; i >= 0; i-- {
+			fb, err := lazy.Body(i)
+			if err != nil {
+				t.Fatalf("%s: body %d: %v", dec.name, i, err)
+			}
+			if !reflect.DeepEqual(fb, code.Bodies[i]) {
+				t.Errorf("%s: body %d: got %+v, want %+v", dec.name, i, fb, code.Bodies[i])
+			}
+		}
+		if _, err := lazy.Body(n); err == nil {
+			t.Errorf("%s: expected an error for body %d", dec.name, n)
+		}
+
+		// sections decoded lazily are encoded verbatim.
+		var out bytes.Buffer
+		if err := wasm.Encode(*m, &out); err != nil {
+			t.Fatalf("%s: %v", dec.name, err)
+		}
+		if !bytes.Equal(out.Bytes(), raw) {
+			t.Errorf("%s: re-encoded module differs", dec.name)
+		}
+
+		if err := wasm.Validate(m); err != nil {
+			t.Errorf("%s: %v", dec.name, err)
+		}
+		if err := m.Load(); err != nil {
+			t.Fatalf("%s: %v", dec.name, err)
+		}
+		if !reflect.DeepEqual(m.Sections, want.Sections) {
+			t.Errorf("%s: loaded sections differ", dec.name)
+		}
+	}
+}
+
+func TestOpenLazy(t *testing.T) {
+	want, err := wasm.Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := wasm.DecodeOptions{Lazy: true}.Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	for _, id := range []wasm.SectionID{wasm.ImportID, wasm.ExportID, wasm.CodeID} {
+		got, ok, err := m.Section(id)
+		if err != nil || !ok {
+			t.Fatalf("%v section: ok=%v, err=%v", id, ok, err)
+		}
+		w, _, _ := want.Section(id)
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("%v section: got %+v, want %+v", id, got, w)
+		}
+	}
+	if _, ok, err := m.Section(wasm.StartID); ok || err != nil {
+		t.Errorf("start section: ok=%v, err=%v", ok, err)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Sections[0].(*wasm.LazySection).Payload(); err == nil {
+		t.Errorf("expected an error reading a closed module")
+	}
+}
+
+func TestDecodeLazyError(t *testing.T) {
+	// the function body, which does not end with an end instruction, is
+	// not decoded until accessed.
+	b := wasm.NewBuilder()
+	f := b.FuncCode(wasm.FuncType{}, nil, wasm.Code{Code: []byte{byte(wasm.Op_nop)}})
+	b.Export("f", wasm.FunctionKind, f)
+	mod, err := b.Module()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := wasm.Encode(*mod, &buf); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	raw[len(raw)-1] = byte(wasm.Op_nop)
+
+	for _, dec := range lazyDecoders {
+		m, err := dec.decode(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", dec.name, err)
+		}
+		sec, _, err := m.Section(wasm.ExportID)
+		if err != nil {
+			t.Fatalf("%s: %v", dec.name, err)
+		}
+		if got := sec.(wasm.ExportSection).Exports[0].Field; got != "f" {
+			t.Errorf("%s: got export %q", dec.name, got)
+		}
+		code, _, err := m.Section(wasm.CodeID)
+		if err == nil {
+			t.Errorf("%s: expected an error decoding the code section, got %+v", dec.name, code)
+		}
+		if err := wasm.Validate(m); err == nil {
+			t.Errorf("%s: expected a validation error", dec.name)
+		}
+
+		// truncated modules are reported while decoding.
+		_, err = dec.decode(raw[:len(raw)-2])
+		if err == nil {
+			t.Errorf("%s: expected an error decoding a truncated module", dec.name)
+		}
+	}
+}
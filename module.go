@@ -7,34 +7,55 @@ package wasm
 import (
 	"fmt"
 	"io"
-	"os"
 )
 
 // Module is a WebAssembly module.
 type Module struct {
-	Header   ModuleHeader
-	Sections []Section
+	Header   ModuleHeader `json:"header"`
+	Sections []Section    `json:"sections"`
+
+	// Layout describes where each section of Sections was found in the
+	// decoded module. The encoder only uses it to encode the size of
+	// unchanged sections in as many bytes as in the decoded module.
+	Layout []SectionLayout `json:"-"`
+
+	closer io.Closer // file of a module opened lazily
 }
 
-func Open(name string) (Module, error) {
-	f, err := os.Open(name)
-	if err != nil {
-		return Module{}, err
-	}
-	defer f.Close()
+// SectionLayout describes the position of a section within a binary module.
+type SectionLayout struct {
+	Offset  int64  `json:"offset"`  // offset of the section ID
+	Payload int64  `json:"payload"` // offset of the section payload
+	Size    uint32 `json:"size"`    // size of the section payload, in bytes
+}
 
-	dec := decoder{r: f}
-	return dec.readModule()
+// Open decodes the WebAssembly module stored in the named file.
+// Each section is read from the file at once, as with DecodeReaderAt.
+func Open(name string) (*Module, error) {
+	return DecodeOptions{}.Open(name)
 }
 
+// Close closes the file of a module opened lazily by DecodeOptions.Open.
+// Its lazily decoded sections cannot be accessed anymore.
+func (m *Module) Close() error {
+	if m.closer == nil {
+		return nil
+	}
+	err := m.closer.Close()
+	m.closer = nil
+	return err
+}
+
+// NewModule returns an empty module with a valid header.
 func NewModule() *Module {
-	h := ModuleHeader{Magic: magicWASM, Version: 1}
+	h := ModuleHeader{Magic: magicWASM, Version: version}
 	return &Module{Header: h}
 }
 
+// ModuleHeader is the preamble of every WebAssembly module.
 type ModuleHeader struct {
-	Magic   [4]byte // wasm magic number (0x6d736100, ie: "\0asm")
-	Version uint32  // version number
+	Magic   [4]byte `json:"magic"`   // wasm magic number (0x6d736100, ie: "\0asm")
+	Version uint32  `json:"version"` // version number
 }
 
 func (hdr ModuleHeader) String() string {
@@ -51,19 +72,41 @@ type SectionID byte // edvakf:varuint7
 
 const (
 	UnknownID  SectionID = 0  // User section ID
-	TypeID               = 1  // Function signature declarations
-	ImportID             = 2  // Import declarations
-	FunctionID           = 3  // Function declarations
-	TableID              = 4  // Indirect function table and other tables
-	MemoryID             = 5  // Memory attributes
-	GlobalID             = 6  // Global declarations
-	ExportID             = 7  // Exports
-	StartID              = 8  // Start function declaration
-	ElementID            = 9  // Elements section
-	CodeID               = 10 // Function bodies (code)
-	DataID               = 11 // Data segments
+	TypeID     SectionID = 1  // Function signature declarations
+	ImportID   SectionID = 2  // Import declarations
+	FunctionID SectionID = 3  // Function declarations
+	TableID    SectionID = 4  // Indirect function table and other tables
+	MemoryID   SectionID = 5  // Memory attributes
+	GlobalID   SectionID = 6  // Global declarations
+	ExportID   SectionID = 7  // Exports
+	StartID    SectionID = 8  // Start function declaration
+	ElementID  SectionID = 9  // Elements section
+	CodeID     SectionID = 10 // Function bodies (code)
+	DataID     SectionID = 11 // Data segments
 )
 
+var sectionNames = [...]string{
+	UnknownID:  "custom",
+	TypeID:     "type",
+	ImportID:   "import",
+	FunctionID: "function",
+	TableID:    "table",
+	MemoryID:   "memory",
+	GlobalID:   "global",
+	ExportID:   "export",
+	StartID:    "start",
+	ElementID:  "element",
+	CodeID:     "code",
+	DataID:     "data",
+}
+
+func (id SectionID) String() string {
+	if int(id) < len(sectionNames) {
+		return sectionNames[id]
+	}
+	return fmt.Sprintf("SectionID(%d)", byte(id))
+}
+
 func (TypeSection) ID() SectionID     { return TypeID }
 func (ImportSection) ID() SectionID   { return ImportID }
 func (FunctionSection) ID() SectionID { return FunctionID }
@@ -75,89 +118,86 @@ func (StartSection) ID() SectionID    { return StartID }
 func (ElementSection) ID() SectionID  { return ElementID }
 func (CodeSection) ID() SectionID     { return CodeID }
 func (DataSection) ID() SectionID     { return DataID }
+func (CustomSection) ID() SectionID   { return UnknownID }
 func (NameSection) ID() SectionID     { return UnknownID }
 
+// TypeSection declares all function signatures used in the module.
 type TypeSection struct {
-	types []FuncType // type entries
-}
-
-func (s *TypeSection) readWasm(r io.Reader) error {
-	var err error
-	return err
+	Types []FuncType `json:"types"` // type entries
 }
 
+// ImportSection declares all imports defined by the module.
 type ImportSection struct {
-	imports []ImportEntry
+	Imports []ImportEntry `json:"imports"`
 }
 
+// ImportEntry describes an imported function, table, memory or global.
+// Only the descriptor matching Kind is meaningful.
 type ImportEntry struct {
-	module string
-	field  string
-	kind   ExternalKind // the kind of definition being imported
-
-	typ interface{} // imported value
-
-	/*
-		FunctionType varuint32  // type index of the function signature (if Kind is Function)
-		TableType    TableType  // type of imported table (if Kind==Table)
-		MemoryType   MemoryType // type of imported memory (if Kind==Memory)
-		GlobalType   GlobalType // type of importer global
-	*/
+	Module string       `json:"module"` // module name
+	Field  string       `json:"field"`  // field name
+	Kind   ExternalKind `json:"kind"`   // the kind of definition being imported
+
+	TypeIndex uint32     `json:"type_index"` // type index of the function signature (if Kind==FunctionKind) // edvakf:varuint32
+	Table     TableType  `json:"table"`      // type of imported table (if Kind==TableKind)
+	Memory    MemoryType `json:"memory"`     // type of imported memory (if Kind==MemoryKind)
+	Global    GlobalType `json:"global"`     // type of imported global (if Kind==GlobalKind)
 }
 
 // FunctionSection declares the signature of all functions in the module
 type FunctionSection struct {
-	types []uint32 // indices into the type sections // edvakf:varuint32
+	Types []uint32 `json:"types"` // indices into the type sections // edvakf:varuint32
 }
 
 // TableSection encodes a table
 type TableSection struct {
-	tables []TableType
+	Tables []TableType `json:"tables"`
 }
 
 // MemorySection encodes a memory
 type MemorySection struct {
-	memories []MemoryType
+	Memories []MemoryType `json:"memories"`
 }
 
 // GlobalSection encodes the global section
 type GlobalSection struct {
-	globals []GlobalVariable
+	Globals []GlobalVariable `json:"globals"`
 }
 
 // GlobalVariable represents a single global variable of a given type,
 // mutability and with the given initializer.
 type GlobalVariable struct {
-	Type GlobalType // type of the variables
-	Init InitExpr   // initial value of the global
+	Type GlobalType `json:"type"` // type of the variables
+	Init InitExpr   `json:"init"` // initial value of the global
 }
 
 // ExportSection encodes the export section
 type ExportSection struct {
-	exports []ExportEntry
+	Exports []ExportEntry `json:"exports"`
 }
 
 // ExportEntry represents an exported entity.
 type ExportEntry struct {
-	field string
-	kind  ExternalKind // kind of definition being exported
-	index uint32       // index into the corresponding index space // edvakf:varuint32
+	Field string       `json:"field"` // field name
+	Kind  ExternalKind `json:"kind"`  // kind of definition being exported
+	Index uint32       `json:"index"` // index into the corresponding index space // edvakf:varuint32
 }
 
 // StartSection declares the start function
 type StartSection struct {
-	Index uint32 // start function index
+	Index uint32 `json:"index"` // start function index
 }
 
 // ElementSection encodes the elements section
 type ElementSection struct {
-	elements []ElemSegment
+	Elements []ElemSegment `json:"elements"`
 }
 
+// ElemSegment initializes a range of elements of a table.
 type ElemSegment struct {
-	Index  uint32   // the table index
-	Offset InitExpr // an i32 initializer expression that computes the offset at which to place the elements
-	Elems  []uint32 // sequence of function indices
+	Index  uint32   `json:"index"`  // the table index
+	Offset InitExpr `json:"offset"` // an i32 initializer expression that computes the offset at which to place the elements
+	Elems  []uint32 `json:"elems"`  // sequence of function indices
 }
 
 // CodeSection contains a body for every function in the module.
@@ -165,48 +205,73 @@ type ElemSegment struct {
 // defined in this section must be the same and the i-th declaration corresponds
 // to the i-th function body.
 type CodeSection struct {
-	Bodies []FunctionBody
+	Bodies []FunctionBody `json:"bodies"`
 }
 
 // DataSection declares the initialized data that is loaded into linear memory
 type DataSection struct {
-	segments []DataSegment
+	Segments []DataSegment `json:"segments"`
 }
 
+// DataSegment initializes a range of bytes of a linear memory.
 type DataSegment struct {
-	Index  uint32   // the linear memory index
-	Offset InitExpr // an i32 initializer expression that computes the offset at which to place the data
-	Data   []byte
+	Index  uint32   `json:"index"`  // the linear memory index
+	Offset InitExpr `json:"offset"` // an i32 initializer expression that computes the offset at which to place the data
+	Data   []byte   `json:"data"`
 }
 
-// NameSection describes user-defined sections
-type NameSection struct {
-	name  string
-	funcs []FunctionNames
-}
+// CustomSection is a user-defined section.
+// Its payload is kept verbatim: known custom sections, like the "name"
+// section, may be decoded on demand.
+type CustomSection struct {
+	Name    string `json:"name"`    // name of the custom section
+	Payload []byte `json:"payload"` // content of the section, after its name
 
-type FunctionNames struct {
-	name   string
-	locals []LocalName
+	// Offset is the position of the payload within the decoded module.
+	// It is ignored by the encoder.
+	Offset int64 `json:"-"`
 }
 
-type LocalName struct {
-	name string
+// Custom returns the first custom section with the given name.
+// Lazily decoded custom sections which cannot be read are ignored.
+func (m *Module) Custom(name string) (CustomSection, bool) {
+	for _, sec := range m.Sections {
+		if lazy, ok := sec.(*LazySection); ok && lazy.id == UnknownID && lazy.name == name {
+			sec, _ = lazy.Decode()
+		}
+		if sec, ok := sec.(CustomSection); ok && sec.Name == name {
+			return sec, true
+		}
+	}
+	return CustomSection{}, false
 }
 
+// FunctionBody holds the local variables and the bytecode of a function.
+// BodySize and LocalCount are set by the decoder, and derived from the
+// content of the body by the encoder. The size of unchanged bodies is
+// encoded in as many bytes as in the decoded module.
 type FunctionBody struct {
-	BodySize   uint32       // size of function body to follow, in bytes // edvakf:varuint32
-	LocalCount varuint32    // number of local entries
-	Locals     []LocalEntry // local variables
-	Code       Code         // bytecode of the function
+	BodySize   uint32       `json:"body_size"`   // size of function body to follow, in bytes // edvakf:varuint32
+	LocalCount uint32       `json:"local_count"` // number of local entries // edvakf:varuint32
+	Locals     []LocalEntry `json:"locals"`      // local variables
+	Code       Code         `json:"code"`        // bytecode of the function
+
+	sizeWidth int // number of bytes of the decoded body size
 }
 
+// Code holds the bytecode of a function body.
+// The decoder does not decode its instructions: see Instrs and Validate.
 type Code struct {
-	Code []byte // bytecode of the function
-	End  byte   // 0x0b, indicating the end of the body
+	Code []byte `json:"code"` // bytecode of the function
+	End  byte   `json:"end"`  // 0x0b, indicating the end of the body
+
+	// Offset is the position of the bytecode within the decoded module.
+	// It is ignored by the encoder.
+	Offset int64 `json:"-"`
 }
 
+// LocalEntry declares Count local variables of the same type.
 type LocalEntry struct {
-	Count uint32    // number of local variables of the following type
-	Type  ValueType // type of the variables
+	Count uint32    `json:"count"` // number of local variables of the following type
+	Type  ValueType `json:"type"`  // type of the variables
 }
@@ -0,0 +1,146 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// IDs of the subsections of the "name" custom section.
+const (
+	ModuleNameID    byte = 0 // name of the module
+	FunctionNamesID byte = 1 // names of functions
+	LocalNamesID    byte = 2 // names of locals, per function
+	LabelNamesID    byte = 3 // names of labels, per function (extended name section)
+	TypeNamesID     byte = 4 // names of types (extended name section)
+	TableNamesID    byte = 5 // names of tables (extended name section)
+	MemoryNamesID   byte = 6 // names of memories (extended name section)
+	GlobalNamesID   byte = 7 // names of globals (extended name section)
+	ElemNamesID     byte = 8 // names of element segments (extended name section)
+	DataNamesID     byte = 9 // names of data segments (extended name section)
+)
+
+// NameSection is the "name" custom section, holding debug names of the
+// entities defined in a module.
+// Empty subsections are not encoded.
+type NameSection struct {
+	Module    string          `json:"module,omitempty"`
+	Functions NameMap         `json:"functions,omitempty"`
+	Locals    IndirectNameMap `json:"locals,omitempty"` // names of locals, per function index
+	Labels    IndirectNameMap `json:"labels,omitempty"` // names of labels, per function index
+	Types     NameMap         `json:"types,omitempty"`
+	Tables    NameMap         `json:"tables,omitempty"`
+	Memories  NameMap         `json:"memories,omitempty"`
+	Globals   NameMap         `json:"globals,omitempty"`
+	Elements  NameMap         `json:"elements,omitempty"`
+	Data      NameMap         `json:"data,omitempty"`
+
+	// Unknown holds the subsections not understood by this package,
+	// sorted by ID.
+	Unknown []NameSubsection `json:"unknown,omitempty"`
+}
+
+// NameSubsection is a subsection of the "name" section.
+type NameSubsection struct {
+	ID      byte   `json:"id"`
+	Payload []byte `json:"payload"`
+}
+
+// Naming associates a name to an index.
+type Naming struct {
+	Index uint32 `json:"index"`
+	Name  string `json:"name"`
+}
+
+// NameMap associates names to indices, sorted by increasing index.
+type NameMap []Naming
+
+// Name returns the name associated to idx.
+func (m NameMap) Name(idx uint32) (string, bool) {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Index >= idx })
+	if i < len(m) && m[i].Index == idx {
+		return m[i].Name, true
+	}
+	return "", false
+}
+
+// IndirectNaming associates a NameMap to an index.
+type IndirectNaming struct {
+	Index uint32  `json:"index"`
+	Names NameMap `json:"names"`
+}
+
+// IndirectNameMap associates name maps to indices, sorted by increasing index.
+type IndirectNameMap []IndirectNaming
+
+// Name returns the name associated to the sub-th entry of the idx-th map.
+func (m IndirectNameMap) Name(idx, sub uint32) (string, bool) {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Index >= idx })
+	if i < len(m) && m[i].Index == idx {
+		return m[i].Names.Name(sub)
+	}
+	return "", false
+}
+
+// FunctionName returns the name of the idx-th function.
+func (s NameSection) FunctionName(idx uint32) (string, bool) {
+	return s.Functions.Name(idx)
+}
+
+// LocalName returns the name of the local-th local of the fct-th function.
+func (s NameSection) LocalName(fct, local uint32) (string, bool) {
+	return s.Locals.Name(fct, local)
+}
+
+// NameSection decodes the payload of the "name" custom section.
+// Offsets reported in errors are positions within the decoded module,
+// starting at the Offset of the section.
+func (s CustomSection) NameSection() (NameSection, error) {
+	var ns NameSection
+	if s.Name != "name" {
+		return ns, fmt.Errorf("wasm: custom section %q is not a name section", s.Name)
+	}
+	c := &cursor{buf: s.Payload, base: s.Offset}
+	d := &decoder{r: c, cr: c, section: -1, entry: -1, errOff: -1}
+	d.section = int(UnknownID)
+	d.readNameSection(&limitedReader{r: d.r, n: int64(len(s.Payload))}, &ns)
+	if d.err == io.EOF {
+		d.err = io.ErrUnexpectedEOF
+	}
+	return ns, d.wrap()
+}
+
+// Names returns the content of the "name" section of the module, if any.
+// A malformed name section, like the ones of pre-release versions of the
+// binary format, is ignored: it is kept verbatim as a CustomSection, and
+// may be decoded with its NameSection method.
+// Names only returns an error if a section decoded lazily cannot be read.
+func (m *Module) Names() (NameSection, bool, error) {
+	for _, sec := range m.Sections {
+		if lazy, ok := sec.(*LazySection); ok && lazy.Name() == "name" {
+			var err error
+			sec, err = lazy.Decode()
+			if err != nil {
+				return NameSection{}, true, err
+			}
+		}
+		switch sec := sec.(type) {
+		case NameSection:
+			return sec, true, nil
+		case CustomSection:
+			if sec.Name != "name" {
+				continue
+			}
+			ns, err := sec.NameSection()
+			if err != nil {
+				return NameSection{}, false, nil
+			}
+			return ns, true, nil
+		}
+	}
+	return NameSection{}, false, nil
+}
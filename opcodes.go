@@ -4,7 +4,18 @@
 
 package wasm
 
+import "fmt"
+
 // Opcode is a wasm opcode.
+//
+// Besides the MVP instructions, the package supports the instructions of
+// the following proposals, merged in WebAssembly 2.0:
+//   - sign-extension operators (i32.extend8_s, ...);
+//   - non-trapping float-to-int conversions (i32.trunc_s:sat/f32, ...);
+//   - memory.copy and memory.fill, from the bulk memory operations.
+//
+// The last two are encoded as the Op_prefix_misc opcode, followed by a
+// MiscOpcode.
 type Opcode byte
 
 // Language types opcodes as defined by:
@@ -230,3 +241,249 @@ const (
 	Op_f32_reinterpret_i32        = 0xbe
 	Op_f64_reinterpret_i64        = 0xbf
 )
+
+// Sign-extension operators
+const (
+	Op_i32_extend8_s  Opcode = 0xc0
+	Op_i32_extend16_s        = 0xc1
+	Op_i64_extend8_s         = 0xc2
+	Op_i64_extend16_s        = 0xc3
+	Op_i64_extend32_s        = 0xc4
+)
+
+// Op_prefix_misc is the prefix byte of the non-trapping float-to-int
+// conversions and bulk memory operators, identified by the MiscOpcode
+// following it.
+const Op_prefix_misc Opcode = 0xfc
+
+func (op Opcode) String() string {
+	if name := opcodeNames[op]; name != "" {
+		return name
+	}
+	return fmt.Sprintf("Opcode(0x%02x)", byte(op))
+}
+
+// opcodeNames holds the text format name of every instruction opcode.
+var opcodeNames = [256]string{
+	Op_unreachable:         "unreachable",
+	Op_nop:                 "nop",
+	Op_block:               "block",
+	Op_loop:                "loop",
+	Op_if:                  "if",
+	Op_else:                "else",
+	Op_end:                 "end",
+	Op_br:                  "br",
+	Op_br_if:               "br_if",
+	Op_br_table:            "br_table",
+	Op_return:              "return",
+	Op_call:                "call",
+	Op_call_indirect:       "call_indirect",
+	Op_drop:                "drop",
+	Op_select:              "select",
+	Op_get_local:           "get_local",
+	Op_set_local:           "set_local",
+	Op_tee_local:           "tee_local",
+	Op_get_global:          "get_global",
+	Op_set_global:          "set_global",
+	Op_i32_load:            "i32.load",
+	Op_i64_load:            "i64.load",
+	Op_f32_load:            "f32.load",
+	Op_f64_load:            "f64.load",
+	Op_i32_load8_s:         "i32.load8_s",
+	Op_i32_load8_u:         "i32.load8_u",
+	Op_i32_load16_s:        "i32.load16_s",
+	Op_i32_load16_u:        "i32.load16_u",
+	Op_i64_load8_s:         "i64.load8_s",
+	Op_i64_load8_u:         "i64.load8_u",
+	Op_i64_load16_s:        "i64.load16_s",
+	Op_i64_load16_u:        "i64.load16_u",
+	Op_i64_load32_s:        "i64.load32_s",
+	Op_i64_load32_u:        "i64.load32_u",
+	Op_i32_store:           "i32.store",
+	Op_i64_store:           "i64.store",
+	Op_f32_store:           "f32.store",
+	Op_f64_store:           "f64.store",
+	Op_i32_store8:          "i32.store8",
+	Op_i32_store16:         "i32.store16",
+	Op_i64_store8:          "i64.store8",
+	Op_i64_store16:         "i64.store16",
+	Op_i64_store32:         "i64.store32",
+	Op_current_memory:      "current_memory",
+	Op_grow_memory:         "grow_memory",
+	Op_i32_const:           "i32.const",
+	Op_i64_const:           "i64.const",
+	Op_f32_const:           "f32.const",
+	Op_f64_const:           "f64.const",
+	Op_i32_eqz:             "i32.eqz",
+	Op_i32_eq:              "i32.eq",
+	Op_i32_ne:              "i32.ne",
+	Op_i32_lt_s:            "i32.lt_s",
+	Op_i32_lt_u:            "i32.lt_u",
+	Op_i32_gt_s:            "i32.gt_s",
+	Op_i32_gt_u:            "i32.gt_u",
+	Op_i32_le_s:            "i32.le_s",
+	Op_i32_le_u:            "i32.le_u",
+	Op_i32_ge_s:            "i32.ge_s",
+	Op_i32_ge_u:            "i32.ge_u",
+	Op_i64_eqz:             "i64.eqz",
+	Op_i64_eq:              "i64.eq",
+	Op_i64_ne:              "i64.ne",
+	Op_i64_lt_s:            "i64.lt_s",
+	Op_i64_lt_u:            "i64.lt_u",
+	Op_i64_gt_s:            "i64.gt_s",
+	Op_i64_gt_u:            "i64.gt_u",
+	Op_i64_le_s:            "i64.le_s",
+	Op_i64_le_u:            "i64.le_u",
+	Op_i64_ge_s:            "i64.ge_s",
+	Op_i64_ge_u:            "i64.ge_u",
+	Op_f32_eq:              "f32.eq",
+	Op_f32_ne:              "f32.ne",
+	Op_f32_lt:              "f32.lt",
+	Op_f32_gt:              "f32.gt",
+	Op_f32_le:              "f32.le",
+	Op_f32_ge:              "f32.ge",
+	Op_f64_eq:              "f64.eq",
+	Op_f64_ne:              "f64.ne",
+	Op_f64_lt:              "f64.lt",
+	Op_f64_gt:              "f64.gt",
+	Op_f64_le:              "f64.le",
+	Op_f64_ge:              "f64.ge",
+	Op_i32_clz:             "i32.clz",
+	Op_i32_ctz:             "i32.ctz",
+	Op_i32_popcnt:          "i32.popcnt",
+	Op_i32_add:             "i32.add",
+	Op_i32_sub:             "i32.sub",
+	Op_i32_mul:             "i32.mul",
+	Op_i32_div_s:           "i32.div_s",
+	Op_i32_div_u:           "i32.div_u",
+	Op_i32_rem_s:           "i32.rem_s",
+	Op_i32_rem_u:           "i32.rem_u",
+	Op_i32_and:             "i32.and",
+	Op_i32_or:              "i32.or",
+	Op_i32_xor:             "i32.xor",
+	Op_i32_shl:             "i32.shl",
+	Op_i32_shr_s:           "i32.shr_s",
+	Op_i32_shr_u:           "i32.shr_u",
+	Op_i32_rotl:            "i32.rotl",
+	Op_i32_rotr:            "i32.rotr",
+	Op_i64_clz:             "i64.clz",
+	Op_i64_ctz:             "i64.ctz",
+	Op_i64_popcnt:          "i64.popcnt",
+	Op_i64_add:             "i64.add",
+	Op_i64_sub:             "i64.sub",
+	Op_i64_mul:             "i64.mul",
+	Op_i64_div_s:           "i64.div_s",
+	Op_i64_div_u:           "i64.div_u",
+	Op_i64_rem_s:           "i64.rem_s",
+	Op_i64_rem_u:           "i64.rem_u",
+	Op_i64_and:             "i64.and",
+	Op_i64_or:              "i64.or",
+	Op_i64_xor:             "i64.xor",
+	Op_i64_shl:             "i64.shl",
+	Op_i64_shr_s:           "i64.shr_s",
+	Op_i64_shr_u:           "i64.shr_u",
+	Op_i64_rotl:            "i64.rotl",
+	Op_i64_rotr:            "i64.rotr",
+	Op_f32_abs:             "f32.abs",
+	Op_f32_neg:             "f32.neg",
+	Op_f32_ceil:            "f32.ceil",
+	Op_f32_floor:           "f32.floor",
+	Op_f32_trunc:           "f32.trunc",
+	Op_f32_nearest:         "f32.nearest",
+	Op_f32_sqrt:            "f32.sqrt",
+	Op_f32_add:             "f32.add",
+	Op_f32_sub:             "f32.sub",
+	Op_f32_mul:             "f32.mul",
+	Op_f32_div:             "f32.div",
+	Op_f32_min:             "f32.min",
+	Op_f32_max:             "f32.max",
+	Op_f32_copysign:        "f32.copysign",
+	Op_f64_abs:             "f64.abs",
+	Op_f64_neg:             "f64.neg",
+	Op_f64_ceil:            "f64.ceil",
+	Op_f64_floor:           "f64.floor",
+	Op_f64_trunc:           "f64.trunc",
+	Op_f64_nearest:         "f64.nearest",
+	Op_f64_sqrt:            "f64.sqrt",
+	Op_f64_add:             "f64.add",
+	Op_f64_sub:             "f64.sub",
+	Op_f64_mul:             "f64.mul",
+	Op_f64_div:             "f64.div",
+	Op_f64_min:             "f64.min",
+	Op_f64_max:             "f64.max",
+	Op_f64_copysign:        "f64.copysign",
+	Op_i32_wrap_i64:        "i32.wrap/i64",
+	Op_i32_trunc_s_f32:     "i32.trunc_s/f32",
+	Op_i32_trunc_u_f32:     "i32.trunc_u/f32",
+	Op_i32_trunc_s_f64:     "i32.trunc_s/f64",
+	Op_i32_trunc_u_f64:     "i32.trunc_u/f64",
+	Op_i64_extend_s_i32:    "i64.extend_s/i32",
+	Op_i64_extend_u_i32:    "i64.extend_u/i32",
+	Op_i64_trunc_s_f32:     "i64.trunc_s/f32",
+	Op_i64_trunc_u_f32:     "i64.trunc_u/f32",
+	Op_i64_trunc_s_f64:     "i64.trunc_s/f64",
+	Op_i64_trunc_u_f64:     "i64.trunc_u/f64",
+	Op_f32_convert_s_i32:   "f32.convert_s/i32",
+	Op_f32_convert_u_i32:   "f32.convert_u/i32",
+	Op_f32_convert_s_i64:   "f32.convert_s/i64",
+	Op_f32_convert_u_i64:   "f32.convert_u/i64",
+	Op_f32_demote_f64:      "f32.demote/f64",
+	Op_f64_convert_s_i32:   "f64.convert_s/i32",
+	Op_f64_convert_u_i32:   "f64.convert_u/i32",
+	Op_f64_convert_s_i64:   "f64.convert_s/i64",
+	Op_f64_convert_u_i64:   "f64.convert_u/i64",
+	Op_f64_promote_f32:     "f64.promote/f32",
+	Op_i32_reinterpret_f32: "i32.reinterpret/f32",
+	Op_i64_reinterpret_f64: "i64.reinterpret/f64",
+	Op_f32_reinterpret_i32: "f32.reinterpret/i32",
+	Op_f64_reinterpret_i64: "f64.reinterpret/i64",
+	Op_i32_extend8_s:       "i32.extend8_s",
+	Op_i32_extend16_s:      "i32.extend16_s",
+	Op_i64_extend8_s:       "i64.extend8_s",
+	Op_i64_extend16_s:      "i64.extend16_s",
+	Op_i64_extend32_s:      "i64.extend32_s",
+}
+
+// MiscOpcode is the opcode of an instruction following the Op_prefix_misc
+// prefix byte.
+type MiscOpcode byte
+
+// Non-trapping float-to-int conversions
+const (
+	Op_i32_trunc_sat_s_f32 MiscOpcode = 0x00
+	Op_i32_trunc_sat_u_f32 MiscOpcode = 0x01
+	Op_i32_trunc_sat_s_f64 MiscOpcode = 0x02
+	Op_i32_trunc_sat_u_f64 MiscOpcode = 0x03
+	Op_i64_trunc_sat_s_f32 MiscOpcode = 0x04
+	Op_i64_trunc_sat_u_f32 MiscOpcode = 0x05
+	Op_i64_trunc_sat_s_f64 MiscOpcode = 0x06
+	Op_i64_trunc_sat_u_f64 MiscOpcode = 0x07
+)
+
+// Bulk memory operators
+const (
+	Op_memory_copy MiscOpcode = 0x0a
+	Op_memory_fill MiscOpcode = 0x0b
+)
+
+func (op MiscOpcode) String() string {
+	if name := miscOpcodeNames[op]; name != "" {
+		return name
+	}
+	return fmt.Sprintf("MiscOpcode(0x%02x)", byte(op))
+}
+
+// miscOpcodeNames holds the text format name of every instruction
+// following the Op_prefix_misc prefix.
+var miscOpcodeNames = [256]string{
+	Op_i32_trunc_sat_s_f32: "i32.trunc_s:sat/f32",
+	Op_i32_trunc_sat_u_f32: "i32.trunc_u:sat/f32",
+	Op_i32_trunc_sat_s_f64: "i32.trunc_s:sat/f64",
+	Op_i32_trunc_sat_u_f64: "i32.trunc_u:sat/f64",
+	Op_i64_trunc_sat_s_f32: "i64.trunc_s:sat/f32",
+	Op_i64_trunc_sat_u_f32: "i64.trunc_u:sat/f32",
+	Op_i64_trunc_sat_s_f64: "i64.trunc_s:sat/f64",
+	Op_i64_trunc_sat_u_f64: "i64.trunc_u:sat/f64",
+	Op_memory_copy:         "memory.copy",
+	Op_memory_fill:         "memory.fill",
+}
@@ -0,0 +1,232 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// offsetReader is a reader of modules, which knows its position within the
+// module.
+type offsetReader interface {
+	io.Reader
+	offset() int64
+}
+
+// slicer is implemented by readers of modules held in memory, which return
+// the next bytes of the module without copying them.
+type slicer interface {
+	next(n int) ([]byte, error)
+}
+
+// next returns the next n bytes read from r, without copying them if r is
+// a slicer.
+// Otherwise, large buffers grow as they are read, so that a corrupted
+// length does not allocate more memory than r holds.
+func next(r io.Reader, n int) ([]byte, error) {
+	if s, ok := r.(slicer); ok {
+		return s.next(n)
+	}
+	if n <= maxPrealloc {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(r, int64(n)))
+	if err == nil && len(buf) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf, err
+}
+
+// maxPrealloc is the size of the largest buffer allocated before reading
+// its content.
+const maxPrealloc = 1 << 20
+
+// readByte reads a single byte from r.
+func readByte(r io.Reader) (byte, error) {
+	if br, ok := r.(io.ByteReader); ok {
+		return br.ReadByte()
+	}
+	var b [1]byte
+	_, err := io.ReadFull(r, b[:])
+	return b[0], err
+}
+
+// readAll reads all the bytes remaining in r, without copying them if r is
+// a slicer.
+func readAll(r io.Reader) ([]byte, error) {
+	if lr, ok := r.(*limitedReader); ok {
+		return lr.next(int(lr.n))
+	}
+	return ioutil.ReadAll(r)
+}
+
+// limitedReader reads at most n bytes from r.
+// Unlike io.LimitedReader, it reads single bytes, and slices modules
+// held in memory, as efficiently as r does.
+type limitedReader struct {
+	r io.Reader
+	n int64 // number of bytes remaining
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > lr.n {
+		p = p[:lr.n]
+	}
+	n, err := lr.r.Read(p)
+	lr.n -= int64(n)
+	return n, err
+}
+
+func (lr *limitedReader) ReadByte() (byte, error) {
+	if lr.n <= 0 {
+		return 0, io.EOF
+	}
+	b, err := readByte(lr.r)
+	if err == nil {
+		lr.n--
+	}
+	return b, err
+}
+
+func (lr *limitedReader) next(n int) ([]byte, error) {
+	if int64(n) > lr.n {
+		buf, err := next(lr.r, int(lr.n))
+		lr.n = 0
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf, err
+	}
+	buf, err := next(lr.r, n)
+	lr.n -= int64(len(buf))
+	return buf, err
+}
+
+// skip skips the next n bytes of lr.
+func (lr *limitedReader) skip(n int64) error {
+	short := n > lr.n
+	if short {
+		n = lr.n
+	}
+	lr.n -= n
+	err := skip(lr.r, n)
+	if err == nil && short {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// skip skips the next n bytes read from r, without reading them if r is a
+// cursor.
+func skip(r io.Reader, n int64) error {
+	if c, ok := r.(*cursor); ok {
+		return c.skip(n)
+	}
+	m, err := io.CopyN(ioutil.Discard, r, n)
+	if m < n && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// cursor reads a module held in memory.
+// If ra is not nil, the module is read from ra, and buf only holds the part
+// of the module being decoded, starting at offset base.
+type cursor struct {
+	buf  []byte
+	pos  int   // position of the cursor within buf
+	base int64 // offset of buf within the module
+
+	ra   io.ReaderAt
+	size int64 // size of the module read from ra
+}
+
+// chunk is the minimum number of bytes read at once from an io.ReaderAt.
+const chunk = 64
+
+func (c *cursor) offset() int64 {
+	return c.base + int64(c.pos)
+}
+
+// fill makes sure the next n bytes of the module, if any, are held in
+// memory.
+func (c *cursor) fill(n int) error {
+	if c.ra == nil || len(c.buf)-c.pos >= n {
+		return nil
+	}
+	off := c.offset()
+	if rem := c.size - off; int64(n) > rem {
+		n = int(rem)
+	}
+	buf := make([]byte, n)
+	m, err := c.ra.ReadAt(buf, off)
+	if m == n {
+		err = nil
+	}
+	c.buf, c.pos, c.base = buf[:m], 0, off
+	return err
+}
+
+func (c *cursor) Read(p []byte) (int, error) {
+	if err := c.fill(len(p)); err != nil {
+		return 0, err
+	}
+	if c.pos == len(c.buf) {
+		return 0, io.EOF
+	}
+	n := copy(p, c.buf[c.pos:])
+	c.pos += n
+	return n, nil
+}
+
+func (c *cursor) ReadByte() (byte, error) {
+	if c.pos == len(c.buf) {
+		if err := c.fill(chunk); err != nil {
+			return 0, err
+		}
+		if c.pos == len(c.buf) {
+			return 0, io.EOF
+		}
+	}
+	b := c.buf[c.pos]
+	c.pos++
+	return b, nil
+}
+
+func (c *cursor) next(n int) ([]byte, error) {
+	if err := c.fill(n); err != nil {
+		return nil, err
+	}
+	if rem := len(c.buf) - c.pos; n > rem {
+		c.pos = len(c.buf)
+		if rem == 0 {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := c.buf[c.pos : c.pos+n : c.pos+n]
+	c.pos += n
+	return buf, nil
+}
+
+func (c *cursor) skip(n int64) error {
+	if rem := int64(len(c.buf) - c.pos); n <= rem {
+		c.pos += int(n)
+		return nil
+	}
+	off := c.offset()
+	if c.ra == nil || n > c.size-off {
+		c.pos = len(c.buf)
+		return io.ErrUnexpectedEOF
+	}
+	c.buf, c.pos, c.base = nil, 0, off+n
+	return nil
+}
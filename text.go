@@ -0,0 +1,748 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TextOptions controls how modules are rendered in, and parsed from, the
+// text format.
+type TextOptions struct {
+	Folded        bool // write function bodies as folded S-expressions
+	InlineExports bool // write exports within the definition of the exported entities
+	DebugNames    bool // record the identifiers of parsed modules in a "name" section
+}
+
+// WriteText writes m to w in the WebAssembly text format, with flat
+// instructions and explicit exports.
+func WriteText(w io.Writer, m *Module) error {
+	return TextOptions{}.WriteText(w, m)
+}
+
+// WriteText writes m to w in the WebAssembly text format.
+// Entities are named after the content of the "name" section of m, if any.
+func (opts TextOptions) WriteText(w io.Writer, m *Module) error {
+	tw := newTextWriter(w, m, opts)
+	tw.writeModule()
+	return tw.w.Flush()
+}
+
+type textWriter struct {
+	w    *bufio.Writer
+	idx  *moduleIndex
+	opts TextOptions
+
+	names    NameSection
+	module   string
+	types    []string
+	funcs    []string
+	tables   []string
+	memories []string
+	globals  []string
+	exports  [4]map[uint32][]string // inline exports, per kind and index
+
+	// state of the function being written.
+	fct    uint32
+	locals []string
+	labels []string // names of the enclosing labels, innermost last
+	nlabel uint32   // number of labels defined so far
+	arity  []int    // arities of the enclosing labels, innermost last
+	indent int
+}
+
+func newTextWriter(w io.Writer, m *Module, opts TextOptions) *textWriter {
+	idx := newModuleIndex(m)
+	tw := &textWriter{
+		w:    bufio.NewWriter(w),
+		idx:  idx,
+		opts: opts,
+	}
+	if ns, ok, err := m.Names(); ok && err == nil {
+		tw.names = ns
+	}
+	tw.module = textName(tw.names.Module)
+	tw.types = textNames(tw.names.Types, len(idx.types))
+	tw.funcs = textNames(tw.names.Functions, len(idx.funcs))
+	tw.tables = textNames(tw.names.Tables, len(idx.tables))
+	tw.memories = textNames(tw.names.Memories, len(idx.memories))
+	tw.globals = textNames(tw.names.Globals, len(idx.globals))
+
+	if opts.InlineExports {
+		for _, exp := range idx.exports {
+			if exp.Kind > GlobalKind {
+				continue
+			}
+			if tw.exports[exp.Kind] == nil {
+				tw.exports[exp.Kind] = make(map[uint32][]string)
+			}
+			tw.exports[exp.Kind][exp.Index] = append(tw.exports[exp.Kind][exp.Index], exp.Field)
+		}
+	}
+	return tw
+}
+
+// textNames returns the identifiers of the n entities of an index space.
+// Entities without a valid and unique name are given an empty identifier.
+func textNames(names NameMap, n int) []string {
+	ids := make([]string, n)
+	seen := make(map[string]bool, len(names))
+	for _, na := range names {
+		if int(na.Index) >= n {
+			continue
+		}
+		id := textName(na.Name)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids[na.Index] = id
+	}
+	return ids
+}
+
+// textName returns the text format identifier of a name, or an empty string.
+// Characters not allowed in identifiers are replaced with underscores.
+func textName(name string) string {
+	if name == "" {
+		return ""
+	}
+	id := []byte(name)
+	for i, c := range id {
+		if !isIDChar(c) {
+			id[i] = '_'
+		}
+	}
+	return "$" + string(id)
+}
+
+func isIDChar(c byte) bool {
+	switch {
+	case '0' <= c && c <= '9', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-./:<=>?@\\^_`|~", c) >= 0
+}
+
+// ref returns the reference to the i-th entity of an index space.
+func ref(ids []string, i uint32) string {
+	if int(i) < len(ids) && ids[i] != "" {
+		return ids[i]
+	}
+	return strconv.FormatUint(uint64(i), 10)
+}
+
+// decl returns the identifier, or index comment, of the i-th entity of an
+// index space, for its declaration.
+func decl(ids []string, i int) string {
+	if i < len(ids) && ids[i] != "" {
+		return ids[i]
+	}
+	return fmt.Sprintf("(;%d;)", i)
+}
+
+// quote returns s as a text format string.
+func quote(s []byte) string {
+	const hex = "0123456789abcdef"
+	o := make([]byte, 0, len(s)+2)
+	o = append(o, '"')
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			o = append(o, '\\', c)
+		case 0x20 <= c && c < 0x7f:
+			o = append(o, c)
+		default:
+			o = append(o, '\\', hex[c>>4], hex[c&0xf])
+		}
+	}
+	return string(append(o, '"'))
+}
+
+// floatText returns the text format of a floating point value of the given
+// bit size, holding the IEEE 754 representation bits.
+func floatText(bits uint64, size int) string {
+	var (
+		f         float64
+		sign      bool
+		payload   uint64
+		canonical uint64
+	)
+	switch size {
+	case 32:
+		f32 := math.Float32frombits(uint32(bits))
+		f = float64(f32)
+		sign = bits&(1<<31) != 0
+		payload = bits & (1<<23 - 1)
+		canonical = 1 << 22
+	default:
+		f = math.Float64frombits(bits)
+		sign = bits&(1<<63) != 0
+		payload = bits & (1<<52 - 1)
+		canonical = 1 << 51
+	}
+	var str string
+	switch {
+	case math.IsNaN(f):
+		str = "nan"
+		if payload != canonical {
+			str += fmt.Sprintf(":0x%x", payload)
+		}
+		if sign {
+			str = "-" + str
+		}
+	case math.IsInf(f, 1):
+		str = "inf"
+	case math.IsInf(f, -1):
+		str = "-inf"
+	default:
+		str = strconv.FormatFloat(f, 'g', -1, size)
+	}
+	return str
+}
+
+func (tw *textWriter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(tw.w, format, args...)
+}
+
+func (tw *textWriter) newline() {
+	tw.w.WriteByte('\n')
+	for i := 0; i < tw.indent; i++ {
+		tw.w.WriteString("  ")
+	}
+}
+
+// writeExports writes the inline exports of an entity.
+func (tw *textWriter) writeExports(kind ExternalKind, i uint32) {
+	for _, field := range tw.exports[kind][i] {
+		tw.printf(" (export %s)", quote([]byte(field)))
+	}
+}
+
+func (tw *textWriter) writeModule() {
+	idx := tw.idx
+	tw.printf("(module")
+	if tw.module != "" {
+		tw.printf(" %s", tw.module)
+	}
+	tw.indent++
+
+	for i, ft := range idx.types {
+		tw.newline()
+		tw.printf("(type %s (func", decl(tw.types, i))
+		tw.writeSignature(ft, nil)
+		tw.printf("))")
+	}
+
+	var nimports [4]int
+	for _, imp := range idx.imports {
+		if imp.Kind > GlobalKind {
+			continue
+		}
+		i := nimports[imp.Kind]
+		nimports[imp.Kind]++
+		tw.newline()
+		tw.printf("(import %s %s ", quote([]byte(imp.Module)), quote([]byte(imp.Field)))
+		switch imp.Kind {
+		case FunctionKind:
+			tw.printf("(func %s (type %s)", decl(tw.funcs, i), ref(tw.types, imp.TypeIndex))
+			if ft, ok := idx.funcType(uint32(i)); ok {
+				tw.writeSignature(ft, nil)
+			}
+		case TableKind:
+			tw.printf("(table %s %s", decl(tw.tables, i), tableText(imp.Table))
+		case MemoryKind:
+			tw.printf("(memory %s %s", decl(tw.memories, i), limitsText(imp.Memory.Limits))
+		case GlobalKind:
+			tw.printf("(global %s %s", decl(tw.globals, i), globalTypeText(imp.Global))
+		}
+		tw.printf("))")
+	}
+
+	for i, body := range idx.bodies {
+		tw.writeFunc(uint32(idx.nfuncs+i), body)
+	}
+
+	for i := nimports[TableKind]; i < len(idx.tables); i++ {
+		tw.newline()
+		tw.printf("(table %s", decl(tw.tables, i))
+		tw.writeExports(TableKind, uint32(i))
+		tw.printf(" %s)", tableText(idx.tables[i]))
+	}
+
+	for i := nimports[MemoryKind]; i < len(idx.memories); i++ {
+		tw.newline()
+		tw.printf("(memory %s", decl(tw.memories, i))
+		tw.writeExports(MemoryKind, uint32(i))
+		tw.printf(" %s)", limitsText(idx.memories[i].Limits))
+	}
+
+	for i, init := range idx.globalInits {
+		gidx := idx.nglobals + i
+		tw.newline()
+		tw.printf("(global %s", decl(tw.globals, gidx))
+		tw.writeExports(GlobalKind, uint32(gidx))
+		tw.printf(" %s ", globalTypeText(idx.globals[gidx]))
+		tw.writeInitExpr(init)
+		tw.printf(")")
+	}
+
+	for _, exp := range idx.exports {
+		if exp.Kind <= GlobalKind && tw.opts.InlineExports && !tw.isImported(exp.Kind, exp.Index) {
+			continue
+		}
+		var ids []string
+		switch exp.Kind {
+		case FunctionKind:
+			ids = tw.funcs
+		case TableKind:
+			ids = tw.tables
+		case MemoryKind:
+			ids = tw.memories
+		case GlobalKind:
+			ids = tw.globals
+		}
+		tw.newline()
+		tw.printf("(export %s (%v %s))", quote([]byte(exp.Field)), exp.Kind, ref(ids, exp.Index))
+	}
+
+	if idx.start != nil {
+		tw.newline()
+		tw.printf("(start %s)", ref(tw.funcs, idx.start.Index))
+	}
+
+	for i, seg := range idx.elements {
+		tw.newline()
+		tw.printf("(elem (;%d;)", i)
+		if seg.Index != 0 {
+			tw.printf(" %s", ref(tw.tables, seg.Index))
+		}
+		tw.printf(" ")
+		tw.writeInitExpr(seg.Offset)
+		for _, f := range seg.Elems {
+			tw.printf(" %s", ref(tw.funcs, f))
+		}
+		tw.printf(")")
+	}
+
+	for i, seg := range idx.data {
+		tw.newline()
+		tw.printf("(data (;%d;)", i)
+		if seg.Index != 0 {
+			tw.printf(" %s", ref(tw.memories, seg.Index))
+		}
+		tw.printf(" ")
+		tw.writeInitExpr(seg.Offset)
+		tw.printf(" %s)", quote(seg.Data))
+	}
+
+	tw.indent--
+	tw.printf(")\n")
+}
+
+// isImported returns whether the i-th entity of the given kind is imported.
+func (tw *textWriter) isImported(kind ExternalKind, i uint32) bool {
+	var n int
+	for _, imp := range tw.idx.imports {
+		if imp.Kind == kind {
+			n++
+		}
+	}
+	return int(i) < n
+}
+
+// writeSignature writes the parameters and results of a function type.
+// Parameters are named after ids, if any.
+func (tw *textWriter) writeSignature(ft FuncType, ids []string) {
+	tw.printf("%s%s", valueTypesText("param", ft.Params, ids), valueTypesText("result", ft.Results, nil))
+}
+
+// valueTypesText returns the text of a list of parameters, results or
+// locals, each clause being preceded by a space.
+// Named entries are written in their own clause, and consecutive unnamed
+// entries are grouped.
+func valueTypesText(kind string, types []ValueType, ids []string) string {
+	var (
+		str  strings.Builder
+		open = false
+	)
+	for i, t := range types {
+		id := ""
+		if i < len(ids) {
+			id = ids[i]
+		}
+		switch {
+		case id != "":
+			if open {
+				str.WriteString(")")
+				open = false
+			}
+			fmt.Fprintf(&str, " (%s %s %v)", kind, id, t)
+		case open:
+			fmt.Fprintf(&str, " %v", t)
+		default:
+			fmt.Fprintf(&str, " (%s %v", kind, t)
+			open = true
+		}
+	}
+	if open {
+		str.WriteString(")")
+	}
+	return str.String()
+}
+
+func limitsText(l ResizableLimits) string {
+	if l.Flags&0x1 != 0 {
+		return fmt.Sprintf("%d %d", l.Initial, l.Maximum)
+	}
+	return strconv.FormatUint(uint64(l.Initial), 10)
+}
+
+func tableText(t TableType) string {
+	return limitsText(t.Limits) + " " + t.ElemType.String()
+}
+
+func globalTypeText(g GlobalType) string {
+	if g.Mutability != 0 {
+		return "(mut " + g.ContentType.String() + ")"
+	}
+	return g.ContentType.String()
+}
+
+func (tw *textWriter) writeInitExpr(expr InitExpr) {
+	instrs, err := expr.Instrs()
+	if err != nil {
+		tw.printf("(;%v;)", err)
+		return
+	}
+	for i, ins := range instrs[:len(instrs)-1] {
+		if i > 0 {
+			tw.printf(" ")
+		}
+		tw.printf("(%s)", tw.instrText(ins))
+	}
+}
+
+func (tw *textWriter) writeFunc(fct uint32, body FunctionBody) {
+	idx := tw.idx
+	ft, _ := idx.funcType(fct)
+
+	var locals []ValueType
+	locals = append(locals, ft.Params...)
+	for _, l := range body.Locals {
+		if len(locals)+int(l.Count) > maxLocals {
+			break
+		}
+		for i := uint32(0); i < l.Count; i++ {
+			locals = append(locals, l.Type)
+		}
+	}
+	var names NameMap
+	for _, fn := range tw.names.Locals {
+		if fn.Index == fct {
+			names = fn.Names
+			break
+		}
+	}
+
+	tw.fct = fct
+	tw.locals = textNames(names, len(locals))
+	tw.labels = tw.labels[:0]
+	tw.arity = append(tw.arity[:0], len(ft.Results))
+	tw.nlabel = 0
+
+	tw.newline()
+	tw.printf("(func %s", decl(tw.funcs, int(fct)))
+	tw.writeExports(FunctionKind, fct)
+	if int(fct) < len(idx.funcs) {
+		tw.printf(" (type %s)", ref(tw.types, idx.funcs[fct]))
+	}
+	tw.writeSignature(ft, tw.locals)
+	tw.indent++
+	if len(locals) > len(ft.Params) {
+		tw.newline()
+		tw.printf("%s", strings.TrimPrefix(valueTypesText("local", locals[len(ft.Params):], tw.locals[len(ft.Params):]), " "))
+	}
+
+	instrs, err := body.Code.Instrs()
+	switch {
+	case err != nil:
+		tw.newline()
+		tw.printf("(; %v ;)", err)
+	case tw.opts.Folded:
+		pos := 0
+		seq, _ := tw.fold(instrs, &pos)
+		tw.writeNodes(seq)
+	default:
+		tw.writeFlat(instrs[:len(instrs)-1])
+	}
+	tw.indent--
+	tw.printf(")")
+}
+
+// labelName returns the identifier of the next label of the function.
+func (tw *textWriter) labelName() string {
+	name, _ := tw.names.Labels.Name(tw.fct, tw.nlabel)
+	tw.nlabel++
+	return textName(name)
+}
+
+// label returns the reference to the label at depth l.
+func (tw *textWriter) label(l uint32) string {
+	if int(l) < len(tw.labels) {
+		if id := tw.labels[len(tw.labels)-1-int(l)]; id != "" {
+			return id
+		}
+	}
+	return strconv.FormatUint(uint64(l), 10)
+}
+
+// blockText returns the text of the immediates of a block instruction.
+func blockText(label string, bt BlockType) string {
+	str := ""
+	if label != "" {
+		str += " " + label
+	}
+	if bt != BlockTypeEmpty {
+		str += " (result " + ValueType(bt).String() + ")"
+	}
+	return str
+}
+
+// instrText returns the text of a plain instruction, with symbolic
+// references.
+func (tw *textWriter) instrText(ins Instr) string {
+	name := ins.Name()
+	switch ins.Op {
+	case Op_br, Op_br_if:
+		return name + " " + tw.label(ins.Index)
+	case Op_br_table:
+		str := name
+		for _, l := range ins.Targets {
+			str += " " + tw.label(l)
+		}
+		return str + " " + tw.label(ins.Default)
+	case Op_call:
+		return name + " " + ref(tw.funcs, ins.Index)
+	case Op_call_indirect:
+		return name + " (type " + ref(tw.types, ins.Index) + ")"
+	case Op_get_local, Op_set_local, Op_tee_local:
+		return name + " " + ref(tw.locals, ins.Index)
+	case Op_get_global, Op_set_global:
+		return name + " " + ref(tw.globals, ins.Index)
+	case Op_f32_const:
+		return name + " " + floatText(ins.Value, 32)
+	case Op_f64_const:
+		return name + " " + floatText(ins.Value, 64)
+	}
+	return ins.String()
+}
+
+// writeFlat writes a sequence of instructions, one per line.
+func (tw *textWriter) writeFlat(instrs []Instr) {
+	for _, ins := range instrs {
+		switch ins.Op {
+		case Op_block, Op_loop, Op_if:
+			label := tw.labelName()
+			tw.newline()
+			tw.printf("%v%s", ins.Op, blockText(label, ins.Block))
+			tw.labels = append(tw.labels, label)
+			tw.indent++
+		case Op_else:
+			tw.indent--
+			tw.newline()
+			tw.printf("else")
+			tw.indent++
+		case Op_end:
+			tw.indent--
+			tw.newline()
+			tw.printf("end")
+			if len(tw.labels) > 0 {
+				tw.labels = tw.labels[:len(tw.labels)-1]
+			}
+		default:
+			tw.newline()
+			tw.printf("%s", tw.instrText(ins))
+		}
+	}
+}
+
+// node is a folded instruction.
+type node struct {
+	ins   Instr
+	label string
+	arity int     // number of values pushed on the operand stack
+	args  []*node // folded operands
+	body  []*node // instructions of a block, a loop or the then branch of an if
+	els   []*node // instructions of the else branch of an if
+	alt   bool    // whether an if has an else branch
+}
+
+// fold folds instructions up to the next else or end instruction, and
+// returns the folded instructions with the terminating opcode.
+func (tw *textWriter) fold(instrs []Instr, pos *int) ([]*node, Opcode) {
+	var seq []*node
+	for *pos < len(instrs) {
+		ins := instrs[*pos]
+		*pos++
+		n := &node{ins: ins}
+		switch ins.Op {
+		case Op_else, Op_end:
+			return seq, ins.Op
+
+		case Op_block, Op_loop, Op_if:
+			n.label = tw.labelName()
+			n.arity = len(blockResults(ins.Block))
+			if ins.Op == Op_if {
+				n.args = popNodes(&seq, 1)
+			}
+			arity := n.arity
+			if ins.Op == Op_loop {
+				arity = 0
+			}
+			tw.arity = append(tw.arity, arity)
+			var term Opcode
+			n.body, term = tw.fold(instrs, pos)
+			if term == Op_else {
+				n.alt = true
+				n.els, _ = tw.fold(instrs, pos)
+			}
+			tw.arity = tw.arity[:len(tw.arity)-1]
+
+		default:
+			nin, nout := tw.stackEffect(ins)
+			n.args = popNodes(&seq, nin)
+			n.arity = nout
+		}
+		seq = append(seq, n)
+	}
+	return seq, Op_end
+}
+
+// popNodes removes and returns the nodes computing the n topmost operands,
+// stopping at the first node not pushing exactly one value.
+func popNodes(seq *[]*node, n int) []*node {
+	i := len(*seq)
+	for i > 0 && len(*seq)-i < n && (*seq)[i-1].arity == 1 {
+		i--
+	}
+	args := append([]*node(nil), (*seq)[i:]...)
+	*seq = (*seq)[:i]
+	return args
+}
+
+// stackEffect returns the number of operands and results of a plain
+// instruction.
+func (tw *textWriter) stackEffect(ins Instr) (int, int) {
+	idx := tw.idx
+	labelArity := func(l uint32) int {
+		if int(l) < len(tw.arity) {
+			return tw.arity[len(tw.arity)-1-int(l)]
+		}
+		return 0
+	}
+	switch ins.Op {
+	case Op_unreachable, Op_nop:
+		return 0, 0
+	case Op_br:
+		return labelArity(ins.Index), 0
+	case Op_br_if:
+		n := labelArity(ins.Index)
+		return n + 1, n
+	case Op_br_table:
+		return labelArity(ins.Default) + 1, 0
+	case Op_return:
+		return tw.arity[0], 0
+	case Op_call:
+		ft, _ := idx.funcType(ins.Index)
+		return len(ft.Params), len(ft.Results)
+	case Op_call_indirect:
+		if int(ins.Index) < len(idx.types) {
+			ft := idx.types[ins.Index]
+			return len(ft.Params) + 1, len(ft.Results)
+		}
+		return 1, 0
+	case Op_drop:
+		return 1, 0
+	case Op_select:
+		return 3, 1
+	case Op_get_local, Op_get_global:
+		return 0, 1
+	case Op_set_local, Op_set_global:
+		return 1, 0
+	case Op_tee_local:
+		return 1, 1
+	case Op_current_memory:
+		return 0, 1
+	case Op_grow_memory:
+		return 1, 1
+	case Op_i32_const, Op_i64_const, Op_f32_const, Op_f64_const:
+		return 0, 1
+	}
+	params, results, err := Signature(ins.Op)
+	if ins.Op == Op_prefix_misc {
+		params, results, err = MiscSignature(ins.Misc)
+	}
+	if err != nil {
+		return 0, 0
+	}
+	return len(params), len(results)
+}
+
+// writeNodes writes a sequence of folded instructions, one per line.
+func (tw *textWriter) writeNodes(seq []*node) {
+	for _, n := range seq {
+		tw.newline()
+		tw.writeNode(n)
+	}
+}
+
+func (tw *textWriter) writeNode(n *node) {
+	switch n.ins.Op {
+	case Op_block, Op_loop:
+		tw.printf("(%v%s", n.ins.Op, blockText(n.label, n.ins.Block))
+		tw.labels = append(tw.labels, n.label)
+		tw.indent++
+		tw.writeNodes(n.body)
+		tw.indent--
+		tw.labels = tw.labels[:len(tw.labels)-1]
+		tw.printf(")")
+
+	case Op_if:
+		tw.printf("(if%s", blockText(n.label, n.ins.Block))
+		tw.indent++
+		tw.writeNodes(n.args)
+		tw.labels = append(tw.labels, n.label)
+		tw.newline()
+		tw.printf("(then")
+		tw.indent++
+		tw.writeNodes(n.body)
+		tw.indent--
+		tw.printf(")")
+		if n.alt {
+			tw.newline()
+			tw.printf("(else")
+			tw.indent++
+			tw.writeNodes(n.els)
+			tw.indent--
+			tw.printf(")")
+		}
+		tw.labels = tw.labels[:len(tw.labels)-1]
+		tw.indent--
+		tw.printf(")")
+
+	default:
+		tw.printf("(%s", tw.instrText(n.ins))
+		tw.indent++
+		tw.writeNodes(n.args)
+		tw.indent--
+		tw.printf(")")
+	}
+}
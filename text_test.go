@@ -0,0 +1,200 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+func TestWriteText(t *testing.T) {
+	// the full module of TestBinaries, with its "name" section.
+	raw := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x08, 0x02, 0x60, 0x01, 0x7f, 0x00, 0x60,
+		0x00, 0x00, 0x02, 0x1f, 0x03, 0x03, 0x65, 0x6e,
+		0x76, 0x03, 0x6c, 0x6f, 0x67, 0x00, 0x00, 0x03,
+		0x65, 0x6e, 0x76, 0x03, 0x6d, 0x65, 0x6d, 0x02,
+		0x00, 0x01, 0x03, 0x65, 0x6e, 0x76, 0x01, 0x67,
+		0x03, 0x7f, 0x00, 0x03, 0x02, 0x01, 0x01, 0x04,
+		0x05, 0x01, 0x70, 0x01, 0x01, 0x02, 0x06, 0x12,
+		0x02, 0x7f, 0x01, 0x41, 0x0b, 0x0b, 0x7c, 0x00,
+		0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0,
+		0x3f, 0x0b, 0x07, 0x0e, 0x02, 0x04, 0x6d, 0x61,
+		0x69, 0x6e, 0x00, 0x01, 0x03, 0x74, 0x62, 0x6c,
+		0x01, 0x00, 0x08, 0x01, 0x01, 0x09, 0x07, 0x01,
+		0x00, 0x41, 0x00, 0x0b, 0x01, 0x01, 0x0a, 0x0e,
+		0x01, 0x0c, 0x01, 0x02, 0x7f, 0x02, 0x40, 0x01,
+		0x0b, 0x41, 0x0b, 0x10, 0x00, 0x0b, 0x0b, 0x0b,
+		0x01, 0x00, 0x41, 0x08, 0x0b, 0x05, 0x68, 0x65,
+		0x6c, 0x6c, 0x6f, 0x00, 0x16, 0x04, 0x6e, 0x61,
+		0x6d, 0x65, 0x01, 0x07, 0x01, 0x01, 0x04, 0x6d,
+		0x61, 0x69, 0x6e, 0x02, 0x06, 0x01, 0x01, 0x01,
+		0x00, 0x01, 0x78,
+	}
+	mod, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts wasm.TextOptions
+		want string
+	}{
+		{
+			name: "flat",
+			want: `(module
+  (type (;0;) (func (param i32)))
+  (type (;1;) (func))
+  (import "env" "log" (func (;0;) (type 0) (param i32)))
+  (import "env" "mem" (memory (;0;) 1))
+  (import "env" "g" (global (;0;) i32))
+  (func $main (type 1)
+    (local $x i32) (local i32)
+    block
+      nop
+    end
+    i32.const 11
+    call 0)
+  (table (;0;) 1 2 anyfunc)
+  (global (;1;) (mut i32) (i32.const 11))
+  (global (;2;) f64 (f64.const 1))
+  (export "main" (func $main))
+  (export "tbl" (table 0))
+  (start $main)
+  (elem (;0;) (i32.const 0) $main)
+  (data (;0;) (i32.const 8) "hello"))
+`,
+		},
+		{
+			name: "folded",
+			opts: wasm.TextOptions{Folded: true, InlineExports: true},
+			want: `(module
+  (type (;0;) (func (param i32)))
+  (type (;1;) (func))
+  (import "env" "log" (func (;0;) (type 0) (param i32)))
+  (import "env" "mem" (memory (;0;) 1))
+  (import "env" "g" (global (;0;) i32))
+  (func $main (export "main") (type 1)
+    (local $x i32) (local i32)
+    (block
+      (nop))
+    (call 0
+      (i32.const 11)))
+  (table (;0;) (export "tbl") 1 2 anyfunc)
+  (global (;1;) (mut i32) (i32.const 11))
+  (global (;2;) f64 (f64.const 1))
+  (start $main)
+  (elem (;0;) (i32.const 0) $main)
+  (data (;0;) (i32.const 8) "hello"))
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := new(bytes.Buffer)
+			err := tc.opts.WriteText(w, mod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.String(); got != tc.want {
+				t.Fatalf("invalid text:\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteTextInstrs(t *testing.T) {
+	// (func $f (param $p i32) (result f64) ...)
+	mod := wasm.NewModule()
+	mod.Sections = []wasm.Section{
+		wasm.TypeSection{Types: []wasm.FuncType{{
+			Form:    wasm.Op_func,
+			Params:  []wasm.ValueType{wasm.I32},
+			Results: []wasm.ValueType{wasm.F64},
+		}}},
+		wasm.FunctionSection{Types: []uint32{0}},
+		wasm.CodeSection{Bodies: []wasm.FunctionBody{{Code: wasm.Code{
+			Code: []byte{
+				0x02, 0x40, // block
+				0x20, 0x00, // get_local 0
+				0x0d, 0x00, // br_if 0
+				0x43, 0x00, 0x00, 0x80, 0x7f, // f32.const inf
+				0x1a,       // drop
+				0x0b,       // end
+				0x20, 0x00, // get_local 0
+				0x04, 0x7c, // if f64
+				0x44, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0xff, // f64.const -nan:0x8000000000001
+				0x05,                                                 // else
+				0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, // f64.const -0
+				0x0b, // end
+			},
+			End: 0x0b,
+		}}}},
+		wasm.NameSection{
+			Functions: wasm.NameMap{{Index: 0, Name: "f"}},
+			Locals:    wasm.IndirectNameMap{{Index: 0, Names: wasm.NameMap{{Index: 0, Name: "p"}}}},
+			Labels:    wasm.IndirectNameMap{{Index: 0, Names: wasm.NameMap{{Index: 0, Name: "exit loop"}}}},
+		},
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts wasm.TextOptions
+		want string
+	}{
+		{
+			name: "flat",
+			want: `(module
+  (type (;0;) (func (param i32) (result f64)))
+  (func $f (type 0) (param $p i32) (result f64)
+    block $exit_loop
+      get_local $p
+      br_if $exit_loop
+      f32.const inf
+      drop
+    end
+    get_local $p
+    if (result f64)
+      f64.const -nan:0x8000000000001
+    else
+      f64.const -0
+    end))
+`,
+		},
+		{
+			name: "folded",
+			opts: wasm.TextOptions{Folded: true},
+			want: `(module
+  (type (;0;) (func (param i32) (result f64)))
+  (func $f (type 0) (param $p i32) (result f64)
+    (block $exit_loop
+      (br_if $exit_loop
+        (get_local $p))
+      (drop
+        (f32.const inf)))
+    (if (result f64)
+      (get_local $p)
+      (then
+        (f64.const -nan:0x8000000000001))
+      (else
+        (f64.const -0)))))
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := new(bytes.Buffer)
+			err := tc.opts.WriteText(w, mod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.String(); got != tc.want {
+				t.Fatalf("invalid text:\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokLParen           // (
+	tokRParen           // )
+	tokAtom             // keyword, number or reserved word
+	tokID               // identifier, starting with '$'
+	tokString           // string literal
+)
+
+// token is a lexical token of the text format.
+type token struct {
+	kind tokenKind
+	text string // text of the token, or decoded content of a string literal
+	line int
+	col  int
+}
+
+// sexpr is an atom, or a parenthesized list of S-expressions.
+type sexpr struct {
+	tok    token // the atom, or the opening parenthesis of a list
+	list   []*sexpr
+	isList bool
+}
+
+// keyword returns the keyword at the head of a list, or the keyword of an
+// atom, or an empty string.
+func (s *sexpr) keyword() string {
+	tok := s.tok
+	if s.isList {
+		if len(s.list) == 0 {
+			return ""
+		}
+		tok = s.list[0].tok
+	}
+	if tok.kind != tokAtom {
+		return ""
+	}
+	return tok.text
+}
+
+// is returns whether s is a list starting with the given keyword.
+func (s *sexpr) is(kw string) bool {
+	return s.isList && s.keyword() == kw
+}
+
+// lexer splits a module in the text format into tokens.
+type lexer struct {
+	src  []byte
+	pos  int
+	line int
+	col  int
+}
+
+func newLexer(src []byte) *lexer {
+	return &lexer{src: src, line: 1, col: 1}
+}
+
+func (lx *lexer) errorf(line, col int, format string, args ...interface{}) {
+	panic(&SyntaxError{Line: line, Col: col, Err: fmt.Errorf("wasm: "+format, args...)})
+}
+
+func (lx *lexer) advance(n int) {
+	for i := 0; i < n; i++ {
+		if lx.src[lx.pos] == '\n' {
+			lx.line++
+			lx.col = 0
+		}
+		lx.pos++
+		lx.col++
+	}
+}
+
+func (lx *lexer) peek(s string) bool {
+	return bytes.HasPrefix(lx.src[lx.pos:], []byte(s))
+}
+
+// skip skips white spaces and comments.
+func (lx *lexer) skip() {
+	for lx.pos < len(lx.src) {
+		switch c := lx.src[lx.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			lx.advance(1)
+		case lx.peek(";;"):
+			for lx.pos < len(lx.src) && lx.src[lx.pos] != '\n' {
+				lx.advance(1)
+			}
+		case lx.peek("(;"):
+			line, col := lx.line, lx.col
+			depth := 0
+			for {
+				switch {
+				case lx.pos >= len(lx.src):
+					lx.errorf(line, col, "unterminated block comment")
+				case lx.peek("(;"):
+					depth++
+					lx.advance(2)
+				case lx.peek(";)"):
+					depth--
+					lx.advance(2)
+				default:
+					lx.advance(1)
+				}
+				if depth == 0 {
+					break
+				}
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (lx *lexer) next() token {
+	lx.skip()
+	tok := token{line: lx.line, col: lx.col}
+	if lx.pos >= len(lx.src) {
+		tok.kind = tokEOF
+		return tok
+	}
+
+	switch c := lx.src[lx.pos]; {
+	case c == '(':
+		tok.kind = tokLParen
+		lx.advance(1)
+	case c == ')':
+		tok.kind = tokRParen
+		lx.advance(1)
+	case c == '"':
+		tok.kind = tokString
+		tok.text = lx.readString()
+	case isIDChar(c):
+		start := lx.pos
+		for lx.pos < len(lx.src) && isIDChar(lx.src[lx.pos]) {
+			lx.advance(1)
+		}
+		tok.text = string(lx.src[start:lx.pos])
+		tok.kind = tokAtom
+		if c == '$' {
+			tok.kind = tokID
+			if len(tok.text) == 1 {
+				lx.errorf(tok.line, tok.col, "empty identifier")
+			}
+		}
+	default:
+		lx.errorf(tok.line, tok.col, "unexpected character %q", c)
+	}
+	return tok
+}
+
+// readString reads a string literal, and returns its decoded content.
+func (lx *lexer) readString() string {
+	line, col := lx.line, lx.col
+	lx.advance(1)
+	var o []byte
+	for {
+		if lx.pos >= len(lx.src) || lx.src[lx.pos] == '\n' {
+			lx.errorf(line, col, "unterminated string")
+		}
+		c := lx.src[lx.pos]
+		switch {
+		case c == '"':
+			lx.advance(1)
+			return string(o)
+		case c != '\\':
+			o = append(o, c)
+			lx.advance(1)
+			continue
+		}
+
+		eline, ecol := lx.line, lx.col
+		if lx.pos+1 >= len(lx.src) {
+			lx.errorf(line, col, "unterminated string")
+		}
+		lx.advance(1)
+		switch c := lx.src[lx.pos]; c {
+		case 't':
+			o = append(o, '\t')
+		case 'n':
+			o = append(o, '\n')
+		case 'r':
+			o = append(o, '\r')
+		case '"', '\'', '\\':
+			o = append(o, c)
+		case 'u':
+			end := bytes.IndexByte(lx.src[lx.pos:], '}')
+			if !lx.peek("u{") || end < 0 {
+				lx.errorf(eline, ecol, "invalid unicode escape")
+			}
+			var r rune
+			for _, h := range lx.src[lx.pos+2 : lx.pos+end] {
+				v, ok := hexDigit(h)
+				if !ok || r > utf8.MaxRune {
+					lx.errorf(eline, ecol, "invalid unicode escape")
+				}
+				r = r<<4 | rune(v)
+			}
+			if !utf8.ValidRune(r) {
+				lx.errorf(eline, ecol, "invalid unicode escape")
+			}
+			o = utf8.AppendRune(o, r)
+			lx.advance(end)
+		default:
+			hi, ok1 := hexDigit(c)
+			var lo byte
+			ok2 := false
+			if lx.pos+1 < len(lx.src) {
+				lo, ok2 = hexDigit(lx.src[lx.pos+1])
+			}
+			if !ok1 || !ok2 {
+				lx.errorf(eline, ecol, "invalid escape sequence")
+			}
+			o = append(o, hi<<4|lo)
+			lx.advance(1)
+		}
+		lx.advance(1)
+	}
+}
+
+func hexDigit(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// parseSexprs parses src into a sequence of S-expressions.
+func parseSexprs(src []byte) []*sexpr {
+	var (
+		lx    = newLexer(src)
+		stack []*sexpr
+		top   []*sexpr
+	)
+	for {
+		tok := lx.next()
+		switch tok.kind {
+		case tokEOF:
+			if len(stack) > 0 {
+				open := stack[len(stack)-1].tok
+				lx.errorf(open.line, open.col, "unclosed parenthesis")
+			}
+			return top
+		case tokLParen:
+			stack = append(stack, &sexpr{tok: tok, isList: true})
+			continue
+		case tokRParen:
+			if len(stack) == 0 {
+				lx.errorf(tok.line, tok.col, "unexpected ')'")
+			}
+			list := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				top = append(top, list)
+			} else {
+				parent := stack[len(stack)-1]
+				parent.list = append(parent.list, list)
+			}
+		default:
+			atom := &sexpr{tok: tok}
+			if len(stack) == 0 {
+				top = append(top, atom)
+			} else {
+				parent := stack[len(stack)-1]
+				parent.list = append(parent.list, atom)
+			}
+		}
+	}
+}
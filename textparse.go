@@ -0,0 +1,1316 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseText parses a module in the WebAssembly text format.
+// Both the folded and flat forms of instructions are accepted.
+func ParseText(src []byte) (*Module, error) {
+	return TextOptions{}.ParseText(src)
+}
+
+// ParseText parses a module in the WebAssembly text format.
+// If opts.DebugNames is set, the identifiers of the module are recorded in
+// a "name" section.
+func (opts TextOptions) ParseText(src []byte) (m *Module, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			serr, ok := e.(*SyntaxError)
+			if !ok {
+				panic(e)
+			}
+			m, err = nil, serr
+		}
+	}()
+
+	p := newTextParser(opts)
+	p.parseModule(parseSexprs(src))
+	return p.module(), nil
+}
+
+// instrsByName associates text format names to instructions, without
+// their immediates.
+// Names of the current version of the text format, such as "local.get" or
+// "i32.wrap_i64", are accepted as aliases.
+var instrsByName = func() map[string]Instr {
+	instrs := make(map[string]Instr)
+	add := func(name string, ins Instr) {
+		instrs[name] = ins
+		// "i32.trunc_s/f32" is also known as "i32.trunc_f32_s",
+		// "i32.trunc_s:sat/f32" as "i32.trunc_sat_f32_s" and
+		// "i32.wrap/i64" as "i32.wrap_i64".
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			alias := name[:i]
+			sat := ""
+			if strings.HasSuffix(alias, ":sat") {
+				sat, alias = "_sat", strings.TrimSuffix(alias, ":sat")
+			}
+			sx := ""
+			if strings.HasSuffix(alias, "_s") || strings.HasSuffix(alias, "_u") {
+				sx, alias = alias[len(alias)-2:], alias[:len(alias)-2]
+			}
+			instrs[alias+sat+"_"+name[i+1:]+sx] = ins
+		}
+	}
+	for op, name := range opcodeNames {
+		if name != "" {
+			add(name, Instr{Op: Opcode(op)})
+		}
+	}
+	for op, name := range miscOpcodeNames {
+		if name != "" {
+			add(name, Instr{Op: Op_prefix_misc, Misc: MiscOpcode(op)})
+		}
+	}
+	for alias, op := range map[string]Opcode{
+		"local.get":   Op_get_local,
+		"local.set":   Op_set_local,
+		"local.tee":   Op_tee_local,
+		"global.get":  Op_get_global,
+		"global.set":  Op_set_global,
+		"memory.size": Op_current_memory,
+		"memory.grow": Op_grow_memory,
+	} {
+		instrs[alias] = Instr{Op: op}
+	}
+	return instrs
+}()
+
+// textSpace is an index space of functions, tables, memories or globals.
+type textSpace struct {
+	ids     map[string]uint32
+	n       uint32 // number of entities
+	defined bool   // whether a non-imported entity was declared
+	names   NameMap
+}
+
+// textFunc is a function defined in the text format.
+type textFunc struct {
+	index  uint32 // index in the function index space
+	typ    uint32
+	params int // number of parameters
+	locals []ValueType
+	ids    map[string]uint32 // identifiers of parameters and locals
+	names  NameMap
+	decl   *sexpr // definition of the function
+	body   []*sexpr
+}
+
+type textParser struct {
+	opts TextOptions
+	name string
+
+	types   []FuncType
+	typeIDs map[string]uint32
+	spaces  [4]textSpace // index spaces, per ExternalKind
+
+	imports  []ImportEntry
+	funcs    []*textFunc
+	tables   []TableType
+	memories []MemoryType
+	globals  []GlobalVariable
+	exports  []ExportEntry
+	start    *StartSection
+	elems    []ElemSegment
+	data     []DataSegment
+	bodies   []FunctionBody
+	locals   IndirectNameMap
+	typeNms  NameMap
+
+	later []func() // actions run once all the identifiers are declared
+}
+
+func newTextParser(opts TextOptions) *textParser {
+	p := &textParser{
+		opts:    opts,
+		typeIDs: make(map[string]uint32),
+	}
+	for i := range p.spaces {
+		p.spaces[i].ids = make(map[string]uint32)
+	}
+	return p
+}
+
+func (p *textParser) errorf(s *sexpr, format string, args ...interface{}) {
+	panic(&SyntaxError{Line: s.tok.line, Col: s.tok.col, Err: fmt.Errorf("wasm: "+format, args...)})
+}
+
+func (p *textParser) module() *Module {
+	m := NewModule()
+	if len(p.types) > 0 {
+		m.Sections = append(m.Sections, TypeSection{Types: p.types})
+	}
+	if len(p.imports) > 0 {
+		m.Sections = append(m.Sections, ImportSection{Imports: p.imports})
+	}
+	if len(p.funcs) > 0 {
+		sec := FunctionSection{Types: make([]uint32, len(p.funcs))}
+		for i, f := range p.funcs {
+			sec.Types[i] = f.typ
+		}
+		m.Sections = append(m.Sections, sec)
+	}
+	if len(p.tables) > 0 {
+		m.Sections = append(m.Sections, TableSection{Tables: p.tables})
+	}
+	if len(p.memories) > 0 {
+		m.Sections = append(m.Sections, MemorySection{Memories: p.memories})
+	}
+	if len(p.globals) > 0 {
+		m.Sections = append(m.Sections, GlobalSection{Globals: p.globals})
+	}
+	if len(p.exports) > 0 {
+		m.Sections = append(m.Sections, ExportSection{Exports: p.exports})
+	}
+	if p.start != nil {
+		m.Sections = append(m.Sections, *p.start)
+	}
+	if len(p.elems) > 0 {
+		m.Sections = append(m.Sections, ElementSection{Elements: p.elems})
+	}
+	if len(p.bodies) > 0 {
+		m.Sections = append(m.Sections, CodeSection{Bodies: p.bodies})
+	}
+	if len(p.data) > 0 {
+		m.Sections = append(m.Sections, DataSection{Segments: p.data})
+	}
+	if p.opts.DebugNames {
+		ns := NameSection{
+			Module:    p.name,
+			Functions: p.spaces[FunctionKind].names,
+			Locals:    p.locals,
+			Types:     p.typeNms,
+			Tables:    p.spaces[TableKind].names,
+			Memories:  p.spaces[MemoryKind].names,
+			Globals:   p.spaces[GlobalKind].names,
+		}
+		if !isEmptyNameSection(ns) {
+			m.Sections = append(m.Sections, ns)
+		}
+	}
+	return m
+}
+
+func isEmptyNameSection(ns NameSection) bool {
+	return ns.Module == "" && len(ns.Functions) == 0 && len(ns.Locals) == 0 &&
+		len(ns.Types) == 0 && len(ns.Tables) == 0 && len(ns.Memories) == 0 &&
+		len(ns.Globals) == 0
+}
+
+// parseModule parses a (module ...) S-expression, or a sequence of module
+// fields.
+func (p *textParser) parseModule(top []*sexpr) {
+	fields := top
+	if len(top) == 1 && top[0].is("module") {
+		fields = top[0].list[1:]
+		if len(fields) > 0 && fields[0].tok.kind == tokID {
+			p.name = fields[0].tok.text[1:]
+			fields = fields[1:]
+		}
+	}
+	for _, f := range fields {
+		if !f.isList {
+			p.errorf(f, "expected a module field, got %q", f.tok.text)
+		}
+	}
+
+	// types are declared first, as they may be referenced by any field.
+	for _, f := range fields {
+		if f.is("type") {
+			p.parseType(f)
+		}
+	}
+	for _, f := range fields {
+		switch kw := f.keyword(); kw {
+		case "type":
+		case "import":
+			p.parseImport(f)
+		case "func":
+			p.parseFunc(f)
+		case "table":
+			p.parseTable(f)
+		case "memory":
+			p.parseMemory(f)
+		case "global":
+			p.parseGlobal(f)
+		case "export":
+			p.parseExport(f)
+		case "start":
+			p.parseStart(f)
+		case "elem":
+			p.parseElem(f)
+		case "data":
+			p.parseData(f)
+		default:
+			p.errorf(f, "unknown module field %q", kw)
+		}
+	}
+	for _, fct := range p.later {
+		fct()
+	}
+}
+
+// items is a cursor over the elements of a list.
+type items struct {
+	list []*sexpr
+	pos  int
+	end  *sexpr // the list itself, for errors at its end
+}
+
+func newItems(s *sexpr) *items {
+	return &items{list: s.list, pos: 1, end: s}
+}
+
+func (it *items) done() bool { return it.pos >= len(it.list) }
+
+func (it *items) peek() *sexpr {
+	if it.done() {
+		return nil
+	}
+	return it.list[it.pos]
+}
+
+func (it *items) next() *sexpr {
+	s := it.peek()
+	if s != nil {
+		it.pos++
+	}
+	return s
+}
+
+// peekList returns whether the next item is a list starting with kw.
+func (it *items) peekList(kw string) bool {
+	s := it.peek()
+	return s != nil && s.is(kw)
+}
+
+// peekKind returns whether the next item is an atom of the given kind.
+func (it *items) peekKind(kind tokenKind) bool {
+	s := it.peek()
+	return s != nil && !s.isList && s.tok.kind == kind
+}
+
+func (p *textParser) expect(it *items, what string) *sexpr {
+	s := it.next()
+	if s == nil {
+		p.errorf(it.end, "missing %s", what)
+	}
+	return s
+}
+
+func (p *textParser) expectAtom(it *items, what string) *sexpr {
+	s := p.expect(it, what)
+	if s.isList || s.tok.kind != tokAtom {
+		p.errorf(s, "expected %s", what)
+	}
+	return s
+}
+
+func (p *textParser) expectString(it *items) string {
+	s := p.expect(it, "string")
+	if s.isList || s.tok.kind != tokString {
+		p.errorf(s, "expected a string")
+	}
+	return s.tok.text
+}
+
+func (p *textParser) expectEnd(it *items) {
+	if s := it.peek(); s != nil {
+		p.errorf(s, "unexpected %s", describe(s))
+	}
+}
+
+func describe(s *sexpr) string {
+	if s.isList {
+		if kw := s.keyword(); kw != "" {
+			return fmt.Sprintf("(%s ...)", kw)
+		}
+		return "list"
+	}
+	if s.tok.kind == tokString {
+		return "string"
+	}
+	return strconv.Quote(s.tok.text)
+}
+
+// optID returns the optional identifier at the cursor, or nil.
+func (p *textParser) optID(it *items) *sexpr {
+	if it.peekKind(tokID) {
+		return it.next()
+	}
+	return nil
+}
+
+// declare declares a new entity of the given kind, and returns its index.
+func (p *textParser) declare(kind ExternalKind, id *sexpr, imported bool, at *sexpr) uint32 {
+	space := &p.spaces[kind]
+	if imported && space.defined {
+		p.errorf(at, "import after %v definition", kind)
+	}
+	if !imported {
+		space.defined = true
+	}
+	idx := space.n
+	space.n++
+	if id != nil {
+		name := id.tok.text
+		if _, dup := space.ids[name]; dup {
+			p.errorf(id, "duplicate %v identifier %s", kind, name)
+		}
+		space.ids[name] = idx
+		space.names = append(space.names, Naming{Index: idx, Name: name[1:]})
+	}
+	return idx
+}
+
+// index parses a reference to an entity of an index space.
+func (p *textParser) index(s *sexpr, ids map[string]uint32, what string) uint32 {
+	switch {
+	case s.isList:
+		p.errorf(s, "expected %s index", what)
+	case s.tok.kind == tokID:
+		idx, ok := ids[s.tok.text]
+		if !ok {
+			p.errorf(s, "unknown %s %s", what, s.tok.text)
+		}
+		return idx
+	case s.tok.kind == tokAtom:
+		v, err := parseUint(s.tok.text, 32)
+		if err != nil {
+			p.errorf(s, "invalid %s index %q", what, s.tok.text)
+		}
+		return uint32(v)
+	}
+	p.errorf(s, "expected %s index", what)
+	panic("unreachable")
+}
+
+func (p *textParser) ref(kind ExternalKind, s *sexpr) uint32 {
+	return p.index(s, p.spaces[kind].ids, kind.String())
+}
+
+// inlineExports parses the (export "name") abbreviations of a definition.
+func (p *textParser) inlineExports(it *items, kind ExternalKind, idx uint32) {
+	for it.peekList("export") {
+		s := it.next()
+		eit := newItems(s)
+		field := p.expectString(eit)
+		p.expectEnd(eit)
+		p.later = append(p.later, func() {
+			p.exports = append(p.exports, ExportEntry{Field: field, Kind: kind, Index: idx})
+		})
+	}
+}
+
+// inlineImport parses the (import "module" "field") abbreviation of a
+// definition, if any.
+func (p *textParser) inlineImport(it *items) (ImportEntry, bool) {
+	if !it.peekList("import") {
+		return ImportEntry{}, false
+	}
+	iit := newItems(it.next())
+	imp := ImportEntry{Module: p.expectString(iit), Field: p.expectString(iit)}
+	p.expectEnd(iit)
+	return imp, true
+}
+
+func (p *textParser) valueType(s *sexpr) ValueType {
+	if !s.isList && s.tok.kind == tokAtom {
+		switch s.tok.text {
+		case "i32":
+			return I32
+		case "i64":
+			return I64
+		case "f32":
+			return F32
+		case "f64":
+			return F64
+		}
+	}
+	p.errorf(s, "invalid value type %s", describe(s))
+	panic("unreachable")
+}
+
+func (p *textParser) parseType(f *sexpr) {
+	it := newItems(f)
+	id := p.optID(it)
+	fn := p.expect(it, "function type")
+	if !fn.is("func") {
+		p.errorf(fn, "expected (func ...)")
+	}
+	fit := newItems(fn)
+	ft, _ := p.parseSignature(fit, false)
+	p.expectEnd(fit)
+	p.expectEnd(it)
+
+	idx := uint32(len(p.types))
+	if id != nil {
+		if _, dup := p.typeIDs[id.tok.text]; dup {
+			p.errorf(id, "duplicate type identifier %s", id.tok.text)
+		}
+		p.typeIDs[id.tok.text] = idx
+		p.typeNms = append(p.typeNms, Naming{Index: idx, Name: id.tok.text[1:]})
+	}
+	p.types = append(p.types, ft)
+}
+
+// parseSignature parses (param ...) and (result ...) clauses.
+// If named is set, parameters may be named, and the identifiers of the
+// parameters are returned.
+func (p *textParser) parseSignature(it *items, named bool) (FuncType, []*sexpr) {
+	ft := FuncType{Form: Op_func}
+	var ids []*sexpr
+	for it.peekList("param") {
+		pit := newItems(it.next())
+		if id := p.optID(pit); id != nil {
+			ft.Params = append(ft.Params, p.valueType(p.expect(pit, "parameter type")))
+			ids = append(ids, id)
+			p.expectEnd(pit)
+			continue
+		}
+		for !pit.done() {
+			ft.Params = append(ft.Params, p.valueType(pit.next()))
+			ids = append(ids, nil)
+		}
+	}
+	for it.peekList("result") {
+		rit := newItems(it.next())
+		for !rit.done() {
+			ft.Results = append(ft.Results, p.valueType(rit.next()))
+		}
+	}
+	return ft, ids
+}
+
+// parseTypeUse parses a type use: a (type x) reference, followed by an
+// optional signature.
+// Signatures without matching type definition are added to the types of
+// the module.
+func (p *textParser) parseTypeUse(it *items) (uint32, []*sexpr) {
+	var (
+		idx      uint32
+		explicit *sexpr
+	)
+	if it.peekList("type") {
+		explicit = it.next()
+		tit := newItems(explicit)
+		idx = p.index(p.expect(tit, "type index"), p.typeIDs, "type")
+		p.expectEnd(tit)
+		if int(idx) >= len(p.types) {
+			p.errorf(explicit, "unknown type %d", idx)
+		}
+	}
+	ft, ids := p.parseSignature(it, true)
+	if explicit != nil {
+		if (len(ft.Params) != 0 || len(ft.Results) != 0) && !equalFuncTypes(ft, p.types[idx]) {
+			p.errorf(explicit, "signature %v does not match type %d %v", ft, idx, p.types[idx])
+		}
+		if len(ids) == 0 {
+			ids = make([]*sexpr, len(p.types[idx].Params))
+		}
+		return idx, ids
+	}
+	for i, t := range p.types {
+		if equalFuncTypes(ft, t) {
+			return uint32(i), ids
+		}
+	}
+	p.types = append(p.types, ft)
+	return uint32(len(p.types) - 1), ids
+}
+
+func equalFuncTypes(a, b FuncType) bool {
+	return equalTypes(a.Params, b.Params) && equalTypes(a.Results, b.Results)
+}
+
+func (p *textParser) parseImport(f *sexpr) {
+	it := newItems(f)
+	imp := ImportEntry{Module: p.expectString(it), Field: p.expectString(it)}
+	desc := p.expect(it, "import description")
+	p.expectEnd(it)
+	if !desc.isList {
+		p.errorf(desc, "expected an import description")
+	}
+	dit := newItems(desc)
+	id := p.optID(dit)
+	switch kw := desc.keyword(); kw {
+	case "func":
+		imp.Kind = FunctionKind
+		imp.TypeIndex, _ = p.parseTypeUse(dit)
+	case "table":
+		imp.Kind = TableKind
+		imp.Table = p.parseTableType(dit, desc)
+	case "memory":
+		imp.Kind = MemoryKind
+		imp.Memory = MemoryType{Limits: p.parseLimits(dit, desc)}
+	case "global":
+		imp.Kind = GlobalKind
+		imp.Global = p.parseGlobalType(p.expect(dit, "global type"))
+	default:
+		p.errorf(desc, "unknown import kind %q", kw)
+	}
+	p.expectEnd(dit)
+	p.declare(imp.Kind, id, true, f)
+	p.imports = append(p.imports, imp)
+}
+
+func (p *textParser) parseLimits(it *items, at *sexpr) ResizableLimits {
+	var l ResizableLimits
+	min, err := parseUint(p.expectAtom(it, "limits").tok.text, 32)
+	if err != nil {
+		p.errorf(at, "invalid limits: %v", err)
+	}
+	l.Initial = uint32(min)
+	if it.peekKind(tokAtom) {
+		if v, err := parseUint(it.peek().tok.text, 32); err == nil {
+			it.next()
+			l.Flags = 1
+			l.Maximum = uint32(v)
+		}
+	}
+	return l
+}
+
+func (p *textParser) elemType(s *sexpr) ElemType {
+	switch s.keyword() {
+	case "anyfunc", "funcref":
+		if !s.isList {
+			return AnyFunc
+		}
+	}
+	p.errorf(s, "invalid element type %s", describe(s))
+	panic("unreachable")
+}
+
+func (p *textParser) parseTableType(it *items, at *sexpr) TableType {
+	limits := p.parseLimits(it, at)
+	return TableType{ElemType: p.elemType(p.expect(it, "element type")), Limits: limits}
+}
+
+func (p *textParser) parseGlobalType(s *sexpr) GlobalType {
+	if s.is("mut") {
+		mit := newItems(s)
+		g := GlobalType{ContentType: p.valueType(p.expect(mit, "value type")), Mutability: 1}
+		p.expectEnd(mit)
+		return g
+	}
+	return GlobalType{ContentType: p.valueType(s)}
+}
+
+func (p *textParser) parseFunc(f *sexpr) {
+	it := newItems(f)
+	id := p.optID(it)
+	start := it.pos
+	for it.peekList("export") {
+		it.next()
+	}
+	if imp, ok := p.inlineImport(it); ok {
+		imp.Kind = FunctionKind
+		imp.TypeIndex, _ = p.parseTypeUse(it)
+		p.expectEnd(it)
+		idx := p.declare(FunctionKind, id, true, f)
+		p.imports = append(p.imports, imp)
+		it.pos = start
+		p.inlineExports(it, FunctionKind, idx)
+		return
+	}
+
+	idx := p.declare(FunctionKind, id, false, f)
+	it.pos = start
+	p.inlineExports(it, FunctionKind, idx)
+
+	fn := &textFunc{index: idx, ids: make(map[string]uint32), decl: f}
+	var ids []*sexpr
+	fn.typ, ids = p.parseTypeUse(it)
+	fn.params = len(p.types[fn.typ].Params)
+	if len(ids) != fn.params {
+		ids = make([]*sexpr, fn.params)
+	}
+	for it.peekList("local") {
+		lit := newItems(it.next())
+		if lid := p.optID(lit); lid != nil {
+			ids = append(ids, lid)
+			fn.locals = append(fn.locals, p.valueType(p.expect(lit, "local type")))
+			p.expectEnd(lit)
+			continue
+		}
+		for !lit.done() {
+			ids = append(ids, nil)
+			fn.locals = append(fn.locals, p.valueType(lit.next()))
+		}
+	}
+	for i, id := range ids {
+		if id == nil {
+			continue
+		}
+		if _, dup := fn.ids[id.tok.text]; dup {
+			p.errorf(id, "duplicate local identifier %s", id.tok.text)
+		}
+		fn.ids[id.tok.text] = uint32(i)
+		fn.names = append(fn.names, Naming{Index: uint32(i), Name: id.tok.text[1:]})
+	}
+	fn.body = it.list[it.pos:]
+	p.funcs = append(p.funcs, fn)
+
+	p.later = append(p.later, func() {
+		fc := p.newFuncCtx(fn)
+		fc.parseInstrs(fn.decl, fn.body)
+		p.bodies = append(p.bodies, newFunctionBody(fn.locals, fc.code))
+		if len(fn.names) > 0 {
+			p.locals = append(p.locals, IndirectNaming{Index: fn.index, Names: fn.names})
+		}
+	})
+}
+
+// newFunctionBody returns the function body holding the given locals and
+// bytecode, without its final end instruction.
+func newFunctionBody(locals []ValueType, code []byte) FunctionBody {
+	var fb FunctionBody
+	for _, t := range locals {
+		if n := len(fb.Locals); n > 0 && fb.Locals[n-1].Type == t {
+			fb.Locals[n-1].Count++
+			continue
+		}
+		fb.Locals = append(fb.Locals, LocalEntry{Count: 1, Type: t})
+	}
+	fb.LocalCount = uint32(len(fb.Locals))
+	fb.Code = Code{Code: code, End: byte(Op_end)}
+
+	size := len(appendUleb(nil, uint64(fb.LocalCount))) + len(code) + 1
+	for _, l := range fb.Locals {
+		size += len(appendUleb(nil, uint64(l.Count))) + 1
+	}
+	fb.BodySize = uint32(size)
+	return fb
+}
+
+func (p *textParser) parseTable(f *sexpr) {
+	it := newItems(f)
+	id := p.optID(it)
+	start := it.pos
+	for it.peekList("export") {
+		it.next()
+	}
+	var idx uint32
+	if imp, ok := p.inlineImport(it); ok {
+		imp.Kind = TableKind
+		imp.Table = p.parseTableType(it, f)
+		p.expectEnd(it)
+		idx = p.declare(TableKind, id, true, f)
+		p.imports = append(p.imports, imp)
+	} else {
+		idx = p.declare(TableKind, id, false, f)
+		if it.peekKind(tokAtom) && p.isElemType(it.peek()) {
+			// (table anyfunc (elem ...)) abbreviation.
+			et := p.elemType(it.next())
+			elem := p.expect(it, "(elem ...)")
+			if !elem.is("elem") {
+				p.errorf(elem, "expected (elem ...)")
+			}
+			p.expectEnd(it)
+			refs := elem.list[1:]
+			n := uint32(len(refs))
+			p.tables = append(p.tables, TableType{
+				ElemType: et,
+				Limits:   ResizableLimits{Flags: 1, Initial: n, Maximum: n},
+			})
+			p.later = append(p.later, func() {
+				seg := ElemSegment{
+					Index:  idx,
+					Offset: InitExpr{Expr: appendInstr(nil, Instr{Op: Op_i32_const}), End: byte(Op_end)},
+				}
+				for _, ref := range refs {
+					seg.Elems = append(seg.Elems, p.ref(FunctionKind, ref))
+				}
+				p.elems = append(p.elems, seg)
+			})
+		} else {
+			p.tables = append(p.tables, p.parseTableType(it, f))
+			p.expectEnd(it)
+		}
+	}
+	it.pos = start
+	p.inlineExports(it, TableKind, idx)
+}
+
+func (p *textParser) isElemType(s *sexpr) bool {
+	switch s.keyword() {
+	case "anyfunc", "funcref":
+		return true
+	}
+	return false
+}
+
+func (p *textParser) parseMemory(f *sexpr) {
+	it := newItems(f)
+	id := p.optID(it)
+	start := it.pos
+	for it.peekList("export") {
+		it.next()
+	}
+	var idx uint32
+	if imp, ok := p.inlineImport(it); ok {
+		imp.Kind = MemoryKind
+		imp.Memory = MemoryType{Limits: p.parseLimits(it, f)}
+		p.expectEnd(it)
+		idx = p.declare(MemoryKind, id, true, f)
+		p.imports = append(p.imports, imp)
+	} else {
+		idx = p.declare(MemoryKind, id, false, f)
+		if it.peekList("data") {
+			// (memory (data ...)) abbreviation.
+			data := newItems(it.next())
+			p.expectEnd(it)
+			var buf []byte
+			for !data.done() {
+				buf = append(buf, p.expectString(data)...)
+			}
+			n := uint32((len(buf) + pageSize - 1) / pageSize)
+			p.memories = append(p.memories, MemoryType{
+				Limits: ResizableLimits{Flags: 1, Initial: n, Maximum: n},
+			})
+			p.later = append(p.later, func() {
+				p.data = append(p.data, DataSegment{
+					Index:  idx,
+					Offset: InitExpr{Expr: appendInstr(nil, Instr{Op: Op_i32_const}), End: byte(Op_end)},
+					Data:   buf,
+				})
+			})
+		} else {
+			p.memories = append(p.memories, MemoryType{Limits: p.parseLimits(it, f)})
+			p.expectEnd(it)
+		}
+	}
+	it.pos = start
+	p.inlineExports(it, MemoryKind, idx)
+}
+
+func (p *textParser) parseGlobal(f *sexpr) {
+	it := newItems(f)
+	id := p.optID(it)
+	start := it.pos
+	for it.peekList("export") {
+		it.next()
+	}
+	var idx uint32
+	if imp, ok := p.inlineImport(it); ok {
+		imp.Kind = GlobalKind
+		imp.Global = p.parseGlobalType(p.expect(it, "global type"))
+		p.expectEnd(it)
+		idx = p.declare(GlobalKind, id, true, f)
+		p.imports = append(p.imports, imp)
+	} else {
+		idx = p.declare(GlobalKind, id, false, f)
+		g := GlobalVariable{Type: p.parseGlobalType(p.expect(it, "global type"))}
+		init := it.list[it.pos:]
+		i := len(p.globals)
+		p.globals = append(p.globals, g)
+		p.later = append(p.later, func() {
+			p.globals[i].Init = p.parseInitExpr(f, init)
+		})
+	}
+	it.pos = start
+	p.inlineExports(it, GlobalKind, idx)
+}
+
+func (p *textParser) parseInitExpr(at *sexpr, instrs []*sexpr) InitExpr {
+	fc := p.newFuncCtx(nil)
+	fc.parseInstrs(at, instrs)
+	return InitExpr{Expr: fc.code, End: byte(Op_end)}
+}
+
+func (p *textParser) parseExport(f *sexpr) {
+	it := newItems(f)
+	field := p.expectString(it)
+	desc := p.expect(it, "export description")
+	p.expectEnd(it)
+
+	var kind ExternalKind
+	switch kw := desc.keyword(); {
+	case !desc.isList:
+		p.errorf(desc, "expected an export description")
+	case kw == "func":
+		kind = FunctionKind
+	case kw == "table":
+		kind = TableKind
+	case kw == "memory":
+		kind = MemoryKind
+	case kw == "global":
+		kind = GlobalKind
+	default:
+		p.errorf(desc, "unknown export kind %q", kw)
+	}
+	dit := newItems(desc)
+	ref := p.expect(dit, "index")
+	p.expectEnd(dit)
+	p.later = append(p.later, func() {
+		p.exports = append(p.exports, ExportEntry{Field: field, Kind: kind, Index: p.ref(kind, ref)})
+	})
+}
+
+func (p *textParser) parseStart(f *sexpr) {
+	it := newItems(f)
+	ref := p.expect(it, "function index")
+	p.expectEnd(it)
+	if p.start != nil {
+		p.errorf(f, "multiple start functions")
+	}
+	p.start = &StartSection{}
+	p.later = append(p.later, func() {
+		p.start.Index = p.ref(FunctionKind, ref)
+	})
+}
+
+// parseOffset parses the optional index and the offset of an element or
+// data segment.
+func (p *textParser) parseOffset(it *items) (*sexpr, []*sexpr) {
+	var ref *sexpr
+	if s := it.peek(); s != nil && !s.isList && s.tok.kind != tokString {
+		ref = it.next()
+	}
+	off := p.expect(it, "offset")
+	if !off.isList {
+		p.errorf(off, "expected an offset expression")
+	}
+	if off.is("offset") {
+		return ref, off.list[1:]
+	}
+	return ref, []*sexpr{off}
+}
+
+func (p *textParser) parseElem(f *sexpr) {
+	it := newItems(f)
+	ref, offset := p.parseOffset(it)
+	refs := it.list[it.pos:]
+	p.later = append(p.later, func() {
+		var seg ElemSegment
+		if ref != nil {
+			seg.Index = p.ref(TableKind, ref)
+		}
+		seg.Offset = p.parseInitExpr(f, offset)
+		for _, ref := range refs {
+			seg.Elems = append(seg.Elems, p.ref(FunctionKind, ref))
+		}
+		p.elems = append(p.elems, seg)
+	})
+}
+
+func (p *textParser) parseData(f *sexpr) {
+	it := newItems(f)
+	ref, offset := p.parseOffset(it)
+	var buf []byte
+	for !it.done() {
+		buf = append(buf, p.expectString(it)...)
+	}
+	p.later = append(p.later, func() {
+		seg := DataSegment{Data: buf}
+		if ref != nil {
+			seg.Index = p.ref(MemoryKind, ref)
+		}
+		seg.Offset = p.parseInitExpr(f, offset)
+		p.data = append(p.data, seg)
+	})
+}
+
+// funcCtx holds the state of the parser of a sequence of instructions.
+type funcCtx struct {
+	p      *textParser
+	fn     *textFunc // function being parsed, or nil for initializer expressions
+	labels []string  // identifiers of the enclosing labels, innermost last
+	code   []byte
+}
+
+func (p *textParser) newFuncCtx(fn *textFunc) *funcCtx {
+	return &funcCtx{p: p, fn: fn}
+}
+
+func (fc *funcCtx) emit(ins Instr) {
+	fc.code = appendInstr(fc.code, ins)
+}
+
+// parseInstrs parses the instructions of a function body or an
+// initializer expression, defined by the at field.
+func (fc *funcCtx) parseInstrs(at *sexpr, instrs []*sexpr) {
+	it := &items{list: instrs, end: at}
+	for !it.done() {
+		fc.parseInstr(it)
+	}
+	if len(fc.labels) != 0 {
+		fc.p.errorf(at, "missing end instruction")
+	}
+}
+
+// instr returns the instruction, without immediates, of an instruction
+// keyword.
+func (fc *funcCtx) instr(s *sexpr) Instr {
+	kw := s.keyword()
+	ins, ok := instrsByName[kw]
+	if !ok {
+		if s.isList && len(s.list) > 0 {
+			s = s.list[0]
+		}
+		fc.p.errorf(s, "unknown instruction %s", describe(s))
+	}
+	return ins
+}
+
+// parseInstr parses a plain, block or folded instruction.
+func (fc *funcCtx) parseInstr(it *items) {
+	s := it.next()
+	if s.isList {
+		fc.parseFolded(s)
+		return
+	}
+	ins := fc.instr(s)
+	switch op := ins.Op; op {
+	case Op_block, Op_loop, Op_if:
+		fc.parseBlock(op, it)
+	case Op_else:
+		if len(fc.labels) == 0 {
+			fc.p.errorf(s, "else outside of if block")
+		}
+		fc.checkLabel(it)
+		fc.emit(Instr{Op: op})
+	case Op_end:
+		if len(fc.labels) == 0 {
+			fc.p.errorf(s, "end outside of block")
+		}
+		fc.checkLabel(it)
+		fc.labels = fc.labels[:len(fc.labels)-1]
+		fc.emit(Instr{Op: op})
+	default:
+		fc.emit(fc.parseImmediates(ins, s, it))
+	}
+}
+
+// checkLabel checks the optional label following an else or end
+// instruction matches the label of the enclosing block.
+func (fc *funcCtx) checkLabel(it *items) {
+	if !it.peekKind(tokID) {
+		return
+	}
+	id := it.next()
+	if id.tok.text != fc.labels[len(fc.labels)-1] {
+		fc.p.errorf(id, "mismatching label %s", id.tok.text)
+	}
+}
+
+// parseBlockType parses the optional label and block signature of a block,
+// loop or if instruction.
+func (fc *funcCtx) parseBlockType(it *items) (string, BlockType) {
+	label := ""
+	if id := fc.p.optID(it); id != nil {
+		label = id.tok.text
+	}
+	bt := BlockTypeEmpty
+	if it.peekList("result") {
+		r := it.next()
+		rit := newItems(r)
+		bt = BlockType(fc.p.valueType(fc.p.expect(rit, "result type")))
+		if !rit.done() {
+			fc.p.errorf(r, "blocks may only have one result")
+		}
+	}
+	return label, bt
+}
+
+// parseBlock parses the immediates of a block, loop or if instruction, and
+// enters the block.
+func (fc *funcCtx) parseBlock(op Opcode, it *items) {
+	label, bt := fc.parseBlockType(it)
+	fc.labels = append(fc.labels, label)
+	fc.emit(Instr{Op: op, Block: bt})
+}
+
+// parseFolded parses a folded instruction.
+func (fc *funcCtx) parseFolded(s *sexpr) {
+	ins := fc.instr(s)
+	it := newItems(s)
+	switch op := ins.Op; op {
+	case Op_block, Op_loop:
+		fc.parseBlock(op, it)
+		for !it.done() {
+			fc.parseInstr(it)
+		}
+		fc.labels = fc.labels[:len(fc.labels)-1]
+		fc.emit(Instr{Op: Op_end})
+
+	case Op_if:
+		label, bt := fc.parseBlockType(it)
+		for !it.done() && !it.peekList("then") {
+			fc.parseOperand(it)
+		}
+		then := fc.p.expect(it, "(then ...)")
+		fc.labels = append(fc.labels, label)
+		fc.emit(Instr{Op: op, Block: bt})
+		fc.parseInstrList(then)
+		if it.peekList("else") {
+			fc.emit(Instr{Op: Op_else})
+			fc.parseInstrList(it.next())
+		}
+		fc.p.expectEnd(it)
+		fc.labels = fc.labels[:len(fc.labels)-1]
+		fc.emit(Instr{Op: Op_end})
+
+	case Op_else, Op_end:
+		fc.p.errorf(s, "unexpected folded %v", op)
+
+	default:
+		ins = fc.parseImmediates(ins, s.list[0], it)
+		for !it.done() {
+			fc.parseOperand(it)
+		}
+		fc.emit(ins)
+	}
+}
+
+// parseOperand parses a folded instruction used as an operand.
+func (fc *funcCtx) parseOperand(it *items) {
+	s := it.next()
+	if !s.isList {
+		fc.p.errorf(s, "expected a folded instruction, got %s", describe(s))
+	}
+	fc.parseFolded(s)
+}
+
+// parseInstrList parses the instructions of a (then ...) or (else ...)
+// list.
+func (fc *funcCtx) parseInstrList(s *sexpr) {
+	if !s.is("then") && !s.is("else") {
+		fc.p.errorf(s, "expected (then ...) or (else ...)")
+	}
+	it := newItems(s)
+	for !it.done() {
+		fc.parseInstr(it)
+	}
+}
+
+// label parses a reference to a label.
+func (fc *funcCtx) label(s *sexpr) uint32 {
+	if !s.isList && s.tok.kind == tokID {
+		for i := len(fc.labels) - 1; i >= 0; i-- {
+			if fc.labels[i] == s.tok.text {
+				return uint32(len(fc.labels) - 1 - i)
+			}
+		}
+		fc.p.errorf(s, "unknown label %s", s.tok.text)
+	}
+	return fc.p.index(s, nil, "label")
+}
+
+// isIndex returns whether the next item is a reference to an entity.
+func isIndex(it *items) bool {
+	s := it.peek()
+	if s == nil || s.isList {
+		return false
+	}
+	switch s.tok.kind {
+	case tokID:
+		return true
+	case tokAtom:
+		_, err := parseUint(s.tok.text, 32)
+		return err == nil
+	}
+	return false
+}
+
+// parseImmediates parses the immediates of a plain instruction.
+func (fc *funcCtx) parseImmediates(ins Instr, s *sexpr, it *items) Instr {
+	p := fc.p
+	switch op := ins.Op; op {
+	case Op_br, Op_br_if:
+		ins.Index = fc.label(p.expect(it, "label"))
+
+	case Op_br_table:
+		var labels []uint32
+		for isIndex(it) {
+			labels = append(labels, fc.label(it.next()))
+		}
+		if len(labels) == 0 {
+			p.errorf(s, "missing label")
+		}
+		ins.Targets = labels[:len(labels)-1]
+		ins.Default = labels[len(labels)-1]
+
+	case Op_call:
+		ins.Index = p.ref(FunctionKind, p.expect(it, "function index"))
+
+	case Op_call_indirect:
+		ins.Index, _ = p.parseTypeUse(it)
+
+	case Op_get_local, Op_set_local, Op_tee_local:
+		var ids map[string]uint32
+		if fc.fn != nil {
+			ids = fc.fn.ids
+		}
+		ins.Index = p.index(p.expect(it, "local index"), ids, "local")
+
+	case Op_get_global, Op_set_global:
+		ins.Index = p.ref(GlobalKind, p.expect(it, "global index"))
+
+	case Op_i32_const, Op_i64_const:
+		bits := uint(32)
+		if op == Op_i64_const {
+			bits = 64
+		}
+		lit := p.expectAtom(it, "integer")
+		v, err := parseInt(lit.tok.text, bits)
+		if err != nil {
+			p.errorf(lit, "invalid %v literal %q", op, lit.tok.text)
+		}
+		ins.Value = v
+
+	case Op_f32_const, Op_f64_const:
+		bits := 32
+		if op == Op_f64_const {
+			bits = 64
+		}
+		lit := p.expectAtom(it, "float")
+		v, err := parseFloat(lit.tok.text, bits)
+		if err != nil {
+			p.errorf(lit, "invalid %v literal %q", op, lit.tok.text)
+		}
+		ins.Value = v
+
+	default:
+		if !isMemoryAccess(op) {
+			break
+		}
+		ins.Mem.Align = naturalAlignment(op)
+		if it.peekKind(tokAtom) && strings.HasPrefix(it.peek().tok.text, "offset=") {
+			arg := it.next()
+			v, err := parseUint(strings.TrimPrefix(arg.tok.text, "offset="), 32)
+			if err != nil {
+				p.errorf(arg, "invalid offset %q", arg.tok.text)
+			}
+			ins.Mem.Offset = uint32(v)
+		}
+		if it.peekKind(tokAtom) && strings.HasPrefix(it.peek().tok.text, "align=") {
+			arg := it.next()
+			v, err := parseUint(strings.TrimPrefix(arg.tok.text, "align="), 32)
+			if err != nil || v == 0 || v&(v-1) != 0 {
+				p.errorf(arg, "invalid alignment %q", arg.tok.text)
+			}
+			ins.Mem.Align = 0
+			for v > 1 {
+				v >>= 1
+				ins.Mem.Align++
+			}
+		}
+	}
+	return ins
+}
+
+// parseUint parses an unsigned integer literal of the given bit size.
+func parseUint(s string, bits uint) (uint64, error) {
+	s, base := numberBase(s)
+	if s == "" || s[0] == '+' || s[0] == '-' {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.ParseUint(s, base, int(bits))
+}
+
+// parseInt parses a signed or unsigned integer literal of the given bit
+// size, and returns its sign-extended value.
+func parseInt(s string, bits uint) (uint64, error) {
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	v, err := parseUint(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case neg && v > 1<<(bits-1):
+		return 0, strconv.ErrRange
+	case !neg && bits < 64 && v >= 1<<bits:
+		return 0, strconv.ErrRange
+	}
+	if neg {
+		v = -v
+	}
+	if bits == 32 {
+		v = uint64(int64(int32(uint32(v))))
+	}
+	return v, nil
+}
+
+// numberBase strips the underscores and the hexadecimal prefix of a
+// number, and returns its base.
+func numberBase(s string) (string, int) {
+	if strings.Contains(s, "__") || strings.HasPrefix(s, "_") || strings.HasSuffix(s, "_") {
+		return "", 10
+	}
+	s = strings.Replace(s, "_", "", -1)
+	if strings.HasPrefix(s, "0x") {
+		return s[2:], 16
+	}
+	return s, 10
+}
+
+// parseFloat parses a floating point literal of the given bit size, and
+// returns its IEEE 754 representation.
+func parseFloat(s string, bits int) (uint64, error) {
+	sign := ""
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		sign, s = s[:1], s[1:]
+	}
+	var (
+		signBit  uint64 = 1 << 63
+		expBits  uint64 = 0x7ff << 52
+		quietBit uint64 = 1 << 51
+		fracMask uint64 = 1<<52 - 1
+	)
+	if bits == 32 {
+		signBit, expBits, quietBit, fracMask = 1<<31, 0xff<<23, 1<<22, 1<<23-1
+	}
+	neg := uint64(0)
+	if sign == "-" {
+		neg = signBit
+	}
+
+	switch {
+	case s == "inf":
+		return neg | expBits, nil
+	case s == "nan":
+		return neg | expBits | quietBit, nil
+	case strings.HasPrefix(s, "nan:0x"):
+		payload, err := parseUint(s[4:], 64)
+		if err != nil || payload == 0 || payload > fracMask {
+			return 0, strconv.ErrRange
+		}
+		return neg | expBits | payload, nil
+	}
+
+	s, base := numberBase(s)
+	if s == "" || s[0] == '+' || s[0] == '-' {
+		return 0, strconv.ErrSyntax
+	}
+	if base == 16 {
+		s = "0x" + s
+		if !strings.ContainsAny(s, "pP") {
+			s += "p0"
+		}
+	}
+	f, err := strconv.ParseFloat(sign+s, bits)
+	if err != nil {
+		return 0, err
+	}
+	if bits == 32 {
+		return uint64(math.Float32bits(float32(f))), nil
+	}
+	return math.Float64bits(f), nil
+}
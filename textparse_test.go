@@ -0,0 +1,256 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+func TestParseText(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		want []byte
+	}{
+		{
+			name: "empty",
+			src:  "(module)",
+			want: []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00},
+		},
+		{
+			name: "add",
+			src: `(module
+  (func $add (export "add") (param $lhs i32) (param $rhs i32) (result i32)
+    get_local $lhs
+    get_local $rhs
+    i32.add))`,
+			want: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0x07, 0x01, 0x60, 0x02, 0x7f, 0x7f, 0x01,
+				0x7f, 0x03, 0x02, 0x01, 0x00, 0x07, 0x07, 0x01,
+				0x03, 0x61, 0x64, 0x64, 0x00, 0x00, 0x0a, 0x09,
+				0x01, 0x07, 0x00, 0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b,
+			},
+		},
+		{
+			name: "full",
+			src: `;; the full module of TestBinaries.
+(module
+  (type (func (param i32)))
+  (type (func))
+  (import "env" "log" (func (type 0)))
+  (import "env" "mem" (memory 1))
+  (import "env" "g" (global i32))
+  (table 1 2 anyfunc)
+  (global (mut i32) (i32.const 11))
+  (global f64 (f64.const 1))
+  (export "main" (func $main))
+  (export "tbl" (table 0))
+  (start $main)
+  (elem (i32.const 0) $main)
+  (func $main (local $x i32) (local i32)
+    (block
+      nop)
+    (call 0 (i32.const 11)))
+  (data (offset (i32.const 8)) "hel" "lo"))`,
+			want: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0x08, 0x02, 0x60, 0x01, 0x7f, 0x00, 0x60,
+				0x00, 0x00, 0x02, 0x1f, 0x03, 0x03, 0x65, 0x6e,
+				0x76, 0x03, 0x6c, 0x6f, 0x67, 0x00, 0x00, 0x03,
+				0x65, 0x6e, 0x76, 0x03, 0x6d, 0x65, 0x6d, 0x02,
+				0x00, 0x01, 0x03, 0x65, 0x6e, 0x76, 0x01, 0x67,
+				0x03, 0x7f, 0x00, 0x03, 0x02, 0x01, 0x01, 0x04,
+				0x05, 0x01, 0x70, 0x01, 0x01, 0x02, 0x06, 0x12,
+				0x02, 0x7f, 0x01, 0x41, 0x0b, 0x0b, 0x7c, 0x00,
+				0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0,
+				0x3f, 0x0b, 0x07, 0x0e, 0x02, 0x04, 0x6d, 0x61,
+				0x69, 0x6e, 0x00, 0x01, 0x03, 0x74, 0x62, 0x6c,
+				0x01, 0x00, 0x08, 0x01, 0x01, 0x09, 0x07, 0x01,
+				0x00, 0x41, 0x00, 0x0b, 0x01, 0x01, 0x0a, 0x0e,
+				0x01, 0x0c, 0x01, 0x02, 0x7f, 0x02, 0x40, 0x01,
+				0x0b, 0x41, 0x0b, 0x10, 0x00, 0x0b, 0x0b, 0x0b,
+				0x01, 0x00, 0x41, 0x08, 0x0b, 0x05, 0x68, 0x65,
+				0x6c, 0x6c, 0x6f, 0x00, 0x16, 0x04, 0x6e, 0x61,
+				0x6d, 0x65, 0x01, 0x07, 0x01, 0x01, 0x04, 0x6d,
+				0x61, 0x69, 0x6e, 0x02, 0x06, 0x01, 0x01, 0x01,
+				0x00, 0x01, 0x78,
+			},
+		},
+		{
+			name: "instructions",
+			src: `(func (param i64) (result i32)
+  (local f32)
+  block $out
+    loop $loop
+      get_local 0
+      i64.eqz
+      br_if $out
+      (if (i32.const -1)
+        (then (br $loop))
+        (else (br_table $loop $out 0 (i32.const 0x10))))
+    end $loop
+  end
+  f32.const -0x1.8p1
+  set_local 1
+  f64.const nan:0x4
+  drop
+  i64.const 0xffff_ffff_ffff_ffff
+  i32.wrap_i64)`,
+			want: []byte{
+				0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0x06, 0x01, 0x60, 0x01, 0x7e, 0x01, 0x7f,
+				0x03, 0x02, 0x01, 0x00,
+				0x0a, 0x34, 0x01, 0x32, 0x01, 0x01, 0x7d,
+				0x02, 0x40, // block
+				0x03, 0x40, // loop
+				0x20, 0x00, // get_local 0
+				0x50,       // i64.eqz
+				0x0d, 0x01, // br_if 1
+				0x41, 0x7f, // i32.const -1
+				0x04, 0x40, // if
+				0x0c, 0x01, // br 1
+				0x05,       // else
+				0x41, 0x10, // i32.const 16
+				0x0e, 0x02, 0x01, 0x02, 0x00, // br_table 1 2 0
+				0x0b,                         // end
+				0x0b,                         // end
+				0x0b,                         // end
+				0x43, 0x00, 0x00, 0x40, 0xc0, // f32.const -3
+				0x21, 0x01, // set_local 1
+				0x44, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x7f, // f64.const nan:0x4
+				0x1a,       // drop
+				0x42, 0x7f, // i64.const -1
+				0xa7, // i32.wrap/i64
+				0x0b, // end
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mod, err := wasm.TextOptions{DebugNames: tc.name == "full"}.ParseText([]byte(tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = wasm.Validate(mod)
+			if err != nil {
+				t.Fatalf("invalid module: %v", err)
+			}
+			w := new(bytes.Buffer)
+			err = wasm.Encode(*mod, w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Bytes(); !bytes.Equal(got, tc.want) {
+				t.Fatalf("invalid binary:\ngot= %#v\nwant=%#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseTextRoundTrip(t *testing.T) {
+	mod, err := wasm.Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []wasm.TextOptions{
+		{},
+		{Folded: true, InlineExports: true},
+	} {
+		want := new(bytes.Buffer)
+		err = opts.WriteText(want, mod)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := wasm.ParseText(want.Bytes())
+		if err != nil {
+			t.Fatalf("could not parse text (folded=%v): %v", opts.Folded, err)
+		}
+		got := new(bytes.Buffer)
+		err = opts.WriteText(got, parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Fatalf("text round trip failed (folded=%v)", opts.Folded)
+		}
+	}
+}
+
+func TestParseTextErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "unclosed",
+			src:  "(module\n  (func)",
+			want: "wasm: 1:1: unclosed parenthesis",
+		},
+		{
+			name: "unknown-field",
+			src:  "(module (fun))",
+			want: `wasm: 1:9: unknown module field "fun"`,
+		},
+		{
+			name: "unknown-instruction",
+			src:  "(module\n  (func\n    i32.ad))",
+			want: `wasm: 3:5: unknown instruction "i32.ad"`,
+		},
+		{
+			name: "unknown-label",
+			src:  "(module (func block br $l end))",
+			want: "wasm: 1:24: unknown label $l",
+		},
+		{
+			name: "unknown-function",
+			src:  `(module (export "f" (func $f)))`,
+			want: "wasm: 1:27: unknown func $f",
+		},
+		{
+			name: "duplicate-identifier",
+			src:  "(module (global $g i32 (i32.const 0)) (global $g i32 (i32.const 0)))",
+			want: "wasm: 1:47: duplicate global identifier $g",
+		},
+		{
+			name: "import-after-definition",
+			src:  `(module (func) (import "env" "f" (func)))`,
+			want: "wasm: 1:16: import after func definition",
+		},
+		{
+			name: "i32-overflow",
+			src:  "(module (func i32.const 0x1_0000_0000 drop))",
+			want: `wasm: 1:25: invalid i32.const literal "0x1_0000_0000"`,
+		},
+		{
+			name: "missing-end",
+			src:  "(module (func block))",
+			want: "wasm: 1:9: missing end instruction",
+		},
+		{
+			name: "invalid-escape",
+			src:  `(module (data (i32.const 0) "\x"))`,
+			want: "wasm: 1:30: invalid escape sequence",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := wasm.ParseText([]byte(tc.src))
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if _, ok := err.(*wasm.SyntaxError); !ok {
+				t.Fatalf("expected a *SyntaxError, got %T", err)
+			}
+			if got := err.Error(); got != tc.want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, tc.want)
+			}
+		})
+	}
+}
@@ -6,7 +6,6 @@ package wasm
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
 )
@@ -44,39 +43,36 @@ func (v *varuint7) read(r io.Reader) (int, error) {
 func uvarint(r io.Reader) (uint32, int, error) {
 	var x uint32
 	var s uint
-	var buf = make([]byte, 1)
 	for i := 0; ; i++ {
-		_, err := r.Read(buf)
+		b, err := readByte(r)
 		if err != nil {
 			return 0, i, err
 		}
-		b := buf[0]
+		// the 5th byte holds the 4 most significant bits, and is the last.
+		if i == 4 && b > 0x0f {
+			return 0, i, errOverflow
+		}
 		if b < 0x80 {
-			if i > 9 || i == 9 && b > 1 {
-				return 0, i, errors.New("wasm: overflow")
-			}
 			return x | uint32(b)<<s, i, nil
 		}
 		x |= uint32(b&0x7f) << s
 		s += 7
 	}
-	panic("unreachable")
 }
 
 func varint(r io.Reader) (int32, int, error) {
 	var result int32 = 0
 	var shift uint = 0
 	var size uint = 32
-	buf := make([]byte, 1)
 	n := 0
 	for {
-		_, err := r.Read(buf)
+		c, err := readByte(r)
 		if err != nil {
 			return 0, n, err
 		}
 		n++
-		b := int32(buf[0])
-		result |= (b << shift) & 0x7F
+		b := int32(c)
+		result |= (b & 0x7F) << shift
 		shift += 7
 		if b&0x80 == 0 {
 			if shift < size && b&0x40 != 0 {
@@ -89,23 +85,33 @@ func varint(r io.Reader) (int32, int, error) {
 }
 
 func (v varuint32) write(w io.Writer) (int, error) {
-	n := 0 // how many bytes written
+	return v.writePadded(w, 1)
+}
+
+// writePadded writes v in at least width bytes, and at most 5, padding its
+// encoding with continuation bytes like producers reserving room for sizes
+// patched in place do.
+func (v varuint32) writePadded(w io.Writer, width int) (int, error) {
+	var (
+		buf [5]byte
+		n   int
+	)
+	if width > len(buf) {
+		width = len(buf)
+	}
 	for {
-		b := v & 0x7F
+		b := byte(v & 0x7F)
 		v >>= 7
-		if v != 0 {
+		if v != 0 || n+1 < width {
 			b |= 0x80
 		}
-		_, err := w.Write([]byte{uint8(b)})
-		if err != nil {
-			return n, err
-		}
+		buf[n] = b
 		n++
-		if v == 0 {
+		if v == 0 && n >= width {
 			break
 		}
 	}
-	return n, nil
+	return w.Write(buf[:n])
 }
 
 func (v varuint7) write(w io.Writer) error {
@@ -161,32 +167,97 @@ func (v varint32) write(w io.Writer) (int, error) {
 	return n, nil
 }
 
+// ValueType is the type of a value: one of i32, i64, f32 or f64.
 type ValueType varint7
 
+// Value types, as encoded in the binary format.
+const (
+	I32 ValueType = 0x7f
+	I64 ValueType = 0x7e
+	F32 ValueType = 0x7d
+	F64 ValueType = 0x7c
+)
+
+func (vt ValueType) String() string {
+	switch vt {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	case Op_anyfunc:
+		return "anyfunc"
+	case Op_func:
+		return "func"
+	case Op_empty:
+		return "empty"
+	}
+	return fmt.Sprintf("ValueType(0x%x)", int32(vt))
+}
+
+// BlockType is the signature of a block: a ValueType, or BlockTypeEmpty
+// for blocks that do not yield a value.
 type BlockType ValueType
+
+// BlockTypeEmpty is the BlockType of a block without result.
+const BlockTypeEmpty BlockType = 0x40
+
+// ElemType is the type of the elements of a table.
 type ElemType ValueType
 
+// AnyFunc is the only ElemType of the MVP: any function.
+const AnyFunc ElemType = 0x70
+
+func (et ElemType) String() string {
+	if et == AnyFunc {
+		return "anyfunc"
+	}
+	return fmt.Sprintf("ElemType(0x%x)", int32(et))
+}
+
+// FuncType describes a function signature.
 type FuncType struct {
-	form    ValueType   // value for the 'func' type constructor
-	params  []ValueType // parameters of the function
-	results []ValueType // results of the function
+	Form    ValueType   `json:"form"`    // value for the 'func' type constructor
+	Params  []ValueType `json:"params"`  // parameters of the function
+	Results []ValueType `json:"results"` // results of the function
+}
+
+func (ft FuncType) String() string {
+	str := "("
+	for i, p := range ft.Params {
+		if i > 0 {
+			str += ", "
+		}
+		str += p.String()
+	}
+	str += ") -> ("
+	for i, r := range ft.Results {
+		if i > 0 {
+			str += ", "
+		}
+		str += r.String()
+	}
+	return str + ")"
 }
 
 // GlobalType describes a global variable
 type GlobalType struct {
-	ContentType ValueType
-	Mutability  varuint1 // 0:immutable, 1:mutable
+	ContentType ValueType `json:"content_type"`
+	Mutability  uint32    `json:"mutability"` // 0:immutable, 1:mutable // edvakf:varuint1
 }
 
 // TableType describes a table
 type TableType struct {
-	ElemType ElemType // the type of elements
-	Limits   ResizableLimits
+	ElemType ElemType        `json:"elem_type"` // the type of elements
+	Limits   ResizableLimits `json:"limits"`
 }
 
 // MemoryType describes a memory
 type MemoryType struct {
-	Limits ResizableLimits
+	Limits ResizableLimits `json:"limits"`
 }
 
 // ExternalKind indicates the kind of definition being imported or defined:
@@ -202,16 +273,30 @@ type ExternalKind byte
 // 3: indicates a Global import or definition
 const (
 	FunctionKind ExternalKind = 0
-	TableKind                 = 1
-	MemoryKind                = 2
-	GlobalKind                = 3
+	TableKind    ExternalKind = 1
+	MemoryKind   ExternalKind = 2
+	GlobalKind   ExternalKind = 3
 )
 
+func (k ExternalKind) String() string {
+	switch k {
+	case FunctionKind:
+		return "func"
+	case TableKind:
+		return "table"
+	case MemoryKind:
+		return "memory"
+	case GlobalKind:
+		return "global"
+	}
+	return fmt.Sprintf("ExternalKind(%d)", byte(k))
+}
+
 // ResizableLimits describes the limits of a table or memory
 type ResizableLimits struct {
-	Flags   uint32 // bit 0x1 is set if the maximum field is present
-	Initial uint32 // initial length (in units of table elements or wasm pages)
-	Maximum uint32 // only present if specified by Flags
+	Flags   uint32 `json:"flags"`             // bit 0x1 is set if the maximum field is present
+	Initial uint32 `json:"initial"`           // initial length (in units of table elements or wasm pages)
+	Maximum uint32 `json:"maximum,omitempty"` // only present if specified by Flags
 }
 
 // InitExpr encodes an initializer expression.
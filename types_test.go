@@ -44,7 +44,6 @@ func TestVaruint32(t *testing.T) {
 		128:        []byte{0x80, 0x01},
 		16264:      []byte{0x88, 0x7F},
 		4294967295: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F}, // max of varuint32
-		// []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x10}, // out of range
 	}
 
 	for n, in := range inputs {
@@ -52,6 +51,32 @@ func TestVaruint32(t *testing.T) {
 	}
 }
 
+func TestVaruint32Errors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		want uint32
+		err  error
+	}{
+		{"padded", []byte{0x80, 0x80, 0x80, 0x80, 0x00}, 0, nil},
+		{"padded-max", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F}, 0xffffffff, nil},
+		{"overflow", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x10}, 0, errOverflow},
+		{"overflow-high-bit", []byte{0x80, 0x80, 0x80, 0x80, 0x40}, 0, errOverflow},
+		{"overlong", []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, 0, errOverflow},
+		{"overlong-64", []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, 0, errOverflow},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v, _, err := uvarint(bytes.NewReader(tc.in))
+			if err != tc.err {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if v != tc.want {
+				t.Errorf("got %d, want %d", v, tc.want)
+			}
+		})
+	}
+}
+
 func readWriteVaruint32(t *testing.T, n varuint32, in []byte) {
 	r := bytes.NewBuffer(in)
 	var v varuint32
@@ -0,0 +1,885 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	pageSize = 65536 // size of a page of linear memory, in bytes
+	maxPages = 65536 // maximum number of pages of a linear memory
+)
+
+// maxLocals is the maximum number of locals of a function, including its
+// parameters. It is not a rule of the specification, but an implementation
+// limit, shared with the major engines, bounding the memory used to
+// validate and run functions.
+const maxLocals = 50000
+
+// ValidationError describes a violation of the validation rules of the
+// WebAssembly specification, or of the implementation limits of the
+// package.
+type ValidationError struct {
+	Section SectionID // section holding the invalid definition
+	Entry   int       // index of the invalid entry within its section, or -1
+	Func    int       // index of the invalid function in the function index space, or -1
+	Offset  int       // offset of the invalid instruction within the function code, or -1
+	Err     error     // underlying error
+}
+
+func (e *ValidationError) Error() string {
+	var ctx []string
+	switch {
+	case e.Func >= 0:
+		ctx = append(ctx, fmt.Sprintf("func[%d]", e.Func))
+		if e.Offset >= 0 {
+			ctx = append(ctx, fmt.Sprintf("offset 0x%x", e.Offset))
+		}
+	case e.Entry >= 0:
+		ctx = append(ctx, fmt.Sprintf("%v section", e.Section), fmt.Sprintf("entry %d", e.Entry))
+	default:
+		ctx = append(ctx, fmt.Sprintf("%v section", e.Section))
+	}
+	msg := strings.TrimPrefix(e.Err.Error(), "wasm: ")
+	return fmt.Sprintf("wasm: %s: %s", strings.Join(ctx, ", "), msg)
+}
+
+// Unwrap returns the underlying error.
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// ValidationErrors is the list of errors reported by Validate.
+// The validation of a function body stops at its first error: the list
+// holds at most one error per function body.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	switch len(errs) {
+	case 0:
+		return "wasm: no validation error"
+	case 1:
+		return errs[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more errors)", errs[0], len(errs)-1)
+}
+
+// Unwrap returns the list of errors.
+func (errs ValidationErrors) Unwrap() []error {
+	o := make([]error, len(errs))
+	for i, err := range errs {
+		o[i] = err
+	}
+	return o
+}
+
+// Validate checks that m is a valid module, following the validation rules
+// of the WebAssembly MVP specification, extended by the following
+// proposals:
+//   - mutable globals: mutable globals may be imported and exported;
+//   - sign-extension operators;
+//   - non-trapping float-to-int conversions;
+//   - bulk memory operations, limited to memory.copy and memory.fill.
+//
+// Validate returns nil or a ValidationErrors holding the errors found,
+// including the first error of each invalid function body.
+func Validate(m *Module) error {
+	v := validator{idx: newModuleIndex(m)}
+	v.validate(m)
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+type validator struct {
+	idx  *moduleIndex
+	errs ValidationErrors
+}
+
+func (v *validator) errorf(sec SectionID, entry int, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{
+		Section: sec,
+		Entry:   entry,
+		Func:    -1,
+		Offset:  -1,
+		Err:     fmt.Errorf("wasm: "+format, args...),
+	})
+}
+
+func (v *validator) validate(m *Module) {
+	var last SectionID
+	for _, sec := range m.Sections {
+		id := sec.ID()
+		if lazy, ok := sec.(*LazySection); ok {
+			if _, err := lazy.Decode(); err != nil {
+				v.errs = append(v.errs, &ValidationError{
+					Section: id, Entry: -1, Func: -1, Offset: -1, Err: err,
+				})
+			}
+		}
+		if id == UnknownID {
+			continue
+		}
+		if id <= last {
+			if id == last {
+				v.errorf(id, -1, "duplicate %v section", id)
+			} else {
+				v.errorf(id, -1, "%v section out of order (after %v section)", id, last)
+			}
+			continue
+		}
+		last = id
+	}
+
+	idx := v.idx
+	for i, ft := range idx.types {
+		v.validateFuncType(i, ft)
+	}
+
+	var nimports [4]int
+	for i, imp := range idx.imports {
+		switch imp.Kind {
+		case FunctionKind:
+			if int(imp.TypeIndex) >= len(idx.types) {
+				v.errorf(ImportID, i, "unknown type %d", imp.TypeIndex)
+			}
+		case TableKind:
+			v.validateTable(ImportID, i, imp.Table)
+		case MemoryKind:
+			v.validateMemory(ImportID, i, imp.Memory)
+		case GlobalKind:
+			v.validateGlobalType(ImportID, i, imp.Global)
+		default:
+			v.errorf(ImportID, i, "invalid external kind %v", imp.Kind)
+			continue
+		}
+		nimports[imp.Kind]++
+	}
+
+	for i, t := range idx.funcs[idx.nfuncs:] {
+		if int(t) >= len(idx.types) {
+			v.errorf(FunctionID, i, "unknown type %d", t)
+		}
+	}
+
+	for i, t := range idx.tables[nimports[TableKind]:] {
+		v.validateTable(TableID, i, t)
+	}
+	if len(idx.tables) > 1 {
+		v.errorf(TableID, -1, "multiple tables (%d)", len(idx.tables))
+	}
+
+	for i, mem := range idx.memories[nimports[MemoryKind]:] {
+		v.validateMemory(MemoryID, i, mem)
+	}
+	if len(idx.memories) > 1 {
+		v.errorf(MemoryID, -1, "multiple memories (%d)", len(idx.memories))
+	}
+
+	for i, g := range idx.globals[idx.nglobals:] {
+		v.validateGlobalType(GlobalID, i, g)
+		v.validateInitExpr(GlobalID, i, idx.globalInits[i], g.ContentType)
+	}
+
+	names := make(map[string]int, len(idx.exports))
+	for i, exp := range idx.exports {
+		if j, dup := names[exp.Field]; dup {
+			v.errorf(ExportID, i, "duplicate export name %q (also exported by entry %d)", exp.Field, j)
+		} else {
+			names[exp.Field] = i
+		}
+		var n int
+		switch exp.Kind {
+		case FunctionKind:
+			n = len(idx.funcs)
+		case TableKind:
+			n = len(idx.tables)
+		case MemoryKind:
+			n = len(idx.memories)
+		case GlobalKind:
+			n = len(idx.globals)
+		default:
+			v.errorf(ExportID, i, "invalid external kind %v", exp.Kind)
+			continue
+		}
+		if int(exp.Index) >= n {
+			v.errorf(ExportID, i, "unknown %v %d", exp.Kind, exp.Index)
+		}
+	}
+
+	if idx.start != nil {
+		switch ft, ok := idx.funcType(idx.start.Index); {
+		case int(idx.start.Index) >= len(idx.funcs):
+			v.errorf(StartID, -1, "unknown function %d", idx.start.Index)
+		case !ok:
+			// invalid type index, already reported.
+		case len(ft.Params) != 0 || len(ft.Results) != 0:
+			v.errorf(StartID, -1, "invalid start function signature %v, want () -> ()", ft)
+		}
+	}
+
+	for i, seg := range idx.elements {
+		if int(seg.Index) >= len(idx.tables) {
+			v.errorf(ElementID, i, "unknown table %d", seg.Index)
+		}
+		v.validateInitExpr(ElementID, i, seg.Offset, I32)
+		for _, f := range seg.Elems {
+			if int(f) >= len(idx.funcs) {
+				v.errorf(ElementID, i, "unknown function %d", f)
+			}
+		}
+	}
+
+	if n, nbodies := len(idx.funcs)-idx.nfuncs, len(idx.bodies); n != nbodies {
+		v.errorf(CodeID, -1, "function and code section have inconsistent lengths (%d != %d)", n, nbodies)
+	}
+	for i, body := range idx.bodies {
+		fct := idx.nfuncs + i
+		if fct >= len(idx.funcs) {
+			break
+		}
+		ft, ok := idx.funcType(uint32(fct))
+		if !ok {
+			continue
+		}
+		fv := funcValidator{idx: idx, fct: fct}
+		if err := fv.validate(ft, body); err != nil {
+			v.errs = append(v.errs, err)
+		}
+	}
+
+	for i, seg := range idx.data {
+		if int(seg.Index) >= len(idx.memories) {
+			v.errorf(DataID, i, "unknown memory %d", seg.Index)
+		}
+		v.validateInitExpr(DataID, i, seg.Offset, I32)
+	}
+}
+
+func isValueType(vt ValueType) bool {
+	switch vt {
+	case I32, I64, F32, F64:
+		return true
+	}
+	return false
+}
+
+func (v *validator) validateFuncType(i int, ft FuncType) {
+	if ft.Form != Op_func {
+		v.errorf(TypeID, i, "invalid type form %v", ft.Form)
+	}
+	for _, p := range ft.Params {
+		if !isValueType(p) {
+			v.errorf(TypeID, i, "invalid parameter type %v", p)
+		}
+	}
+	for _, r := range ft.Results {
+		if !isValueType(r) {
+			v.errorf(TypeID, i, "invalid result type %v", r)
+		}
+	}
+	if len(ft.Results) > 1 {
+		v.errorf(TypeID, i, "too many results (%d)", len(ft.Results))
+	}
+}
+
+func (v *validator) validateLimits(sec SectionID, i int, l ResizableLimits, max uint32) {
+	switch {
+	case l.Flags > 1:
+		v.errorf(sec, i, "invalid limits flags 0x%x", l.Flags)
+	case l.Initial > max:
+		v.errorf(sec, i, "initial size %d exceeds %d", l.Initial, max)
+	case l.Flags == 1 && l.Maximum > max:
+		v.errorf(sec, i, "maximum size %d exceeds %d", l.Maximum, max)
+	case l.Flags == 1 && l.Maximum < l.Initial:
+		v.errorf(sec, i, "maximum size %d smaller than initial size %d", l.Maximum, l.Initial)
+	}
+}
+
+func (v *validator) validateTable(sec SectionID, i int, t TableType) {
+	if t.ElemType != AnyFunc {
+		v.errorf(sec, i, "invalid table element type %v", t.ElemType)
+	}
+	v.validateLimits(sec, i, t.Limits, 1<<32-1)
+}
+
+func (v *validator) validateMemory(sec SectionID, i int, mem MemoryType) {
+	v.validateLimits(sec, i, mem.Limits, maxPages)
+}
+
+func (v *validator) validateGlobalType(sec SectionID, i int, g GlobalType) {
+	if !isValueType(g.ContentType) {
+		v.errorf(sec, i, "invalid global type %v", g.ContentType)
+	}
+	if g.Mutability > 1 {
+		v.errorf(sec, i, "invalid global mutability %d", g.Mutability)
+	}
+}
+
+// validateInitExpr checks that expr is a constant expression of type want.
+func (v *validator) validateInitExpr(sec SectionID, i int, expr InitExpr, want ValueType) {
+	instrs, err := expr.Instrs()
+	if err != nil {
+		v.errorf(sec, i, "invalid initializer expression: %v", strings.TrimPrefix(err.Error(), "wasm: "))
+		return
+	}
+	if len(instrs) != 2 {
+		v.errorf(sec, i, "initializer expression must hold exactly one instruction (got %d)", len(instrs)-1)
+		return
+	}
+
+	var got ValueType
+	switch ins := instrs[0]; ins.Op {
+	case Op_i32_const:
+		got = I32
+	case Op_i64_const:
+		got = I64
+	case Op_f32_const:
+		got = F32
+	case Op_f64_const:
+		got = F64
+	case Op_get_global:
+		if int(ins.Index) >= v.idx.nglobals {
+			v.errorf(sec, i, "initializer expression may only access imported globals (global %d)", ins.Index)
+			return
+		}
+		g := v.idx.globals[ins.Index]
+		if g.Mutability != 0 {
+			v.errorf(sec, i, "initializer expression may only access immutable globals (global %d)", ins.Index)
+			return
+		}
+		got = g.ContentType
+	default:
+		v.errorf(sec, i, "non-constant instruction %v in initializer expression", ins.Op)
+		return
+	}
+	if got != want {
+		v.errorf(sec, i, "type mismatch in initializer expression: expected %v, got %v", want, got)
+	}
+}
+
+// unknownType is the type of the operands of unreachable code, matching
+// any other type.
+const unknownType ValueType = 0
+
+// ctrlFrame is an entry of the control stack of the function validator.
+type ctrlFrame struct {
+	op          Opcode
+	label       []ValueType // types of the operands of branches to this frame
+	results     []ValueType // types of the results of this frame
+	height      int         // height of the operand stack at the start of the frame
+	unreachable bool        // whether the rest of the frame is unreachable
+}
+
+// funcValidator type-checks the body of a function.
+type funcValidator struct {
+	idx    *moduleIndex
+	fct    int
+	locals []ValueType
+	vals   []ValueType
+	ctrls  []ctrlFrame
+	ins    Instr
+}
+
+func (fv *funcValidator) error(offset int, err error) *ValidationError {
+	return &ValidationError{
+		Section: CodeID,
+		Entry:   fv.fct - fv.idx.nfuncs,
+		Func:    fv.fct,
+		Offset:  offset,
+		Err:     err,
+	}
+}
+
+func (fv *funcValidator) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("wasm: %v: "+format, append([]interface{}{fv.ins.Name()}, args...)...)
+}
+
+func (fv *funcValidator) push(t ValueType) {
+	fv.vals = append(fv.vals, t)
+}
+
+func (fv *funcValidator) pushAll(ts []ValueType) {
+	fv.vals = append(fv.vals, ts...)
+}
+
+func (fv *funcValidator) pop() (ValueType, error) {
+	frame := &fv.ctrls[len(fv.ctrls)-1]
+	if len(fv.vals) == frame.height {
+		if frame.unreachable {
+			return unknownType, nil
+		}
+		return unknownType, fv.errorf("operand stack underflow")
+	}
+	t := fv.vals[len(fv.vals)-1]
+	fv.vals = fv.vals[:len(fv.vals)-1]
+	return t, nil
+}
+
+func (fv *funcValidator) popExpect(want ValueType) (ValueType, error) {
+	got, err := fv.pop()
+	if err != nil {
+		return got, err
+	}
+	switch {
+	case got == unknownType:
+		return want, nil
+	case want == unknownType:
+		return got, nil
+	case got != want:
+		return got, fv.errorf("type mismatch: expected %v, got %v", want, got)
+	}
+	return got, nil
+}
+
+func (fv *funcValidator) popAll(ts []ValueType) error {
+	for i := len(ts) - 1; i >= 0; i-- {
+		if _, err := fv.popExpect(ts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fv *funcValidator) pushCtrl(op Opcode, label, results []ValueType) {
+	fv.ctrls = append(fv.ctrls, ctrlFrame{
+		op:      op,
+		label:   label,
+		results: results,
+		height:  len(fv.vals),
+	})
+}
+
+func (fv *funcValidator) popCtrl() (ctrlFrame, error) {
+	frame := fv.ctrls[len(fv.ctrls)-1]
+	if err := fv.popAll(frame.results); err != nil {
+		return frame, err
+	}
+	if n := len(fv.vals) - frame.height; n != 0 {
+		return frame, fv.errorf("%d extra values on the operand stack at end of block", n)
+	}
+	fv.ctrls = fv.ctrls[:len(fv.ctrls)-1]
+	return frame, nil
+}
+
+func (fv *funcValidator) setUnreachable() {
+	frame := &fv.ctrls[len(fv.ctrls)-1]
+	fv.vals = fv.vals[:frame.height]
+	frame.unreachable = true
+}
+
+// label returns the types of the operands of a branch to label l.
+func (fv *funcValidator) label(l uint32) ([]ValueType, error) {
+	if int(l) >= len(fv.ctrls) {
+		return nil, fv.errorf("unknown label %d", l)
+	}
+	return fv.ctrls[len(fv.ctrls)-1-int(l)].label, nil
+}
+
+func blockResults(bt BlockType) []ValueType {
+	if bt == BlockTypeEmpty {
+		return nil
+	}
+	return []ValueType{ValueType(bt)}
+}
+
+func (fv *funcValidator) validate(ft FuncType, body FunctionBody) *ValidationError {
+	fv.locals = append(fv.locals, ft.Params...)
+	for _, l := range body.Locals {
+		if !isValueType(l.Type) {
+			return fv.error(-1, fmt.Errorf("wasm: invalid local type %v", l.Type))
+		}
+		if uint64(len(fv.locals))+uint64(l.Count) > maxLocals {
+			return fv.error(-1, fmt.Errorf("wasm: too many locals (implementation limit of %d)", maxLocals))
+		}
+		for i := uint32(0); i < l.Count; i++ {
+			fv.locals = append(fv.locals, l.Type)
+		}
+	}
+
+	instrs, err := body.Code.Instrs()
+	if err != nil {
+		offset := -1
+		var eerr *exprError
+		if errors.As(err, &eerr) {
+			offset = eerr.Offset
+			err = eerr.Err
+		}
+		return fv.error(offset, err)
+	}
+
+	fv.pushCtrl(Op_block, ft.Results, ft.Results)
+	for _, ins := range instrs {
+		fv.ins = ins
+		if len(fv.ctrls) == 0 {
+			return fv.error(ins.Offset, fv.errorf("instruction after end of function"))
+		}
+		if err := fv.validateInstr(ft, ins); err != nil {
+			return fv.error(ins.Offset, err)
+		}
+	}
+	if len(fv.ctrls) != 0 {
+		return fv.error(-1, errors.New("wasm: missing end of function"))
+	}
+	return nil
+}
+
+func (fv *funcValidator) validateInstr(ft FuncType, ins Instr) error {
+	idx := fv.idx
+	switch op := ins.Op; op {
+	case Op_unreachable:
+		fv.setUnreachable()
+
+	case Op_nop:
+
+	case Op_block:
+		res := blockResults(ins.Block)
+		fv.pushCtrl(op, res, res)
+
+	case Op_loop:
+		fv.pushCtrl(op, nil, blockResults(ins.Block))
+
+	case Op_if:
+		if _, err := fv.popExpect(I32); err != nil {
+			return err
+		}
+		res := blockResults(ins.Block)
+		fv.pushCtrl(op, res, res)
+
+	case Op_else:
+		frame, err := fv.popCtrl()
+		if err != nil {
+			return err
+		}
+		if frame.op != Op_if {
+			return fv.errorf("else outside of if block")
+		}
+		fv.pushCtrl(op, frame.label, frame.results)
+
+	case Op_end:
+		frame, err := fv.popCtrl()
+		if err != nil {
+			return err
+		}
+		if frame.op == Op_if && len(frame.results) != 0 {
+			return fv.errorf("if block with a result type but no else branch")
+		}
+		fv.pushAll(frame.results)
+
+	case Op_br:
+		label, err := fv.label(ins.Index)
+		if err != nil {
+			return err
+		}
+		if err := fv.popAll(label); err != nil {
+			return err
+		}
+		fv.setUnreachable()
+
+	case Op_br_if:
+		if _, err := fv.popExpect(I32); err != nil {
+			return err
+		}
+		label, err := fv.label(ins.Index)
+		if err != nil {
+			return err
+		}
+		if err := fv.popAll(label); err != nil {
+			return err
+		}
+		fv.pushAll(label)
+
+	case Op_br_table:
+		if _, err := fv.popExpect(I32); err != nil {
+			return err
+		}
+		def, err := fv.label(ins.Default)
+		if err != nil {
+			return err
+		}
+		for _, l := range ins.Targets {
+			label, err := fv.label(l)
+			if err != nil {
+				return err
+			}
+			if !equalTypes(label, def) {
+				return fv.errorf("type mismatch between label %d %v and default label %d %v", l, label, ins.Default, def)
+			}
+		}
+		if err := fv.popAll(def); err != nil {
+			return err
+		}
+		fv.setUnreachable()
+
+	case Op_return:
+		if err := fv.popAll(ft.Results); err != nil {
+			return err
+		}
+		fv.setUnreachable()
+
+	case Op_call:
+		if int(ins.Index) >= len(idx.funcs) {
+			return fv.errorf("unknown function %d", ins.Index)
+		}
+		callee, ok := idx.funcType(ins.Index)
+		if !ok {
+			return fv.errorf("unknown type %d", idx.funcs[ins.Index])
+		}
+		if err := fv.popAll(callee.Params); err != nil {
+			return err
+		}
+		fv.pushAll(callee.Results)
+
+	case Op_call_indirect:
+		if len(idx.tables) == 0 {
+			return fv.errorf("unknown table 0")
+		}
+		if int(ins.Index) >= len(idx.types) {
+			return fv.errorf("unknown type %d", ins.Index)
+		}
+		if _, err := fv.popExpect(I32); err != nil {
+			return err
+		}
+		callee := idx.types[ins.Index]
+		if err := fv.popAll(callee.Params); err != nil {
+			return err
+		}
+		fv.pushAll(callee.Results)
+
+	case Op_drop:
+		if _, err := fv.pop(); err != nil {
+			return err
+		}
+
+	case Op_select:
+		if _, err := fv.popExpect(I32); err != nil {
+			return err
+		}
+		t1, err := fv.pop()
+		if err != nil {
+			return err
+		}
+		t2, err := fv.popExpect(t1)
+		if err != nil {
+			return err
+		}
+		fv.push(t2)
+
+	case Op_get_local, Op_set_local, Op_tee_local:
+		if int(ins.Index) >= len(fv.locals) {
+			return fv.errorf("unknown local %d", ins.Index)
+		}
+		t := fv.locals[ins.Index]
+		if op != Op_get_local {
+			if _, err := fv.popExpect(t); err != nil {
+				return err
+			}
+		}
+		if op != Op_set_local {
+			fv.push(t)
+		}
+
+	case Op_get_global, Op_set_global:
+		if int(ins.Index) >= len(idx.globals) {
+			return fv.errorf("unknown global %d", ins.Index)
+		}
+		g := idx.globals[ins.Index]
+		if op == Op_get_global {
+			fv.push(g.ContentType)
+			break
+		}
+		if g.Mutability == 0 {
+			return fv.errorf("global %d is immutable", ins.Index)
+		}
+		if _, err := fv.popExpect(g.ContentType); err != nil {
+			return err
+		}
+
+	case Op_current_memory, Op_grow_memory:
+		if len(idx.memories) == 0 {
+			return fv.errorf("unknown memory 0")
+		}
+		if op == Op_grow_memory {
+			if _, err := fv.popExpect(I32); err != nil {
+				return err
+			}
+		}
+		fv.push(I32)
+
+	case Op_i32_const:
+		fv.push(I32)
+	case Op_i64_const:
+		fv.push(I64)
+	case Op_f32_const:
+		fv.push(F32)
+	case Op_f64_const:
+		fv.push(F64)
+
+	case Op_prefix_misc:
+		if ins.Misc == Op_memory_copy || ins.Misc == Op_memory_fill {
+			if len(idx.memories) == 0 {
+				return fv.errorf("unknown memory 0")
+			}
+		}
+		params, results, err := MiscSignature(ins.Misc)
+		if err != nil {
+			return fv.errorf("unexpected instruction")
+		}
+		if err := fv.popAll(params); err != nil {
+			return err
+		}
+		fv.pushAll(results)
+
+	default:
+		if isMemoryAccess(op) {
+			if len(idx.memories) == 0 {
+				return fv.errorf("unknown memory 0")
+			}
+			if ins.Mem.Align > naturalAlignment(op) {
+				return fv.errorf("alignment 2**%d larger than natural alignment 2**%d",
+					ins.Mem.Align, naturalAlignment(op),
+				)
+			}
+		}
+		params, results, err := Signature(op)
+		if err != nil {
+			return fv.errorf("unexpected instruction")
+		}
+		if err := fv.popAll(params); err != nil {
+			return err
+		}
+		fv.pushAll(results)
+	}
+	return nil
+}
+
+func equalTypes(a, b []ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Signature returns the types of the operands and results of a memory
+// access or numeric instruction.
+// Signature returns an error for other instructions, whose types depend
+// on their immediates or on their context.
+func Signature(op Opcode) (params, results []ValueType, err error) {
+	switch op {
+	case Op_i32_wrap_i64:
+		return []ValueType{I64}, []ValueType{I32}, nil
+	case Op_i32_trunc_s_f32, Op_i32_trunc_u_f32, Op_i32_reinterpret_f32:
+		return []ValueType{F32}, []ValueType{I32}, nil
+	case Op_i32_trunc_s_f64, Op_i32_trunc_u_f64:
+		return []ValueType{F64}, []ValueType{I32}, nil
+	case Op_i64_extend_s_i32, Op_i64_extend_u_i32:
+		return []ValueType{I32}, []ValueType{I64}, nil
+	case Op_i64_trunc_s_f32, Op_i64_trunc_u_f32:
+		return []ValueType{F32}, []ValueType{I64}, nil
+	case Op_i64_trunc_s_f64, Op_i64_trunc_u_f64, Op_i64_reinterpret_f64:
+		return []ValueType{F64}, []ValueType{I64}, nil
+	case Op_f32_convert_s_i32, Op_f32_convert_u_i32, Op_f32_reinterpret_i32:
+		return []ValueType{I32}, []ValueType{F32}, nil
+	case Op_f32_convert_s_i64, Op_f32_convert_u_i64:
+		return []ValueType{I64}, []ValueType{F32}, nil
+	case Op_f32_demote_f64:
+		return []ValueType{F64}, []ValueType{F32}, nil
+	case Op_f64_convert_s_i32, Op_f64_convert_u_i32:
+		return []ValueType{I32}, []ValueType{F64}, nil
+	case Op_f64_convert_s_i64, Op_f64_convert_u_i64, Op_f64_reinterpret_i64:
+		return []ValueType{I64}, []ValueType{F64}, nil
+	case Op_f64_promote_f32:
+		return []ValueType{F32}, []ValueType{F64}, nil
+	case Op_i32_extend8_s, Op_i32_extend16_s:
+		return []ValueType{I32}, []ValueType{I32}, nil
+	case Op_i64_extend8_s, Op_i64_extend16_s, Op_i64_extend32_s:
+		return []ValueType{I64}, []ValueType{I64}, nil
+	}
+
+	switch {
+	case Op_i32_load <= op && op <= Op_i64_load32_u:
+		return []ValueType{I32}, []ValueType{loadType(op)}, nil
+	case Op_i32_store <= op && op <= Op_i64_store32:
+		return []ValueType{I32, storeType(op)}, nil, nil
+	case op == Op_i32_eqz:
+		return []ValueType{I32}, []ValueType{I32}, nil
+	case op == Op_i64_eqz:
+		return []ValueType{I64}, []ValueType{I32}, nil
+	case Op_i32_eq <= op && op <= Op_i32_ge_u:
+		return []ValueType{I32, I32}, []ValueType{I32}, nil
+	case Op_i64_eq <= op && op <= Op_i64_ge_u:
+		return []ValueType{I64, I64}, []ValueType{I32}, nil
+	case Op_f32_eq <= op && op <= Op_f32_ge:
+		return []ValueType{F32, F32}, []ValueType{I32}, nil
+	case Op_f64_eq <= op && op <= Op_f64_ge:
+		return []ValueType{F64, F64}, []ValueType{I32}, nil
+	case Op_i32_clz <= op && op <= Op_i32_popcnt:
+		return []ValueType{I32}, []ValueType{I32}, nil
+	case Op_i32_add <= op && op <= Op_i32_rotr:
+		return []ValueType{I32, I32}, []ValueType{I32}, nil
+	case Op_i64_clz <= op && op <= Op_i64_popcnt:
+		return []ValueType{I64}, []ValueType{I64}, nil
+	case Op_i64_add <= op && op <= Op_i64_rotr:
+		return []ValueType{I64, I64}, []ValueType{I64}, nil
+	case Op_f32_abs <= op && op <= Op_f32_sqrt:
+		return []ValueType{F32}, []ValueType{F32}, nil
+	case Op_f32_add <= op && op <= Op_f32_copysign:
+		return []ValueType{F32, F32}, []ValueType{F32}, nil
+	case Op_f64_abs <= op && op <= Op_f64_sqrt:
+		return []ValueType{F64}, []ValueType{F64}, nil
+	case Op_f64_add <= op && op <= Op_f64_copysign:
+		return []ValueType{F64, F64}, []ValueType{F64}, nil
+	}
+	return nil, nil, fmt.Errorf("wasm: no signature for instruction %v", op)
+}
+
+// MiscSignature returns the types of the operands and results of an
+// instruction following the Op_prefix_misc prefix.
+func MiscSignature(op MiscOpcode) (params, results []ValueType, err error) {
+	switch op {
+	case Op_i32_trunc_sat_s_f32, Op_i32_trunc_sat_u_f32:
+		return []ValueType{F32}, []ValueType{I32}, nil
+	case Op_i32_trunc_sat_s_f64, Op_i32_trunc_sat_u_f64:
+		return []ValueType{F64}, []ValueType{I32}, nil
+	case Op_i64_trunc_sat_s_f32, Op_i64_trunc_sat_u_f32:
+		return []ValueType{F32}, []ValueType{I64}, nil
+	case Op_i64_trunc_sat_s_f64, Op_i64_trunc_sat_u_f64:
+		return []ValueType{F64}, []ValueType{I64}, nil
+	case Op_memory_copy, Op_memory_fill:
+		return []ValueType{I32, I32, I32}, nil, nil
+	}
+	return nil, nil, fmt.Errorf("wasm: no signature for instruction %v", op)
+}
+
+func loadType(op Opcode) ValueType {
+	switch op {
+	case Op_i32_load, Op_i32_load8_s, Op_i32_load8_u, Op_i32_load16_s, Op_i32_load16_u:
+		return I32
+	case Op_f32_load:
+		return F32
+	case Op_f64_load:
+		return F64
+	}
+	return I64
+}
+
+func storeType(op Opcode) ValueType {
+	switch op {
+	case Op_i32_store, Op_i32_store8, Op_i32_store16:
+		return I32
+	case Op_f32_store:
+		return F32
+	case Op_f64_store:
+		return F64
+	}
+	return I64
+}
@@ -0,0 +1,346 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+func TestValidate(t *testing.T) {
+	// (module
+	//  (import "env" "g" (global i32))
+	//  (memory 1)
+	//  (global (mut i64) (i64.const 1))
+	//  (func $f (param i32) (result i32)
+	//   block i32
+	//    get_local 0
+	//    get_local 0
+	//    br_if 0
+	//    get_local 0
+	//    i32.const 1
+	//    i32.load offset=4
+	//    select
+	//   end
+	//   get_global 0
+	//   i32.add
+	//   i64.extend_u/i32
+	//   set_global 1
+	//   unreachable
+	//   f32.add
+	//   drop)
+	//  (data (get_global 0) "x")
+	// )
+	raw := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x06, 0x01, 0x60, 0x01, 0x7f, 0x01, 0x7f,
+		0x02, 0x0a, 0x01, 0x03, 0x65, 0x6e, 0x76, 0x01,
+		0x67, 0x03, 0x7f, 0x00,
+		0x03, 0x02, 0x01, 0x00,
+		0x05, 0x03, 0x01, 0x00, 0x01,
+		0x06, 0x06, 0x01, 0x7e, 0x01, 0x42, 0x01, 0x0b,
+		0x0a, 0x1e, 0x01, 0x1c, 0x00,
+		0x02, 0x7f, // block i32
+		0x20, 0x00, // get_local 0
+		0x20, 0x00, // get_local 0
+		0x0d, 0x00, // br_if 0
+		0x20, 0x00, // get_local 0
+		0x41, 0x01, // i32.const 1
+		0x28, 0x02, 0x04, // i32.load offset=4
+		0x1b,       // select
+		0x0b,       // end
+		0x23, 0x00, // get_global 0
+		0x6a,       // i32.add
+		0xad,       // i64.extend_u/i32
+		0x24, 0x01, // set_global 1
+		0x00, // unreachable
+		0x92, // f32.add
+		0x1a, // drop
+		0x0b, // end
+		0x0b, 0x07, 0x01, 0x00, 0x23, 0x00, 0x0b, 0x01, 0x78,
+	}
+	mod, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wasm.Validate(mod)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	for _, tc := range []struct {
+		op      wasm.Opcode
+		params  []wasm.ValueType
+		results []wasm.ValueType
+	}{
+		{wasm.Op_i32_load8_u, []wasm.ValueType{wasm.I32}, []wasm.ValueType{wasm.I32}},
+		{wasm.Op_f64_store, []wasm.ValueType{wasm.I32, wasm.F64}, nil},
+		{wasm.Op_i64_lt_s, []wasm.ValueType{wasm.I64, wasm.I64}, []wasm.ValueType{wasm.I32}},
+		{wasm.Op_i32_wrap_i64, []wasm.ValueType{wasm.I64}, []wasm.ValueType{wasm.I32}},
+		{wasm.Op_f32_demote_f64, []wasm.ValueType{wasm.F64}, []wasm.ValueType{wasm.F32}},
+		{wasm.Op_f64_reinterpret_i64, []wasm.ValueType{wasm.I64}, []wasm.ValueType{wasm.F64}},
+		{wasm.Op_i64_extend16_s, []wasm.ValueType{wasm.I64}, []wasm.ValueType{wasm.I64}},
+	} {
+		params, results, err := wasm.Signature(tc.op)
+		if err != nil {
+			t.Errorf("%v: %v", tc.op, err)
+			continue
+		}
+		if !reflect.DeepEqual(params, tc.params) || !reflect.DeepEqual(results, tc.results) {
+			t.Errorf("%v: got %v -> %v, want %v -> %v", tc.op, params, results, tc.params, tc.results)
+		}
+	}
+
+	for _, op := range []wasm.Opcode{wasm.Op_call, wasm.Op_get_local, wasm.Op_i32_const, wasm.Op_prefix_misc, 0xff} {
+		if _, _, err := wasm.Signature(op); err == nil {
+			t.Errorf("%v: expected an error", op)
+		}
+	}
+}
+
+func TestMiscSignature(t *testing.T) {
+	for _, tc := range []struct {
+		op      wasm.MiscOpcode
+		params  []wasm.ValueType
+		results []wasm.ValueType
+	}{
+		{wasm.Op_i32_trunc_sat_s_f64, []wasm.ValueType{wasm.F64}, []wasm.ValueType{wasm.I32}},
+		{wasm.Op_i64_trunc_sat_u_f32, []wasm.ValueType{wasm.F32}, []wasm.ValueType{wasm.I64}},
+		{wasm.Op_memory_fill, []wasm.ValueType{wasm.I32, wasm.I32, wasm.I32}, nil},
+	} {
+		params, results, err := wasm.MiscSignature(tc.op)
+		if err != nil {
+			t.Errorf("%v: %v", tc.op, err)
+			continue
+		}
+		if !reflect.DeepEqual(params, tc.params) || !reflect.DeepEqual(results, tc.results) {
+			t.Errorf("%v: got %v -> %v, want %v -> %v", tc.op, params, results, tc.params, tc.results)
+		}
+	}
+
+	if _, _, err := wasm.MiscSignature(0x08); err == nil {
+		t.Errorf("MiscOpcode(0x08): expected an error")
+	}
+}
+
+func TestValidateMutableGlobals(t *testing.T) {
+	mut := wasm.GlobalType{ContentType: wasm.I32, Mutability: 1}
+	mod := wasm.NewModule()
+	mod.Sections = []wasm.Section{
+		wasm.ImportSection{Imports: []wasm.ImportEntry{
+			{Module: "env", Field: "g", Kind: wasm.GlobalKind, Global: mut},
+		}},
+		wasm.GlobalSection{Globals: []wasm.GlobalVariable{
+			{Type: mut, Init: wasm.InitExpr{Expr: []byte{0x41, 0x00}, End: 0x0b}},
+		}},
+		wasm.ExportSection{Exports: []wasm.ExportEntry{
+			{Field: "imported", Kind: wasm.GlobalKind, Index: 0},
+			{Field: "defined", Kind: wasm.GlobalKind, Index: 1},
+		}},
+	}
+	err := wasm.Validate(mod)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	var (
+		void   = wasm.FuncType{Form: wasm.Op_func}
+		i32i32 = wasm.FuncType{Form: wasm.Op_func, Params: []wasm.ValueType{wasm.I32}, Results: []wasm.ValueType{wasm.I32}}
+		i32    = wasm.InitExpr{Expr: []byte{0x41, 0x00}, End: 0x0b}
+		body   = func(code ...byte) wasm.FunctionBody {
+			return wasm.FunctionBody{Code: wasm.Code{Code: code, End: 0x0b}}
+		}
+	)
+
+	for _, tc := range []struct {
+		name string
+		secs []wasm.Section
+		want []string
+	}{
+		{
+			name: "section-order",
+			secs: []wasm.Section{
+				wasm.FunctionSection{},
+				wasm.TypeSection{},
+				wasm.CustomSection{Name: "x"},
+				wasm.TypeSection{},
+			},
+			want: []string{
+				"wasm: type section: type section out of order (after function section)",
+				"wasm: type section: type section out of order (after function section)",
+			},
+		},
+		{
+			name: "duplicate-section",
+			secs: []wasm.Section{wasm.MemorySection{}, wasm.MemorySection{}},
+			want: []string{"wasm: memory section: duplicate memory section"},
+		},
+		{
+			name: "index-bounds",
+			secs: []wasm.Section{
+				wasm.TypeSection{Types: []wasm.FuncType{void}},
+				wasm.ImportSection{Imports: []wasm.ImportEntry{
+					{Module: "env", Field: "f", Kind: wasm.FunctionKind, TypeIndex: 1},
+				}},
+				wasm.FunctionSection{Types: []uint32{0, 2}},
+				wasm.ExportSection{Exports: []wasm.ExportEntry{
+					{Field: "f", Kind: wasm.FunctionKind, Index: 3},
+					{Field: "m", Kind: wasm.MemoryKind, Index: 0},
+				}},
+				wasm.ElementSection{Elements: []wasm.ElemSegment{
+					{Index: 0, Offset: i32, Elems: []uint32{1, 4}},
+				}},
+				wasm.CodeSection{Bodies: []wasm.FunctionBody{body(), body()}},
+				wasm.DataSection{Segments: []wasm.DataSegment{{Index: 0, Offset: i32}}},
+			},
+			want: []string{
+				"wasm: import section, entry 0: unknown type 1",
+				"wasm: function section, entry 1: unknown type 2",
+				"wasm: export section, entry 0: unknown func 3",
+				"wasm: export section, entry 1: unknown memory 0",
+				"wasm: element section, entry 0: unknown table 0",
+				"wasm: element section, entry 0: unknown function 4",
+				"wasm: data section, entry 0: unknown memory 0",
+			},
+		},
+		{
+			name: "counts",
+			secs: []wasm.Section{
+				wasm.TypeSection{Types: []wasm.FuncType{void}},
+				wasm.FunctionSection{Types: []uint32{0, 0}},
+				wasm.CodeSection{Bodies: []wasm.FunctionBody{body()}},
+			},
+			want: []string{
+				"wasm: code section: function and code section have inconsistent lengths (2 != 1)",
+			},
+		},
+		{
+			name: "limits",
+			secs: []wasm.Section{
+				wasm.TableSection{Tables: []wasm.TableType{
+					{ElemType: wasm.AnyFunc, Limits: wasm.ResizableLimits{Flags: 1, Initial: 2, Maximum: 1}},
+					{ElemType: 0x7f},
+				}},
+				wasm.MemorySection{Memories: []wasm.MemoryType{
+					{Limits: wasm.ResizableLimits{Initial: 65537}},
+				}},
+			},
+			want: []string{
+				"wasm: table section, entry 0: maximum size 1 smaller than initial size 2",
+				"wasm: table section, entry 1: invalid table element type ElemType(0x7f)",
+				"wasm: table section: multiple tables (2)",
+				"wasm: memory section, entry 0: initial size 65537 exceeds 65536",
+			},
+		},
+		{
+			name: "constant-expressions",
+			secs: []wasm.Section{
+				wasm.ImportSection{Imports: []wasm.ImportEntry{
+					{Module: "env", Field: "g", Kind: wasm.GlobalKind, Global: wasm.GlobalType{ContentType: wasm.I64}},
+				}},
+				wasm.GlobalSection{Globals: []wasm.GlobalVariable{
+					{Type: wasm.GlobalType{ContentType: wasm.I32}, Init: wasm.InitExpr{Expr: []byte{0x23, 0x00}, End: 0x0b}},
+					{Type: wasm.GlobalType{ContentType: wasm.I32}, Init: wasm.InitExpr{Expr: []byte{0x23, 0x01}, End: 0x0b}},
+					{Type: wasm.GlobalType{ContentType: wasm.I32}, Init: wasm.InitExpr{Expr: []byte{0x41, 0x00, 0x41, 0x00, 0x6a}, End: 0x0b}},
+					{Type: wasm.GlobalType{ContentType: wasm.I32}, Init: wasm.InitExpr{Expr: []byte{0x3f, 0x00}, End: 0x0b}},
+				}},
+			},
+			want: []string{
+				"wasm: global section, entry 0: type mismatch in initializer expression: expected i32, got i64",
+				"wasm: global section, entry 1: initializer expression may only access imported globals (global 1)",
+				"wasm: global section, entry 2: initializer expression must hold exactly one instruction (got 3)",
+				"wasm: global section, entry 3: non-constant instruction current_memory in initializer expression",
+			},
+		},
+		{
+			name: "exports-and-start",
+			secs: []wasm.Section{
+				wasm.TypeSection{Types: []wasm.FuncType{i32i32}},
+				wasm.FunctionSection{Types: []uint32{0}},
+				wasm.ExportSection{Exports: []wasm.ExportEntry{
+					{Field: "f", Kind: wasm.FunctionKind},
+					{Field: "f", Kind: wasm.FunctionKind},
+				}},
+				wasm.StartSection{Index: 0},
+				wasm.CodeSection{Bodies: []wasm.FunctionBody{body(0x20, 0x00)}},
+			},
+			want: []string{
+				`wasm: export section, entry 1: duplicate export name "f" (also exported by entry 0)`,
+				"wasm: start section: invalid start function signature (i32) -> (i32), want () -> ()",
+			},
+		},
+		{
+			name: "type-checking",
+			secs: []wasm.Section{
+				wasm.TypeSection{Types: []wasm.FuncType{void, i32i32}},
+				wasm.ImportSection{Imports: []wasm.ImportEntry{
+					{Module: "env", Field: "f", Kind: wasm.FunctionKind, TypeIndex: 0},
+				}},
+				wasm.FunctionSection{Types: []uint32{1, 1, 0, 0, 0, 0, 1}},
+				wasm.CodeSection{Bodies: []wasm.FunctionBody{
+					body(0x20, 0x00, 0x42, 0x01, 0x6a),             // get_local 0; i64.const 1; i32.add
+					body(0x20, 0x00, 0x20, 0x00),                   // get_local 0; get_local 0
+					body(0x6a),                                     // i32.add
+					body(0x02, 0x7f, 0x0b),                         // block i32; end
+					body(0x41, 0x00, 0x04, 0x7f, 0x41, 0x00, 0x0b), // i32.const 0; if i32; i32.const 0; end
+					body(0x28, 0x03, 0x00, 0x1a),                   // i32.load align=8; drop
+					body(0x20, 0x01, 0x0c, 0x01),                   // get_local 1; br 1
+				}},
+			},
+			want: []string{
+				"wasm: func[1], offset 0x4: i32.add: type mismatch: expected i32, got i64",
+				"wasm: func[2], offset 0x4: end: 1 extra values on the operand stack at end of block",
+				"wasm: func[3], offset 0x0: i32.add: operand stack underflow",
+				"wasm: func[4], offset 0x2: end: operand stack underflow",
+				"wasm: func[5], offset 0x6: end: if block with a result type but no else branch",
+				"wasm: func[6], offset 0x0: i32.load: unknown memory 0",
+				"wasm: func[7], offset 0x0: get_local: unknown local 1",
+			},
+		},
+		{
+			name: "implementation-limits",
+			secs: []wasm.Section{
+				wasm.TypeSection{Types: []wasm.FuncType{i32i32}},
+				wasm.FunctionSection{Types: []uint32{0}},
+				wasm.CodeSection{Bodies: []wasm.FunctionBody{{
+					Locals: []wasm.LocalEntry{{Count: 49999, Type: wasm.I32}, {Count: 1, Type: wasm.I64}},
+					Code:   wasm.Code{End: 0x0b},
+				}}},
+			},
+			want: []string{
+				"wasm: func[0]: too many locals (implementation limit of 50000)",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mod := wasm.NewModule()
+			mod.Sections = tc.secs
+			err := wasm.Validate(mod)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+
+			var errs wasm.ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected a ValidationErrors, got %T: %v", err, err)
+			}
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid errors:\ngot= %q\nwant=%q", got, tc.want)
+			}
+		})
+	}
+}
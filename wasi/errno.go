@@ -0,0 +1,125 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasi
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// Errno is an error code returned by WASI functions.
+type Errno uint32
+
+// Error codes used by this package.
+const (
+	ESUCCESS     Errno = 0
+	E2BIG        Errno = 1
+	EACCES       Errno = 2
+	EAGAIN       Errno = 6
+	EBADF        Errno = 8
+	EEXIST       Errno = 20
+	EFAULT       Errno = 21
+	EINVAL       Errno = 28
+	EIO          Errno = 29
+	EISDIR       Errno = 31
+	ELOOP        Errno = 32
+	ENAMETOOLONG Errno = 37
+	ENOENT       Errno = 44
+	ENOSPC       Errno = 51
+	ENOSYS       Errno = 52
+	ENOTDIR      Errno = 54
+	ENOTEMPTY    Errno = 55
+	ENOTSUP      Errno = 58
+	EPERM        Errno = 63
+	EROFS        Errno = 69
+	ESPIPE       Errno = 70
+	EXDEV        Errno = 75
+	ENOTCAPABLE  Errno = 76
+)
+
+var errnoNames = map[Errno]string{
+	ESUCCESS:     "success",
+	E2BIG:        "argument list too long",
+	EACCES:       "permission denied",
+	EAGAIN:       "resource unavailable, try again",
+	EBADF:        "bad file descriptor",
+	EEXIST:       "file exists",
+	EFAULT:       "bad address",
+	EINVAL:       "invalid argument",
+	EIO:          "I/O error",
+	EISDIR:       "is a directory",
+	ELOOP:        "too many levels of symbolic links",
+	ENAMETOOLONG: "filename too long",
+	ENOENT:       "no such file or directory",
+	ENOSPC:       "no space left on device",
+	ENOSYS:       "function not supported",
+	ENOTDIR:      "not a directory",
+	ENOTEMPTY:    "directory not empty",
+	ENOTSUP:      "not supported",
+	EPERM:        "operation not permitted",
+	EROFS:        "read-only file system",
+	ESPIPE:       "invalid seek",
+	EXDEV:        "cross-device link",
+	ENOTCAPABLE:  "capabilities insufficient",
+}
+
+func (e Errno) Error() string {
+	if name, ok := errnoNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("errno %d", uint32(e))
+}
+
+var syscallErrnos = map[syscall.Errno]Errno{
+	syscall.E2BIG:        E2BIG,
+	syscall.EACCES:       EACCES,
+	syscall.EAGAIN:       EAGAIN,
+	syscall.EBADF:        EBADF,
+	syscall.EEXIST:       EEXIST,
+	syscall.EINVAL:       EINVAL,
+	syscall.EIO:          EIO,
+	syscall.EISDIR:       EISDIR,
+	syscall.ELOOP:        ELOOP,
+	syscall.ENAMETOOLONG: ENAMETOOLONG,
+	syscall.ENOENT:       ENOENT,
+	syscall.ENOSPC:       ENOSPC,
+	syscall.ENOSYS:       ENOSYS,
+	syscall.ENOTDIR:      ENOTDIR,
+	syscall.ENOTEMPTY:    ENOTEMPTY,
+	syscall.EPERM:        EPERM,
+	syscall.EROFS:        EROFS,
+	syscall.ESPIPE:       ESPIPE,
+	syscall.EXDEV:        EXDEV,
+}
+
+// errnoOf returns the error code describing err.
+func errnoOf(err error) Errno {
+	if err == nil {
+		return ESUCCESS
+	}
+	var (
+		errno Errno
+		sys   syscall.Errno
+	)
+	switch {
+	case errors.As(err, &errno):
+		return errno
+	case errors.As(err, &sys):
+		if errno, ok := syscallErrnos[sys]; ok {
+			return errno
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		return ENOENT
+	case errors.Is(err, fs.ErrExist):
+		return EEXIST
+	case errors.Is(err, fs.ErrPermission):
+		return EPERM
+	case errors.Is(err, fs.ErrInvalid):
+		return EINVAL
+	}
+	return EIO
+}
@@ -0,0 +1,876 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasi
+
+import (
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sbinet/wasm/exec"
+)
+
+var order = binary.LittleEndian
+
+// WriteFS is a file system which may be modified.
+type WriteFS interface {
+	fs.FS
+
+	// OpenFile opens the named file with the given os.O_* flags.
+	OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error)
+	Mkdir(name string, perm fs.FileMode) error
+	Remove(name string) error
+	Rename(oldname, newname string) error
+}
+
+// Optional operations of a WriteFS.
+type (
+	symlinkFS interface {
+		Symlink(oldname, newname string) error
+	}
+	linkFS interface {
+		Link(oldname, newname string) error
+	}
+	chtimesFS interface {
+		Chtimes(name string, atime, mtime time.Time) error
+	}
+)
+
+// DirFS returns a writable file system for the tree of files rooted at the
+// host directory dir.
+// Accesses through the file system can not escape dir, even through
+// symbolic links.
+func DirFS(dir string) (WriteFS, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &dirFS{FS: root.FS(), root: root}, nil
+}
+
+type dirFS struct {
+	fs.FS
+	root *os.Root
+}
+
+func (d *dirFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	f, err := d.root.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (d *dirFS) Stat(name string) (fs.FileInfo, error)  { return d.root.Stat(name) }
+func (d *dirFS) Lstat(name string) (fs.FileInfo, error) { return d.root.Lstat(name) }
+func (d *dirFS) ReadLink(name string) (string, error)   { return d.root.Readlink(name) }
+func (d *dirFS) Mkdir(name string, perm fs.FileMode) error {
+	return d.root.Mkdir(name, perm)
+}
+func (d *dirFS) Remove(name string) error              { return d.root.Remove(name) }
+func (d *dirFS) Rename(oldname, newname string) error  { return d.root.Rename(oldname, newname) }
+func (d *dirFS) Symlink(oldname, newname string) error { return d.root.Symlink(oldname, newname) }
+func (d *dirFS) Link(oldname, newname string) error    { return d.root.Link(oldname, newname) }
+func (d *dirFS) Chtimes(name string, atime, mtime time.Time) error {
+	return d.root.Chtimes(name, atime, mtime)
+}
+
+// File types.
+const (
+	filetypeUnknown         = 0
+	filetypeBlockDevice     = 1
+	filetypeCharacterDevice = 2
+	filetypeDirectory       = 3
+	filetypeRegularFile     = 4
+	filetypeSocketStream    = 6
+	filetypeSymbolicLink    = 7
+)
+
+func filetypeOf(mode fs.FileMode) byte {
+	switch {
+	case mode.IsDir():
+		return filetypeDirectory
+	case mode.IsRegular():
+		return filetypeRegularFile
+	case mode&fs.ModeSymlink != 0:
+		return filetypeSymbolicLink
+	case mode&fs.ModeCharDevice != 0:
+		return filetypeCharacterDevice
+	case mode&fs.ModeDevice != 0:
+		return filetypeBlockDevice
+	case mode&fs.ModeSocket != 0:
+		return filetypeSocketStream
+	}
+	return filetypeUnknown
+}
+
+// Flags of path_open and fd_fdstat_set_flags.
+const (
+	oflagCreat     = 1 << 0
+	oflagDirectory = 1 << 1
+	oflagExcl      = 1 << 2
+	oflagTrunc     = 1 << 3
+
+	fdflagAppend = 1 << 0
+
+	lookupSymlinkFollow = 1 << 0
+)
+
+// Rights. They are reported, but not enforced: access is only limited by
+// the file systems.
+const (
+	rightFdRead  = 1 << 1
+	rightFdSeek  = 1 << 2
+	rightFdTell  = 1 << 5
+	rightFdWrite = 1 << 6
+
+	rightsAll   = 1<<30 - 1
+	rightsStdio = rightsAll &^ (rightFdSeek | rightFdTell)
+)
+
+// file is an open file descriptor.
+type file struct {
+	typ     byte
+	fsys    fs.FS     // file system of the file, nil for standard streams
+	path    string    // path of the file in fsys
+	f       fs.File   // open file, nil for preopened directories and standard streams
+	r       io.Reader // standard input
+	w       io.Writer // standard output and error
+	preopen string    // guest path of a preopened directory
+	append  bool
+}
+
+func (f *file) reader() (io.Reader, bool) {
+	if f.r != nil {
+		return f.r, true
+	}
+	r, ok := f.f.(io.Reader)
+	return r, ok && f.typ != filetypeDirectory
+}
+
+func (f *file) writer() (io.Writer, bool) {
+	if f.w != nil {
+		return f.w, true
+	}
+	w, ok := f.f.(io.Writer)
+	if ok && f.append {
+		if s, ok := f.f.(io.Seeker); ok {
+			s.Seek(0, io.SeekEnd)
+		}
+	}
+	return w, ok && f.typ != filetypeDirectory
+}
+
+func (f *file) stat() (fs.FileInfo, error) {
+	if f.f != nil {
+		return f.f.Stat()
+	}
+	return fs.Stat(f.fsys, f.path)
+}
+
+func (f *file) close() error {
+	if f.f == nil {
+		return nil
+	}
+	return f.f.Close()
+}
+
+// file returns the file open as fd.
+func (s *system) file(fd uint32) (*file, Errno) {
+	f, ok := s.files[fd]
+	if !ok {
+		return nil, EBADF
+	}
+	return f, ESUCCESS
+}
+
+// alloc adds f to the open files, and returns its file descriptor.
+func (s *system) alloc(f *file) uint32 {
+	fd := uint32(0)
+	for s.files[fd] != nil {
+		fd++
+	}
+	s.files[fd] = f
+	return fd
+}
+
+// path returns the directory open as fd, and the name in its file system
+// of the path of n bytes at offset ptr, relative to that directory.
+func (s *system) path(mem *exec.Memory, fd, ptr, n uint32) (*file, string, Errno) {
+	dir, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return nil, "", errno
+	}
+	if dir.fsys == nil {
+		return nil, "", EBADF
+	}
+	if dir.typ != filetypeDirectory {
+		return nil, "", ENOTDIR
+	}
+	b, ok := mem.Read(ptr, n)
+	if !ok {
+		return nil, "", EFAULT
+	}
+	p := string(b)
+	if strings.HasPrefix(p, "/") {
+		return nil, "", ENOTCAPABLE
+	}
+	name := path.Join(dir.path, p)
+	if !fs.ValidPath(name) {
+		return nil, "", ENOTCAPABLE
+	}
+	return dir, name, ESUCCESS
+}
+
+// iovecs returns the buffers of the array of n iovecs at offset ptr.
+func iovecs(mem *exec.Memory, ptr, n uint32) ([][]byte, Errno) {
+	// the array is checked to fit in memory before allocating the buffers.
+	if uint64(n)*8 > uint64(len(mem.Bytes())) {
+		return nil, EFAULT
+	}
+	vecs, ok := mem.Read(ptr, 8*n)
+	if !ok {
+		return nil, EFAULT
+	}
+	bufs := make([][]byte, n)
+	for i := range bufs {
+		b := vecs[8*i:]
+		bufs[i], ok = mem.Read(order.Uint32(b[0:]), order.Uint32(b[4:]))
+		if !ok {
+			return nil, EFAULT
+		}
+	}
+	return bufs, ESUCCESS
+}
+
+// readv reads into bufs until a short read.
+func readv(bufs [][]byte, read func([]byte) (int, error)) (uint32, Errno) {
+	total := 0
+	for _, b := range bufs {
+		n, err := read(b)
+		total += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, errnoOf(err)
+		}
+		if n < len(b) {
+			break
+		}
+	}
+	return uint32(total), ESUCCESS
+}
+
+// writev writes bufs.
+func writev(bufs [][]byte, write func([]byte) (int, error)) (uint32, Errno) {
+	total := 0
+	for _, b := range bufs {
+		n, err := write(b)
+		total += n
+		if err != nil {
+			return 0, errnoOf(err)
+		}
+	}
+	return uint32(total), ESUCCESS
+}
+
+// result writes the 32-bit result of a successful operation.
+func result(mem *exec.Memory, ptr, v uint32, errno Errno) Errno {
+	if errno != ESUCCESS {
+		return errno
+	}
+	if !mem.WriteUint32(ptr, v) {
+		return EFAULT
+	}
+	return ESUCCESS
+}
+
+func (s *system) fdAdvise(fd uint32, offset, n uint64, advice uint32) Errno {
+	_, errno := s.file(fd)
+	return errno
+}
+
+func (s *system) fdAllocate(fd uint32, offset, n uint64) Errno {
+	_, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	return ENOTSUP
+}
+
+func (s *system) fdClose(fd uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	delete(s.files, fd)
+	return errnoOf(f.close())
+}
+
+func (s *system) fdSync(fd uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	if syncer, ok := f.f.(interface{ Sync() error }); ok {
+		return errnoOf(syncer.Sync())
+	}
+	return ESUCCESS
+}
+
+func (s *system) fdFdstatGet(c *exec.Caller, fd, ptr uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	buf := make([]byte, 24)
+	buf[0] = f.typ
+	if f.append {
+		order.PutUint16(buf[2:], fdflagAppend)
+	}
+	rights := uint64(rightsAll)
+	if f.fsys == nil {
+		rights = rightsStdio
+	}
+	order.PutUint64(buf[8:], rights)
+	order.PutUint64(buf[16:], rights)
+	if !memory(c).Write(ptr, buf) {
+		return EFAULT
+	}
+	return ESUCCESS
+}
+
+func (s *system) fdFdstatSetFlags(fd, flags uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	if flags&^fdflagAppend != 0 {
+		return ENOTSUP
+	}
+	f.append = flags&fdflagAppend != 0
+	return ESUCCESS
+}
+
+func (s *system) fdFdstatSetRights(fd uint32, base, inheriting uint64) Errno {
+	_, errno := s.file(fd)
+	return errno
+}
+
+// writeFilestat writes the filestat structure describing fi.
+func writeFilestat(mem *exec.Memory, ptr uint32, fi fs.FileInfo) Errno {
+	buf := make([]byte, 64)
+	if fi != nil {
+		buf[16] = filetypeOf(fi.Mode())
+		order.PutUint64(buf[24:], 1)
+		order.PutUint64(buf[32:], uint64(fi.Size()))
+		mtim := uint64(fi.ModTime().UnixNano())
+		order.PutUint64(buf[40:], mtim)
+		order.PutUint64(buf[48:], mtim)
+		order.PutUint64(buf[56:], mtim)
+	} else {
+		buf[16] = filetypeCharacterDevice
+	}
+	if !mem.Write(ptr, buf) {
+		return EFAULT
+	}
+	return ESUCCESS
+}
+
+func (s *system) fdFilestatGet(c *exec.Caller, fd, ptr uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	var fi fs.FileInfo
+	if f.fsys != nil {
+		var err error
+		fi, err = f.stat()
+		if err != nil {
+			return errnoOf(err)
+		}
+	}
+	return writeFilestat(memory(c), ptr, fi)
+}
+
+func (s *system) fdFilestatSetSize(fd uint32, size uint64) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	t, ok := f.f.(interface{ Truncate(int64) error })
+	if !ok {
+		return EBADF
+	}
+	return errnoOf(t.Truncate(int64(size)))
+}
+
+// Flags of the set_times functions.
+const (
+	fstflagAtim    = 1 << 0
+	fstflagAtimNow = 1 << 1
+	fstflagMtim    = 1 << 2
+	fstflagMtimNow = 1 << 3
+)
+
+// setTimes sets the access and modification times of the named file.
+func (s *system) setTimes(fsys fs.FS, name string, atim, mtim uint64, flags uint32) Errno {
+	if flags&(fstflagAtim|fstflagAtimNow) == fstflagAtim|fstflagAtimNow ||
+		flags&(fstflagMtim|fstflagMtimNow) == fstflagMtim|fstflagMtimNow {
+		return EINVAL
+	}
+	c, ok := fsys.(chtimesFS)
+	if !ok {
+		return EROFS
+	}
+	// zero times are left unchanged.
+	var atime, mtime time.Time
+	switch {
+	case flags&fstflagAtim != 0:
+		atime = time.Unix(0, int64(atim))
+	case flags&fstflagAtimNow != 0:
+		atime = s.now()
+	}
+	switch {
+	case flags&fstflagMtim != 0:
+		mtime = time.Unix(0, int64(mtim))
+	case flags&fstflagMtimNow != 0:
+		mtime = s.now()
+	}
+	return errnoOf(c.Chtimes(name, atime, mtime))
+}
+
+func (s *system) fdFilestatSetTimes(fd uint32, atim, mtim uint64, flags uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	if f.fsys == nil {
+		return EBADF
+	}
+	return s.setTimes(f.fsys, f.path, atim, mtim, flags)
+}
+
+func (s *system) fdPread(c *exec.Caller, fd, iovs, n uint32, offset uint64, nread uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	r, ok := f.f.(io.ReaderAt)
+	if !ok || f.typ == filetypeDirectory {
+		return ESPIPE
+	}
+	mem := memory(c)
+	bufs, errno := iovecs(mem, iovs, n)
+	if errno != ESUCCESS {
+		return errno
+	}
+	off := int64(offset)
+	v, errno := readv(bufs, func(b []byte) (int, error) {
+		n, err := r.ReadAt(b, off)
+		off += int64(n)
+		return n, err
+	})
+	return result(mem, nread, v, errno)
+}
+
+func (s *system) fdPwrite(c *exec.Caller, fd, iovs, n uint32, offset uint64, nwritten uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	w, ok := f.f.(io.WriterAt)
+	if !ok || f.typ == filetypeDirectory {
+		return ESPIPE
+	}
+	mem := memory(c)
+	bufs, errno := iovecs(mem, iovs, n)
+	if errno != ESUCCESS {
+		return errno
+	}
+	off := int64(offset)
+	v, errno := writev(bufs, func(b []byte) (int, error) {
+		n, err := w.WriteAt(b, off)
+		off += int64(n)
+		return n, err
+	})
+	return result(mem, nwritten, v, errno)
+}
+
+func (s *system) fdPrestatGet(c *exec.Caller, fd, ptr uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	if f.preopen == "" {
+		return EBADF
+	}
+	buf := make([]byte, 8) // tag 0: directory.
+	order.PutUint32(buf[4:], uint32(len(f.preopen)))
+	if !memory(c).Write(ptr, buf) {
+		return EFAULT
+	}
+	return ESUCCESS
+}
+
+func (s *system) fdPrestatDirName(c *exec.Caller, fd, ptr, n uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	if f.preopen == "" {
+		return EBADF
+	}
+	if n < uint32(len(f.preopen)) {
+		return ENAMETOOLONG
+	}
+	if !memory(c).Write(ptr, []byte(f.preopen)) {
+		return EFAULT
+	}
+	return ESUCCESS
+}
+
+func (s *system) fdRead(c *exec.Caller, fd, iovs, n, nread uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	r, ok := f.reader()
+	if !ok {
+		return EBADF
+	}
+	mem := memory(c)
+	bufs, errno := iovecs(mem, iovs, n)
+	if errno != ESUCCESS {
+		return errno
+	}
+	v, errno := readv(bufs, r.Read)
+	return result(mem, nread, v, errno)
+}
+
+func (s *system) fdWrite(c *exec.Caller, fd, iovs, n, nwritten uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	w, ok := f.writer()
+	if !ok {
+		return EBADF
+	}
+	mem := memory(c)
+	bufs, errno := iovecs(mem, iovs, n)
+	if errno != ESUCCESS {
+		return errno
+	}
+	v, errno := writev(bufs, w.Write)
+	return result(mem, nwritten, v, errno)
+}
+
+// direntSize is the size of the header of a dirent, preceding its name.
+const direntSize = 24
+
+func (s *system) fdReaddir(c *exec.Caller, fd, buf, n uint32, cookie uint64, bufused uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	if f.fsys == nil || f.typ != filetypeDirectory {
+		return ENOTDIR
+	}
+	entries, err := fs.ReadDir(f.fsys, f.path)
+	if err != nil {
+		return errnoOf(err)
+	}
+
+	type dirent struct {
+		name string
+		typ  byte
+	}
+	list := []dirent{{".", filetypeDirectory}, {"..", filetypeDirectory}}
+	for _, e := range entries {
+		list = append(list, dirent{e.Name(), filetypeOf(e.Type())})
+	}
+
+	// entries are written until the buffer is full: the last one may be
+	// truncated, signaling the end of the buffer was reached.
+	var out []byte
+	for i := cookie; i < uint64(len(list)) && len(out) < int(n); i++ {
+		e := list[i]
+		var hdr [direntSize]byte
+		order.PutUint64(hdr[0:], i+1)
+		order.PutUint32(hdr[16:], uint32(len(e.name)))
+		hdr[20] = e.typ
+		out = append(out, hdr[:]...)
+		out = append(out, e.name...)
+	}
+	if len(out) > int(n) {
+		out = out[:n]
+	}
+	mem := memory(c)
+	if !mem.Write(buf, out) {
+		return EFAULT
+	}
+	return result(mem, bufused, uint32(len(out)), ESUCCESS)
+}
+
+func (s *system) fdRenumber(from, to uint32) Errno {
+	f, errno := s.file(from)
+	if errno != ESUCCESS {
+		return errno
+	}
+	old, errno := s.file(to)
+	if errno != ESUCCESS {
+		return errno
+	}
+	if from == to {
+		return ESUCCESS
+	}
+	old.close()
+	s.files[to] = f
+	delete(s.files, from)
+	return ESUCCESS
+}
+
+func (s *system) fdSeek(c *exec.Caller, fd uint32, offset uint64, whence, ptr uint32) Errno {
+	f, errno := s.file(fd)
+	if errno != ESUCCESS {
+		return errno
+	}
+	seeker, ok := f.f.(io.Seeker)
+	if !ok || f.typ == filetypeDirectory {
+		return ESPIPE
+	}
+	if whence > io.SeekEnd {
+		return EINVAL
+	}
+	pos, err := seeker.Seek(int64(offset), int(whence))
+	if err != nil {
+		return errnoOf(err)
+	}
+	if !memory(c).WriteUint64(ptr, uint64(pos)) {
+		return EFAULT
+	}
+	return ESUCCESS
+}
+
+func (s *system) fdTell(c *exec.Caller, fd, ptr uint32) Errno {
+	return s.fdSeek(c, fd, 0, io.SeekCurrent, ptr)
+}
+
+func (s *system) pathCreateDirectory(c *exec.Caller, fd, ptr, n uint32) Errno {
+	dir, name, errno := s.path(memory(c), fd, ptr, n)
+	if errno != ESUCCESS {
+		return errno
+	}
+	wfs, ok := dir.fsys.(WriteFS)
+	if !ok {
+		return EROFS
+	}
+	return errnoOf(wfs.Mkdir(name, 0777))
+}
+
+func (s *system) pathFilestatGet(c *exec.Caller, fd, flags, ptr, n, buf uint32) Errno {
+	mem := memory(c)
+	dir, name, errno := s.path(mem, fd, ptr, n)
+	if errno != ESUCCESS {
+		return errno
+	}
+	stat := fs.Lstat
+	if flags&lookupSymlinkFollow != 0 {
+		stat = fs.Stat
+	}
+	fi, err := stat(dir.fsys, name)
+	if err != nil {
+		return errnoOf(err)
+	}
+	return writeFilestat(mem, buf, fi)
+}
+
+func (s *system) pathFilestatSetTimes(c *exec.Caller, fd, flags, ptr, n uint32, atim, mtim uint64, fstflags uint32) Errno {
+	dir, name, errno := s.path(memory(c), fd, ptr, n)
+	if errno != ESUCCESS {
+		return errno
+	}
+	return s.setTimes(dir.fsys, name, atim, mtim, fstflags)
+}
+
+func (s *system) pathLink(c *exec.Caller, oldFd, oldFlags, oldPtr, oldN, newFd, newPtr, newN uint32) Errno {
+	mem := memory(c)
+	oldDir, oldName, errno := s.path(mem, oldFd, oldPtr, oldN)
+	if errno != ESUCCESS {
+		return errno
+	}
+	newDir, newName, errno := s.path(mem, newFd, newPtr, newN)
+	if errno != ESUCCESS {
+		return errno
+	}
+	if oldDir.fsys != newDir.fsys {
+		return EXDEV
+	}
+	l, ok := oldDir.fsys.(linkFS)
+	if !ok {
+		return ENOTSUP
+	}
+	return errnoOf(l.Link(oldName, newName))
+}
+
+func (s *system) pathOpen(c *exec.Caller, fd, dirflags, ptr, n, oflags uint32, base, inheriting uint64, fdflags, opened uint32) Errno {
+	mem := memory(c)
+	dir, name, errno := s.path(mem, fd, ptr, n)
+	if errno != ESUCCESS {
+		return errno
+	}
+
+	var (
+		f     fs.File
+		err   error
+		read  = base&rightFdRead != 0
+		write = base&rightFdWrite != 0
+	)
+	switch {
+	case write || oflags&(oflagCreat|oflagTrunc) != 0:
+		wfs, ok := dir.fsys.(WriteFS)
+		if !ok {
+			return EROFS
+		}
+		flag := os.O_WRONLY
+		if read {
+			flag = os.O_RDWR
+		}
+		if oflags&oflagCreat != 0 {
+			flag |= os.O_CREATE
+		}
+		if oflags&oflagExcl != 0 {
+			flag |= os.O_EXCL
+		}
+		if oflags&oflagTrunc != 0 {
+			flag |= os.O_TRUNC
+		}
+		f, err = wfs.OpenFile(name, flag, 0666)
+	default:
+		f, err = dir.fsys.Open(name)
+	}
+	if err != nil {
+		return errnoOf(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errnoOf(err)
+	}
+	typ := filetypeOf(fi.Mode())
+	if oflags&oflagDirectory != 0 && typ != filetypeDirectory {
+		f.Close()
+		return ENOTDIR
+	}
+	nfd := s.alloc(&file{
+		typ:    typ,
+		fsys:   dir.fsys,
+		path:   name,
+		f:      f,
+		append: fdflags&fdflagAppend != 0,
+	})
+	return result(mem, opened, nfd, ESUCCESS)
+}
+
+func (s *system) pathReadlink(c *exec.Caller, fd, ptr, n, buf, bufLen, bufused uint32) Errno {
+	mem := memory(c)
+	dir, name, errno := s.path(mem, fd, ptr, n)
+	if errno != ESUCCESS {
+		return errno
+	}
+	target, err := fs.ReadLink(dir.fsys, name)
+	if err != nil {
+		return errnoOf(err)
+	}
+	if len(target) > int(bufLen) {
+		target = target[:bufLen]
+	}
+	if !mem.Write(buf, []byte(target)) {
+		return EFAULT
+	}
+	return result(mem, bufused, uint32(len(target)), ESUCCESS)
+}
+
+func (s *system) pathRemoveDirectory(c *exec.Caller, fd, ptr, n uint32) Errno {
+	dir, name, errno := s.path(memory(c), fd, ptr, n)
+	if errno != ESUCCESS {
+		return errno
+	}
+	wfs, ok := dir.fsys.(WriteFS)
+	if !ok {
+		return EROFS
+	}
+	fi, err := fs.Lstat(wfs, name)
+	if err != nil {
+		return errnoOf(err)
+	}
+	if !fi.IsDir() {
+		return ENOTDIR
+	}
+	return errnoOf(wfs.Remove(name))
+}
+
+func (s *system) pathRename(c *exec.Caller, oldFd, oldPtr, oldN, newFd, newPtr, newN uint32) Errno {
+	mem := memory(c)
+	oldDir, oldName, errno := s.path(mem, oldFd, oldPtr, oldN)
+	if errno != ESUCCESS {
+		return errno
+	}
+	newDir, newName, errno := s.path(mem, newFd, newPtr, newN)
+	if errno != ESUCCESS {
+		return errno
+	}
+	if oldDir.fsys != newDir.fsys {
+		return EXDEV
+	}
+	wfs, ok := oldDir.fsys.(WriteFS)
+	if !ok {
+		return EROFS
+	}
+	return errnoOf(wfs.Rename(oldName, newName))
+}
+
+func (s *system) pathSymlink(c *exec.Caller, oldPtr, oldN, fd, newPtr, newN uint32) Errno {
+	mem := memory(c)
+	target, ok := mem.Read(oldPtr, oldN)
+	if !ok {
+		return EFAULT
+	}
+	dir, name, errno := s.path(mem, fd, newPtr, newN)
+	if errno != ESUCCESS {
+		return errno
+	}
+	l, ok := dir.fsys.(symlinkFS)
+	if !ok {
+		return ENOTSUP
+	}
+	return errnoOf(l.Symlink(string(target), name))
+}
+
+func (s *system) pathUnlinkFile(c *exec.Caller, fd, ptr, n uint32) Errno {
+	dir, name, errno := s.path(memory(c), fd, ptr, n)
+	if errno != ESUCCESS {
+		return errno
+	}
+	wfs, ok := dir.fsys.(WriteFS)
+	if !ok {
+		return EROFS
+	}
+	fi, err := fs.Lstat(wfs, name)
+	if err != nil {
+		return errnoOf(err)
+	}
+	if fi.IsDir() {
+		return EISDIR
+	}
+	return errnoOf(wfs.Remove(name))
+}
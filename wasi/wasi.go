@@ -0,0 +1,433 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package wasi implements the WASI snapshot_preview1 system interface, as a
+// host module for the exec package.
+//
+// Command modules, exporting a _start function, are run with Run:
+//
+//	code, err := wasi.Run(mod, wasi.Config{
+//		Args:   []string{"prog", "-v"},
+//		Stdout: os.Stdout,
+//		Preopens: []wasi.Preopen{
+//			{Path: "/", FS: os.DirFS("/tmp/data")},
+//		},
+//	})
+//
+// Files are accessed through preopened directories, backed by a fs.FS.
+// File systems implementing WriteFS, like the ones returned by DirFS, may
+// also be modified by the module.
+package wasi
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"runtime"
+	"time"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+// ModuleName is the name of the module imported by WASI programs.
+const ModuleName = "wasi_snapshot_preview1"
+
+// Config describes the environment of a WASI program.
+type Config struct {
+	Args []string // command line arguments, including the program name
+	Env  []string // environment variables, as "key=value" strings
+
+	Stdin  io.Reader // standard input (default: empty)
+	Stdout io.Writer // standard output (default: discarded)
+	Stderr io.Writer // standard error (default: discarded)
+
+	// Preopens lists the directories made available to the program, as
+	// file descriptors starting at 3.
+	Preopens []Preopen
+
+	Rand io.Reader        // source of random_get (default: crypto/rand.Reader)
+	Now  func() time.Time // wall clock (default: time.Now)
+}
+
+// Preopen is a directory made available to a WASI program.
+type Preopen struct {
+	Path string // path of the directory, as seen by the program
+	FS   fs.FS  // content of the directory
+}
+
+// ExitError is the error returned when a program exits through proc_exit.
+type ExitError struct {
+	Code uint32 // exit code
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("wasi: exit status %d", e.Code)
+}
+
+// Run instantiates the WASI command module m, and calls its _start
+// function.
+// Run returns the exit code of the program: the argument of proc_exit, or
+// 0 if _start returned.
+func Run(m *wasm.Module, cfg Config) (uint32, error) {
+	inst, err := exec.Instantiate(m, New(cfg))
+	if err == nil {
+		_, err = inst.Call("_start")
+	}
+	var exit *ExitError
+	if errors.As(err, &exit) {
+		return exit.Code, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// New returns a host module implementing WASI for a program running in
+// the given environment.
+// The state of the system, like open files, is shared by all the
+// instances importing the module.
+func New(cfg Config) *exec.HostModule {
+	s := newSystem(cfg)
+	hm := exec.NewHostModule(ModuleName)
+	for _, def := range []struct {
+		name string
+		fn   interface{}
+	}{
+		{"args_get", s.argsGet},
+		{"args_sizes_get", s.argsSizesGet},
+		{"environ_get", s.environGet},
+		{"environ_sizes_get", s.environSizesGet},
+		{"clock_res_get", s.clockResGet},
+		{"clock_time_get", s.clockTimeGet},
+		{"fd_advise", s.fdAdvise},
+		{"fd_allocate", s.fdAllocate},
+		{"fd_close", s.fdClose},
+		{"fd_datasync", s.fdSync},
+		{"fd_fdstat_get", s.fdFdstatGet},
+		{"fd_fdstat_set_flags", s.fdFdstatSetFlags},
+		{"fd_fdstat_set_rights", s.fdFdstatSetRights},
+		{"fd_filestat_get", s.fdFilestatGet},
+		{"fd_filestat_set_size", s.fdFilestatSetSize},
+		{"fd_filestat_set_times", s.fdFilestatSetTimes},
+		{"fd_pread", s.fdPread},
+		{"fd_prestat_get", s.fdPrestatGet},
+		{"fd_prestat_dir_name", s.fdPrestatDirName},
+		{"fd_pwrite", s.fdPwrite},
+		{"fd_read", s.fdRead},
+		{"fd_readdir", s.fdReaddir},
+		{"fd_renumber", s.fdRenumber},
+		{"fd_seek", s.fdSeek},
+		{"fd_sync", s.fdSync},
+		{"fd_tell", s.fdTell},
+		{"fd_write", s.fdWrite},
+		{"path_create_directory", s.pathCreateDirectory},
+		{"path_filestat_get", s.pathFilestatGet},
+		{"path_filestat_set_times", s.pathFilestatSetTimes},
+		{"path_link", s.pathLink},
+		{"path_open", s.pathOpen},
+		{"path_readlink", s.pathReadlink},
+		{"path_remove_directory", s.pathRemoveDirectory},
+		{"path_rename", s.pathRename},
+		{"path_symlink", s.pathSymlink},
+		{"path_unlink_file", s.pathUnlinkFile},
+		{"poll_oneoff", s.pollOneoff},
+		{"proc_exit", s.procExit},
+		{"proc_raise", s.procRaise},
+		{"sched_yield", s.schedYield},
+		{"random_get", s.randomGet},
+		{"sock_accept", s.sockAccept},
+		{"sock_recv", s.sockRecv},
+		{"sock_send", s.sockSend},
+		{"sock_shutdown", s.sockShutdown},
+	} {
+		err := hm.DefineFunc(def.name, def.fn)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return hm
+}
+
+// system is the state of a WASI program.
+type system struct {
+	args  []string
+	env   []string
+	rand  io.Reader
+	now   func() time.Time
+	start time.Time // origin of the monotonic clock
+	files map[uint32]*file
+}
+
+func newSystem(cfg Config) *system {
+	s := &system{
+		args:  cfg.Args,
+		env:   cfg.Env,
+		rand:  cfg.Rand,
+		now:   cfg.Now,
+		start: time.Now(),
+		files: make(map[uint32]*file),
+	}
+	if s.rand == nil {
+		s.rand = rand.Reader
+	}
+	if s.now == nil {
+		s.now = time.Now
+	}
+
+	stdin := cfg.Stdin
+	if stdin == nil {
+		stdin = new(bytes.Reader)
+	}
+	stdout, stderr := cfg.Stdout, cfg.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	s.files[0] = &file{typ: filetypeCharacterDevice, r: stdin}
+	s.files[1] = &file{typ: filetypeCharacterDevice, w: stdout}
+	s.files[2] = &file{typ: filetypeCharacterDevice, w: stderr}
+	for i, p := range cfg.Preopens {
+		s.files[uint32(3+i)] = &file{
+			typ:     filetypeDirectory,
+			fsys:    p.FS,
+			path:    ".",
+			preopen: p.Path,
+		}
+	}
+	return s
+}
+
+// memory returns the memory of the caller.
+func memory(c *exec.Caller) *exec.Memory {
+	if mem := c.Memory(); mem != nil {
+		return mem
+	}
+	// a module without memory can not pass any pointer: use an empty
+	// memory so all accesses fault.
+	return exec.NewMemory(wasm.MemoryType{})
+}
+
+// writeStrings writes a list of NUL-terminated strings to the buffer at
+// offset buf, and pointers to them to the array at offset ptrs.
+func writeStrings(mem *exec.Memory, strs []string, ptrs, buf uint32) Errno {
+	for i, str := range strs {
+		if !mem.WriteUint32(ptrs+uint32(4*i), buf) {
+			return EFAULT
+		}
+		if !mem.Write(buf, append([]byte(str), 0)) {
+			return EFAULT
+		}
+		buf += uint32(len(str) + 1)
+	}
+	return ESUCCESS
+}
+
+// writeSizes writes the number of strings, and the size of the buffer
+// needed to hold them.
+func writeSizes(mem *exec.Memory, strs []string, countPtr, sizePtr uint32) Errno {
+	size := 0
+	for _, str := range strs {
+		size += len(str) + 1
+	}
+	if !mem.WriteUint32(countPtr, uint32(len(strs))) || !mem.WriteUint32(sizePtr, uint32(size)) {
+		return EFAULT
+	}
+	return ESUCCESS
+}
+
+func (s *system) argsGet(c *exec.Caller, argv, buf uint32) Errno {
+	return writeStrings(memory(c), s.args, argv, buf)
+}
+
+func (s *system) argsSizesGet(c *exec.Caller, argc, size uint32) Errno {
+	return writeSizes(memory(c), s.args, argc, size)
+}
+
+func (s *system) environGet(c *exec.Caller, environ, buf uint32) Errno {
+	return writeStrings(memory(c), s.env, environ, buf)
+}
+
+func (s *system) environSizesGet(c *exec.Caller, count, size uint32) Errno {
+	return writeSizes(memory(c), s.env, count, size)
+}
+
+// Clock IDs.
+const (
+	clockRealtime         = 0
+	clockMonotonic        = 1
+	clockProcessCputimeID = 2
+	clockThreadCputimeID  = 3
+)
+
+func (s *system) clockResGet(c *exec.Caller, id, res uint32) Errno {
+	if id > clockThreadCputimeID {
+		return EINVAL
+	}
+	if !memory(c).WriteUint64(res, 1) {
+		return EFAULT
+	}
+	return ESUCCESS
+}
+
+func (s *system) clockTimeGet(c *exec.Caller, id uint32, precision uint64, ptr uint32) Errno {
+	t, errno := s.clock(id)
+	if errno != ESUCCESS {
+		return errno
+	}
+	if !memory(c).WriteUint64(ptr, t) {
+		return EFAULT
+	}
+	return ESUCCESS
+}
+
+// clock returns the time of the given clock, in nanoseconds.
+func (s *system) clock(id uint32) (uint64, Errno) {
+	switch id {
+	case clockRealtime:
+		return uint64(s.now().UnixNano()), ESUCCESS
+	case clockMonotonic, clockProcessCputimeID, clockThreadCputimeID:
+		return uint64(time.Since(s.start)), ESUCCESS
+	}
+	return 0, EINVAL
+}
+
+func (s *system) randomGet(c *exec.Caller, buf, n uint32) Errno {
+	b, ok := memory(c).Read(buf, n)
+	if !ok {
+		return EFAULT
+	}
+	_, err := io.ReadFull(s.rand, b)
+	if err != nil {
+		return EIO
+	}
+	return ESUCCESS
+}
+
+// Event types of poll_oneoff.
+const (
+	eventtypeClock   = 0
+	eventtypeFdRead  = 1
+	eventtypeFdWrite = 2
+)
+
+const (
+	subscriptionSize = 48
+	eventSize        = 32
+)
+
+// pollOneoff waits for the subscribed events.
+// Reads and writes are always ready: only clock subscriptions may block.
+func (s *system) pollOneoff(c *exec.Caller, in, out, nsubs, neventsPtr uint32) Errno {
+	if nsubs == 0 {
+		return EINVAL
+	}
+	type event struct {
+		userdata uint64
+		errno    Errno
+		typ      byte
+		timeout  time.Duration
+	}
+	var (
+		mem     = memory(c)
+		events  []event
+		clocks  []event
+		timeout = time.Duration(-1)
+	)
+	for i := uint32(0); i < nsubs; i++ {
+		sub, ok := mem.Read(in+i*subscriptionSize, subscriptionSize)
+		if !ok {
+			return EFAULT
+		}
+		ev := event{userdata: order.Uint64(sub[0:]), typ: sub[8]}
+		switch ev.typ {
+		case eventtypeClock:
+			id := order.Uint32(sub[16:])
+			t := order.Uint64(sub[24:])
+			flags := order.Uint16(sub[40:])
+			ev.timeout = time.Duration(t)
+			if flags&1 != 0 { // subscription_clock_abstime
+				now, errno := s.clock(id)
+				if errno != ESUCCESS {
+					ev.errno = errno
+					events = append(events, ev)
+					continue
+				}
+				ev.timeout = time.Duration(t - now)
+				if t < now {
+					ev.timeout = 0
+				}
+			}
+			if timeout < 0 || ev.timeout < timeout {
+				timeout = ev.timeout
+			}
+			clocks = append(clocks, ev)
+		case eventtypeFdRead, eventtypeFdWrite:
+			if _, errno := s.file(order.Uint32(sub[16:])); errno != ESUCCESS {
+				ev.errno = errno
+			}
+			events = append(events, ev)
+		default:
+			return EINVAL
+		}
+	}
+
+	if len(events) == 0 {
+		time.Sleep(timeout)
+		for _, ev := range clocks {
+			if ev.timeout <= timeout {
+				events = append(events, ev)
+			}
+		}
+	}
+
+	for i, ev := range events {
+		buf := make([]byte, eventSize)
+		order.PutUint64(buf[0:], ev.userdata)
+		order.PutUint16(buf[8:], uint16(ev.errno))
+		buf[10] = ev.typ
+		if !mem.Write(out+uint32(i*eventSize), buf) {
+			return EFAULT
+		}
+	}
+	if !mem.WriteUint32(neventsPtr, uint32(len(events))) {
+		return EFAULT
+	}
+	return ESUCCESS
+}
+
+func (s *system) procExit(code uint32) error {
+	return &ExitError{Code: code}
+}
+
+func (s *system) procRaise(sig uint32) Errno {
+	return ENOSYS
+}
+
+func (s *system) schedYield() Errno {
+	runtime.Gosched()
+	return ESUCCESS
+}
+
+func (s *system) sockAccept(fd, flags, fdPtr uint32) Errno {
+	return ENOSYS
+}
+
+func (s *system) sockRecv(fd, data, n, flags, nread, oflags uint32) Errno {
+	return ENOSYS
+}
+
+func (s *system) sockSend(fd, data, n, flags, nwritten uint32) Errno {
+	return ENOSYS
+}
+
+func (s *system) sockShutdown(fd, how uint32) Errno {
+	return ENOSYS
+}
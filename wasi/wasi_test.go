@@ -0,0 +1,404 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasi_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/wasi"
+)
+
+var order = binary.LittleEndian
+
+// funcs lists the parameters of the WASI functions exercised by the tests.
+var funcs = []struct {
+	name   string
+	params string
+}{
+	{"args_get", "i32 i32"},
+	{"args_sizes_get", "i32 i32"},
+	{"environ_get", "i32 i32"},
+	{"environ_sizes_get", "i32 i32"},
+	{"clock_time_get", "i32 i64 i32"},
+	{"fd_close", "i32"},
+	{"fd_fdstat_get", "i32 i32"},
+	{"fd_filestat_get", "i32 i32"},
+	{"fd_prestat_get", "i32 i32"},
+	{"fd_prestat_dir_name", "i32 i32 i32"},
+	{"fd_read", "i32 i32 i32 i32"},
+	{"fd_readdir", "i32 i32 i32 i64 i32"},
+	{"fd_seek", "i32 i64 i32 i32"},
+	{"fd_write", "i32 i32 i32 i32"},
+	{"path_create_directory", "i32 i32 i32"},
+	{"path_filestat_get", "i32 i32 i32 i32 i32"},
+	{"path_open", "i32 i32 i32 i32 i32 i64 i64 i32 i32"},
+	{"path_rename", "i32 i32 i32 i32 i32 i32"},
+	{"path_unlink_file", "i32 i32 i32"},
+	{"poll_oneoff", "i32 i32 i32 i32"},
+	{"random_get", "i32 i32"},
+	{"sock_accept", "i32 i32 i32"},
+}
+
+// system instantiates a module exporting its memory, and functions
+// forwarding their arguments to the WASI functions of the same name.
+func system(t *testing.T, cfg wasi.Config) (*exec.Instance, *exec.Memory) {
+	t.Helper()
+	var imports, exports strings.Builder
+	for i, f := range funcs {
+		fmt.Fprintf(&imports, "  (import %q %q (func $%d (param %s) (result i32)))\n", wasi.ModuleName, f.name, i, f.params)
+		fmt.Fprintf(&exports, "  (func (export %q) (param %s) (result i32) (call $%d", f.name, f.params, i)
+		for j := range strings.Fields(f.params) {
+			fmt.Fprintf(&exports, " (get_local %d)", j)
+		}
+		exports.WriteString("))\n")
+	}
+	src := "(module\n" + imports.String() + "  (memory (export \"memory\") 1)\n" + exports.String() + ")"
+	mod, err := wasm.ParseText([]byte(src))
+	if err != nil {
+		t.Fatalf("could not parse module: %v", err)
+	}
+	inst, err := exec.Instantiate(mod, wasi.New(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, _ := inst.Export("memory")
+	return inst, mem.(*exec.Memory)
+}
+
+func call(t *testing.T, inst *exec.Instance, name string, args ...interface{}) wasi.Errno {
+	t.Helper()
+	for i, arg := range args {
+		if v, ok := arg.(int); ok {
+			args[i] = int32(v)
+		}
+	}
+	res, err := inst.Call(name, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return wasi.Errno(res[0].(int32))
+}
+
+func check(t *testing.T, inst *exec.Instance, name string, args ...interface{}) {
+	t.Helper()
+	if errno := call(t, inst, name, args...); errno != wasi.ESUCCESS {
+		t.Fatalf("%s: %v", name, errno)
+	}
+}
+
+func u32(t *testing.T, mem *exec.Memory, off uint32) uint32 {
+	t.Helper()
+	v, ok := mem.ReadUint32(off)
+	if !ok {
+		t.Fatalf("invalid offset %d", off)
+	}
+	return v
+}
+
+func str(t *testing.T, mem *exec.Memory, off, n uint32) string {
+	t.Helper()
+	b, ok := mem.Read(off, n)
+	if !ok {
+		t.Fatalf("invalid range [%d, %d)", off, off+n)
+	}
+	return string(b)
+}
+
+// iovec writes an iovec for the n bytes at offset buf to offset ptr.
+func iovec(mem *exec.Memory, ptr, buf, n uint32) {
+	mem.WriteUint32(ptr, buf)
+	mem.WriteUint32(ptr+4, n)
+}
+
+func TestArgsEnviron(t *testing.T) {
+	inst, mem := system(t, wasi.Config{
+		Args: []string{"prog", "-v"},
+		Env:  []string{"HOME=/home/gopher"},
+	})
+
+	check(t, inst, "args_sizes_get", 0, 4)
+	if argc, size := u32(t, mem, 0), u32(t, mem, 4); argc != 2 || size != 8 {
+		t.Fatalf("invalid args sizes: got (%d, %d), want (2, 8)", argc, size)
+	}
+	check(t, inst, "args_get", 16, 32)
+	if p0, p1 := u32(t, mem, 16), u32(t, mem, 20); p0 != 32 || p1 != 37 {
+		t.Fatalf("invalid argv: got [%d %d], want [32 37]", p0, p1)
+	}
+	if got, want := str(t, mem, 32, 8), "prog\x00-v\x00"; got != want {
+		t.Fatalf("invalid args: got %q, want %q", got, want)
+	}
+
+	check(t, inst, "environ_sizes_get", 0, 4)
+	if n, size := u32(t, mem, 0), u32(t, mem, 4); n != 1 || size != 18 {
+		t.Fatalf("invalid environ sizes: got (%d, %d), want (1, 18)", n, size)
+	}
+	check(t, inst, "environ_get", 16, 32)
+	if got, want := str(t, mem, u32(t, mem, 16), 18), "HOME=/home/gopher\x00"; got != want {
+		t.Fatalf("invalid environ: got %q, want %q", got, want)
+	}
+
+	if errno := call(t, inst, "args_get", 16, 0xfffffffc); errno != wasi.EFAULT {
+		t.Fatalf("invalid errno: got %v, want %v", errno, wasi.EFAULT)
+	}
+}
+
+func TestClockRandom(t *testing.T) {
+	now := time.Unix(1500000000, 42)
+	inst, mem := system(t, wasi.Config{
+		Now:  func() time.Time { return now },
+		Rand: strings.NewReader("0123456789"),
+	})
+
+	check(t, inst, "clock_time_get", 0, int64(1), 8)
+	if got, _ := mem.ReadUint64(8); got != uint64(now.UnixNano()) {
+		t.Fatalf("invalid realtime clock: got %d, want %d", got, now.UnixNano())
+	}
+	if errno := call(t, inst, "clock_time_get", 4, int64(1), 8); errno != wasi.EINVAL {
+		t.Fatalf("invalid errno: got %v, want %v", errno, wasi.EINVAL)
+	}
+
+	check(t, inst, "random_get", 16, 4)
+	if got := str(t, mem, 16, 4); got != "0123" {
+		t.Fatalf("invalid random bytes: got %q", got)
+	}
+
+	// a relative timeout of 1ms.
+	sub := make([]byte, 48)
+	order.PutUint64(sub[0:], 0xcafe)
+	order.PutUint32(sub[16:], 1)
+	order.PutUint64(sub[24:], uint64(time.Millisecond))
+	mem.Write(64, sub)
+	start := time.Now()
+	check(t, inst, "poll_oneoff", 64, 128, 1, 0)
+	if d := time.Since(start); d < time.Millisecond {
+		t.Fatalf("poll_oneoff returned after %v", d)
+	}
+	if n := u32(t, mem, 0); n != 1 {
+		t.Fatalf("invalid number of events: %d", n)
+	}
+	if userdata, _ := mem.ReadUint64(128); userdata != 0xcafe {
+		t.Fatalf("invalid event userdata: %#x", userdata)
+	}
+}
+
+func TestStdio(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	inst, mem := system(t, wasi.Config{
+		Stdin:  strings.NewReader("hello"),
+		Stdout: stdout,
+	})
+
+	iovec(mem, 0, 100, 3)
+	iovec(mem, 8, 200, 16)
+	check(t, inst, "fd_read", 0, 0, 2, 16)
+	if n := u32(t, mem, 16); n != 5 {
+		t.Fatalf("invalid number of bytes read: %d", n)
+	}
+	if got := str(t, mem, 100, 3) + str(t, mem, 200, 2); got != "hello" {
+		t.Fatalf("invalid stdin content: %q", got)
+	}
+
+	iovec(mem, 8, 200, 2)
+	check(t, inst, "fd_write", 1, 0, 2, 16)
+	if n := u32(t, mem, 16); n != 5 {
+		t.Fatalf("invalid number of bytes written: %d", n)
+	}
+	if got := stdout.String(); got != "hello" {
+		t.Fatalf("invalid stdout content: %q", got)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []interface{}
+		want wasi.Errno
+	}{
+		{"fd_write", []interface{}{0, 0, 1, 16}, wasi.EBADF},
+		{"fd_write", []interface{}{7, 0, 1, 16}, wasi.EBADF},
+		{"fd_write", []interface{}{1, 0, -1, 16}, wasi.EFAULT},
+		{"fd_write", []interface{}{1, 8, 1 << 13, 16}, wasi.EFAULT},
+		{"fd_read", []interface{}{0, 0, 0x20000000, 16}, wasi.EFAULT},
+		{"fd_seek", []interface{}{1, int64(0), 0, 16}, wasi.ESPIPE},
+		{"fd_prestat_get", []interface{}{2, 16}, wasi.EBADF},
+		{"sock_accept", []interface{}{1, 0, 16}, wasi.ENOSYS},
+	} {
+		if errno := call(t, inst, tc.name, tc.args...); errno != tc.want {
+			t.Errorf("%s%v: got %v, want %v", tc.name, tc.args, errno, tc.want)
+		}
+	}
+}
+
+// open opens the file at path, relative to the directory fd, and returns
+// its file descriptor.
+func open(t *testing.T, inst *exec.Instance, mem *exec.Memory, fd uint32, path string, oflags uint32, rights int64) (uint32, wasi.Errno) {
+	t.Helper()
+	mem.Write(1024, []byte(path))
+	errno := call(t, inst, "path_open", int32(fd), 0, 1024, len(path), int32(oflags), rights, rights, 0, 0)
+	return u32(t, mem, 0), errno
+}
+
+const (
+	rightRead  = 1 << 1
+	rightWrite = 1 << 6
+)
+
+func TestReadOnlyFS(t *testing.T) {
+	inst, mem := system(t, wasi.Config{
+		Preopens: []wasi.Preopen{{
+			Path: "/data",
+			FS: fstest.MapFS{
+				"dir/a.txt": &fstest.MapFile{Data: []byte("content")},
+			},
+		}},
+	})
+
+	check(t, inst, "fd_prestat_get", 3, 0)
+	if n := u32(t, mem, 4); n != 5 {
+		t.Fatalf("invalid preopen name length: %d", n)
+	}
+	check(t, inst, "fd_prestat_dir_name", 3, 8, 5)
+	if got := str(t, mem, 8, 5); got != "/data" {
+		t.Fatalf("invalid preopen name: %q", got)
+	}
+
+	fd, errno := open(t, inst, mem, 3, "dir/a.txt", 0, rightRead)
+	if errno != wasi.ESUCCESS {
+		t.Fatalf("could not open file: %v", errno)
+	}
+	check(t, inst, "fd_filestat_get", int32(fd), 64)
+	if typ, _ := mem.Read(64+16, 1); typ[0] != 4 {
+		t.Fatalf("invalid file type: %d", typ[0])
+	}
+	if size, _ := mem.ReadUint64(64 + 32); size != 7 {
+		t.Fatalf("invalid file size: %d", size)
+	}
+	iovec(mem, 16, 100, 32)
+	check(t, inst, "fd_read", int32(fd), 16, 1, 24)
+	if got := str(t, mem, 100, u32(t, mem, 24)); got != "content" {
+		t.Fatalf("invalid file content: %q", got)
+	}
+	check(t, inst, "fd_seek", int32(fd), int64(-3), 2, 32)
+	if pos, _ := mem.ReadUint64(32); pos != 4 {
+		t.Fatalf("invalid position: %d", pos)
+	}
+	check(t, inst, "fd_close", int32(fd))
+	if errno := call(t, inst, "fd_close", int32(fd)); errno != wasi.EBADF {
+		t.Fatalf("invalid errno: got %v, want %v", errno, wasi.EBADF)
+	}
+
+	check(t, inst, "fd_readdir", 3, 256, 512, int64(0), 16)
+	var names []string
+	for buf, end := uint32(256), 256+u32(t, mem, 16); buf < end; {
+		n := u32(t, mem, buf+16)
+		names = append(names, str(t, mem, buf+24, n))
+		buf += 24 + n
+	}
+	if got, want := strings.Join(names, " "), ". .. dir"; got != want {
+		t.Fatalf("invalid directory entries: got %q, want %q", got, want)
+	}
+
+	for _, tc := range []struct {
+		path   string
+		oflags uint32
+		rights int64
+		want   wasi.Errno
+	}{
+		{"missing", 0, rightRead, wasi.ENOENT},
+		{"../etc/passwd", 0, rightRead, wasi.ENOTCAPABLE},
+		{"/etc/passwd", 0, rightRead, wasi.ENOTCAPABLE},
+		{"dir/a.txt", 0, rightWrite, wasi.EROFS},
+		{"dir/a.txt", 2, rightRead, wasi.ENOTDIR},
+	} {
+		if _, errno := open(t, inst, mem, 3, tc.path, tc.oflags, tc.rights); errno != tc.want {
+			t.Errorf("open %q: got %v, want %v", tc.path, errno, tc.want)
+		}
+	}
+}
+
+func TestDirFS(t *testing.T) {
+	dir := t.TempDir()
+	fsys, err := wasi.DirFS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, mem := system(t, wasi.Config{
+		Preopens: []wasi.Preopen{{Path: "/", FS: fsys}},
+	})
+
+	mem.Write(512, []byte("sub"))
+	check(t, inst, "path_create_directory", 3, 512, 3)
+
+	fd, errno := open(t, inst, mem, 3, "sub/f.txt", 1|8, rightRead|rightWrite)
+	if errno != wasi.ESUCCESS {
+		t.Fatalf("could not create file: %v", errno)
+	}
+	mem.Write(100, []byte("hello"))
+	iovec(mem, 16, 100, 5)
+	check(t, inst, "fd_write", int32(fd), 16, 1, 24)
+	check(t, inst, "fd_close", int32(fd))
+
+	got, err := os.ReadFile(filepath.Join(dir, "sub", "f.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("invalid file content: %q", got)
+	}
+
+	mem.Write(512, []byte("sub/f.txtsub/g.txt"))
+	check(t, inst, "path_rename", 3, 512, 9, 3, 521, 9)
+	check(t, inst, "path_filestat_get", 3, 0, 521, 9, 64)
+	if size, _ := mem.ReadUint64(64 + 32); size != 5 {
+		t.Fatalf("invalid file size: %d", size)
+	}
+	if errno := call(t, inst, "path_unlink_file", 3, 512, 3); errno != wasi.EISDIR {
+		t.Fatalf("invalid errno: got %v, want %v", errno, wasi.EISDIR)
+	}
+	check(t, inst, "path_unlink_file", 3, 521, 9)
+	if _, err := os.Stat(filepath.Join(dir, "sub", "g.txt")); !os.IsNotExist(err) {
+		t.Fatalf("file was not removed: %v", err)
+	}
+
+	if _, errno := open(t, inst, mem, 3, "sub/../../x", 1, rightWrite); errno != wasi.ENOTCAPABLE {
+		t.Fatalf("invalid errno: got %v, want %v", errno, wasi.ENOTCAPABLE)
+	}
+}
+
+func TestRun(t *testing.T) {
+	mod, err := wasm.ParseText([]byte(`(module
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 0) "\10\00\00\00\06\00\00\00")
+  (data (i32.const 16) "hello\n")
+  (func (export "_start")
+    (drop (call $fd_write (i32.const 1) (i32.const 0) (i32.const 1) (i32.const 8)))
+    (call $proc_exit (i32.const 3))
+    (unreachable)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := new(bytes.Buffer)
+	code, err := wasi.Run(mod, wasi.Config{Stdout: stdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 {
+		t.Fatalf("invalid exit code: %d", code)
+	}
+	if got := stdout.String(); got != "hello\n" {
+		t.Fatalf("invalid stdout: %q", got)
+	}
+}
@@ -5,3 +5,13 @@
 package wasm
 
 var magicWASM = [4]byte{0x00, 0x61, 0x73, 0x6d} // "\0asm"
+
+// version is the version of the binary format supported by this package.
+const version = 1
+
+// supportedVersion reports whether modules of version v are decoded: the
+// version 1, and the pre-release versions 0xb to 0xd still found in modules
+// produced by early toolchains, such as the ones of testdata.
+func supportedVersion(v uint32) bool {
+	return v == version || 0xb <= v && v <= 0xd
+}
@@ -5,6 +5,7 @@
 package wasm_test
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 
@@ -20,3 +21,43 @@ func TestOpen(t *testing.T) {
 	fmt.Printf("module header: %v\n", mod.Header)
 	fmt.Printf("#sections: %d\n", len(mod.Sections))
 }
+
+func TestModuleContents(t *testing.T) {
+	// (module
+	//  (func $add (export "add") (param $lhs i32) (param $rhs i32) (result i32)
+	//   get_local $lhs
+	//   get_local $rhs
+	//   i32.add)
+	// )
+	raw := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x07, 0x01, 0x60, 0x02, 0x7f, 0x7f, 0x01,
+		0x7f, 0x03, 0x02, 0x01, 0x00, 0x07, 0x07, 0x01,
+		0x03, 0x61, 0x64, 0x64, 0x00, 0x00, 0x0a, 0x09,
+		0x01, 0x07, 0x00, 0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b,
+	}
+	mod, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types := mod.Sections[0].(wasm.TypeSection)
+	if got, want := types.Types[0].String(), "(i32, i32) -> (i32)"; got != want {
+		t.Fatalf("invalid signature: got=%q, want=%q", got, want)
+	}
+
+	exports := mod.Sections[2].(wasm.ExportSection)
+	if got, want := exports.Exports[0], (wasm.ExportEntry{Field: "add", Kind: wasm.FunctionKind}); got != want {
+		t.Fatalf("invalid export: got=%#v, want=%#v", got, want)
+	}
+
+	exports.Exports[0].Field = "sum"
+	w := new(bytes.Buffer)
+	err = wasm.Encode(*mod, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(w.Bytes(), []byte("\x03sum\x00\x00")) {
+		t.Fatalf("renamed export not encoded:\n%x", w.Bytes())
+	}
+}